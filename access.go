@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// allowedNets restricts clients to these subnets (-allow-cidr); empty allows all
+var allowedNets []*net.IPNet
+
+func parseAllowCIDR(list string) error {
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			// a single address
+			if strings.Contains(s, ":") {
+				s += "/128"
+			} else {
+				s += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return err
+		}
+		allowedNets = append(allowedNets, n)
+	}
+	return nil
+}
+
+// clientAllowed reports whether the request comes from an allowed subnet;
+// loopback is always allowed
+func clientAllowed(r *http.Request) bool {
+	if len(allowedNets) == 0 {
+		return true
+	}
+	ip := net.ParseIP(clientIP(r))
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() {
+		return true
+	}
+	for _, n := range allowedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isWriteRequest reports whether a request can change the unit: anything
+// but GET/HEAD, plus the WebSocket channel which accepts writes
+func isWriteRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return true
+	}
+	return r.URL.Path == "/api/ws"
+}
+
+// allowCIDR rejects requests from outside -allow-cidr; unless all is set only
+// write requests are restricted. Signed requests are allowed from anywhere.
+func allowCIDR(next http.Handler, all bool) http.Handler {
+	if len(allowedNets) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (all || isWriteRequest(r)) && !clientAllowed(r) && signedBy(r) == "" {
+			log.Printf("Rejected %s %s from %s (not in -allow-cidr)", r.Method, r.URL.Path, clientIP(r))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, `{"success":false,"error":"forbidden from this network"}`)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "futura_http_requests_total",
+		Help: "HTTP requests by the handler pattern they matched (\"other\" for unknown paths), method and status code",
+	}, []string{"handler", "method", "code"})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "futura_http_request_duration_seconds",
+		Help:    "Time to serve HTTP requests by handler pattern (s); streams count until they close",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"handler", "method"})
+)
+
+// metricMethod returns the method label: the standard methods as they are,
+// "other" for anything else a client sends, so it can't create series
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+func RegisterAccessLogMetrics() {
+	prometheus.MustRegister(httpRequestsTotal, httpRequestDuration)
+}
+
+// statusRecorder remembers the status code written through it. It passes
+// Flush and Hijack on, which the SSE stream and WebSockets need.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	if s.status == 0 {
+		s.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// logRequests counts and times every request by handler pattern and, with
+// -log-requests, logs API requests. Only the path is logged; query strings
+// may carry tokens.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		dur := time.Since(start)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		// label by pattern, not path, to keep the number of series bounded
+		_, pattern := http.DefaultServeMux.Handler(r)
+		if pattern == "" {
+			pattern = "other"
+		}
+		method := metricMethod(r.Method)
+		httpRequestsTotal.WithLabelValues(pattern, method, strconv.Itoa(rec.status)).Inc()
+		httpRequestDuration.WithLabelValues(pattern, method).Observe(dur.Seconds())
+
+		if *flagLogRequests && strings.HasPrefix(r.URL.Path, "/api/") {
+			log.Printf("HTTP %s %s %d %s from %s", r.Method, r.URL.Path, rec.status, dur.Round(time.Microsecond), clientIP(r))
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AnalogInputConfig converts the millivolts of a 0-10 V input into a
+// physical value: value = volts*scale + offset
+type AnalogInputConfig struct {
+	Name   string  `yaml:"name"`
+	Unit   string  `yaml:"unit"`
+	Scale  float64 `yaml:"scale"`
+	Offset float64 `yaml:"offset"`
+}
+
+// AnalogInputsConfig holds the mapping of both analog inputs
+type AnalogInputsConfig struct {
+	Uin1 *AnalogInputConfig `yaml:"uin1"`
+	Uin2 *AnalogInputConfig `yaml:"uin2"`
+}
+
+var analogInputGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "analog_input",
+	Help: "Scaled value of configured analog inputs",
+}, []string{"input", "name", "unit"})
+
+func RegisterAnalogInputMetrics() {
+	prometheus.MustRegister(analogInputGauge)
+}
+
+func (c *AnalogInputConfig) convert(mv uint16) float64 {
+	return float64(mv)/1000*c.Scale + c.Offset
+}
+
+// applyAnalogScaling fills the scaled Uin values of a decoded snapshot
+func applyAnalogScaling(r *futura.InputRegs) {
+	if c := appConfig().AnalogInputs.Uin1; c != nil {
+		r.Uin1Scaled = c.convert(r.Uin1Voltage)
+	}
+	if c := appConfig().AnalogInputs.Uin2; c != nil {
+		r.Uin2Scaled = c.convert(r.Uin2Voltage)
+	}
+}
+
+// updateAnalogMetrics exports the scaled values of configured inputs
+func updateAnalogMetrics(r futura.InputRegs) {
+	if c := appConfig().AnalogInputs.Uin1; c != nil {
+		analogInputGauge.WithLabelValues("uin1", c.Name, c.Unit).Set(r.Uin1Scaled)
+	}
+	if c := appConfig().AnalogInputs.Uin2; c != nil {
+		analogInputGauge.WithLabelValues("uin2", c.Name, c.Unit).Set(r.Uin2Scaled)
+	}
+}
+
+func (c AnalogInputsConfig) validate() error {
+	for input, in := range map[string]*AnalogInputConfig{"uin1": c.Uin1, "uin2": c.Uin2} {
+		if in == nil {
+			continue
+		}
+		if in.Name == "" {
+			in.Name = input
+		}
+		if in.Scale == 0 {
+			return fmt.Errorf("analog input %s: scale must not be 0", input)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/simonvetter/modbus"
+)
+
+// Write audit log: every write of a field, by whoever and whether it
+// succeeded, with the value it replaced. With -audit-log the entries are
+// appended to a file as JSON lines and the latest are loaded back at
+// startup, so /api/audit reaches across restarts.
+
+// auditLogSize is how many entries /api/audit keeps in memory
+const auditLogSize = 1000
+
+// AuditEntry is one write attempt
+type AuditEntry struct {
+	Seq    int64     `json:"seq"` // line number in -audit-log
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Client string    `json:"client,omitempty"`
+	IP     string    `json:"ip,omitempty"`
+	Field  string    `json:"field"`
+	Old    *float64  `json:"old"` // null when it wasn't read
+	New    float64   `json:"new"`
+	Result string    `json:"result"` // "ok" or the error
+}
+
+type auditLog struct {
+	mu      sync.Mutex
+	seq     int64
+	entries []AuditEntry
+	file    *os.File
+}
+
+var audit = &auditLog{}
+
+// openAuditLog loads the latest entries of path and appends to it from now on
+func openAuditLog(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		audit.seq++
+		var e AuditEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			continue
+		}
+		e.Seq = audit.seq
+		audit.append(e)
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	audit.file = f
+	return nil
+}
+
+// append adds an entry to the in-memory ring. l.mu must be held or the log
+// not shared yet.
+func (l *auditLog) append(e AuditEntry) {
+	l.entries = append(l.entries, e)
+	if len(l.entries) > auditLogSize {
+		l.entries = append([]AuditEntry(nil), l.entries[len(l.entries)-auditLogSize:]...)
+	}
+}
+
+func (l *auditLog) add(e AuditEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	e.Seq = l.seq
+	l.append(e)
+	if l.file == nil {
+		return
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		log.Printf("audit log: %v, writing to the file stopped", err)
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// list returns entries after seq at or after since, optionally of one field
+func (l *auditLog) list(after int64, since time.Time, field string) []AuditEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	start := sort.Search(len(l.entries), func(i int) bool { return l.entries[i].Seq > after })
+	var out []AuditEntry
+	for _, e := range l.entries[start:] {
+		if e.Time.Before(since) || (field != "" && e.Field != field) {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+func auditResult(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
+
+// auditWrite records a write attempt; old is nil when the value before
+// wasn't read
+func auditWrite(field string, value float64, old *float64, o origin, err error) {
+	audit.add(AuditEntry{
+		Time:   time.Now(),
+		Source: o.Source,
+		Client: o.Client,
+		IP:     o.IP,
+		Field:  field,
+		Old:    old,
+		New:    value,
+		Result: auditResult(err),
+	})
+}
+
+// auditBulkWrite records the changed fields of a full form write. before
+// holds the values read before the write; err is the write error or the
+// WriteMismatches of its verification.
+func auditBulkWrite(changed []string, data map[string]interface{}, before futura.HoldingRegs, o origin, err error) {
+	mismatches, _ := err.(WriteMismatches)
+	for _, f := range changed {
+		var old *float64
+		if v, ok := structField(reflect.ValueOf(before), f, 0); ok {
+			old = &v
+		}
+		val, _ := data[f].(float64)
+		fieldErr := err
+		if mismatches != nil {
+			fieldErr = nil
+			for _, m := range mismatches {
+				if m.Field == f {
+					fieldErr = m
+				}
+			}
+		}
+		auditWrite(f, val, old, o, fieldErr)
+	}
+}
+
+// readField reads the current value of a single-register field, or nil
+func readField(client *ModbusConn, spec futura.WriteFieldSpec) *float64 {
+	regs, err := client.ReadRegisters(spec.Addr, 1, modbus.HOLDING_REGISTER)
+	if err != nil {
+		return nil
+	}
+	v := decodeField(spec, regs[0])
+	return &v
+}
+
+// handleAudit returns the recorded writes, oldest first; ?field= filters
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	page, err := parsePage(r, 100)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"success":false,"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	entries := audit.list(page.After, page.Since, r.URL.Query().Get("field"))
+	err = writePage(w, page, len(entries),
+		func(i int) interface{} { return entries[i] },
+		func(i int) int64 { return entries[i].Seq })
+	if err != nil {
+		log.Printf("encode audit json: %v", err)
+	}
+}
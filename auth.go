@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Optional authentication of the main port, configured separately for read
+// endpoints (the UI and GET API), write endpoints (anything that can change
+// the unit, see isWriteRequest) and the metrics. A class without users or
+// tokens stays open. Endpoints with their own tokens (guest actions,
+// management, intents) and /readyz are never asked for credentials, and
+// signed requests are already authenticated.
+
+// AuthConfig holds the credentials of each class of endpoints
+type AuthConfig struct {
+	Read    AuthCredentials `yaml:"read"`
+	Write   AuthCredentials `yaml:"write"`
+	Metrics AuthCredentials `yaml:"metrics"` // /metrics, /metrics/federate and /probe
+}
+
+// AuthCredentials are the basic auth users and bearer tokens accepted
+type AuthCredentials struct {
+	Users  map[string]string `yaml:"users"`  // user -> password
+	Tokens []string          `yaml:"tokens"` // Authorization: Bearer <token>
+}
+
+func (c AuthCredentials) enabled() bool {
+	return len(c.Users) > 0 || len(c.Tokens) > 0
+}
+
+func (c AuthConfig) validate() error {
+	for class, creds := range map[string]AuthCredentials{"read": c.Read, "write": c.Write, "metrics": c.Metrics} {
+		for user, pass := range creds.Users {
+			if user == "" || strings.Contains(user, ":") {
+				return fmt.Errorf("auth.%s: invalid user name %q", class, user)
+			}
+			if pass == "" {
+				return fmt.Errorf("auth.%s: user %s has no password", class, user)
+			}
+		}
+		for i, t := range creds.Tokens {
+			if t == "" {
+				return fmt.Errorf("auth.%s: token %d is empty", class, i)
+			}
+		}
+	}
+	return nil
+}
+
+// check reports whether the request carries valid credentials
+func (c AuthCredentials) check(r *http.Request) bool {
+	if checkBearer(r, c.Tokens) {
+		return true
+	}
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	want, known := c.Users[user]
+	// compare anyway so unknown users take as long as wrong passwords
+	match := subtle.ConstantTimeCompare([]byte(pass), []byte(want)) == 1
+	return known && match
+}
+
+// authExempt reports whether a path authenticates clients itself or must
+// stay reachable without credentials
+func authExempt(path string) bool {
+	switch {
+	case path == "/readyz",
+		path == "/api/guest/action",
+		path == "/static/guest.html",
+		path == "/api/intent",
+		strings.HasPrefix(path, "/api/manage/"):
+		return true
+	}
+	return false
+}
+
+// authClass returns the class of endpoints a request belongs to and the
+// credentials it needs
+func authClass(r *http.Request) (string, AuthCredentials) {
+	cfg := appConfig().Auth
+	switch {
+	case r.URL.Path == "/metrics" || r.URL.Path == "/metrics/federate" || r.URL.Path == "/probe":
+		return "metrics", cfg.Metrics
+	case isWriteRequest(r):
+		return "write", cfg.Write
+	}
+	return "read", cfg.Read
+}
+
+// requireAuth asks for credentials where auth is configured. Browsers get a
+// basic auth prompt per class, so the UI works with users; scripts can send
+// either.
+func requireAuth(next http.Handler) http.Handler {
+	cfg := appConfig().Auth
+	if !cfg.Read.enabled() && !cfg.Write.enabled() && !cfg.Metrics.enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		class, creds := authClass(r)
+		if !creds.enabled() || authExempt(r.URL.Path) || signedBy(r) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if authLocked(w, r) {
+			return
+		}
+		if !creds.check(r) {
+			if r.Header.Get("Authorization") != "" {
+				// a missing header is the browser asking for the prompt
+				authFailed(r, class+" credentials")
+			}
+			if len(creds.Users) > 0 {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Basic realm="gofutura %s", charset="UTF-8"`, class))
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="gofutura"`)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, `{"success":false,"error":"unauthorized"}`)
+			return
+		}
+		authSucceeded(r)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"math"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/danielkucera/gofutura/futura"
+)
+
+// Binary snapshot for microcontroller clients: a fixed little-endian layout
+// derived from InputRegs, so it follows the register map automatically.
+//
+//	header: "FUTS", layout CRC32 (u32), Seq (u32), unix time (u32)
+//	body:   the numeric fields of InputRegs in declaration order; arrays are
+//	        expanded, uint16/uint32 stay integers and float64 becomes float32
+//	        (NaN when a derived value is unavailable)
+//
+// The layout CRC changes whenever fields are added or moved, so a client
+// built against /api/snapshot.layout can refuse data it can't parse.
+
+const (
+	binSnapshotMagic = "FUTS"
+	binHeaderSize    = 16
+)
+
+// binField is one field of the binary layout
+type binField struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"` // u16, u32 or f32
+	Offset int    `json:"offset"`
+	index  []int
+	elem   int // array element, -1 for scalars
+}
+
+var (
+	binLayoutOnce sync.Once
+	binLayout     []binField
+	binLayoutCRC  uint32
+	binSize       int
+)
+
+func binType(k reflect.Kind) string {
+	switch k {
+	case reflect.Uint16:
+		return "u16"
+	case reflect.Uint32:
+		return "u32"
+	case reflect.Float64:
+		return "f32"
+	}
+	return ""
+}
+
+func binTypeSize(t string) int {
+	if t == "u16" {
+		return 2
+	}
+	return 4
+}
+
+// buildBinLayout walks InputRegs once and assigns offsets
+func buildBinLayout() {
+	t := reflect.TypeOf(futura.InputRegs{})
+	off := binHeaderSize
+	var desc strings.Builder
+	add := func(name, typ string, index []int, elem int) {
+		binLayout = append(binLayout, binField{Name: name, Type: typ, Offset: off, index: index, elem: elem})
+		fmt.Fprintf(&desc, "%s:%s;", name, typ)
+		off += binTypeSize(typ)
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			continue // SnapshotMeta is in the header
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Array {
+			typ := binType(ft.Elem().Kind())
+			if typ == "" {
+				continue
+			}
+			for j := 0; j < ft.Len(); j++ {
+				add(fmt.Sprintf("%s%d", f.Name, j+1), typ, f.Index, j)
+			}
+			continue
+		}
+		if typ := binType(ft.Kind()); typ != "" {
+			add(f.Name, typ, f.Index, -1)
+		}
+	}
+	binSize = off
+	binLayoutCRC = crc32.ChecksumIEEE([]byte(desc.String()))
+}
+
+// encodeBinSnapshot encodes a snapshot in the binary layout
+func encodeBinSnapshot(r futura.InputRegs) []byte {
+	binLayoutOnce.Do(buildBinLayout)
+	buf := make([]byte, binSize)
+	copy(buf, binSnapshotMagic)
+	binary.LittleEndian.PutUint32(buf[4:], binLayoutCRC)
+	binary.LittleEndian.PutUint32(buf[8:], uint32(r.Seq))
+	binary.LittleEndian.PutUint32(buf[12:], uint32(r.Time.Unix()))
+
+	v := reflect.ValueOf(r)
+	for _, f := range binLayout {
+		fv := v.FieldByIndex(f.index)
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				binary.LittleEndian.PutUint32(buf[f.Offset:], math.Float32bits(float32(math.NaN())))
+				continue
+			}
+			fv = fv.Elem()
+		}
+		if f.elem >= 0 {
+			fv = fv.Index(f.elem)
+		}
+		switch f.Type {
+		case "u16":
+			binary.LittleEndian.PutUint16(buf[f.Offset:], uint16(fv.Uint()))
+		case "u32":
+			binary.LittleEndian.PutUint32(buf[f.Offset:], uint32(fv.Uint()))
+		case "f32":
+			binary.LittleEndian.PutUint32(buf[f.Offset:], math.Float32bits(float32(fv.Float())))
+		}
+	}
+	return buf
+}
+
+// handleSnapshotBin returns the last polled snapshot in the binary layout
+func handleSnapshotBin(w http.ResponseWriter, r *http.Request) {
+	in, _, ok := latestSnapshot()
+	if !ok {
+		http.Error(w, "no data polled yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	setSnapshotHeaders(w, in.SnapshotMeta)
+	w.Write(encodeBinSnapshot(in))
+}
+
+// handleSnapshotLayout describes the binary layout as JSON, or as a packed
+// C struct with ?format=c
+func handleSnapshotLayout(w http.ResponseWriter, r *http.Request) {
+	binLayoutOnce.Do(buildBinLayout)
+
+	if r.URL.Query().Get("format") == "c" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "// gofutura /api/snapshot.bin, %d bytes, little-endian\n", binSize)
+		fmt.Fprintf(&b, "#define FUTS_LAYOUT_CRC 0x%08XUL\n\n", binLayoutCRC)
+		fmt.Fprintf(&b, "typedef struct __attribute__((packed)) {\n")
+		fmt.Fprintf(&b, "\tchar magic[4]; // \"FUTS\"\n\tuint32_t layout_crc;\n\tuint32_t seq;\n\tuint32_t time;\n")
+		ctypes := map[string]string{"u16": "uint16_t", "u32": "uint32_t", "f32": "float"}
+		for _, f := range binLayout {
+			fmt.Fprintf(&b, "\t%s %s;\n", ctypes[f.Type], f.Name)
+		}
+		fmt.Fprintf(&b, "} futura_snapshot_t;\n")
+		w.Write(b.Bytes())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	resp := struct {
+		Size      int        `json:"size"`
+		LayoutCRC uint32     `json:"layout_crc"`
+		Fields    []binField `json:"fields"`
+	}{binSize, binLayoutCRC, binLayout}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("encode snapshot layout json: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/simonvetter/modbus"
+)
+
+// Bitmask registers (ExtSensInvalidate and the like) are changed with a
+// read-modify-write on the server, so clients never need the current mask
+// and two clients changing different bits don't undo each other.
+
+// bitsMu serializes the read-modify-writes
+var bitsMu sync.Mutex
+
+// bitsRequest is the body of /api/write-bits
+type bitsRequest struct {
+	Field     string `json:"field"`
+	SetBits   []uint `json:"set_bits"`
+	ClearBits []uint `json:"clear_bits"`
+}
+
+// bitmaskField returns the address of a field that can hold a bitmask: a
+// writable unsigned single register without scaling
+func bitmaskField(field string) (uint16, error) {
+	spec, ok := futura.WriteableFields[field]
+	if !ok {
+		return 0, fmt.Errorf("unknown or not-writable field: %s", field)
+	}
+	if spec.RegCount != 1 || spec.Scale != 1 || spec.Signed {
+		return 0, fmt.Errorf("field %s is not a bitmask register", field)
+	}
+	return spec.Addr, nil
+}
+
+// bitMask turns bit numbers into a mask
+func bitMask(bits []uint) (uint16, error) {
+	var m uint16
+	for _, b := range bits {
+		if b > 15 {
+			return 0, fmt.Errorf("bit %d out of range 0-15", b)
+		}
+		m |= 1 << b
+	}
+	return m, nil
+}
+
+// updateBits sets and clears bits of a bitmask field and returns the new
+// value. Nothing is written when the value doesn't change. r may be nil.
+func updateBits(r *http.Request, client *ModbusConn, field string, set, clear uint16) (uint16, error) {
+	addr, err := bitmaskField(field)
+	if err != nil {
+		return 0, err
+	}
+	if set&clear != 0 {
+		return 0, fmt.Errorf("bits 0x%04X are both set and cleared", set&clear)
+	}
+	bitsMu.Lock()
+	defer bitsMu.Unlock()
+	regs, err := client.ReadRegisters(addr, 1, modbus.HOLDING_REGISTER)
+	if err != nil {
+		return 0, fmt.Errorf("read %s: %w", field, err)
+	}
+	mask := regs[0]&^clear | set
+	if mask == regs[0] {
+		return mask, nil
+	}
+	if err := checkWritePolicy(r, field, float64(mask)); err != nil {
+		return 0, err
+	}
+	if err := WriteSingleRegister(client, field, float64(mask), requestOrigin(r, "api")); err != nil {
+		return 0, err
+	}
+	return mask, nil
+}
+
+// handleWriteBits sets and clears bits of a bitmask holding register:
+// POST {"field":"ExtSensInvalidate3","set_bits":[0],"clear_bits":[2]}
+func handleWriteBits(client *ModbusConn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodPost {
+			fmt.Fprintf(w, `{"success":false,"error":"POST required"}`)
+			return
+		}
+		if activeProfile.Decoder != DecoderFutura {
+			fmt.Fprintf(w, `{"success":false,"error":"profile %s does not support writes"}`, activeProfile.Name)
+			return
+		}
+		var req bitsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":"Invalid JSON"}`)
+			return
+		}
+		set, err := bitMask(req.SetBits)
+		var clear uint16
+		if err == nil {
+			clear, err = bitMask(req.ClearBits)
+		}
+		var value uint16
+		if err == nil {
+			value, err = updateBits(r, client, req.Field, set, clear)
+		}
+		if err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+			return
+		}
+		resp := struct {
+			Success bool   `json:"success"`
+			Field   string `json:"field"`
+			Value   uint16 `json:"value"`
+		}{true, req.Field, value}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("encode write bits json: %v", err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Volumetric heat capacity of air in Wh/(m3*K)
+const airHeatCapacity = 0.335
+
+// Bypass event types
+const (
+	EventBypassOpened = "bypass_opened"
+	EventBypassClosed = "bypass_closed"
+)
+
+var (
+	bypassOpenGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "bypass_open",
+		Help: "Heat exchanger bypass state (1=open)",
+	})
+	bypassOpenSeconds = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "bypass_open_seconds_total",
+		Help: "Total time the bypass was open (s)",
+	})
+	bypassFreeCoolingKWh = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "bypass_free_cooling_kwh_total",
+		Help: "Estimated free-cooling energy gained with open bypass (kWh)",
+	})
+)
+
+func RegisterBypassMetrics() {
+	prometheus.MustRegister(bypassOpenGauge, bypassOpenSeconds, bypassFreeCoolingKWh)
+}
+
+// bypassTracker measures how long the bypass stays open or closed
+type bypassTracker struct {
+	known bool
+	open  bool
+	since time.Time
+	last  time.Time
+}
+
+var bypass bypassTracker
+
+// update accounts the time since the previous poll to the previous state and
+// logs state changes with the duration of the state that ended
+func (b *bypassTracker) update(r futura.InputRegs, now time.Time) {
+	open := r.FutMode&futura.FutModeBypass != 0
+	if open {
+		bypassOpenGauge.Set(1)
+	} else {
+		bypassOpenGauge.Set(0)
+	}
+
+	if b.known && b.open {
+		dt := now.Sub(b.last)
+		bypassOpenSeconds.Add(dt.Seconds())
+
+		// cooling power of outdoor air replacing warmer indoor air
+		var kwh float64
+		if dT := r.TempIndoor - r.TempAmbient; dT > 0 {
+			kwh = float64(r.AirFlow) * airHeatCapacity * dT * dt.Hours() / 1000
+			bypassFreeCoolingKWh.Add(kwh)
+		}
+		updateReport(now, func(rep *MonthlyReport) {
+			rep.BypassOpenHours += dt.Hours()
+			rep.FreeCoolingKWh += kwh
+		})
+	}
+
+	if !b.known || open != b.open {
+		if b.known {
+			state, typ := "closed", EventBypassOpened
+			if b.open {
+				state, typ = "open", EventBypassClosed
+			}
+			log.Printf("Bypass was %s for %s", state, now.Sub(b.since).Round(time.Second))
+			emitEvent(Event{Type: typ, Source: "bypass", Data: map[string]interface{}{
+				"previous_duration_seconds": now.Sub(b.since).Seconds(),
+			}})
+		}
+		b.since = now
+	}
+	b.known, b.open, b.last = true, open, now
+}
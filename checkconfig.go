@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/simonvetter/modbus"
+)
+
+// check-config validates the configuration without starting the exporter,
+// for CI and provisioning: the config file (including rules), the profile
+// and its register ranges, the schedule file and, with -connect, the unit
+// itself (read-only, the startup self-test). The exit status is 1 when a
+// check fails.
+
+func runCheckConfig(args []string) int {
+	fs := flag.NewFlagSet("check-config", flag.ExitOnError)
+	fs.StringVar(flagConfig, "config", "", "Path to YAML config file")
+	fs.StringVar(flagSecretKeyFile, "secret-key-file", "", "File with the key for enc: values in the config (default $"+secretKeyEnv+")")
+	fs.StringVar(flagProfile, "profile", "futura", "Device profile: embedded profile name or path to a YAML profile")
+	fs.StringVar(flagScheduleFile, "schedule-file", "", "Ventilation schedule file to check")
+	fs.UintVar(flagInputMaxAddr, "input-max-addr", 0, "Max input register address (0 = profile default)")
+	fs.UintVar(flagHoldingMaxAddr, "holding-max-addr", 0, "Max holding register address (0 = profile default)")
+	fs.StringVar(flagInputRanges, "input-ranges", "", "Input registers to poll, e.g. 0-40,60-90")
+	fs.StringVar(flagHoldingRanges, "holding-ranges", "", "Holding registers to poll, e.g. 0-20,200-300")
+	fs.StringVar(flagUnitHost, "host", "", "Modbus host or IP (default: device.host from the config)")
+	fs.UintVar(flagUnitPort, "port", 502, "Modbus port")
+	fs.UintVar(flagSlaveID, "slave-id", 1, "Modbus slave ID (0-255)")
+	fs.UintVar(flagMaxBlockSize, "max-block-size", 125, "Max registers per Modbus read")
+	connect := fs.Bool("connect", false, "Also connect to the unit and run the self-test (reads only)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gofutura check-config [-config FILE] [-profile P] [-schedule-file FILE] [-connect]\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	failed := false
+	report := func(name string, err error) bool {
+		if err != nil {
+			fmt.Printf("%-10s FAILED: %v\n", name, err)
+			failed = true
+			return false
+		}
+		fmt.Printf("%-10s ok\n", name)
+		return true
+	}
+
+	cfg, err := loadConfig(*flagConfig, *flagSecretKeyFile)
+	if report("config", err) {
+		currentConfig.Store(cfg)
+		applyDeviceConfig(fs, cfg.Device)
+	}
+
+	profile, err := loadProfile(*flagProfile)
+	if report("profile", err) {
+		report("ranges", applyRanges(profile, appConfig().Device))
+	}
+
+	if *flagScheduleFile != "" {
+		report("schedule", loadSchedule(*flagScheduleFile))
+	}
+
+	if *connect && profile != nil {
+		report("device", checkDevice(profile))
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// checkDevice connects to the unit and runs the self-test
+func checkDevice(p *Profile) error {
+	switch {
+	case *flagUnitHost == "":
+		return fmt.Errorf("no host: pass -host or set device.host")
+	case *flagSlaveID > 255:
+		return fmt.Errorf("slave-id %d exceeds 255", *flagSlaveID)
+	case *flagMaxBlockSize == 0 || *flagMaxBlockSize > 125:
+		return fmt.Errorf("max-block-size must be 1-125")
+	}
+	mc, err := modbus.NewClient(&modbus.ClientConfiguration{
+		URL:     fmt.Sprintf("tcp://%s:%d", *flagUnitHost, *flagUnitPort),
+		Timeout: 5 * time.Second,
+	})
+	if err == nil {
+		err = mc.SetUnitId(uint8(*flagSlaveID))
+	}
+	if err == nil {
+		err = mc.Open()
+	}
+	if err != nil {
+		return fmt.Errorf("connect %s: %w", *flagUnitHost, err)
+	}
+	client := NewModbusConn(mc)
+	defer client.Close()
+
+	rep := runSelfTest(client, p, uint16(*flagMaxBlockSize))
+	for _, c := range rep.Checks {
+		status := "ok"
+		if !c.OK {
+			status = "FAILED"
+		}
+		if c.Detail != "" {
+			fmt.Printf("  %s: %s (%s)\n", c.Name, status, c.Detail)
+		} else {
+			fmt.Printf("  %s: %s\n", c.Name, status)
+		}
+	}
+	if !rep.Passed {
+		return fmt.Errorf("self-test failed")
+	}
+	return nil
+}
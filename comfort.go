@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ComfortConfig defines the comfort bands used for time-in-range tracking
+type ComfortConfig struct {
+	TempMin float64 `yaml:"temp_min"` // °C
+	TempMax float64 `yaml:"temp_max"` // °C
+	Co2Max  float64 `yaml:"co2_max"`  // ppm
+}
+
+// defaultComfort is used when the config has no comfort section
+var defaultComfort = ComfortConfig{TempMin: 20, TempMax: 25, Co2Max: 1000}
+
+// ComfortStats is today's time-in-range of one zone and band
+type ComfortStats struct {
+	Zone            string  `json:"zone"`
+	Kind            string  `json:"kind"` // temp or co2
+	InRangeSeconds  float64 `json:"in_range_seconds"`
+	ObservedSeconds float64 `json:"observed_seconds"`
+	Ratio           float64 `json:"ratio"`
+}
+
+var (
+	comfortInRange = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "comfort_in_range_seconds_total",
+		Help: "Time a zone spent within its comfort band (s)",
+	}, []string{"zone", "kind"})
+	comfortObserved = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "comfort_observed_seconds_total",
+		Help: "Time a zone was observed for comfort tracking (s)",
+	}, []string{"zone", "kind"})
+	comfortRatio = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "comfort_in_range_ratio",
+		Help: "Fraction of today a zone spent within its comfort band",
+	}, []string{"zone", "kind"})
+)
+
+func RegisterComfortMetrics() {
+	prometheus.MustRegister(comfortInRange, comfortObserved, comfortRatio)
+}
+
+// comfortTracker accumulates daily time-in-range per zone
+type comfortTracker struct {
+	mu    sync.Mutex
+	day   string
+	last  time.Time
+	stats map[string]*ComfortStats // key zone/kind
+}
+
+var comfort = &comfortTracker{stats: map[string]*ComfortStats{}}
+
+func comfortBands() ComfortConfig {
+	if appConfig().Comfort != nil {
+		return *appConfig().Comfort
+	}
+	return defaultComfort
+}
+
+// update accounts the time since the previous poll to each zone's current state
+func (c *comfortTracker) update(r futura.InputRegs, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if day := now.In(appLocation).Format("2006-01-02"); day != c.day {
+		c.day = day
+		c.stats = map[string]*ComfortStats{}
+		comfortRatio.Reset()
+	}
+	if c.last.IsZero() {
+		c.last = now
+		return
+	}
+	dt := now.Sub(c.last).Seconds()
+	c.last = now
+
+	bands := comfortBands()
+	for _, z := range zoneReadings(r) {
+		c.account(z.Zone, "temp", z.Temp >= bands.TempMin && z.Temp <= bands.TempMax, dt)
+		if z.Co2 != 0 {
+			c.account(z.Zone, "co2", z.Co2 <= bands.Co2Max, dt)
+		}
+	}
+}
+
+func (c *comfortTracker) account(zone, kind string, inRange bool, dt float64) {
+	key := zone + "/" + kind
+	st, ok := c.stats[key]
+	if !ok {
+		st = &ComfortStats{Zone: zone, Kind: kind}
+		c.stats[key] = st
+	}
+	st.ObservedSeconds += dt
+	comfortObserved.WithLabelValues(zone, kind).Add(dt)
+	if inRange {
+		st.InRangeSeconds += dt
+		comfortInRange.WithLabelValues(zone, kind).Add(dt)
+	}
+	st.Ratio = st.InRangeSeconds / st.ObservedSeconds
+	comfortRatio.WithLabelValues(zone, kind).Set(st.Ratio)
+}
+
+// handleComfort returns today's time-in-range statistics
+func handleComfort(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	comfort.mu.Lock()
+	out := []ComfortStats{}
+	for _, st := range comfort.stats {
+		out = append(out, *st)
+	}
+	comfort.mu.Unlock()
+
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		log.Printf("encode comfort json: %v", err)
+	}
+}
+
+func (c *ComfortConfig) validate() error {
+	if c.TempMin >= c.TempMax {
+		return fmt.Errorf("comfort: temp_min must be lower than temp_max")
+	}
+	if c.Co2Max <= 0 {
+		return fmt.Errorf("comfort: co2_max must be positive")
+	}
+	return nil
+}
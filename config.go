@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the optional YAML configuration file passed with -config
+type Config struct {
+	Device    DeviceConfig     `yaml:"device"`
+	Dashboard []DashboardGroup `yaml:"dashboard"`
+	Intents   IntentConfig     `yaml:"intents"`
+	Webhooks  []WebhookConfig  `yaml:"webhooks"`
+	MQTT      MQTTConfig       `yaml:"mqtt"`
+	Telegram  TelegramConfig   `yaml:"telegram"`
+	WebPush   WebPushConfig    `yaml:"web_push"`
+
+	DigitalInputs []DigitalInputConfig `yaml:"digital_inputs"`
+	AnalogInputs  AnalogInputsConfig   `yaml:"analog_inputs"`
+	Comfort       *ComfortConfig       `yaml:"comfort"`
+	Vacation      VacationConfig       `yaml:"vacation"`
+	Guest         GuestConfig          `yaml:"guest"`
+	Rules         []RuleConfig         `yaml:"rules"`
+	DesiredState  DesiredStateConfig   `yaml:"desired_state"`
+	Seasons       SeasonsConfig        `yaml:"seasons"`
+
+	Auth           AuthConfig           `yaml:"auth"`
+	SignedRequests SignedRequestsConfig `yaml:"signed_requests"`
+	WritePolicy    WritePolicyConfig    `yaml:"write_policy"`
+	LANRecovery    LANRecoveryConfig    `yaml:"lan_recovery"`
+	PowerAlarm     PowerAlarmConfig     `yaml:"power_alarm"`
+	Polling        PollingConfig        `yaml:"polling"`
+	GrafanaLive    GrafanaLiveConfig    `yaml:"grafana_live"`
+	Federation     FederationConfig     `yaml:"federation"`
+	Management     ManagementConfig     `yaml:"management"`
+	Updates        UpdatesConfig        `yaml:"updates"`
+}
+
+// DashboardGroup is a titled card of tiles on the main UI page
+type DashboardGroup struct {
+	Title string          `yaml:"title"`
+	Tiles []DashboardTile `yaml:"tiles"`
+}
+
+// DashboardTile shows a single field from /api/read-input (or the holding
+// registers when the field is not an input)
+type DashboardTile struct {
+	Field    string `yaml:"field"`
+	Index    int    `yaml:"index"` // 1-based instance for array fields (e.g. AlfaTemp)
+	Label    string `yaml:"label"`
+	Unit     string `yaml:"unit"`
+	Decimals int    `yaml:"decimals"`
+}
+
+// currentConfig holds the loaded configuration; it is empty when no file is
+// given. A reload swaps it while the poll loop and handlers read it.
+var currentConfig atomic.Pointer[Config]
+
+func init() {
+	currentConfig.Store(&Config{})
+}
+
+// appConfig returns the current configuration
+func appConfig() *Config {
+	return currentConfig.Load()
+}
+
+func loadConfig(path, secretKeyFile string) (*Config, error) {
+	cfg := &Config{}
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	if err := resolveSecrets(&doc, secretKeyFile); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	if err := doc.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c *Config) validate() error {
+	for gi, g := range c.Dashboard {
+		for ti := range g.Tiles {
+			t := &c.Dashboard[gi].Tiles[ti]
+			if t.Field == "" {
+				return fmt.Errorf("dashboard group %q tile %d has no field", g.Title, ti)
+			}
+			if t.Index < 0 {
+				return fmt.Errorf("dashboard tile %s has negative index", t.Field)
+			}
+			if t.Label == "" {
+				t.Label = t.Field
+			}
+		}
+	}
+	for i, h := range c.Webhooks {
+		if h.URL == "" {
+			return fmt.Errorf("webhook %d has no url", i)
+		}
+		if err := validateTemplate(fmt.Sprintf("webhook %d", i), h.Template, h.TemplateFile); err != nil {
+			return err
+		}
+	}
+	if err := validateTemplate("mqtt", c.MQTT.Template, c.MQTT.TemplateFile); err != nil {
+		return err
+	}
+	if c.Telegram.Token != "" && c.Telegram.ChatID == "" {
+		return fmt.Errorf("telegram.chat_id is required")
+	}
+	if err := validateTemplate("telegram", c.Telegram.Template, c.Telegram.TemplateFile); err != nil {
+		return err
+	}
+	if err := c.WebPush.validate(); err != nil {
+		return err
+	}
+	if err := validateDigitalInputs(c.DigitalInputs); err != nil {
+		return err
+	}
+	if err := c.AnalogInputs.validate(); err != nil {
+		return err
+	}
+	if c.Comfort != nil {
+		if err := c.Comfort.validate(); err != nil {
+			return err
+		}
+	}
+	if err := validateRules(c.Rules); err != nil {
+		return err
+	}
+	if err := c.DesiredState.validate(); err != nil {
+		return err
+	}
+	if err := c.Seasons.validate(); err != nil {
+		return err
+	}
+	for name, secret := range c.SignedRequests.Keys {
+		if len(secret) < 16 {
+			return fmt.Errorf("signed_requests key %q: secret must be at least 16 characters", name)
+		}
+	}
+	if err := c.Auth.validate(); err != nil {
+		return err
+	}
+	if err := c.WritePolicy.validate(); err != nil {
+		return err
+	}
+	if err := c.LANRecovery.validate(); err != nil {
+		return err
+	}
+	if err := c.PowerAlarm.validate(); err != nil {
+		return err
+	}
+	if err := c.Polling.validate(); err != nil {
+		return err
+	}
+	if err := c.GrafanaLive.validate(); err != nil {
+		return err
+	}
+	if err := c.Federation.validate(); err != nil {
+		return err
+	}
+	if err := c.Device.validate(); err != nil {
+		return err
+	}
+	if err := c.Management.validate(); err != nil {
+		return err
+	}
+	if c.Guest.MaxHours < 0 {
+		return fmt.Errorf("guest.max_hours must not be negative")
+	}
+	if c.Intents.Listen != "" {
+		if len(c.Intents.Tokens) == 0 {
+			return fmt.Errorf("intents.listen requires at least one token")
+		}
+		if c.Intents.TLSCert == "" || c.Intents.TLSKey == "" {
+			return fmt.Errorf("intents.listen requires tls_cert and tls_key")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/simonvetter/modbus"
+)
+
+// Lazy connect: the unit may come up after the exporter, e.g. after a power
+// outage. Instead of exiting, the HTTP server starts right away and the
+// first connection is retried in the background with exponential backoff.
+// Until it succeeds polls are skipped, writes are refused and /readyz
+// answers 503.
+//
+// connState is the one state machine of the connection: connecting until
+// the first connection is established, then connected while polls reach
+// the unit and disconnected while they don't. /readyz and
+// futura_connected{state} follow it.
+
+// connectRetryMin is the pause after the first failed connection attempt;
+// it doubles up to -connect-retry-max
+const connectRetryMin = time.Second
+
+// reopenPause is how long a lost connection stays closed before reopening,
+// which gives the LAN module time to drop it
+const reopenPause = 500 * time.Millisecond
+
+// retryDelay is the pause after n consecutive failed attempts
+func retryDelay(n int) time.Duration {
+	limit := max(*flagConnectRetry, connectRetryMin)
+	d := connectRetryMin
+	for i := 1; i < n && d < limit; i++ {
+		d *= 2
+	}
+	return min(d, limit)
+}
+
+var errNotConnected = errors.New("writes disabled: not connected to the unit yet (see /readyz)")
+
+// connState is the state of the connection to the unit
+type connState struct {
+	Ready     bool       `json:"ready"` // State is connected
+	State     string     `json:"state"` // connecting, connected or disconnected
+	Since     time.Time  `json:"since"`
+	Attempts  int        `json:"attempts"` // to establish the first connection
+	LastError string     `json:"last_error,omitempty"`
+	NextRetry *time.Time `json:"next_retry,omitempty"`
+}
+
+var (
+	connMu sync.Mutex
+	conn   = connState{State: "connecting", Since: time.Now()}
+
+	connectedGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "futura_connected",
+		Help: "1 for the current state of the connection to the unit (connecting, connected or disconnected), 0 for the others",
+	}, []string{"state"})
+	connectAttemptsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "futura_modbus_connect_attempts_total",
+		Help: "Attempts to establish the first connection to the unit, by result",
+	}, []string{"result"})
+	reconnectsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "futura_modbus_reconnects_total",
+		Help: "Attempts to reopen a failed connection to the unit",
+	})
+	circuitOpenGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "futura_modbus_circuit_open",
+		Help: "Connections to the unit that failed and are left alone until their next reconnect attempt",
+	})
+)
+
+func RegisterConnectMetrics() {
+	prometheus.MustRegister(connectedGauge, connectAttemptsTotal, reconnectsTotal, circuitOpenGauge)
+	connMu.Lock()
+	setConnectedGauge(conn.State)
+	connMu.Unlock()
+}
+
+// setConnectedGauge sets futura_connected to 1 for state and 0 for the
+// other states
+func setConnectedGauge(state string) {
+	for _, s := range []string{"connecting", "connected", "disconnected"} {
+		if s == state {
+			connectedGauge.WithLabelValues(s).Set(1)
+		} else {
+			connectedGauge.WithLabelValues(s).Set(0)
+		}
+	}
+}
+
+// connectionOpened reports whether the first connection to the unit has
+// been established, even if it's lost at the moment
+func connectionOpened() bool {
+	connMu.Lock()
+	defer connMu.Unlock()
+	return conn.State != "connecting"
+}
+
+// setConnState moves to state; err and next are the reason and the next
+// attempt while not connected. It reports whether the state changed.
+// connMu must be held.
+func setConnState(state string, err error, next time.Time) bool {
+	changed := conn.State != state
+	if changed {
+		conn.State, conn.Since = state, time.Now()
+	}
+	conn.Ready = state == "connected"
+	conn.LastError, conn.NextRetry = "", nil
+	if err != nil {
+		conn.LastError = err.Error()
+	}
+	if !next.IsZero() {
+		conn.NextRetry = &next
+	}
+	setConnectedGauge(state)
+	return changed
+}
+
+func noteConnectAttempt(err error, next time.Time) {
+	connMu.Lock()
+	defer connMu.Unlock()
+	conn.Attempts++
+	if err == nil {
+		setConnState("connected", nil, time.Time{})
+		connectAttemptsTotal.WithLabelValues("ok").Inc()
+		return
+	}
+	setConnState("connecting", err, next)
+	connectAttemptsTotal.WithLabelValues("error").Inc()
+}
+
+// noteConnection records whether the last poll reached the unit, err is
+// why it didn't, and tells stream clients when that changes
+func noteConnection(err error, next time.Time) {
+	connMu.Lock()
+	if conn.State == "connecting" {
+		connMu.Unlock()
+		return
+	}
+	state := "connected"
+	if err != nil {
+		state = "disconnected"
+	}
+	changed := setConnState(state, err, next)
+	c := sseConnection{conn.State, conn.Since}
+	connMu.Unlock()
+	if changed {
+		publishConnection(c)
+	}
+}
+
+// currentConnection returns the state for stream clients, ok false while
+// connecting
+func currentConnection() (c sseConnection, ok bool) {
+	connMu.Lock()
+	defer connMu.Unlock()
+	return sseConnection{conn.State, conn.Since}, conn.State != "connecting"
+}
+
+// breaker is the circuit breaker of a connection. When a read fails and
+// reopening the connection doesn't help, it opens: reads return at once
+// without touching the unit and polls are skipped. After a pause that
+// doubles with every failed attempt, up to -connect-retry-max, the next
+// read tries again; success closes the breaker.
+type breaker struct {
+	mu       sync.Mutex
+	failures int       // failed attempts in a row, 0 when closed
+	retryAt  time.Time // reads wait until then while open
+	lastErr  error     // of the last failed attempt
+}
+
+// open reports whether reads must wait for the next attempt
+func (b *breaker) open() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures > 0 && time.Now().Before(b.retryAt)
+}
+
+// failing reports whether the last attempt failed, so the next read is one
+func (b *breaker) failing() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures > 0
+}
+
+// trip records a failed attempt and returns the pause before the next
+func (b *breaker) trip(err error) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures == 0 {
+		circuitOpenGauge.Inc()
+	}
+	b.failures++
+	d := retryDelay(b.failures)
+	b.retryAt = time.Now().Add(d)
+	b.lastErr = err
+	return d
+}
+
+// status returns the time of the next attempt and the error of the last
+// while open, nothing while closed
+func (b *breaker) status() (time.Time, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures == 0 {
+		return time.Time{}, nil
+	}
+	return b.retryAt, b.lastErr
+}
+
+// reset closes the breaker and returns the failed attempts before
+func (b *breaker) reset() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := b.failures
+	if n > 0 {
+		circuitOpenGauge.Dec()
+	}
+	b.failures = 0
+	return n
+}
+
+// modbusException reports whether err is an exception response: the unit
+// answered, so the connection is fine and reopening it doesn't help
+func modbusException(err error) bool {
+	for _, e := range []error{
+		modbus.ErrIllegalFunction, modbus.ErrIllegalDataAddress, modbus.ErrIllegalDataValue,
+		modbus.ErrServerDeviceFailure, modbus.ErrAcknowledge, modbus.ErrServerDeviceBusy,
+		modbus.ErrMemoryParityError, modbus.ErrGWPathUnavailable, modbus.ErrGWTargetFailedToRespond,
+	} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// connectDevice retries opening client after the first attempt failed,
+// with exponential backoff, and calls onConnect once it succeeds
+func connectDevice(client *ModbusConn, onConnect func()) {
+	for n := 1; ; n++ {
+		time.Sleep(retryDelay(n))
+		delay := retryDelay(n + 1)
+		if err := client.Open(); err != nil {
+			log.Printf("Connecting to the unit failed: %v; next attempt in %s", err, delay)
+			noteConnectAttempt(err, time.Now().Add(delay))
+			continue
+		}
+		log.Printf("Connected to the unit")
+		onConnect()
+		noteConnectAttempt(nil, time.Time{})
+		return
+	}
+}
+
+// handleReadyz answers 200 while polls reach the unit, 503 while connecting
+// and while the connection is lost
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	connMu.Lock()
+	st := conn
+	connMu.Unlock()
+	if !st.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(st); err != nil {
+		log.Printf("encode readyz json: %v", err)
+	}
+}
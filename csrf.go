@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Protection of the web UI against cross-site requests. Every response gets
+// security headers (CSP, nosniff, framing). Write requests from browsers,
+// recognized by their Origin or Sec-Fetch-Site header, must come from the
+// same origin and carry the token of the gofutura_csrf cookie in
+// X-CSRF-Token; the UI pages send it with every write. Scripts and
+// integrations send neither header and are not affected.
+
+const (
+	csrfCookie = "gofutura_csrf"
+	csrfHeader = "X-CSRF-Token"
+)
+
+// csrfToken is issued to browsers in csrfCookie; it changes on restart, so
+// pages opened before need a reload
+var csrfToken = func() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("generate CSRF token: %v", err)
+	}
+	return hex.EncodeToString(b)
+}()
+
+// securityHeaders sets the headers of every response. The pages use inline
+// scripts and styles, so those are allowed; everything else must come from
+// this server. frameAncestors is the CSP frame-ancestors source list.
+func securityHeaders(next http.Handler, frameAncestors string) http.Handler {
+	csp := "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; " +
+		"img-src 'self' data:; connect-src 'self'; base-uri 'self'; form-action 'self'; " +
+		"frame-ancestors " + frameAncestors
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Security-Policy", csp)
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "same-origin")
+		if frameAncestors == "'self'" {
+			// for browsers without frame-ancestors
+			h.Set("X-Frame-Options", "SAMEORIGIN")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sameOrigin reports whether a browser request was made by a page of this
+// server. Requests without Origin and Sec-Fetch-Site aren't from a browser
+// and pass.
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		// includes "null" from sandboxed frames and file: pages
+		return false
+	}
+	host := r.Host
+	if fwd := r.Header.Get("X-Forwarded-Host"); fwd != "" {
+		// behind a reverse proxy that rewrites Host
+		host = strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+	return strings.EqualFold(u.Host, host)
+}
+
+// fromBrowser reports whether a request was sent by a browser
+func fromBrowser(r *http.Request) bool {
+	return r.Header.Get("Origin") != "" || r.Header.Get("Sec-Fetch-Site") != ""
+}
+
+// issueCSRFCookie hands the token to pages that don't have it yet
+func issueCSRFCookie(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(csrfCookie); err == nil && c.Value == csrfToken {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    csrfToken,
+		Path:     "/",
+		SameSite: http.SameSiteStrictMode,
+		Secure:   r.TLS != nil,
+		// not HttpOnly: the pages read it to send it back in csrfHeader
+	})
+}
+
+// csrfProtect rejects cross-site writes and browser writes without the
+// token. The WebSocket channel can't send headers; its upgrade is checked
+// for the origin only. Endpoints with their own tokens are exempt from the
+// token but not from the origin check.
+func csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isWriteRequest(r) {
+			if r.Method == http.MethodGet && !strings.HasPrefix(r.URL.Path, "/api/") {
+				issueCSRFCookie(w, r)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		reject := ""
+		switch {
+		case signedBy(r) != "":
+		case !sameOrigin(r):
+			reject = "cross-origin request"
+		case r.URL.Path == "/api/ws" || authExempt(r.URL.Path) || !fromBrowser(r):
+		case subtle.ConstantTimeCompare([]byte(r.Header.Get(csrfHeader)), []byte(csrfToken)) != 1:
+			reject = "missing or invalid CSRF token, reload the page"
+		}
+		if reject != "" {
+			log.Printf("Rejected %s %s from %s (%s, origin %q)", r.Method, r.URL.Path, clientIP(r), reject, r.Header.Get("Origin"))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, reject)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
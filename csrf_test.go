@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	h := csrfProtect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		signed  bool
+		want    int
+	}{
+		{"read", http.MethodGet, "/api/read-input", map[string]string{"Origin": "http://evil.example"}, false, http.StatusOK},
+		{"script without browser headers", http.MethodPost, "/api/write-holding", nil, false, http.StatusOK},
+		{"browser with token", http.MethodPost, "/api/write-holding", map[string]string{"Origin": "http://futura.lan", csrfHeader: csrfToken}, false, http.StatusOK},
+		{"browser without token", http.MethodPost, "/api/write-holding", map[string]string{"Origin": "http://futura.lan"}, false, http.StatusForbidden},
+		{"browser with wrong token", http.MethodPost, "/api/write-holding", map[string]string{"Sec-Fetch-Site": "same-origin", csrfHeader: "0123456789abcdef"}, false, http.StatusForbidden},
+		{"cross origin with token", http.MethodPost, "/api/write-holding", map[string]string{"Origin": "http://evil.example", csrfHeader: csrfToken}, false, http.StatusForbidden},
+		{"cross site fetch", http.MethodPost, "/api/write-holding", map[string]string{"Sec-Fetch-Site": "cross-site", csrfHeader: csrfToken}, false, http.StatusForbidden},
+		{"null origin", http.MethodPost, "/api/write-holding", map[string]string{"Origin": "null", csrfHeader: csrfToken}, false, http.StatusForbidden},
+		{"behind proxy", http.MethodPost, "/api/write-holding", map[string]string{"Origin": "https://home.example", "X-Forwarded-Host": "home.example", csrfHeader: csrfToken}, false, http.StatusOK},
+		{"own token endpoint", http.MethodPost, "/api/guest/action", map[string]string{"Origin": "http://futura.lan"}, false, http.StatusOK},
+		{"websocket cross origin", http.MethodGet, "/api/ws", map[string]string{"Origin": "http://evil.example"}, false, http.StatusForbidden},
+		{"websocket same origin", http.MethodGet, "/api/ws", map[string]string{"Origin": "http://futura.lan"}, false, http.StatusOK},
+		{"signed", http.MethodPost, "/api/write-holding", map[string]string{"Origin": "http://evil.example"}, true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.Host = "futura.lan"
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if tt.signed {
+				r = r.WithContext(context.WithValue(r.Context(), signedKeyCtx{}, "nodered"))
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d (%s)", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
+
+func TestCSRFCookieIssued(t *testing.T) {
+	h := csrfProtect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/edit", nil))
+	var found bool
+	for _, c := range w.Result().Cookies() {
+		if c.Name == csrfCookie {
+			found = c.Value == csrfToken
+		}
+	}
+	if !found {
+		t.Fatalf("page response didn't set %s to the token", csrfCookie)
+	}
+}
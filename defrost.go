@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Defrost cycles: the register map has no documented defrost flag, so
+// cycles are estimated from the temperatures. Below freezing outdoors the
+// exchanger ices up once the exhaust air leaving it (TempWaste) cools to
+// about 0 °C; the unit then thaws it, and the exhaust warms up again. A
+// cycle lasts from the exhaust reaching defrostStartWaste until it is back
+// above defrostEndWaste. Frequent or long cycles at mild outdoor
+// temperatures point at ducting or installation problems (cold exhaust,
+// unbalanced flows).
+
+// Thresholds of the estimate (°C)
+const (
+	defrostMaxAmbient = 0.0 // cycles only start below freezing outdoors
+	defrostStartWaste = 1.0
+	defrostEndWaste   = 3.0
+)
+
+var (
+	defrostCycles = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "futura_defrost_cycles_total",
+		Help: "Completed defrost cycles",
+	})
+	defrostCyclesToday = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "futura_defrost_cycles_today",
+		Help: "Defrost cycles started today (local time)",
+	})
+	defrostDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "futura_defrost_duration_seconds",
+		Help:    "Duration of completed defrost cycles (s)",
+		Buckets: prometheus.ExponentialBuckets(60, 2, 7), // 1 min to 64 min
+	})
+	defrostSeconds = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "futura_defrost_seconds_total",
+		Help: "Total time spent defrosting (s)",
+	})
+)
+
+func RegisterDefrostMetrics() {
+	prometheus.MustRegister(defrostCycles, defrostCyclesToday, defrostDuration, defrostSeconds)
+}
+
+// defrostTracker counts defrost cycles and measures their duration
+type defrostTracker struct {
+	known    bool
+	active   bool
+	since    time.Time
+	last     time.Time
+	ambient  float64 // outdoor temperature at the start of the cycle
+	minWaste float64 // lowest exhaust temperature during the cycle
+
+	day   string // YYYY-MM-DD of today
+	today int    // cycles started today
+}
+
+var defrost defrostTracker
+
+// defrostActive estimates from the temperatures whether the exchanger is
+// being defrosted; active is the previous estimate, for the hysteresis
+func defrostActive(r futura.InputRegs, active bool) bool {
+	if active {
+		return r.TempWaste < defrostEndWaste
+	}
+	return r.TempAmbient < defrostMaxAmbient && r.TempWaste <= defrostStartWaste
+}
+
+// maxPollGap is the longest time between two polls accounted to a state;
+// after longer gaps (unit unreachable, breaker open, no scrapes) the time
+// in between is unknown
+func maxPollGap() time.Duration {
+	return 2 * max(*flagPollInterval, *flagIdlePoll)
+}
+
+// update accounts the time since the previous poll and counts cycles; a
+// cycle running at startup is timed but not counted as started today
+func (d *defrostTracker) update(r futura.InputRegs, now time.Time) {
+	active := defrostActive(r, d.known && d.active)
+
+	day := now.In(appLocation).Format("2006-01-02")
+	if day != d.day {
+		d.day, d.today = day, 0
+	}
+
+	if d.known && d.active {
+		dt := min(now.Sub(d.last), maxPollGap())
+		defrostSeconds.Add(dt.Seconds())
+		updateReport(now, func(rep *MonthlyReport) {
+			rep.DefrostHours += dt.Hours()
+		})
+		if r.TempWaste < d.minWaste {
+			d.minWaste = r.TempWaste
+		}
+	}
+
+	switch {
+	case active && (!d.known || !d.active):
+		d.since, d.ambient, d.minWaste = now, r.TempAmbient, r.TempWaste
+		if d.known {
+			d.today++
+			today := d.today
+			updateReport(now, func(rep *MonthlyReport) {
+				rep.DefrostCycles++
+				if today > rep.DefrostMaxPerDay {
+					rep.DefrostMaxPerDay = today
+				}
+			})
+		}
+	case !active && d.known && d.active:
+		dur := now.Sub(d.since)
+		defrostCycles.Inc()
+		defrostDuration.Observe(dur.Seconds())
+		log.Printf("Defrost cycle took %s (outdoor %.1f °C, exhaust down to %.1f °C, %d today)",
+			dur.Round(time.Second), d.ambient, d.minWaste, d.today)
+	}
+	defrostCyclesToday.Set(float64(d.today))
+	d.known, d.active, d.last = true, active, now
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Digital input event types
+const (
+	EventDigitalInputOn  = "digital_input_on"
+	EventDigitalInputOff = "digital_input_off"
+)
+
+// DigitalInputConfig names a bit of the DigInputs register
+type DigitalInputConfig struct {
+	Bit    uint   `yaml:"bit"`
+	Name   string `yaml:"name"`
+	Invert bool   `yaml:"invert"` // treat a cleared bit as on (normally closed contacts)
+}
+
+var digitalInputGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "digital_input",
+	Help: "State of named digital inputs (1=on)",
+}, []string{"name"})
+
+func RegisterDigitalInputMetrics() {
+	prometheus.MustRegister(digitalInputGauge)
+}
+
+func digitalInputOn(cfg DigitalInputConfig, digInputs uint16) bool {
+	on := digInputs&(1<<cfg.Bit) != 0
+	return on != cfg.Invert
+}
+
+// updateDigitalInputs exports the state of each named input and emits
+// events on edges (prev is nil on the first poll)
+func updateDigitalInputs(prev *futura.InputRegs, cur futura.InputRegs) {
+	for _, in := range appConfig().DigitalInputs {
+		on := digitalInputOn(in, cur.DigInputs)
+		v := 0.0
+		if on {
+			v = 1
+		}
+		digitalInputGauge.WithLabelValues(in.Name).Set(v)
+
+		if prev == nil || digitalInputOn(in, prev.DigInputs) == on {
+			continue
+		}
+		typ := EventDigitalInputOff
+		if on {
+			typ = EventDigitalInputOn
+		}
+		emitEvent(Event{
+			Type:   typ,
+			Source: in.Name,
+			Data:   map[string]interface{}{"bit": in.Bit},
+		})
+	}
+}
+
+func validateDigitalInputs(cfg []DigitalInputConfig) error {
+	names := map[string]bool{}
+	for _, in := range cfg {
+		if in.Name == "" {
+			return fmt.Errorf("digital input bit %d has no name", in.Bit)
+		}
+		if in.Bit > 15 {
+			return fmt.Errorf("digital input %s: bit %d out of range 0-15", in.Name, in.Bit)
+		}
+		if names[in.Name] {
+			return fmt.Errorf("duplicate digital input name %q", in.Name)
+		}
+		names[in.Name] = true
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/danielkucera/gofutura/futura"
+)
+
+// DisplayV1 is the /api/display payload for DIY e-ink/LCD displays. Its
+// shape is frozen: fields are never removed, renamed or retyped. Anything
+// that needs that gets a new version next to it (?v=2).
+type DisplayV1 struct {
+	Version       int     `json:"version"` // always 1
+	Seq           uint64  `json:"seq"`
+	Time          int64   `json:"time"`        // unix seconds of the poll
+	TempIndoor    float64 `json:"temp_indoor"` // °C
+	TempOutdoor   float64 `json:"temp_outdoor"`
+	HumiIndoor    float64 `json:"humi_indoor"` // %
+	CO2           float64 `json:"co2"`         // ppm, highest sensor, 0 without one
+	IAQ           string  `json:"iaq"`         // worst zone: green, amber, red; empty without sensors
+	FanLevel      int     `json:"fan_level"`   // 1-5, 6 = auto
+	Mode          string  `json:"mode"`        // normal, boost, party, night, circulation, overpressure, vacation
+	ModeRemaining int     `json:"mode_remaining"`
+	FilterWear    int     `json:"filter_wear"` // %
+	AirFlow       int     `json:"air_flow"`    // m3/h
+	Power         int     `json:"power"`       // W
+	Bypass        bool    `json:"bypass"`
+	Alarm         bool    `json:"alarm"` // the unit reports an error
+}
+
+// displayMode returns the running timed function and its remaining seconds
+func displayMode(hold futura.HoldingRegs) (string, int) {
+	for _, m := range []struct {
+		name string
+		tm   uint16
+	}{
+		{"boost", hold.FuncBoostTm},
+		{"party", hold.FuncPartyTm},
+		{"night", hold.FuncNightTm},
+		{"circulation", hold.FuncCirculationTm},
+		{"overpressure", hold.FuncOverpressureTm},
+	} {
+		if m.tm > 0 {
+			return m.name, int(m.tm)
+		}
+	}
+	if vacationActive() {
+		return "vacation", 0
+	}
+	return "normal", 0
+}
+
+func buildDisplayV1(in futura.InputRegs, hold futura.HoldingRegs) DisplayV1 {
+	d := DisplayV1{
+		Version:     1,
+		Seq:         in.Seq,
+		Time:        in.Time.Unix(),
+		TempIndoor:  in.TempIndoor,
+		TempOutdoor: in.TempAmbient,
+		HumiIndoor:  in.HumiIndoor,
+		CO2:         maxCo2(in),
+		FanLevel:    int(hold.FuncVentilation),
+		FilterWear:  int(in.FilterWear),
+		AirFlow:     int(in.AirFlow),
+		Power:       int(in.PowerConsumption),
+		Bypass:      in.FutMode&futura.FutModeBypass != 0,
+		Alarm:       in.FutError != 0,
+	}
+	d.Mode, d.ModeRemaining = displayMode(hold)
+	worst := -1.0
+	for _, z := range ComputeIAQ(in) {
+		if level, n := iaqLevel(z.Score); n > worst {
+			d.IAQ, worst = level, n
+		}
+	}
+	return d
+}
+
+// handleDisplay returns the display subset of the latest poll
+func handleDisplay(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if v := r.URL.Query().Get("v"); v != "" && v != "1" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"success":false,"error":"unknown version %s"}`, v)
+		return
+	}
+	in, hold, ok := latestSnapshot()
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, `{"success":false,"error":"no data polled yet"}`)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(buildDisplayV1(in, hold)); err != nil {
+		log.Printf("encode display json: %v", err)
+	}
+}
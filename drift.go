@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Desired state: holding values the unit should have, e.g. heating enabled
+// from October to March. Every poll compares them with the unit; a field
+// that starts drifting emits a config_drift event for the notification
+// channels and, with correct: true, is written back once it has drifted
+// for the grace period. Drift caused by the exporter's own writers (seasons,
+// rules, the schedule) is reported but not corrected, so the desired state
+// doesn't fight them.
+
+// DesiredStateConfig declares the desired holding values
+type DesiredStateConfig struct {
+	Correct bool           `yaml:"correct"` // write drifted fields back
+	Grace   time.Duration  `yaml:"grace"`   // how long a field may drift before it's corrected (default 10m)
+	Fields  []DesiredValue `yaml:"fields"`
+}
+
+// DesiredValue is the value a writable field should have, optionally only
+// in some months
+type DesiredValue struct {
+	Field  string  `yaml:"field" json:"field"`
+	Value  float64 `yaml:"value" json:"value"`
+	Months []int   `yaml:"months" json:"months,omitempty"` // 1-12; every month when empty
+}
+
+// EventConfigDrift is emitted when a field starts to differ from its
+// desired value
+const EventConfigDrift = "config_drift"
+
+func (c DesiredStateConfig) validate() error {
+	if c.Grace < 0 {
+		return fmt.Errorf("desired_state.grace must not be negative")
+	}
+	seen := map[string][13]bool{} // field -> months already declared
+	for i, d := range c.Fields {
+		spec, ok := futura.WriteableFields[d.Field]
+		if !ok {
+			return fmt.Errorf("desired_state field %d: unknown field %q", i, d.Field)
+		}
+		if err := spec.Validate(d.Value); err != nil {
+			return fmt.Errorf("desired_state: %s %w", d.Field, err)
+		}
+		months := seen[d.Field]
+		for m := 1; m <= 12; m++ {
+			if !d.inMonth(time.Month(m)) {
+				continue
+			}
+			if months[m] {
+				return fmt.Errorf("desired_state: %s is declared twice for %s", d.Field, time.Month(m))
+			}
+			months[m] = true
+		}
+		for _, m := range d.Months {
+			if m < 1 || m > 12 {
+				return fmt.Errorf("desired_state: %s has invalid month %d", d.Field, m)
+			}
+		}
+		seen[d.Field] = months
+	}
+	return nil
+}
+
+// inMonth reports whether the value applies in month m
+func (d DesiredValue) inMonth(m time.Month) bool {
+	if len(d.Months) == 0 {
+		return true
+	}
+	for _, dm := range d.Months {
+		if time.Month(dm) == m {
+			return true
+		}
+	}
+	return false
+}
+
+// DriftStatus is the state of one field of the desired state
+type DriftStatus struct {
+	Field         string     `json:"field"`
+	Desired       float64    `json:"desired"`
+	Actual        *float64   `json:"actual"` // null until the field is read
+	Drifted       bool       `json:"drifted"`
+	Since         *time.Time `json:"since,omitempty"` // when it started drifting
+	LastCorrected *time.Time `json:"last_corrected,omitempty"`
+	LastError     string     `json:"last_error,omitempty"` // of the last correction
+	ChangedBy     string     `json:"changed_by,omitempty"` // season, rule or schedule when they caused the drift
+	matched       time.Time  // last poll the field had its desired value
+}
+
+// driftOwners are the writers that change settings on purpose
+var driftOwners = map[string]bool{"season": true, "rule": true, "schedule": true}
+
+var (
+	ownedMu     sync.Mutex
+	ownedWrites = map[string]ownedWrite{} // field -> last write by a driftOwner
+)
+
+type ownedWrite struct {
+	source string
+	at     time.Time
+}
+
+// noteOwnedWrite remembers writes of the driftOwners
+func noteOwnedWrite(field string, o origin) {
+	if !driftOwners[o.Source] {
+		return
+	}
+	ownedMu.Lock()
+	ownedWrites[field] = ownedWrite{o.Source, time.Now()}
+	ownedMu.Unlock()
+}
+
+// ownedSince returns the driftOwner that wrote field after t, if any
+func ownedSince(field string, t time.Time) string {
+	ownedMu.Lock()
+	defer ownedMu.Unlock()
+	if w, ok := ownedWrites[field]; ok && w.at.After(t) {
+		return w.source
+	}
+	return ""
+}
+
+var (
+	driftGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "futura_config_drift",
+		Help: "1 while a field differs from its desired value, 0 while it matches",
+	}, []string{"field"})
+	driftCorrections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "futura_config_drift_corrections_total",
+		Help: "Writes of drifted fields back to their desired value, by result",
+	}, []string{"field", "result"})
+)
+
+func RegisterDriftMetrics() {
+	prometheus.MustRegister(driftGauge, driftCorrections)
+}
+
+// driftWatcher compares the polled holding registers with the desired state
+type driftWatcher struct {
+	client *ModbusConn
+
+	mu     sync.Mutex
+	status map[string]*DriftStatus // field -> status of the value applying this month
+}
+
+var drift *driftWatcher
+
+func newDriftWatcher(client *ModbusConn) *driftWatcher {
+	return &driftWatcher{client: client, status: map[string]*DriftStatus{}}
+}
+
+func driftGrace() time.Duration {
+	if g := appConfig().DesiredState.Grace; g > 0 {
+		return g
+	}
+	return 10 * time.Minute
+}
+
+// check compares the holding registers of a poll with the values desired
+// this month, emits events for fields that started drifting and corrects
+// the ones past the grace period
+func (d *driftWatcher) check(holding map[uint16]uint16, now time.Time) {
+	cfg := appConfig().DesiredState
+	month := now.In(appLocation).Month()
+
+	var events []Event
+	var correct []DriftStatus
+	d.mu.Lock()
+	current := map[string]bool{}
+	for _, want := range cfg.Fields {
+		if !want.inMonth(month) {
+			continue
+		}
+		current[want.Field] = true
+		st := d.status[want.Field]
+		if st == nil || st.Desired != want.Value {
+			// a new field or another season's value
+			st = &DriftStatus{Field: want.Field, Desired: want.Value}
+			d.status[want.Field] = st
+		}
+		spec := futura.WriteableFields[want.Field]
+		word, ok := holding[spec.Addr]
+		if !ok {
+			continue
+		}
+		v := decodeField(spec, word)
+		st.Actual = &v
+		drifted := math.Abs(v-want.Value) >= spec.Scale/2
+		switch {
+		case drifted && !st.Drifted:
+			st.Drifted, st.Since = true, &now
+			log.Printf("Config drift: %s is %g, desired %g", want.Field, v, want.Value)
+			events = append(events, Event{Time: now, Type: EventConfigDrift, Source: want.Field, Data: map[string]interface{}{
+				"field": want.Field, "desired": want.Value, "actual": v,
+			}})
+		case !drifted && st.Drifted:
+			st.Drifted, st.Since, st.LastError, st.ChangedBy = false, nil, "", ""
+			log.Printf("Config drift: %s is back at %g", want.Field, v)
+		}
+		if !drifted {
+			st.matched = now
+		} else if by := ownedSince(want.Field, st.matched); by != st.ChangedBy {
+			st.ChangedBy = by
+			if by != "" {
+				log.Printf("Config drift: %s was changed by the %s, not correcting it", want.Field, by)
+			}
+		}
+		if st.Drifted {
+			driftGauge.WithLabelValues(want.Field).Set(1)
+		} else {
+			driftGauge.WithLabelValues(want.Field).Set(0)
+		}
+		if st.Drifted && cfg.Correct && st.ChangedBy == "" && now.Sub(*st.Since) >= driftGrace() &&
+			(st.LastCorrected == nil || now.Sub(*st.LastCorrected) >= driftGrace()) {
+			st.LastCorrected = &now
+			correct = append(correct, *st)
+		}
+	}
+	// fields without a desired value this month
+	for f := range d.status {
+		if !current[f] {
+			delete(d.status, f)
+			driftGauge.DeleteLabelValues(f)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, ev := range events {
+		emitEvent(ev)
+	}
+	if vacationActive() {
+		// the vacation action changes settings on purpose
+		return
+	}
+	for _, st := range correct {
+		err := WriteSingleRegister(d.client, st.Field, st.Desired, origin{Source: "desired_state"})
+		result := "ok"
+		if err != nil {
+			result = "error"
+			log.Printf("Config drift: correcting %s: %v", st.Field, err)
+		}
+		driftCorrections.WithLabelValues(st.Field, result).Inc()
+		d.mu.Lock()
+		if cur := d.status[st.Field]; cur != nil {
+			cur.LastError = ""
+			if err != nil {
+				cur.LastError = err.Error()
+			}
+		}
+		d.mu.Unlock()
+	}
+}
+
+// list returns the status of the fields desired this month, in config order
+func (d *driftWatcher) list() []DriftStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := []DriftStatus{}
+	listed := map[string]bool{}
+	for _, want := range appConfig().DesiredState.Fields {
+		if st, ok := d.status[want.Field]; ok && !listed[want.Field] {
+			listed[want.Field] = true
+			out = append(out, *st)
+		}
+	}
+	return out
+}
+
+// handleDrift returns the desired state of this month with the current
+// values
+func handleDrift(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(drift.list()); err != nil {
+		log.Printf("encode drift json: %v", err)
+	}
+}
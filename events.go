@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/danielkucera/gofutura/futura"
+)
+
+// Event types
+const (
+	EventExtButtonPressed  = "ext_button_pressed"
+	EventExtButtonReleased = "ext_button_released"
+	EventSettingWritten    = "setting_written"
+	EventUnitError         = "unit_error"
+	EventUnitWarning       = "unit_warning"
+	EventFilterDue         = "filter_due"
+)
+
+// Event is something that happened on the unit or in the exporter; events
+// are delivered to the configured webhooks and MQTT broker.
+type Event struct {
+	Time   time.Time              `json:"time"`
+	Type   string                 `json:"type"`
+	Source string                 `json:"source"`
+	Client string                 `json:"client,omitempty"` // who asked for it, see origin
+	Data   map[string]interface{} `json:"data,omitempty"`
+}
+
+// emitEvent stamps and dispatches an event to all notification channels
+func emitEvent(ev Event) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	eventLog.add(ev)
+	notifyEvent(ev)
+	// writes by rules don't trigger rules again, so rules can't loop
+	if e := rules(); e != nil && !(ev.Type == EventSettingWritten && ev.Source == "rule") {
+		e.HandleEvent(ev)
+	}
+}
+
+// origin tells who asked for a write: Source is how it arrived (api, ws,
+// guest, intent, mqtt, rule, schedule, vacation, desired_state, season),
+// Client who sent it and IP the address of an HTTP client
+type origin struct {
+	Source string
+	Client string
+	IP     string
+}
+
+// maxClientName limits X-Client-Name
+const maxClientName = 64
+
+// requestOrigin identifies the client of a request by its X-Client-Name
+// header, the key it was signed with or its admin token. r may be nil.
+func requestOrigin(r *http.Request, source string) origin {
+	o := origin{Source: source}
+	if r == nil {
+		return o
+	}
+	o.IP = clientIP(r)
+	if name := cleanClientName(r.Header.Get("X-Client-Name")); name != "" {
+		o.Client = name
+	} else if key := signedBy(r); key != "" {
+		o.Client = "key:" + key
+	} else if isAdmin(r) {
+		o.Client = "admin"
+	}
+	return o
+}
+
+// cleanClientName drops control characters and limits the length, as the
+// name ends up in logs and notifications
+func cleanClientName(name string) string {
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+	if len(name) > maxClientName {
+		name = strings.ToValidUTF8(name[:maxClientName], "")
+	}
+	return name
+}
+
+// noteWrite reports a completed write to stream clients and as an event
+func noteWrite(field string, value float64, o origin) {
+	publishWrite(field, value, o)
+	noteOwnedWrite(field, o)
+	emitEvent(Event{Type: EventSettingWritten, Source: o.Source, Client: o.Client, Data: map[string]interface{}{
+		"field": field,
+		"value": value,
+	}})
+}
+
+// eventLogSize is how many recent events /api/events keeps
+const eventLogSize = 1000
+
+// loggedEvent is an event with its position in the log
+type loggedEvent struct {
+	Seq int64 `json:"seq"`
+	Event
+}
+
+// recentEvents is an in-memory ring of the latest events
+type recentEvents struct {
+	mu     sync.Mutex
+	seq    int64
+	events []loggedEvent
+}
+
+var eventLog = &recentEvents{}
+
+func (l *recentEvents) add(ev Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	l.events = append(l.events, loggedEvent{Seq: l.seq, Event: ev})
+	if len(l.events) > eventLogSize {
+		l.events = append([]loggedEvent(nil), l.events[len(l.events)-eventLogSize:]...)
+	}
+}
+
+// list returns events after seq that happened at or after since, optionally
+// of a single type
+func (l *recentEvents) list(after int64, since time.Time, typ string) []loggedEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	start := sort.Search(len(l.events), func(i int) bool { return l.events[i].Seq > after })
+	var out []loggedEvent
+	for _, ev := range l.events[start:] {
+		if ev.Time.Before(since) || (typ != "" && ev.Type != typ) {
+			continue
+		}
+		out = append(out, ev)
+	}
+	return out
+}
+
+// handleEvents returns recent events, oldest first, paged with
+// ?since=&limit=&cursor=&fields= and filtered by ?type=
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	page, err := parsePage(r, 100)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"success":false,"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	events := eventLog.list(page.After, page.Since, r.URL.Query().Get("type"))
+	err = writePage(w, page, len(events),
+		func(i int) interface{} { return events[i] },
+		func(i int) int64 { return events[i].Seq })
+	if err != nil {
+		log.Printf("encode events json: %v", err)
+	}
+}
+
+// previous poll, used for edge detection; nil until the first poll
+var prevDecoded *futura.InputRegs
+
+// changes carries the field changes between polls to in-process
+// subscribers, such as the button events below
+var changes futura.Feed
+
+// detectEdges compares a decoded poll with the previous one and emits events
+// for state transitions
+func detectEdges(cur futura.InputRegs) {
+	prev := prevDecoded
+	prevDecoded = &cur
+
+	updateDigitalInputs(prev, cur)
+	updateOperatingStates(prev, cur)
+	changes.Publish(cur)
+}
+
+// watchButtons turns external button changes into events
+func watchButtons() {
+	changes.Subscribe(func(c futura.Change) {
+		if c.Field != "ExtBtnActive" {
+			return
+		}
+		typ := EventExtButtonReleased
+		if c.New != 0 {
+			typ = EventExtButtonPressed
+		}
+		in, _, _ := latestSnapshot()
+		emitEvent(Event{
+			Time:   c.Time,
+			Type:   typ,
+			Source: fmt.Sprintf("ExtBtn%d", c.Index),
+			Data: map[string]interface{}{
+				"button": c.Index,
+				"mode":   in.ExtBtnMode[c.Index-1],
+			},
+		})
+	})
+}
+
+// filterDueWear is the filter wear (%) at which filter_due is emitted
+const filterDueWear = 100
+
+// watchAlarms turns new unit errors and warnings and a worn-out filter into
+// events, so they can be pushed to phones
+func watchAlarms() {
+	changes.Subscribe(func(c futura.Change) {
+		ev := Event{Time: c.Time, Source: "unit"}
+		switch {
+		case c.Field == "FutError" && c.New != 0:
+			ev.Type, ev.Data = EventUnitError, map[string]interface{}{"code": uint32(c.New)}
+		case c.Field == "FutWarning" && c.New != 0:
+			ev.Type, ev.Data = EventUnitWarning, map[string]interface{}{"code": uint32(c.New)}
+		case c.Field == "FilterWear" && c.Old < filterDueWear && c.New >= filterDueWear:
+			ev.Type, ev.Data = EventFilterDue, map[string]interface{}{"wear": c.New}
+		default:
+			return
+		}
+		emitEvent(ev)
+	})
+}
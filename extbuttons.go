@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/simonvetter/modbus"
+)
+
+// extBtnModeSpec names the external button modes (ExtBtnMode)
+var extBtnModeSpec = futura.WriteableFields["ExtBtnMode1"]
+
+// extBtnMaxTm is the longest run time accepted for a button, in seconds
+const extBtnMaxTm = 3600
+
+// ExtButton is the configuration and state of one external button
+type ExtButton struct {
+	Index   int    `json:"index"` // 1-8
+	Present bool   `json:"present"`
+	Mode    string `json:"mode"` // boost or hood
+	Time    uint16 `json:"time"` // run time in seconds
+	Active  bool   `json:"active"`
+}
+
+// ExtButtonUpdate changes one button; omitted fields are left as they are
+type ExtButtonUpdate struct {
+	Index   int     `json:"index"`
+	Present *bool   `json:"present"`
+	Mode    *string `json:"mode"`
+	Time    *uint16 `json:"time"`
+}
+
+func extBtnModeName(v uint16) string {
+	if n := extBtnModeSpec.NameOf(float64(v)); n != "" {
+		return n
+	}
+	return fmt.Sprintf("unknown(%d)", v)
+}
+
+func extBtnModeValue(name string) (uint16, error) {
+	v, ok := extBtnModeSpec.ValueOf(name)
+	if !ok {
+		return 0, fmt.Errorf("invalid mode %q (%s)", name, extBtnModeSpec.NameList())
+	}
+	return uint16(v), nil
+}
+
+// extButtonWrites validates the updates and returns the fields to write
+func extButtonWrites(updates []ExtButtonUpdate) (map[string]float64, error) {
+	writes := map[string]float64{}
+	seen := map[int]bool{}
+	for _, u := range updates {
+		if u.Index < 1 || u.Index > futura.HoldingExtBtnInstances {
+			return nil, fmt.Errorf("button index %d out of range 1-%d", u.Index, futura.HoldingExtBtnInstances)
+		}
+		if seen[u.Index] {
+			return nil, fmt.Errorf("button %d listed twice", u.Index)
+		}
+		seen[u.Index] = true
+		if u.Present != nil {
+			v := 0.0
+			if *u.Present {
+				v = 1
+			}
+			writes[fmt.Sprintf("ExtBtnPresent%d", u.Index)] = v
+		}
+		if u.Mode != nil {
+			v, err := extBtnModeValue(*u.Mode)
+			if err != nil {
+				return nil, fmt.Errorf("button %d: %w", u.Index, err)
+			}
+			writes[fmt.Sprintf("ExtBtnMode%d", u.Index)] = float64(v)
+		}
+		if u.Time != nil {
+			if *u.Time > extBtnMaxTm {
+				return nil, fmt.Errorf("button %d: time %d s exceeds %d s", u.Index, *u.Time, extBtnMaxTm)
+			}
+			writes[fmt.Sprintf("ExtBtnTm%d", u.Index)] = float64(*u.Time)
+		}
+	}
+	return writes, nil
+}
+
+// handleExtButtons returns all external buttons (GET) or configures several
+// at once (PUT [{"index":1,"present":true,"mode":"hood","time":900}])
+func handleExtButtons(client *ModbusConn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if activeProfile.Decoder != DecoderFutura {
+			fmt.Fprintf(w, `{"success":false,"error":"profile %s has no external buttons"}`, activeProfile.Name)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			if maintenanceActive() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprintf(w, `{"success":false,"error":%q}`, errMaintenance.Error())
+				return
+			}
+			hold := futura.DecodeHoldingMap(collectRanges(client, modbus.HOLDING_REGISTER, holdingRanges, runtimeMaxBlockSize))
+			out := make([]ExtButton, futura.HoldingExtBtnInstances)
+			for i := range out {
+				out[i] = ExtButton{
+					Index:   i + 1,
+					Present: hold.ExtBtnPresent[i] != 0,
+					Mode:    extBtnModeName(hold.ExtBtnMode[i]),
+					Time:    hold.ExtBtnTm[i],
+					Active:  hold.ExtBtnActive[i] != 0,
+				}
+			}
+			if err := json.NewEncoder(w).Encode(out); err != nil {
+				log.Printf("encode ext buttons json: %v", err)
+			}
+		case http.MethodPut:
+			var updates []ExtButtonUpdate
+			if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
+				fmt.Fprintf(w, `{"success":false,"error":"Invalid JSON"}`)
+				return
+			}
+			writes, err := extButtonWrites(updates)
+			if err == nil {
+				for field, value := range writes {
+					if err = checkWritePolicy(r, field, value); err != nil {
+						break
+					}
+				}
+			}
+			if err != nil {
+				fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+				return
+			}
+			for field, value := range writes {
+				if err := WriteSingleRegister(client, field, value, requestOrigin(r, "api")); err != nil {
+					fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+					return
+				}
+			}
+			fmt.Fprintf(w, `{"success":true,"message":"%d fields updated"}`, len(writes))
+		default:
+			fmt.Fprintf(w, `{"success":false,"error":"GET or PUT required"}`)
+		}
+	}
+}
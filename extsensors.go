@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/simonvetter/modbus"
+)
+
+// External sensors (ExtSens 1-8) are fed by writing their readings to
+// holding registers. The unit ignores the readings of a slot whose Present
+// flag is 0, so writing them leaves a half-configured sensor that looks fed
+// but isn't used. Such writes are refused; /api/ext-sensor/N sets Present
+// itself on the first feed.
+
+// Bits of ExtSensInvalidate; a set bit marks the reading as invalid
+const (
+	ExtSensInvalidTemp  = 1 << 0
+	ExtSensInvalidRH    = 1 << 1
+	ExtSensInvalidCo2   = 1 << 2
+	ExtSensInvalidFloor = 1 << 3
+)
+
+// extSensReadings lists the reading fields of a sensor (field prefix, JSON
+// key, invalidate bit)
+var extSensReadings = []struct {
+	prefix string
+	key    string
+	bit    uint16
+}{
+	{"ExtSensTemp", "temp", ExtSensInvalidTemp},
+	{"ExtSensRH", "rh", ExtSensInvalidRH},
+	{"ExtSensCo2", "co2", ExtSensInvalidCo2},
+	{"ExtSensTFloor", "floor", ExtSensInvalidFloor},
+}
+
+// ExtSensor is the state of one external sensor slot
+type ExtSensor struct {
+	Index      int     `json:"index"` // 1-8
+	Present    bool    `json:"present"`
+	Invalidate uint16  `json:"invalidate"`
+	Temp       float64 `json:"temp"`
+	RH         float64 `json:"rh"`
+	Co2        uint16  `json:"co2"`
+	Floor      float64 `json:"floor"`
+}
+
+// ExtSensorFeed carries new readings; omitted readings are left as they are
+type ExtSensorFeed struct {
+	Temp  *float64 `json:"temp"`
+	RH    *float64 `json:"rh"`
+	Co2   *float64 `json:"co2"`
+	Floor *float64 `json:"floor"`
+}
+
+func (f ExtSensorFeed) values() map[string]*float64 {
+	return map[string]*float64{"temp": f.Temp, "rh": f.RH, "co2": f.Co2, "floor": f.Floor}
+}
+
+func extSensBase(n int) uint16 {
+	return futura.AddrExtSensBase + uint16((n-1)*10)
+}
+
+// extSensReadingSlot returns the sensor (1-8) a reading field such as
+// ExtSensCo23 belongs to, or 0 for other fields
+func extSensReadingSlot(field string) int {
+	for _, r := range extSensReadings {
+		rest, ok := strings.CutPrefix(field, r.prefix)
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(rest); err == nil && n >= 1 && n <= futura.ExtSensInstances {
+			return n
+		}
+	}
+	return 0
+}
+
+// checkExtSensPresent refuses readings for a sensor that isn't present
+func checkExtSensPresent(client *ModbusConn, field string) error {
+	n := extSensReadingSlot(field)
+	if n == 0 {
+		return nil
+	}
+	regs, err := client.ReadRegisters(extSensBase(n), 1, modbus.HOLDING_REGISTER)
+	if err != nil {
+		return fmt.Errorf("read ExtSensPresent%d: %w", n, err)
+	}
+	if regs[0] == 0 {
+		return fmt.Errorf("external sensor %d is not present; set ExtSensPresent%d to 1 first or feed it through /api/ext-sensor/%d", n, n, n)
+	}
+	return nil
+}
+
+// readExtSensor reads the registers of one sensor slot
+func readExtSensor(client *ModbusConn, n int) (ExtSensor, error) {
+	regs, err := client.ReadRegisters(extSensBase(n), 6, modbus.HOLDING_REGISTER)
+	if err != nil {
+		return ExtSensor{}, err
+	}
+	return ExtSensor{
+		Index:      n,
+		Present:    regs[0] != 0,
+		Invalidate: regs[1],
+		Temp:       float64(int16(regs[2])) * 0.1,
+		RH:         float64(regs[3]),
+		Co2:        regs[4],
+		Floor:      float64(int16(regs[5])) * 0.1,
+	}, nil
+}
+
+// feedExtSensor writes new readings. On the first feed of a slot the
+// readings are written before Present is set, so the unit never uses the
+// slot with stale values, and the invalidate bits of the fed readings are
+// cleared. It returns whether Present was set.
+func feedExtSensor(r *http.Request, client *ModbusConn, n int, feed ExtSensorFeed) (bool, error) {
+	writes := map[string]float64{}
+	var fedBits uint16
+	values := feed.values()
+	for _, rd := range extSensReadings {
+		if v := values[rd.key]; v != nil {
+			writes[fmt.Sprintf("%s%d", rd.prefix, n)] = *v
+			fedBits |= rd.bit
+		}
+	}
+	if len(writes) == 0 {
+		return false, fmt.Errorf("no readings given (temp, rh, co2, floor)")
+	}
+	for field, value := range writes {
+		if err := checkWritePolicy(r, field, value); err != nil {
+			return false, err
+		}
+	}
+
+	cur, err := readExtSensor(client, n)
+	if err != nil {
+		return false, fmt.Errorf("read external sensor %d: %w", n, err)
+	}
+	if !cur.Present {
+		if err := checkWritePolicy(r, fmt.Sprintf("ExtSensPresent%d", n), 1); err != nil {
+			return false, err
+		}
+	}
+	o := requestOrigin(r, "ext_sensor")
+	for field, value := range writes {
+		if _, err := writeSingleRegister(client, field, value, o); err != nil {
+			return false, err
+		}
+	}
+	if cur.Present {
+		return false, nil
+	}
+	if _, err := updateInvalidate(r, client, n, 0, fedBits); err != nil {
+		return false, err
+	}
+	if _, err := writeSingleRegister(client, fmt.Sprintf("ExtSensPresent%d", n), 1, o); err != nil {
+		return false, err
+	}
+	log.Printf("External sensor %d fed for the first time, marked present", n)
+	return true, nil
+}
+
+// invalidateBits returns the named bits of an invalidate mask
+func invalidateBits(mask uint16) map[string]bool {
+	out := map[string]bool{}
+	for _, rd := range extSensReadings {
+		out[rd.key] = mask&rd.bit != 0
+	}
+	return out
+}
+
+// invalidateChange turns {"temp":true,"co2":false} into the bits to set and
+// to clear; bits not named stay as they are
+func invalidateChange(named map[string]bool) (set, clear uint16, err error) {
+	for name, on := range named {
+		var bit uint16
+		for _, rd := range extSensReadings {
+			if rd.key == name {
+				bit = rd.bit
+			}
+		}
+		if bit == 0 {
+			return 0, 0, fmt.Errorf("unknown invalidate bit %q (temp, rh, co2, floor)", name)
+		}
+		if on {
+			set |= bit
+		} else {
+			clear |= bit
+		}
+	}
+	return set, clear, nil
+}
+
+// updateInvalidate sets and clears bits of a sensor's invalidate mask and
+// returns the new mask
+func updateInvalidate(r *http.Request, client *ModbusConn, n int, set, clear uint16) (uint16, error) {
+	return updateBits(r, client, fmt.Sprintf("ExtSensInvalidate%d", n), set, clear)
+}
+
+// handleExtSensorInvalidate returns (GET) or changes (POST {"co2":true})
+// the named invalidate bits of a sensor
+func handleExtSensorInvalidate(w http.ResponseWriter, r *http.Request, client *ModbusConn, n int) {
+	var mask uint16
+	switch r.Method {
+	case http.MethodGet:
+		regs, err := client.ReadRegisters(extSensBase(n)+1, 1, modbus.HOLDING_REGISTER)
+		if err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+			return
+		}
+		mask = regs[0]
+	case http.MethodPost, http.MethodPut:
+		var named map[string]bool
+		if err := json.NewDecoder(r.Body).Decode(&named); err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":"Invalid JSON"}`)
+			return
+		}
+		set, clear, err := invalidateChange(named)
+		if err == nil {
+			mask, err = updateInvalidate(r, client, n, set, clear)
+		}
+		if err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+			return
+		}
+	default:
+		fmt.Fprintf(w, `{"success":false,"error":"GET or POST required"}`)
+		return
+	}
+	resp := struct {
+		Mask uint16          `json:"mask"`
+		Bits map[string]bool `json:"bits"`
+	}{mask, invalidateBits(mask)}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("encode ext sensor invalidate json: %v", err)
+	}
+}
+
+// handleExtSensor returns one external sensor (GET /api/ext-sensor/N) or
+// feeds it new readings (POST {"temp":21.5,"rh":45,"co2":800,"floor":22});
+// /api/ext-sensor/N/invalidate changes single invalidate bits
+func handleExtSensor(client *ModbusConn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if activeProfile.Decoder != DecoderFutura {
+			fmt.Fprintf(w, `{"success":false,"error":"profile %s has no external sensors"}`, activeProfile.Name)
+			return
+		}
+		slot, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/ext-sensor/"), "/")
+		n, err := strconv.Atoi(slot)
+		if err != nil || n < 1 || n > futura.ExtSensInstances {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"success":false,"error":"sensor must be 1-%d"}`, futura.ExtSensInstances)
+			return
+		}
+		if sub != "" && sub != "invalidate" {
+			http.NotFound(w, r)
+			return
+		}
+		if maintenanceActive() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, errMaintenance.Error())
+			return
+		}
+		if sub == "invalidate" {
+			handleExtSensorInvalidate(w, r, client, n)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			s, err := readExtSensor(client, n)
+			if err != nil {
+				fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+				return
+			}
+			if err := json.NewEncoder(w).Encode(s); err != nil {
+				log.Printf("encode ext sensor json: %v", err)
+			}
+		case http.MethodPost, http.MethodPut:
+			var feed ExtSensorFeed
+			if err := json.NewDecoder(r.Body).Decode(&feed); err != nil {
+				fmt.Fprintf(w, `{"success":false,"error":"Invalid JSON"}`)
+				return
+			}
+			presentSet, err := feedExtSensor(r, client, n, feed)
+			if err != nil {
+				fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+				return
+			}
+			fmt.Fprintf(w, `{"success":true,"present_set":%t}`, presentSet)
+		default:
+			fmt.Fprintf(w, `{"success":false,"error":"GET or POST required"}`)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Capability bits of SysOptions and configuration bits of FutConfig
+// (FU_DOC_TCP_CS40). Bits not listed here are reported as unknown so they can
+// be identified on other units.
+const (
+	SysOptHeater            = 1 << 0 // electric heater installed
+	SysOptPreheaterElectric = 1 << 1 // electric anti-frost preheater
+	SysOptPreheaterWater    = 1 << 2 // water preheater
+	SysOptEnthalpy          = 1 << 3 // enthalpy (moisture recovering) exchanger
+
+	FutCfgModelL = 1 << 0 // Futura L (otherwise M)
+
+	sysOptKnown = SysOptHeater | SysOptPreheaterElectric | SysOptPreheaterWater | SysOptEnthalpy
+	futCfgKnown = FutCfgModelL
+)
+
+// Features tells which parts of the unit are installed or connected
+type Features struct {
+	Model         string `json:"model"`          // "L" or "M"
+	Heater        bool   `json:"heater"`         // SysOptions
+	PreheaterType string `json:"preheater_type"` // "none", "electric" or "water"
+	Enthalpy      bool   `json:"enthalpy"`
+
+	// From the connected Modbus devices
+	CoolBreeze      bool `json:"coolbreeze"`
+	ZoneValves      bool `json:"zone_valves"`
+	WallControllers bool `json:"wall_controllers"`
+	Sensors         bool `json:"sensors"`
+	Alfa            bool `json:"alfa"`
+	Buttons         bool `json:"buttons"`
+
+	UnknownSysOptions uint16 `json:"unknown_sys_options,omitempty"`
+	UnknownFutConfig  uint16 `json:"unknown_fut_config,omitempty"`
+}
+
+// DecodeFeatures decodes the capability bitmasks and connected devices
+func DecodeFeatures(r futura.InputRegs) Features {
+	f := Features{
+		Model:           "M",
+		Heater:          r.SysOptions&SysOptHeater != 0,
+		PreheaterType:   "none",
+		Enthalpy:        r.SysOptions&SysOptEnthalpy != 0,
+		CoolBreeze:      r.MBDevConnectedCoolBreeze != 0,
+		ZoneValves:      r.MBDevConnectedValveSupply != 0 || r.MBDevConnectedValveExhaust != 0,
+		WallControllers: r.MBDevConnectedMkUI != 0,
+		Sensors:         r.MBDevConnectedMkSens != 0,
+		Alfa:            r.MBDevConnectedAlfa != 0,
+		Buttons:         r.MBDevConnectedButton != 0,
+
+		UnknownSysOptions: r.SysOptions &^ sysOptKnown,
+		UnknownFutConfig:  r.FutConfig &^ futCfgKnown,
+	}
+	if r.FutConfig&FutCfgModelL != 0 {
+		f.Model = "L"
+	}
+	switch {
+	case r.SysOptions&SysOptPreheaterElectric != 0:
+		f.PreheaterType = "electric"
+	case r.SysOptions&SysOptPreheaterWater != 0:
+		f.PreheaterType = "water"
+	}
+	return f
+}
+
+// fieldFeatures lists writable fields that only apply with a feature.
+// Switches may always be turned off (vacation mode does that).
+var fieldFeatures = map[string]struct {
+	has      func(Features) bool
+	what     string
+	allowOff bool
+}{
+	"CfgHeatingEnable":                 {func(f Features) bool { return f.Heater }, "an installed heater", true},
+	"CfgCoolingEnable":                 {func(f Features) bool { return f.CoolBreeze }, "a connected CoolBreeze", true},
+	"VzvCBPriorityControl":             {func(f Features) bool { return f.CoolBreeze }, "a connected CoolBreeze", false},
+	"VzvKitchenhoodNormallyOpen":       {func(f Features) bool { return f.ZoneValves }, "connected zone valves", true},
+	"VzvBoostVolumePerRun":             {func(f Features) bool { return f.ZoneValves }, "connected zone valves", false},
+	"VzvKitchenhoodNormallyOpenVolume": {func(f Features) bool { return f.ZoneValves }, "connected zone valves", false},
+}
+
+// checkFeature rejects writes to fields the unit has no use for. Before
+// the first poll the features are unknown and all writes pass.
+func checkFeature(field string, value float64) error {
+	ff, ok := fieldFeatures[field]
+	if !ok || (ff.allowOff && value == 0) {
+		return nil
+	}
+	if f, polled := currentFeatures(); polled && !ff.has(f) {
+		return fmt.Errorf("%s needs %s, which this unit doesn't have (see /api/info)", field, ff.what)
+	}
+	return nil
+}
+
+// unsupportedFields lists the writable fields checkFeature rejects
+func unsupportedFields(f Features) []string {
+	out := []string{}
+	for field, ff := range fieldFeatures {
+		if !ff.has(f) {
+			out = append(out, field)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// DeviceInfo is returned by /api/info
+type DeviceInfo struct {
+	DeviceID      uint16   `json:"device_id"`
+	Serial        string   `json:"serial"`
+	MAC           string   `json:"mac"`
+	HWRevision    string   `json:"hw_revision"`
+	FWRevision    string   `json:"fw_revision"`
+	BuildNumber   uint32   `json:"build_number"`
+	RegmapVersion uint32   `json:"regmap_version"`
+	SysOptions    uint16   `json:"sys_options"`
+	FutConfig     uint16   `json:"fut_config"`
+	Features      Features `json:"features"`
+
+	UnsupportedFields []string `json:"unsupported_fields"` // writes are rejected
+
+	ExporterVersion string `json:"exporter_version"`
+}
+
+var (
+	deviceInfoMu sync.Mutex
+	deviceInfo   *DeviceInfo // nil until the first poll
+
+	deviceInfoGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "futura_device_info",
+		Help: "Identity of the unit, always 1",
+	}, []string{"serial", "device_id", "hw_revision", "fw_revision", "build_number", "regmap_version"})
+)
+
+func RegisterDeviceInfoMetrics() {
+	prometheus.MustRegister(deviceInfoGauge)
+}
+
+// featureGauges are metrics that only make sense when a feature is present;
+// they are unregistered otherwise so dashboards don't show flat zeros
+var featureGauges = map[string]func(Features) bool{
+	"fut_heating_power_watts": func(f Features) bool { return f.Heater },
+}
+
+// updateDeviceInfo stores the identity and features of the last poll
+func updateDeviceInfo(r futura.InputRegs) {
+	info := &DeviceInfo{
+		DeviceID:      r.FactDeviceID,
+		Serial:        r.Serial,
+		MAC:           r.MAC,
+		HWRevision:    r.HWRevision,
+		FWRevision:    r.FWRevision,
+		BuildNumber:   r.SysBuildNumber,
+		RegmapVersion: r.SysRegmapVersion,
+		SysOptions:    r.SysOptions,
+		FutConfig:     r.FutConfig,
+		Features:      DecodeFeatures(r),
+
+		ExporterVersion: version,
+	}
+	info.UnsupportedFields = unsupportedFields(info.Features)
+
+	deviceInfoMu.Lock()
+	prev := deviceInfo
+	deviceInfo = info
+	deviceInfoMu.Unlock()
+
+	if prev == nil || prev.Serial != info.Serial || prev.DeviceID != info.DeviceID ||
+		prev.HWRevision != info.HWRevision || prev.FWRevision != info.FWRevision ||
+		prev.BuildNumber != info.BuildNumber || prev.RegmapVersion != info.RegmapVersion {
+		// a firmware update changes the labels; drop the old series
+		deviceInfoGauge.Reset()
+		deviceInfoGauge.WithLabelValues(
+			info.Serial,
+			fmt.Sprintf("0x%04X", info.DeviceID),
+			info.HWRevision,
+			fmt.Sprintf("%d.%d", r.FirmRevision>>16, r.FirmRevision&0xFFFF),
+			fmt.Sprintf("%d", info.BuildNumber),
+			fmt.Sprintf("%d", info.RegmapVersion),
+		).Set(1)
+	}
+
+	if prev != nil && prev.Features == info.Features {
+		return
+	}
+	if info.Features.UnknownSysOptions != 0 || info.Features.UnknownFutConfig != 0 {
+		log.Printf("Unknown option bits: SysOptions 0x%04X, FutConfig 0x%04X", info.Features.UnknownSysOptions, info.Features.UnknownFutConfig)
+	}
+	for name, present := range featureGauges {
+		g := regGauges[name]
+		if present(info.Features) {
+			// already registered is fine
+			_ = prometheus.Register(g)
+		} else {
+			prometheus.Unregister(g)
+		}
+	}
+}
+
+// currentFeatures returns the features of the last poll and whether a poll
+// has happened yet
+func currentFeatures() (Features, bool) {
+	deviceInfoMu.Lock()
+	defer deviceInfoMu.Unlock()
+	if deviceInfo == nil {
+		return Features{}, false
+	}
+	return deviceInfo.Features, true
+}
+
+// handleInfo returns the device identity and its decoded features
+func handleInfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	deviceInfoMu.Lock()
+	info := deviceInfo
+	deviceInfoMu.Unlock()
+	if info == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, `{"success":false,"error":"no data polled yet"}`)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		log.Printf("encode info json: %v", err)
+	}
+}
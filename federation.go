@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"google.golang.org/protobuf/proto"
+)
+
+// Federation lets one instance aggregate others, e.g. one per property:
+// /api/federation/read-input and /api/federation/read-holding return the
+// values of every site keyed by site name, and /metrics/federate merges all
+// metrics with a site label.
+
+// FederationConfig names this instance and lists the remote ones
+type FederationConfig struct {
+	Site    string             `yaml:"site"` // label of this instance (default local)
+	Remotes []FederationRemote `yaml:"remotes"`
+}
+
+// FederationRemote is another gofutura instance
+type FederationRemote struct {
+	Site    string            `yaml:"site"`
+	URL     string            `yaml:"url"`     // e.g. http://10.0.1.5:9090
+	Headers map[string]string `yaml:"headers"` // e.g. an Authorization header
+}
+
+func (c FederationConfig) validate() error {
+	seen := map[string]bool{c.localSite(): true}
+	for i, r := range c.Remotes {
+		if r.Site == "" || r.URL == "" {
+			return fmt.Errorf("federation remote %d needs site and url", i)
+		}
+		if seen[r.Site] {
+			return fmt.Errorf("federation site %q is listed twice", r.Site)
+		}
+		seen[r.Site] = true
+	}
+	return nil
+}
+
+func (c FederationConfig) localSite() string {
+	if c.Site == "" {
+		return "local"
+	}
+	return c.Site
+}
+
+var federationClient = &http.Client{Timeout: 10 * time.Second}
+
+// fetchRemote GETs path from a remote instance
+func fetchRemote(r FederationRemote, path string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(r.URL, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := federationClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// forEachRemote calls fn for all remotes concurrently and waits for them
+func forEachRemote(fn func(r FederationRemote)) {
+	var wg sync.WaitGroup
+	for _, r := range appConfig().Federation.Remotes {
+		wg.Add(1)
+		go func(r FederationRemote) {
+			defer wg.Done()
+			fn(r)
+		}(r)
+	}
+	wg.Wait()
+}
+
+// handleFederationRead merges /api/read-input or /api/read-holding of all
+// sites. The local site reports its last poll rather than reading the unit.
+func handleFederationRead(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	kind := strings.TrimPrefix(r.URL.Path, "/api/federation/")
+	if kind != "read-input" && kind != "read-holding" {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"success":false,"error":"read-input or read-holding expected"}`)
+		return
+	}
+
+	var mu sync.Mutex
+	resp := struct {
+		Sites  map[string]json.RawMessage `json:"sites"`
+		Errors map[string]string          `json:"errors,omitempty"`
+	}{Sites: map[string]json.RawMessage{}, Errors: map[string]string{}}
+
+	local := appConfig().Federation.localSite()
+	if in, hold, ok := latestSnapshot(); ok {
+		var v interface{} = in
+		if kind == "read-holding" {
+			v = hold
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			resp.Errors[local] = err.Error()
+		} else {
+			resp.Sites[local] = data
+		}
+	} else {
+		resp.Errors[local] = "no data polled yet"
+	}
+
+	forEachRemote(func(remote FederationRemote) {
+		data, err := fetchRemote(remote, "/api/"+kind)
+		if err == nil && !json.Valid(data) {
+			err = fmt.Errorf("invalid JSON")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			resp.Errors[remote.Site] = err.Error()
+			return
+		}
+		resp.Sites[remote.Site] = data
+	})
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("encode federation json: %v", err)
+	}
+}
+
+func hasLabel(m *dto.Metric, name string) bool {
+	for _, l := range m.Label {
+		if l.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// handleFederateMetrics serves the metrics of all sites with a site label.
+// Remotes that can't be scraped are reported in futura_federation_up.
+func handleFederateMetrics(w http.ResponseWriter, r *http.Request) {
+	var mu sync.Mutex
+	families := map[string]*dto.MetricFamily{}
+	up := map[string]bool{}
+	merge := func(site string, mfs []*dto.MetricFamily) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, mf := range mfs {
+			for _, m := range mf.Metric {
+				if !hasLabel(m, "site") { // a remote may federate itself
+					m.Label = append(m.Label, &dto.LabelPair{Name: proto.String("site"), Value: proto.String(site)})
+				}
+			}
+			if have, ok := families[mf.GetName()]; ok && have.GetType() == mf.GetType() {
+				have.Metric = append(have.Metric, mf.Metric...)
+			} else if !ok {
+				families[mf.GetName()] = mf
+			}
+		}
+	}
+
+	local := appConfig().Federation.localSite()
+	if mfs, err := prometheus.DefaultGatherer.Gather(); err != nil {
+		log.Printf("federate: gather local metrics: %v", err)
+	} else {
+		merge(local, mfs)
+	}
+	up[local] = true
+
+	forEachRemote(func(remote FederationRemote) {
+		data, err := fetchRemote(remote, "/metrics")
+		var parsed map[string]*dto.MetricFamily
+		if err == nil {
+			parser := expfmt.NewTextParser(model.UTF8Validation)
+			parsed, err = parser.TextToMetricFamilies(bytes.NewReader(data))
+		}
+		if err != nil {
+			log.Printf("federate: %s: %v", remote.Site, err)
+		}
+		mfs := make([]*dto.MetricFamily, 0, len(parsed))
+		for _, mf := range parsed {
+			mfs = append(mfs, mf)
+		}
+		merge(remote.Site, mfs)
+		mu.Lock()
+		up[remote.Site] = err == nil
+		mu.Unlock()
+	})
+
+	upFamily := &dto.MetricFamily{
+		Name: proto.String("futura_federation_up"),
+		Help: proto.String("1 when the site's metrics could be fetched"),
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+	for site, ok := range up {
+		v := 0.0
+		if ok {
+			v = 1
+		}
+		upFamily.Metric = append(upFamily.Metric, &dto.Metric{
+			Label: []*dto.LabelPair{{Name: proto.String("site"), Value: proto.String(site)}},
+			Gauge: &dto.Gauge{Value: proto.Float64(v)},
+		})
+	}
+	families[upFamily.GetName()] = upFamily
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w.Header().Set("Content-Type", string(expfmt.NewFormat(expfmt.TypeTextPlain)))
+	for _, name := range names {
+		if _, err := expfmt.MetricFamilyToText(w, families[name]); err != nil {
+			log.Printf("federate: encode %s: %v", name, err)
+			return
+		}
+	}
+}
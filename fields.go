@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/danielkucera/gofutura/futura"
+)
+
+// Field metadata for clients that build their forms from it: /api/fields
+// describes every field of futura.WriteableFields with its type, range,
+// choices and whether it can be written on this unit, so a register added
+// to the map shows up in the UI without touching the HTML.
+
+// FieldOption is one choice of a select field
+type FieldOption struct {
+	Value float64 `json:"value"`
+	Name  string  `json:"name,omitempty"` // accepted in writes instead of the value
+	Label string  `json:"label"`
+}
+
+// FieldInfo describes a writable field
+type FieldInfo struct {
+	Name     string           `json:"name"`
+	Label    string           `json:"label"`
+	Group    string           `json:"group"`
+	Index    int              `json:"index,omitempty"` // 1-based instance of external sensor and button fields
+	Type     string           `json:"type"`            // "switch", "select" or "number"
+	Unit     string           `json:"unit,omitempty"`
+	Min      *float64         `json:"min,omitempty"` // with write_policy.limits applied
+	Max      *float64         `json:"max,omitempty"`
+	Step     float64          `json:"step"`
+	Options  []FieldOption    `json:"options,omitempty"`
+	Writable bool             `json:"writable"`
+	Reason   string           `json:"reason,omitempty"` // why it isn't writable
+	Locked   bool             `json:"locked,omitempty"` // needs /api/unlock or an admin token
+	Addr     uint16           `json:"addr"`
+	Help     *futura.FieldDoc `json:"help,omitempty"` // unofficial summary, see futura.FieldDoc
+}
+
+// fieldMeta is what the register map doesn't say about a field
+type fieldMeta struct {
+	Label   string
+	Unit    string
+	Group   string
+	Options []FieldOption // offered instead of a number input, e.g. presets
+}
+
+// formFields are the fields of the settings form, in form order. Writable
+// fields not listed here follow in the "Other" group.
+var formFields = []struct {
+	Name string
+	fieldMeta
+}{
+	{"FuncVentilation", fieldMeta{Label: "Ventilation Level", Group: "Ventilation & Functions"}},
+	{"FuncBoostTm", fieldMeta{Label: "Boost Timer", Unit: "s", Group: "Ventilation & Functions"}},
+	{"FuncCirculationTm", fieldMeta{Label: "Circulation Timer", Unit: "s", Group: "Ventilation & Functions"}},
+	{"FuncPartyTm", fieldMeta{Label: "Party Timer", Unit: "s", Group: "Ventilation & Functions"}},
+	{"FuncNightTm", fieldMeta{Label: "Night Timer", Unit: "s", Group: "Ventilation & Functions"}},
+	{"FuncOverpressureTm", fieldMeta{Label: "Overpressure Timer", Unit: "s", Group: "Ventilation & Functions"}},
+
+	{"CfgTempSet", fieldMeta{Label: "Target Temperature", Unit: "°C", Group: "Configuration"}},
+	{"CfgHumiSet", fieldMeta{Label: "Target Humidity", Unit: "%", Group: "Configuration", Options: []FieldOption{
+		{Value: 25, Label: "Dry 25%"}, {Value: 50, Label: "Comfort 50%"}, {Value: 75, Label: "Wet 75%"},
+	}}},
+	{"CfgBypassEnable", fieldMeta{Label: "Enable Bypass", Group: "Configuration"}},
+	{"CfgHeatingEnable", fieldMeta{Label: "Enable Heating", Group: "Configuration"}},
+	{"CfgCoolingEnable", fieldMeta{Label: "Enable Cooling", Group: "Configuration"}},
+	{"CfgComfortEnable", fieldMeta{Label: "Enable Comfort Control", Group: "Configuration"}},
+	{"FuncTimeProg", fieldMeta{Label: "Enable Time Program", Group: "Configuration"}},
+	{"FuncAntiradon", fieldMeta{Label: "Enable Radon Protection", Group: "Configuration"}},
+
+	{"VzvCBPriorityControl", fieldMeta{Label: "Coolbreeze Priority", Group: "HVAC Settings"}},
+	{"VzvKitchenhoodNormallyOpen", fieldMeta{Label: "Hood Normally Open", Group: "HVAC Settings"}},
+	{"VzvBoostVolumePerRun", fieldMeta{Label: "Boost Volume", Unit: "m³/h", Group: "HVAC Settings"}},
+	{"VzvKitchenhoodNormallyOpenVolume", fieldMeta{Label: "Hood Volume", Unit: "m³/h", Group: "HVAC Settings"}},
+}
+
+// instanceGroups are the prefixes of fields that exist once per external
+// sensor or button, numbered 1-8 at the end of the name
+var instanceGroups = []struct{ Prefix, Group string }{
+	{"ExtSens", "External sensors"},
+	{"ExtBtn", "External buttons"},
+}
+
+// instanceMeta returns the group and index of a per-instance field
+func instanceMeta(name string) (fieldMeta, int, bool) {
+	last := name[len(name)-1]
+	if last < '1' || last > '8' {
+		return fieldMeta{}, 0, false
+	}
+	for _, g := range instanceGroups {
+		if strings.HasPrefix(name, g.Prefix) {
+			return fieldMeta{Label: name[len(g.Prefix) : len(name)-1], Group: g.Group}, int(last - '0'), true
+		}
+	}
+	return fieldMeta{}, 0, false
+}
+
+// isSwitch reports whether the field only accepts 0 and 1
+func isSwitch(spec futura.WriteFieldSpec) bool {
+	return len(spec.Enum) == 2 && spec.Enum[0] == 0 && spec.Enum[1] == 1
+}
+
+// fieldInfo describes one writable field with the current features, soft
+// limits and locks applied
+func fieldInfo(name string, spec futura.WriteFieldSpec, meta fieldMeta, index int) FieldInfo {
+	fi := FieldInfo{
+		Name:     name,
+		Label:    meta.Label,
+		Group:    meta.Group,
+		Index:    index,
+		Type:     "number",
+		Unit:     meta.Unit,
+		Step:     spec.Scale,
+		Writable: true,
+		Locked:   fieldLocked(name),
+		Addr:     spec.Addr,
+	}
+	if doc, ok := futura.DocOf(name); ok {
+		fi.Help = &doc
+	}
+	switch {
+	case len(meta.Options) > 0:
+		fi.Type = "select"
+		fi.Options = meta.Options
+	case len(spec.Names) > 0:
+		fi.Type = "select"
+		for _, n := range spec.Names {
+			fi.Options = append(fi.Options, FieldOption{n.Value, n.Name, n.Label})
+		}
+	case isSwitch(spec):
+		fi.Type = "switch"
+	case len(spec.Enum) > 0:
+		fi.Type = "select"
+		for _, v := range spec.Enum {
+			fi.Options = append(fi.Options, FieldOption{Value: v, Label: strconv.FormatFloat(v, 'g', -1, 64)})
+		}
+	}
+	if len(spec.Enum) == 0 && spec.Checked {
+		lo, hi := spec.Min, spec.Max
+		fi.Min, fi.Max = &lo, &hi
+	}
+	if l, ok := appConfig().WritePolicy.Limits[name]; ok {
+		if l.Min != nil && (fi.Min == nil || *l.Min > *fi.Min) {
+			fi.Min = l.Min
+		}
+		if l.Max != nil && (fi.Max == nil || *l.Max < *fi.Max) {
+			fi.Max = l.Max
+		}
+	}
+	// switches of a missing feature can still be turned off
+	if ff, ok := fieldFeatures[name]; ok {
+		if err := checkFeature(name, 1); err != nil && !ff.allowOff {
+			fi.Writable = false
+			fi.Reason = err.Error()
+		} else if err != nil {
+			fi.Reason = err.Error()
+		}
+	}
+	return fi
+}
+
+// allFieldInfo lists the form fields first, then the other writable fields
+// by name
+func allFieldInfo() []FieldInfo {
+	out := make([]FieldInfo, 0, len(futura.WriteableFields))
+	listed := map[string]bool{}
+	for _, f := range formFields {
+		spec, ok := futura.WriteableFields[f.Name]
+		if !ok {
+			continue
+		}
+		listed[f.Name] = true
+		out = append(out, fieldInfo(f.Name, spec, f.fieldMeta, 0))
+	}
+	var rest []FieldInfo
+	for name, spec := range futura.WriteableFields {
+		if listed[name] {
+			continue
+		}
+		meta, index, ok := instanceMeta(name)
+		if !ok {
+			meta = fieldMeta{Label: name, Group: "Other"}
+		}
+		rest = append(rest, fieldInfo(name, spec, meta, index))
+	}
+	sort.Slice(rest, func(i, j int) bool {
+		if rest[i].Group != rest[j].Group {
+			return rest[i].Group > rest[j].Group // "Other" before the instances
+		}
+		if rest[i].Index != rest[j].Index {
+			return rest[i].Index < rest[j].Index
+		}
+		return rest[i].Addr < rest[j].Addr
+	})
+	return append(out, rest...)
+}
+
+// handleFields returns the metadata of all writable fields; ?group= filters
+func handleFields(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fields := allFieldInfo()
+	if g := r.URL.Query().Get("group"); g != "" {
+		filtered := []FieldInfo{}
+		for _, f := range fields {
+			if f.Group == g {
+				filtered = append(filtered, f)
+			}
+		}
+		fields = filtered
+	}
+	if err := json.NewEncoder(w).Encode(fields); err != nil {
+		log.Printf("encode fields json: %v", err)
+	}
+}
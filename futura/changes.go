@@ -0,0 +1,166 @@
+package futura
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Change is a value of InputRegs that differs between two snapshots
+type Change struct {
+	Field string    `json:"field"`           // InputRegs field name, e.g. TempIndoor
+	Index int       `json:"index,omitempty"` // 1-based instance of array fields, 0 otherwise
+	Old   float64   `json:"old"`
+	New   float64   `json:"new"`
+	Time  time.Time `json:"time"` // when the new value was read
+}
+
+// MarshalJSON encodes unavailable (NaN) values as null
+func (c Change) MarshalJSON() ([]byte, error) {
+	type plain Change
+	v := struct {
+		plain
+		Old *float64 `json:"old"`
+		New *float64 `json:"new"`
+	}{plain: plain(c)}
+	if !math.IsNaN(c.Old) {
+		v.Old = &c.Old
+	}
+	if !math.IsNaN(c.New) {
+		v.New = &c.New
+	}
+	return json.Marshal(v)
+}
+
+// Diff returns the numeric fields that changed from prev to cur. Text fields
+// derived from registers (MAC, Serial, ...) are left out; their registers
+// are compared instead. An unavailable derived value counts as NaN.
+func Diff(prev, cur InputRegs) []Change {
+	var out []Change
+	pv, cv := reflect.ValueOf(prev), reflect.ValueOf(cur)
+	t := pv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			continue
+		}
+		a, b := pv.Field(i), cv.Field(i)
+		if f.Type.Kind() == reflect.Array {
+			for j := 0; j < a.Len(); j++ {
+				o, okO := numeric(a.Index(j))
+				n, okN := numeric(b.Index(j))
+				if okO && okN && !sameValue(o, n) {
+					out = append(out, Change{Field: f.Name, Index: j + 1, Old: o, New: n, Time: cur.Time})
+				}
+			}
+			continue
+		}
+		o, okO := numeric(a)
+		n, okN := numeric(b)
+		if okO && okN && !sameValue(o, n) {
+			out = append(out, Change{Field: f.Name, Old: o, New: n, Time: cur.Time})
+		}
+	}
+	return out
+}
+
+func numeric(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Uint16, reflect.Uint32:
+		return float64(v.Uint()), true
+	case reflect.Float64:
+		return v.Float(), true
+	case reflect.Ptr:
+		if v.IsNil() {
+			return math.NaN(), true
+		}
+		return numeric(v.Elem())
+	}
+	return 0, false
+}
+
+func sameValue(a, b float64) bool {
+	return a == b || (math.IsNaN(a) && math.IsNaN(b))
+}
+
+// Feed delivers the changes between consecutive snapshots to subscribers.
+// The zero value is ready to use.
+type Feed struct {
+	mu   sync.Mutex
+	prev *InputRegs
+	subs map[int]func(Change)
+	next int
+}
+
+// Subscribe calls fn for every change published from now on and returns a
+// function that ends the subscription. fn runs on the publishing goroutine
+// and should return quickly.
+func (f *Feed) Subscribe(fn func(Change)) (unsubscribe func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs == nil {
+		f.subs = map[int]func(Change){}
+	}
+	id := f.next
+	f.next++
+	f.subs[id] = fn
+	return func() {
+		f.mu.Lock()
+		delete(f.subs, id)
+		f.mu.Unlock()
+	}
+}
+
+// Publish compares r with the previously published snapshot and hands the
+// changes to the subscribers, in field order. The first snapshot only sets
+// the baseline.
+func (f *Feed) Publish(r InputRegs) {
+	f.mu.Lock()
+	prev := f.prev
+	f.prev = &r
+	subs := make([]func(Change), 0, len(f.subs))
+	for id := 0; id < f.next; id++ {
+		if fn, ok := f.subs[id]; ok {
+			subs = append(subs, fn)
+		}
+	}
+	f.mu.Unlock()
+
+	if prev == nil || len(subs) == 0 {
+		return
+	}
+	for _, c := range Diff(*prev, r) {
+		for _, fn := range subs {
+			fn(c)
+		}
+	}
+}
+
+// Subscribe calls fn for every change found by Poll
+func (c *Client) Subscribe(fn func(Change)) (unsubscribe func()) {
+	return c.feed.Subscribe(fn)
+}
+
+// Poll reads the unit every interval and publishes the changes to the
+// subscribers until ctx is done. Failed reads are passed to onError, which
+// may be nil, and polling continues.
+func (c *Client) Poll(ctx context.Context, interval time.Duration, onError func(error)) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		r, err := c.Readings(ctx)
+		if err == nil {
+			c.feed.Publish(r)
+		} else if onError != nil && ctx.Err() == nil {
+			onError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
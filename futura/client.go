@@ -0,0 +1,229 @@
+// Package futura reads and controls a Jablotron Futura heat recovery unit
+// over Modbus TCP (register map FU_DOC_TCP_CS40). It is the register map and
+// decoder of the gofutura exporter, plus a Client for Go programs that want
+// typed values and setters instead of raw registers.
+//
+//	c, err := futura.Dial("192.168.1.50", 1)
+//	if err != nil { ... }
+//	defer c.Close()
+//	r, err := c.Readings(ctx)
+//	err = c.SetVentilation(ctx, futura.Level3)
+//
+// Changes between polls can be followed with Subscribe and Poll:
+//
+//	c.Subscribe(func(ch futura.Change) { log.Println(ch.Field, ch.Old, "->", ch.New) })
+//	go c.Poll(ctx, 10*time.Second, nil)
+package futura
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/simonvetter/modbus"
+)
+
+// InputRanges and HoldingRanges are the [first, last] registers read for a
+// full snapshot, the same as the exporter's embedded futura profile
+var (
+	InputRanges = [][]uint16{
+		{0, 21}, {30, 38}, {40, 52}, {60, 75}, {100, 154},
+		{160, 165}, {170, 175}, {180, 185}, {190, 195},
+		{200, 205}, {210, 215}, {220, 225}, {230, 235},
+	}
+	HoldingRanges = [][]uint16{
+		{0, 17}, {20, 23},
+		{300, 305}, {310, 315}, {320, 325}, {330, 335},
+		{340, 345}, {350, 355}, {360, 365}, {370, 375},
+		{400, 403}, {410, 413}, {420, 423}, {430, 433},
+		{440, 443}, {450, 453}, {460, 463}, {470, 473},
+	}
+)
+
+// Level is a ventilation level (FuncVentilation)
+type Level uint16
+
+const (
+	Level1 Level = iota + 1
+	Level2
+	Level3
+	Level4
+	Level5
+	LevelAuto
+)
+
+// maxBoost is the longest boost FuncBoostTm accepts
+var maxBoost = time.Duration(WriteableFields["FuncBoostTm"].Max) * time.Second
+
+// Client is a connection to one unit. It is safe for concurrent use; calls
+// are serialized so a multi-request read is never interleaved with a write.
+//
+// The Modbus library has no cancellation, so the context is checked between
+// requests: a request already sent runs until it completes or times out.
+type Client struct {
+	mu           sync.Mutex
+	mc           *modbus.ModbusClient
+	maxBlockSize uint16
+	rounding     Rounding
+	feed         Feed
+}
+
+// Dial connects to the unit at addr (host or host:port, port 502 when
+// omitted) with the given Modbus unit id
+func Dial(addr string, unitID uint8) (*Client, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "502")
+	}
+	mc, err := modbus.NewClient(&modbus.ClientConfiguration{
+		URL:     "tcp://" + addr,
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := mc.SetUnitId(unitID); err != nil {
+		return nil, err
+	}
+	if err := mc.Open(); err != nil {
+		return nil, err
+	}
+	return NewClient(mc), nil
+}
+
+// NewClient wraps an open Modbus client, e.g. one configured for RTU
+func NewClient(mc *modbus.ModbusClient) *Client {
+	return &Client{mc: mc, maxBlockSize: 125}
+}
+
+// SetMaxBlockSize limits how many registers are read per request, for
+// gateways below the standard 125
+func (c *Client) SetMaxBlockSize(n uint16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n > 0 {
+		c.maxBlockSize = n
+	}
+}
+
+// SetRounding selects how Write rounds values to register units
+// (default RoundHalfUp)
+func (c *Client) SetRounding(r Rounding) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rounding = r
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mc.Close()
+}
+
+// readRanges reads ranges into a map[address]value; c.mu must be held
+func (c *Client) readRanges(ctx context.Context, typ modbus.RegType, ranges [][]uint16) (map[uint16]uint16, error) {
+	out := map[uint16]uint16{}
+	for _, r := range ranges {
+		for addr := int(r[0]); addr <= int(r[1]); addr += int(c.maxBlockSize) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			qty := c.maxBlockSize
+			if rest := int(r[1]) - addr + 1; rest < int(qty) {
+				qty = uint16(rest)
+			}
+			regs, err := c.mc.ReadRegisters(uint16(addr), qty, typ)
+			if err != nil {
+				return nil, fmt.Errorf("read %d-%d: %w", addr, addr+int(qty)-1, err)
+			}
+			for i, v := range regs {
+				out[uint16(addr+i)] = v
+			}
+		}
+	}
+	return out, nil
+}
+
+// Readings reads the measured values, device state and the external sensor
+// and button values (which live in holding registers)
+func (c *Client) Readings(ctx context.Context) (InputRegs, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	in, err := c.readRanges(ctx, modbus.INPUT_REGISTER, InputRanges)
+	if err != nil {
+		return InputRegs{}, err
+	}
+	hold, err := c.readRanges(ctx, modbus.HOLDING_REGISTER, HoldingRanges)
+	if err != nil {
+		return InputRegs{}, err
+	}
+	r := DecodeInputMap(in)
+	MergeHoldingExt(&r, hold)
+	r.Time = time.Now()
+	return r, nil
+}
+
+// Settings reads the writable registers
+func (c *Client) Settings(ctx context.Context) (HoldingRegs, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hold, err := c.readRanges(ctx, modbus.HOLDING_REGISTER, HoldingRanges)
+	if err != nil {
+		return HoldingRegs{}, err
+	}
+	r := DecodeHoldingMap(hold)
+	r.Time = time.Now()
+	return r, nil
+}
+
+// Write sets one of WriteableFields, in its unit (e.g. °C for CfgTempSet).
+// Values outside the field's range are rejected without writing.
+func (c *Client) Write(ctx context.Context, field string, value float64) error {
+	spec, ok := WriteableFields[field]
+	if !ok {
+		return fmt.Errorf("unknown or not-writable field: %s", field)
+	}
+	if spec.RegCount != 1 {
+		return fmt.Errorf("field %s requires %d registers", field, spec.RegCount)
+	}
+	if err := spec.Validate(value); err != nil {
+		return fmt.Errorf("%s %w", field, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	word, clamped := c.rounding.Encode(value, spec.Scale, spec.Signed)
+	if clamped {
+		return fmt.Errorf("value %v out of range for field %s", value, field)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := c.mc.WriteRegister(spec.Addr, word); err != nil {
+		return fmt.Errorf("write %s: %w", field, err)
+	}
+	return nil
+}
+
+// SetTemperature sets the target temperature in °C (0.1 °C steps)
+func (c *Client) SetTemperature(ctx context.Context, celsius float64) error {
+	return c.Write(ctx, "CfgTempSet", celsius)
+}
+
+// SetVentilation sets the ventilation level
+func (c *Client) SetVentilation(ctx context.Context, level Level) error {
+	if level < Level1 || level > LevelAuto {
+		return fmt.Errorf("invalid ventilation level %d", level)
+	}
+	return c.Write(ctx, "FuncVentilation", float64(level))
+}
+
+// Boost runs boost ventilation for d, rounded to seconds; 0 stops it
+func (c *Client) Boost(ctx context.Context, d time.Duration) error {
+	if d < 0 || d > maxBoost {
+		return fmt.Errorf("boost duration %s out of range 0-%s", d, maxBoost)
+	}
+	return c.Write(ctx, "FuncBoostTm", d.Round(time.Second).Seconds())
+}
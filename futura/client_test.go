@@ -0,0 +1,162 @@
+package futura
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/simonvetter/modbus"
+)
+
+// fakeUnit records the holding registers written to it
+type fakeUnit struct {
+	mu      sync.Mutex
+	holding map[uint16]uint16
+	writes  int
+}
+
+func (u *fakeUnit) HandleCoils(*modbus.CoilsRequest) ([]bool, error) {
+	return nil, modbus.ErrIllegalFunction
+}
+
+func (u *fakeUnit) HandleDiscreteInputs(*modbus.DiscreteInputsRequest) ([]bool, error) {
+	return nil, modbus.ErrIllegalFunction
+}
+
+func (u *fakeUnit) HandleInputRegisters(req *modbus.InputRegistersRequest) ([]uint16, error) {
+	return make([]uint16, req.Quantity), nil
+}
+
+func (u *fakeUnit) HandleHoldingRegisters(req *modbus.HoldingRegistersRequest) ([]uint16, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !req.IsWrite {
+		out := make([]uint16, req.Quantity)
+		for i := range out {
+			out[i] = u.holding[req.Addr+uint16(i)]
+		}
+		return out, nil
+	}
+	for i, v := range req.Args {
+		u.holding[req.Addr+uint16(i)] = v
+	}
+	u.writes++
+	return nil, nil
+}
+
+// startFakeUnit serves a fakeUnit and returns a Client connected to it
+func startFakeUnit(t *testing.T) (*Client, *fakeUnit) {
+	t.Helper()
+	// the server doesn't report the port it bound, so pick a free one first
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	u := &fakeUnit{holding: map[uint16]uint16{}}
+	srv, err := modbus.NewServer(&modbus.ServerConfiguration{URL: "tcp://" + addr, Timeout: time.Minute, MaxClients: 1}, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+
+	c, err := Dial(addr, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, u
+}
+
+func (u *fakeUnit) register(addr uint16) (uint16, int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.holding[addr], u.writes
+}
+
+func TestClientWrite(t *testing.T) {
+	c, u := startFakeUnit(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		call  func() error
+		addr  uint16
+		word  uint16
+		error string // substring; empty = success
+	}{
+		{"temperature", func() error { return c.SetTemperature(ctx, 21.5) }, AddrHoldingCfgTempSet, 215, ""},
+		{"temperature below range", func() error { return c.SetTemperature(ctx, 5) }, 0, 0, "between 10 and 30"},
+		{"ventilation", func() error { return c.SetVentilation(ctx, Level3) }, AddrHoldingFuncVentilation, 3, ""},
+		{"ventilation level 0", func() error { return c.SetVentilation(ctx, 0) }, 0, 0, "invalid ventilation level"},
+		{"boost", func() error { return c.Boost(ctx, 30*time.Minute) }, AddrHoldingFuncBoostTm, 1800, ""},
+		{"boost rounded", func() error { return c.Boost(ctx, 1500*time.Millisecond) }, AddrHoldingFuncBoostTm, 2, ""},
+		{"boost longer than FuncBoostTm accepts", func() error { return c.Boost(ctx, 3*time.Hour) }, 0, 0, "out of range"},
+		{"boost negative", func() error { return c.Boost(ctx, -time.Second) }, 0, 0, "out of range"},
+		{"write over max", func() error { return c.Write(ctx, "FuncBoostTm", 7201) }, 0, 0, "between 0 and 7200"},
+		{"switch not 0 or 1", func() error { return c.Write(ctx, "FuncAntiradon", 2) }, 0, 0, "one of 0, 1"},
+		{"unknown field", func() error { return c.Write(ctx, "TempIndoor", 1) }, 0, 0, "not-writable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, before := u.register(0)
+			err := tt.call()
+			word, after := u.register(tt.addr)
+			if tt.error != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.error) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.error)
+				}
+				if after != before {
+					t.Errorf("rejected value was written")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if word != tt.word {
+				t.Errorf("register %d = %d, want %d", tt.addr, word, tt.word)
+			}
+		})
+	}
+}
+
+func TestClientSetRounding(t *testing.T) {
+	c, u := startFakeUnit(t)
+	ctx := context.Background()
+	for _, tt := range []struct {
+		r    Rounding
+		word uint16
+	}{{RoundHalfUp, 213}, {RoundHalfEven, 212}} {
+		t.Run(fmt.Sprint(tt.r), func(t *testing.T) {
+			c.SetRounding(tt.r)
+			if err := c.SetTemperature(ctx, 21.25); err != nil {
+				t.Fatal(err)
+			}
+			if word, _ := u.register(AddrHoldingCfgTempSet); word != tt.word {
+				t.Errorf("register = %d, want %d", word, tt.word)
+			}
+		})
+	}
+}
+
+func TestClientWriteCanceled(t *testing.T) {
+	c, u := startFakeUnit(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.SetTemperature(ctx, 21); err != context.Canceled {
+		t.Fatalf("error = %v, want context.Canceled", err)
+	}
+	if _, writes := u.register(0); writes != 0 {
+		t.Errorf("%d writes after cancellation", writes)
+	}
+}
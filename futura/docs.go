@@ -0,0 +1,143 @@
+package futura
+
+// FieldDoc describes a writable field in Czech and in English. The texts are
+// unofficial summaries written for gofutura from the register semantics,
+// not quotes of FU_DOC_TCP_CS40; check the spec where the exact meaning
+// matters.
+type FieldDoc struct {
+	CS string `json:"cs"`
+	EN string `json:"en"`
+}
+
+// fieldDocs are keyed by field name, per-instance fields without their
+// number
+var fieldDocs = map[string]FieldDoc{
+	"FuncVentilation": {
+		"Úroveň větrání: 0 = vypnuto, 1–5 = stupeň výkonu, 6 = automatický režim.",
+		"Ventilation level: 0 = off, 1–5 = power level, 6 = automatic mode.",
+	},
+	"FuncBoostTm": {
+		"Funkce Boost (větrání na maximální výkon), zbývající doba v sekundách; 0 = vypnuto.",
+		"Boost function (ventilation at full power), remaining time in seconds; 0 = off.",
+	},
+	"FuncCirculationTm": {
+		"Funkce Cirkulace, zbývající doba v sekundách; 0 = vypnuto.",
+		"Circulation function, remaining time in seconds; 0 = off.",
+	},
+	"FuncOverpressureTm": {
+		"Funkce Přetlak (přívod převažuje nad odvodem, např. při zatápění v krbu), zbývající doba v sekundách; 0 = vypnuto.",
+		"Overpressure function (supply exceeds exhaust, e.g. when lighting a fireplace), remaining time in seconds; 0 = off.",
+	},
+	"FuncNightTm": {
+		"Funkce Noc (tichý provoz se sníženým výkonem), zbývající doba v sekundách; 0 = vypnuto.",
+		"Night function (quiet operation at reduced power), remaining time in seconds; 0 = off.",
+	},
+	"FuncPartyTm": {
+		"Funkce Party (zvýšený výkon pro více osob), zbývající doba v sekundách; 0 = vypnuto.",
+		"Party function (more ventilation for more people), remaining time in seconds; 0 = off.",
+	},
+	"CfgTempSet": {
+		"Požadovaná teplota v interiéru ve °C, s rozlišením 0,1 °C.",
+		"Requested indoor temperature in °C, in steps of 0.1 °C.",
+	},
+	"CfgHumiSet": {
+		"Požadovaná relativní vlhkost v interiéru v %, s rozlišením 0,1 %.",
+		"Requested indoor relative humidity in %, in steps of 0.1 %.",
+	},
+	"FuncTimeProg": {
+		"Časový program: 1 = jednotka se řídí týdenním programem, 0 = vypnuto.",
+		"Time program: 1 = the unit follows the weekly program, 0 = off.",
+	},
+	"FuncAntiradon": {
+		"Antiradonová ochrana: 1 = jednotka větrá i mimo nastavený režim, aby snížila koncentraci radonu.",
+		"Radon protection: 1 = the unit also ventilates outside the set mode to lower the radon concentration.",
+	},
+	"CfgBypassEnable": {
+		"Povolení bypassu rekuperačního výměníku pro přirozené chlazení nebo ohřev venkovním vzduchem.",
+		"Allows the heat exchanger bypass, for free cooling or heating with outdoor air.",
+	},
+	"CfgHeatingEnable": {
+		"Povolení topení (dohřev přiváděného vzduchu).",
+		"Allows heating (of the supply air).",
+	},
+	"CfgCoolingEnable": {
+		"Povolení chlazení (CoolBreeze).",
+		"Allows cooling (CoolBreeze).",
+	},
+	"CfgComfortEnable": {
+		"Povolení režimu Komfort: jednotka řídí teplotu a vlhkost podle požadovaných hodnot.",
+		"Allows the Comfort mode: the unit controls temperature and humidity to the requested values.",
+	},
+	"VzvCBPriorityControl": {
+		"Priorita řízení CoolBreeze: 0 = teplota, 1 = CO2.",
+		"CoolBreeze control priority: 0 = temperature, 1 = CO2.",
+	},
+	"VzvKitchenhoodNormallyOpen": {
+		"Klapka digestoře je v klidovém stavu otevřená (1) nebo zavřená (0).",
+		"The kitchen hood damper is open (1) or closed (0) when idle.",
+	},
+	"VzvBoostVolumePerRun": {
+		"Průtok vzduchu funkce Boost spuštěné tlačítkem, v m³/h.",
+		"Air flow of the Boost function started by a button, in m³/h.",
+	},
+	"VzvKitchenhoodNormallyOpenVolume": {
+		"Průtok vzduchu při otevřené klapce digestoře, v m³/h.",
+		"Air flow with the kitchen hood damper open, in m³/h.",
+	},
+	"ExtSensTempCorr": {
+		"Korekce teploty externího čidla ve °C.",
+		"Temperature correction of the external sensor in °C.",
+	},
+	"ExtBtnPresent": {
+		"Externí tlačítko je připojeno.",
+		"The external button is installed.",
+	},
+	"ExtBtnMode": {
+		"Funkce externího tlačítka: 0 = Boost, 1 = digestoř.",
+		"Function of the external button: 0 = Boost, 1 = kitchen hood.",
+	},
+	"ExtBtnTm": {
+		"Doba běhu funkce po stisku externího tlačítka v sekundách.",
+		"How long the function runs after the external button is pressed, in seconds.",
+	},
+	"ExtBtnActive": {
+		"Funkce externího tlačítka právě běží (1); zápisem ji lze spustit nebo ukončit.",
+		"The function of the external button is running (1); writing starts or stops it.",
+	},
+	"ExtSensPresent": {
+		"Externí čidlo je připojeno a jeho hodnoty se zapisují přes Modbus.",
+		"The external sensor is installed and its readings are written over Modbus.",
+	},
+	"ExtSensInvalidate": {
+		"Neplatné hodnoty externího čidla, po bitech: 0 teplota, 1 vlhkost, 2 CO2, 3 teplota podlahy.",
+		"Invalid readings of the external sensor, by bit: 0 temperature, 1 humidity, 2 CO2, 3 floor temperature.",
+	},
+	"ExtSensTemp": {
+		"Teplota naměřená externím čidlem ve °C.",
+		"Temperature measured by the external sensor in °C.",
+	},
+	"ExtSensRH": {
+		"Relativní vlhkost naměřená externím čidlem v %.",
+		"Relative humidity measured by the external sensor in %.",
+	},
+	"ExtSensCo2": {
+		"Koncentrace CO2 naměřená externím čidlem v ppm.",
+		"CO2 concentration measured by the external sensor in ppm.",
+	},
+	"ExtSensTFloor": {
+		"Teplota podlahy naměřená externím čidlem ve °C.",
+		"Floor temperature measured by the external sensor in °C.",
+	},
+}
+
+// DocOf returns the description of a field of WriteableFields
+func DocOf(name string) (FieldDoc, bool) {
+	if d, ok := fieldDocs[name]; ok {
+		return d, true
+	}
+	if n := len(name); n > 1 && name[n-1] >= '1' && name[n-1] <= '8' {
+		d, ok := fieldDocs[name[:n-1]]
+		return d, ok
+	}
+	return FieldDoc{}, false
+}
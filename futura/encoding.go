@@ -0,0 +1,78 @@
+package futura
+
+import (
+	"fmt"
+	"math"
+)
+
+// Rounding selects how scaled values are rounded to register integers
+type Rounding int
+
+const (
+	RoundHalfUp   Rounding = iota // 0.5 rounds away from zero (21.25 °C -> 213)
+	RoundHalfEven                 // banker's rounding, 0.5 rounds to the even integer (21.25 °C -> 212)
+)
+
+// ParseRounding parses "half-up" or "half-even"
+func ParseRounding(s string) (Rounding, error) {
+	switch s {
+	case "half-up", "":
+		return RoundHalfUp, nil
+	case "half-even":
+		return RoundHalfEven, nil
+	}
+	return 0, fmt.Errorf("unknown rounding %q (half-up or half-even)", s)
+}
+
+// scaleToInt converts a value to its register integer (value/scale). Float
+// noise from the division (21.3/0.1 = 212.99999999999997) is removed first
+// so it can't decide a tie.
+func (r Rounding) scaleToInt(value, scale float64) float64 {
+	x := value / scale
+	x = math.Round(x*1e6) / 1e6
+	if r == RoundHalfEven {
+		return math.RoundToEven(x)
+	}
+	return math.Round(x)
+}
+
+// EncodeRegister converts a value to a register word with RoundHalfUp; see
+// Rounding.Encode
+func EncodeRegister(value, scale float64, signed bool) (word uint16, clamped bool) {
+	return RoundHalfUp.Encode(value, scale, signed)
+}
+
+// Encode converts a value to a register word, clamping it to the int16 or
+// uint16 range. clamped reports whether the value was out of range.
+func (r Rounding) Encode(value, scale float64, signed bool) (word uint16, clamped bool) {
+	x := r.scaleToInt(value, scale)
+	lo, hi := 0.0, float64(math.MaxUint16)
+	if signed {
+		lo, hi = math.MinInt16, math.MaxInt16
+	}
+	switch {
+	case math.IsNaN(x):
+		x, clamped = 0, true
+	case x < lo:
+		x, clamped = lo, true
+	case x > hi:
+		x, clamped = hi, true
+	}
+	if signed {
+		return uint16(int16(x)), clamped
+	}
+	return uint16(x), clamped
+}
+
+// encodeSigned and encodeUnsigned encode struct fields for bulk writes,
+// where out-of-range values are clamped. Read values round-trip exactly, so
+// the rounding only matters for values set by the caller.
+func encodeSigned(value, scale float64) uint16 {
+	w, _ := EncodeRegister(value, scale, true)
+	return w
+}
+
+func encodeUnsigned(value, scale float64) uint16 {
+	w, _ := EncodeRegister(value, scale, false)
+	return w
+}
@@ -0,0 +1,44 @@
+package futura
+
+import "testing"
+
+func TestRoundingEncode(t *testing.T) {
+	tests := []struct {
+		r       Rounding
+		value   float64
+		scale   float64
+		signed  bool
+		word    uint16
+		clamped bool
+	}{
+		{RoundHalfUp, 21.3, 0.1, true, 213, false}, // 212.99999999999997 before the noise is removed
+		{RoundHalfUp, 21.25, 0.1, true, 213, false},
+		{RoundHalfEven, 21.25, 0.1, true, 212, false},
+		{RoundHalfEven, 21.35, 0.1, true, 214, false},
+		{RoundHalfUp, -2.5, 0.1, true, 0xFFE7, false}, // -25
+		{RoundHalfUp, -0.05, 0.1, true, 0xFFFF, false},
+		{RoundHalfEven, -0.05, 0.1, true, 0, false},
+		{RoundHalfUp, 7200, 1, false, 7200, false},
+		{RoundHalfUp, 70000, 1, false, 0xFFFF, true},
+		{RoundHalfUp, -1, 1, false, 0, true},
+		{RoundHalfUp, 4000, 0.1, true, 0x7FFF, true},
+	}
+	for _, tt := range tests {
+		word, clamped := tt.r.Encode(tt.value, tt.scale, tt.signed)
+		if word != tt.word || clamped != tt.clamped {
+			t.Errorf("Rounding(%d).Encode(%v, %v, %v) = %#04x, %v, want %#04x, %v",
+				tt.r, tt.value, tt.scale, tt.signed, word, clamped, tt.word, tt.clamped)
+		}
+	}
+}
+
+func TestParseRounding(t *testing.T) {
+	for s, want := range map[string]Rounding{"": RoundHalfUp, "half-up": RoundHalfUp, "half-even": RoundHalfEven} {
+		if got, err := ParseRounding(s); err != nil || got != want {
+			t.Errorf("ParseRounding(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	if _, err := ParseRounding("up"); err == nil {
+		t.Error("ParseRounding(\"up\") succeeded")
+	}
+}
@@ -0,0 +1,881 @@
+package futura
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Addresses and layouts per FU_DOC_TCP_CS40
+const (
+	AddrFactDeviceID     = 0
+	AddrFactSerialNum    = 1  // +1
+	AddrFactEthernetMAC  = 3  // 3 registers (3*uint16)
+	AddrFactHWRevision   = 6  // +1
+	AddrFirmRevision     = 8  // +1
+	AddrSysBuildNumber   = 10 // +1
+	AddrSysRegmapVersion = 12 // +1
+	AddrSysOptions       = 14
+	AddrFutConfig        = 15
+	AddrFutMode          = 16 // +1
+	AddrFutError         = 18 // +1
+	AddrFutWarning       = 20 // +1
+
+	AddrFutTempAmbient = 30
+	AddrFutTempFresh   = 31
+	AddrFutTempIndoor  = 32
+	AddrFutTempWaste   = 33
+	AddrFutHumiAmbient = 34
+	AddrFutHumiFresh   = 35
+	AddrFutHumiIndoor  = 36
+	AddrFutHumiWaste   = 37
+	AddrFutTOut        = 38
+
+	AddrFutFilterWear     = 40
+	AddrPowerConsumption  = 41 // power_consumption, W
+	AddrHeatRecovering    = 42 // heat_recovering, W (not a %)
+	AddrHeatingPower      = 43 // heating_power, W
+	AddrAirFlow           = 44
+	AddrFanPWMSupply      = 45
+	AddrFanPWMExhaust     = 46
+	AddrFanRPMSupply      = 47
+	AddrFanRPMExhaust     = 48
+	AddrUin1Voltage       = 49
+	AddrUin2Voltage       = 50
+	AddrDigInputs         = 51
+	AddrSysBatteryVoltage = 52
+
+	AddrMBDevStatReads             = 60 // +1
+	AddrMBDevStatWrites            = 62 // +1
+	AddrMBDevStatFails             = 64 // +1
+	AddrMBDevConnectedMkUI         = 66
+	AddrMBDevConnectedMkSens       = 67 // +1
+	AddrMBDevConnectedCoolBreeze   = 69
+	AddrMBDevConnectedValveSupply  = 70 // +1
+	AddrMBDevConnectedValveExhaust = 72 // +1
+	AddrMBDevConnectedButton       = 74
+	AddrMBDevConnectedAlfa         = 75
+
+	AddrVzvIdentify = 80
+
+	// UI wall controllers start at 100, then 105,110 (3 units)
+	AddrUIBase  = 100
+	UIInstances = 3
+
+	// Wall sensors (1-8) start at 115 and step by 5
+	AddrSensBase  = 115
+	SensInstances = 8
+
+	// ALFA controllers base at 160 stepping by 10 up to 230
+	AddrAlfaBase  = 160
+	AlfaInstances = 8
+
+	// External sensors (1-8) at 300+ stepping by 10
+	AddrExtSensBase  = 300
+	ExtSensInstances = 8
+)
+
+// FutMode bits. Only the bypass bit is documented; the others are reported
+// as bitN until their meaning is known.
+const (
+	FutModeBypass = 1 << 2 // heat exchanger bypass open
+)
+
+// FutModeStates names the documented FutMode bits
+var FutModeStates = []struct {
+	Bit  uint32
+	Name string
+}{
+	{FutModeBypass, "bypass"},
+}
+
+// FutModeStateName returns the name of FutMode bit b, or bitN
+func FutModeStateName(b int) string {
+	for _, s := range FutModeStates {
+		if s.Bit == 1<<b {
+			return s.Name
+		}
+	}
+	return fmt.Sprintf("bit%d", b)
+}
+
+// DecodeFutMode returns the names of the states set in mode, in bit order;
+// bits without a name appear as bitN
+func DecodeFutMode(mode uint32) []string {
+	states := []string{}
+	for b := 0; b < 32; b++ {
+		if mode&(1<<b) != 0 {
+			states = append(states, FutModeStateName(b))
+		}
+	}
+	return states
+}
+
+// Holding registry addresses (for reference)
+const (
+	AddrHoldingFuncVentilation                  = 0
+	AddrHoldingFuncBoostTm                      = 1
+	AddrHoldingFuncCirculationTm                = 2
+	AddrHoldingFuncOverpressureTm               = 3
+	AddrHoldingFuncNightTm                      = 4
+	AddrHoldingFuncPartyTm                      = 5
+	AddrHoldingFuncAwayBegin                    = 6
+	AddrHoldingFuncAwayEnd                      = 8
+	AddrHoldingCfgTempSet                       = 10
+	AddrHoldingCfgHumiSet                       = 11
+	AddrHoldingFuncTimeProg                     = 12
+	AddrHoldingFuncAntiradon                    = 13
+	AddrHoldingCfgBypassEnable                  = 14
+	AddrHoldingCfgHeatingEnable                 = 15
+	AddrHoldingCfgCoolingEnable                 = 16
+	AddrHoldingCfgComfortEnable                 = 17
+	AddrHoldingVzvCBPriorityControl             = 20
+	AddrHoldingVzvKitchenhoodNormallyOpen       = 21
+	AddrHoldingVzvBoostVolumePerRun             = 22
+	AddrHoldingVzvKitchenhoodNormallyOpenVolume = 23
+
+	// UI corrections base at 100 stepping by 5
+	AddrHoldingUITempCorrBase = 100
+	HoldingUIInstances        = 3
+
+	// External sensor corrections base at 115 stepping by 5
+	AddrHoldingExtSensTempCorrBase = 115
+	HoldingExtSensInstances        = 8
+
+	// ALFA corrections base at 160 stepping by 5 (temp) and 162 (ntc temp)
+	AddrHoldingAlfaTempCorrBase    = 160
+	AddrHoldingAlfaNTCTempCorrBase = 162
+
+	// External buttons base at 400 stepping by 10
+	AddrHoldingExtBtnBase  = 400
+	HoldingExtBtnInstances = 8
+
+	// Security
+	AddrHoldingAccessCode      = 900
+	AddrHoldingUserPassword    = 920
+	AddrHoldingPasswordTimeout = 922
+)
+
+// SnapshotMeta identifies a set of decoded registers. Seq counts polls and
+// only increases; Time is when the registers were read. Stale marks
+// registers that are not from the latest read, e.g. restored from disk.
+type SnapshotMeta struct {
+	Seq   uint64
+	Time  time.Time
+	Stale bool
+}
+
+// InputRegs holds all relevant mapped input registers
+type InputRegs struct {
+	FactDeviceID     uint16
+	FactSerialNum    uint32
+	FactEthernetMAC  [3]uint16
+	FactHWRevision   uint32
+	FirmRevision     uint32
+	SysBuildNumber   uint32
+	SysRegmapVersion uint32
+	SysOptions       uint16
+	FutConfig        uint16
+	FutMode          uint32 // see FutModeStates
+	FutError         uint32
+	FutWarning       uint32
+
+	TempAmbient float64 // Celsius
+	TempFresh   float64
+	TempIndoor  float64
+	TempWaste   float64
+	HumiAmbient float64 // %
+	HumiFresh   float64
+	HumiIndoor  float64
+	HumiWaste   float64
+	TOut        float64
+
+	FilterWear        uint16
+	PowerConsumption  uint16 // W, electrical input of the unit
+	HeatRecovering    uint16 // W, heat recovered by the exchanger
+	HeatingPower      uint16 // W, electric (pre)heater
+	AirFlow           uint16
+	FanPWMSupply      uint16
+	FanPWMExhaust     uint16
+	FanRPMSupply      uint16
+	FanRPMExhaust     uint16
+	Uin1Voltage       uint16
+	Uin2Voltage       uint16
+	Uin1Scaled        float64 // Uin1 converted per the analog_inputs config
+	Uin2Scaled        float64
+	DigInputs         uint16
+	SysBatteryVoltage uint16
+
+	// Derived, not read from the unit
+	HeatRecoveryEfficiency     *float64 // %, nil when it can't be estimated
+	MoistureRecoveryEfficiency *float64 // %, latent counterpart of HeatRecoveryEfficiency
+	MAC                        string   // FactEthernetMAC as aa:bb:cc:dd:ee:ff
+	Serial                     string   // FactSerialNum, zero-padded as on the unit's label
+	HWRevision                 string   // FactHWRevision as major.minor
+	FWRevision                 string   // FirmRevision as major.minor, with SysBuildNumber
+	FutModeStates              []string // FutMode as state names, e.g. ["bypass","bit4"]
+
+	ExtBtnModeName [HoldingExtBtnInstances]string // ExtBtnMode as boost or hood
+
+	SnapshotMeta
+
+	MBDevStatReads             uint32
+	MBDevStatWrites            uint32
+	MBDevStatFails             uint32
+	MBDevConnectedMkUI         uint16
+	MBDevConnectedMkSens       uint32
+	MBDevConnectedCoolBreeze   uint16
+	MBDevConnectedValveSupply  uint32
+	MBDevConnectedValveExhaust uint32
+	MBDevConnectedButton       uint16
+	MBDevConnectedAlfa         uint16
+
+	VzvIdentify uint16
+
+	UIAddress [UIInstances]uint16
+	UIOptions [UIInstances]uint16
+	UICo2     [UIInstances]uint16
+	UITemp    [UIInstances]float64
+	UIHumi    [UIInstances]float64
+
+	SensMBAddress [SensInstances]uint16
+	SensOptions   [SensInstances]uint16
+	SensCo2       [SensInstances]uint16
+	SensTemp      [SensInstances]float64
+	SensHumi      [SensInstances]float64
+
+	AlfaMBAddress [AlfaInstances]uint16
+	AlfaOptions   [AlfaInstances]uint16
+	AlfaCo2       [AlfaInstances]uint16
+	AlfaTemp      [AlfaInstances]float64
+	AlfaHumi      [AlfaInstances]float64
+	AlfaNTCTemp   [AlfaInstances]float64
+
+	ExtSensPresent    [ExtSensInstances]uint16
+	ExtSensInvalidate [ExtSensInstances]uint16
+	ExtSensTemp       [ExtSensInstances]float64
+	ExtSensRH         [ExtSensInstances]float64
+	ExtSensCo2        [ExtSensInstances]uint16
+	ExtSensTFloor     [ExtSensInstances]float64
+
+	// External buttons (present, mode, tm, active) - mirrored from holdings so
+	// the /api/read-input endpoint can report their current state
+	ExtBtnPresent [HoldingExtBtnInstances]uint16
+	ExtBtnMode    [HoldingExtBtnInstances]uint16
+	ExtBtnTm      [HoldingExtBtnInstances]uint16
+	ExtBtnActive  [HoldingExtBtnInstances]uint16
+}
+
+// HoldingRegs holds all writable (holding) registers
+type HoldingRegs struct {
+	FuncVentilation                  uint16 // 0-6
+	FuncBoostTm                      uint16 // seconds
+	FuncCirculationTm                uint16
+	FuncOverpressureTm               uint16
+	FuncNightTm                      uint16
+	FuncPartyTm                      uint16
+	FuncAwayBegin                    uint32
+	FuncAwayEnd                      uint32
+	CfgTempSet                       float64 // 0.1°C
+	CfgHumiSet                       float64 // 0.1%
+	FuncTimeProg                     uint16  // 0/1
+	FuncAntiradon                    uint16  // 0/1
+	CfgBypassEnable                  uint16  // 0/1
+	CfgHeatingEnable                 uint16  // 0/1
+	CfgCoolingEnable                 uint16  // 0/1
+	CfgComfortEnable                 uint16  // 0/1
+	VzvCBPriorityControl             uint16  // 0/1
+	VzvKitchenhoodNormallyOpen       uint16  // 0/1
+	VzvBoostVolumePerRun             uint16  // m3/h
+	VzvKitchenhoodNormallyOpenVolume uint16  // m3/h
+
+	UITempCorr      [HoldingUIInstances]float64      // 0.1°C
+	ExtSensTempCorr [HoldingExtSensInstances]float64 // 0.1°C
+	AlfaTempCorr    [AlfaInstances]float64           // 0.1°C
+	AlfaNTCTempCorr [AlfaInstances]float64           // 0.1°C
+
+	ExtBtnPresent [HoldingExtBtnInstances]uint16 // 0/1
+	ExtBtnMode    [HoldingExtBtnInstances]uint16 // 0=boost, 1=hood
+	ExtBtnTm      [HoldingExtBtnInstances]uint16 // seconds
+	ExtBtnActive  [HoldingExtBtnInstances]uint16 // 0/1
+
+	AccessCode      uint16
+	UserPassword    uint16
+	PasswordTimeout uint16
+
+	// Names of the enum values above
+	FuncVentilationName      string                         // off, level1-level5 or auto
+	VzvCBPriorityControlName string                         // temperature or co2
+	ExtBtnModeName           [HoldingExtBtnInstances]string // boost or hood
+
+	SnapshotMeta
+}
+
+// simple helper to safely read address from map
+func u16(m map[uint16]uint16, addr uint16) uint16 { return m[addr] }
+
+func u32(m map[uint16]uint16, addr uint16) uint32 {
+	hi := uint32(m[addr])
+	lo := uint32(m[addr+1])
+	return (hi << 16) | lo
+}
+
+func i16f(m map[uint16]uint16, addr uint16, scale float64) float64 {
+	v := int16(m[addr])
+	return float64(v) * scale
+}
+
+func u16f(m map[uint16]uint16, addr uint16, scale float64) float64 {
+	return float64(m[addr]) * scale
+}
+
+// DecodeInputMap constructs InputRegs from a map[address]value
+func DecodeInputMap(m map[uint16]uint16) InputRegs {
+	r := InputRegs{}
+	// device & system
+	r.FactDeviceID = u16(m, AddrFactDeviceID)
+	r.FactSerialNum = u32(m, AddrFactSerialNum)
+	for i := 0; i < 3; i++ {
+		r.FactEthernetMAC[i] = u16(m, AddrFactEthernetMAC+uint16(i))
+	}
+	r.FactHWRevision = u32(m, AddrFactHWRevision)
+	r.FirmRevision = u32(m, AddrFirmRevision)
+	r.SysBuildNumber = u32(m, AddrSysBuildNumber)
+	r.SysRegmapVersion = u32(m, AddrSysRegmapVersion)
+	r.SysOptions = u16(m, AddrSysOptions)
+	r.FutConfig = u16(m, AddrFutConfig)
+	r.FutMode = u32(m, AddrFutMode)
+	r.FutError = u32(m, AddrFutError)
+	r.FutWarning = u32(m, AddrFutWarning)
+
+	// temps & humi (scale 0.1)
+	r.TempAmbient = i16f(m, AddrFutTempAmbient, 0.1)
+	r.TempFresh = i16f(m, AddrFutTempFresh, 0.1)
+	r.TempIndoor = i16f(m, AddrFutTempIndoor, 0.1)
+	r.TempWaste = i16f(m, AddrFutTempWaste, 0.1)
+	r.HumiAmbient = i16f(m, AddrFutHumiAmbient, 0.1)
+	r.HumiFresh = i16f(m, AddrFutHumiFresh, 0.1)
+	r.HumiIndoor = i16f(m, AddrFutHumiIndoor, 0.1)
+	r.HumiWaste = i16f(m, AddrFutHumiWaste, 0.1)
+	r.TOut = i16f(m, AddrFutTOut, 0.1)
+
+	// misc
+	r.FilterWear = u16(m, AddrFutFilterWear)
+	r.PowerConsumption = u16(m, AddrPowerConsumption)
+	r.HeatRecovering = u16(m, AddrHeatRecovering)
+	r.HeatingPower = u16(m, AddrHeatingPower)
+	r.AirFlow = u16(m, AddrAirFlow)
+	r.FanPWMSupply = u16(m, AddrFanPWMSupply)
+	r.FanPWMExhaust = u16(m, AddrFanPWMExhaust)
+	r.FanRPMSupply = u16(m, AddrFanRPMSupply)
+	r.FanRPMExhaust = u16(m, AddrFanRPMExhaust)
+	r.Uin1Voltage = u16(m, AddrUin1Voltage)
+	r.Uin2Voltage = u16(m, AddrUin2Voltage)
+	r.DigInputs = u16(m, AddrDigInputs)
+	r.SysBatteryVoltage = u16(m, AddrSysBatteryVoltage)
+	r.HeatRecoveryEfficiency = recoveryEfficiency(r)
+	r.MoistureRecoveryEfficiency = moistureRecoveryEfficiency(r)
+	r.FutModeStates = DecodeFutMode(r.FutMode)
+	r.MAC = formatMAC(r.FactEthernetMAC)
+	r.Serial = fmt.Sprintf("%08d", r.FactSerialNum)
+	r.HWRevision = formatRevision(r.FactHWRevision)
+	r.FWRevision = fmt.Sprintf("%s (build %d)", formatRevision(r.FirmRevision), r.SysBuildNumber)
+
+	// stats
+	r.MBDevStatReads = u32(m, AddrMBDevStatReads)
+	r.MBDevStatWrites = u32(m, AddrMBDevStatWrites)
+	r.MBDevStatFails = u32(m, AddrMBDevStatFails)
+	r.MBDevConnectedMkUI = u16(m, AddrMBDevConnectedMkUI)
+	r.MBDevConnectedMkSens = u32(m, AddrMBDevConnectedMkSens)
+	r.MBDevConnectedCoolBreeze = u16(m, AddrMBDevConnectedCoolBreeze)
+	r.MBDevConnectedValveSupply = u32(m, AddrMBDevConnectedValveSupply)
+	r.MBDevConnectedValveExhaust = u32(m, AddrMBDevConnectedValveExhaust)
+	r.MBDevConnectedButton = u16(m, AddrMBDevConnectedButton)
+	r.MBDevConnectedAlfa = u16(m, AddrMBDevConnectedAlfa)
+
+	r.VzvIdentify = u16(m, AddrVzvIdentify)
+
+	// UI instances
+	for i := 0; i < UIInstances; i++ {
+		base := AddrUIBase + uint16(i*5)
+		r.UIAddress[i] = u16(m, base)
+		r.UIOptions[i] = u16(m, base+1)
+		r.UICo2[i] = u16(m, base+2)
+		r.UITemp[i] = i16f(m, base+3, 0.1)
+		r.UIHumi[i] = u16f(m, base+4, 0.1)
+	}
+
+	// sensors 1..8 (step 5)
+	for i := 0; i < SensInstances; i++ {
+		base := AddrSensBase + uint16(i*5)
+		r.SensMBAddress[i] = u16(m, base)
+		r.SensOptions[i] = u16(m, base+1)
+		r.SensCo2[i] = u16(m, base+2)
+		r.SensTemp[i] = i16f(m, base+3, 0.1)
+		r.SensHumi[i] = u16f(m, base+4, 0.1)
+	}
+
+	// ALFA controllers (step 10)
+	for i := 0; i < AlfaInstances; i++ {
+		base := AddrAlfaBase + uint16(i*10)
+		r.AlfaMBAddress[i] = u16(m, base)
+		r.AlfaOptions[i] = u16(m, base+1)
+		r.AlfaCo2[i] = u16(m, base+2)
+		r.AlfaTemp[i] = i16f(m, base+3, 0.1)
+		r.AlfaHumi[i] = u16f(m, base+4, 0.1)
+		r.AlfaNTCTemp[i] = u16f(m, base+5, 0.1)
+	}
+
+	// External sensors (step 10 from 300: 300-305, 310-315, ..., 370-375)
+	for i := 0; i < ExtSensInstances; i++ {
+		base := AddrExtSensBase + uint16(i*10)
+		r.ExtSensPresent[i] = u16(m, base)
+		r.ExtSensInvalidate[i] = u16(m, base+1)
+		r.ExtSensTemp[i] = i16f(m, base+2, 0.1)
+		r.ExtSensRH[i] = u16f(m, base+3, 1.0)
+		r.ExtSensCo2[i] = u16(m, base+4)
+		r.ExtSensTFloor[i] = i16f(m, base+5, 0.1)
+	}
+
+	return r
+}
+
+// DecodeHoldingMap constructs HoldingRegs from a map[address]value
+func DecodeHoldingMap(m map[uint16]uint16) HoldingRegs {
+	r := HoldingRegs{}
+
+	r.FuncVentilation = u16(m, AddrHoldingFuncVentilation)
+	r.FuncBoostTm = u16(m, AddrHoldingFuncBoostTm)
+	r.FuncCirculationTm = u16(m, AddrHoldingFuncCirculationTm)
+	r.FuncOverpressureTm = u16(m, AddrHoldingFuncOverpressureTm)
+	r.FuncNightTm = u16(m, AddrHoldingFuncNightTm)
+	r.FuncPartyTm = u16(m, AddrHoldingFuncPartyTm)
+	r.FuncAwayBegin = u32(m, AddrHoldingFuncAwayBegin)
+	r.FuncAwayEnd = u32(m, AddrHoldingFuncAwayEnd)
+	r.CfgTempSet = i16f(m, AddrHoldingCfgTempSet, 0.1)
+	r.CfgHumiSet = u16f(m, AddrHoldingCfgHumiSet, 0.1)
+	r.FuncTimeProg = u16(m, AddrHoldingFuncTimeProg)
+	r.FuncAntiradon = u16(m, AddrHoldingFuncAntiradon)
+	r.CfgBypassEnable = u16(m, AddrHoldingCfgBypassEnable)
+	r.CfgHeatingEnable = u16(m, AddrHoldingCfgHeatingEnable)
+	r.CfgCoolingEnable = u16(m, AddrHoldingCfgCoolingEnable)
+	r.CfgComfortEnable = u16(m, AddrHoldingCfgComfortEnable)
+	r.VzvCBPriorityControl = u16(m, AddrHoldingVzvCBPriorityControl)
+	r.VzvKitchenhoodNormallyOpen = u16(m, AddrHoldingVzvKitchenhoodNormallyOpen)
+	r.VzvBoostVolumePerRun = u16(m, AddrHoldingVzvBoostVolumePerRun)
+	r.VzvKitchenhoodNormallyOpenVolume = u16(m, AddrHoldingVzvKitchenhoodNormallyOpenVolume)
+
+	// UI temp corrections
+	for i := 0; i < HoldingUIInstances; i++ {
+		addr := AddrHoldingUITempCorrBase + uint16(i*5)
+		r.UITempCorr[i] = i16f(m, addr, 0.1)
+	}
+
+	// External sensor temp corrections
+	for i := 0; i < HoldingExtSensInstances; i++ {
+		addr := AddrHoldingExtSensTempCorrBase + uint16(i*5)
+		r.ExtSensTempCorr[i] = i16f(m, addr, 0.1)
+	}
+
+	// ALFA temp corrections
+	for i := 0; i < AlfaInstances; i++ {
+		r.AlfaTempCorr[i] = i16f(m, AddrHoldingAlfaTempCorrBase+uint16(i*5), 0.1)
+		r.AlfaNTCTempCorr[i] = i16f(m, AddrHoldingAlfaNTCTempCorrBase+uint16(i*5), 0.1)
+	}
+
+	// External buttons
+	for i := 0; i < HoldingExtBtnInstances; i++ {
+		base := AddrHoldingExtBtnBase + uint16(i*10)
+		r.ExtBtnPresent[i] = u16(m, base)
+		r.ExtBtnMode[i] = u16(m, base+1)
+		r.ExtBtnTm[i] = u16(m, base+2)
+		r.ExtBtnActive[i] = u16(m, base+3)
+	}
+
+	r.AccessCode = u16(m, AddrHoldingAccessCode)
+	r.UserPassword = u16(m, AddrHoldingUserPassword)
+	r.PasswordTimeout = u16(m, AddrHoldingPasswordTimeout)
+
+	r.FuncVentilationName = enumName(ventilationNames, r.FuncVentilation)
+	r.VzvCBPriorityControlName = enumName(cbPriorityNames, r.VzvCBPriorityControl)
+	for i, m := range r.ExtBtnMode {
+		r.ExtBtnModeName[i] = enumName(extBtnModeNames, m)
+	}
+
+	return r
+}
+
+// MergeHoldingExt copies the external sensor and button values, which live in
+// holding registers, into the decoded input registers
+func MergeHoldingExt(r *InputRegs, holdingMap map[uint16]uint16) {
+	for i := 0; i < ExtSensInstances; i++ {
+		base := AddrExtSensBase + uint16(i*10)
+		r.ExtSensPresent[i] = u16(holdingMap, base)
+		r.ExtSensInvalidate[i] = u16(holdingMap, base+1)
+		r.ExtSensTemp[i] = i16f(holdingMap, base+2, 0.1)
+		r.ExtSensRH[i] = u16f(holdingMap, base+3, 1.0)
+		r.ExtSensCo2[i] = u16(holdingMap, base+4)
+		r.ExtSensTFloor[i] = i16f(holdingMap, base+5, 0.1)
+	}
+	for i := 0; i < HoldingExtBtnInstances; i++ {
+		base := AddrHoldingExtBtnBase + uint16(i*10)
+		r.ExtBtnPresent[i] = u16(holdingMap, base)
+		r.ExtBtnMode[i] = u16(holdingMap, base+1)
+		r.ExtBtnTm[i] = u16(holdingMap, base+2)
+		r.ExtBtnActive[i] = u16(holdingMap, base+3)
+		r.ExtBtnModeName[i] = enumName(extBtnModeNames, r.ExtBtnMode[i])
+	}
+}
+
+// EncodeHoldingRegs converts HoldingRegs back to map[uint16]uint16 for writing
+func EncodeHoldingRegs(r HoldingRegs) map[uint16]uint16 {
+	m := make(map[uint16]uint16)
+
+	m[AddrHoldingFuncVentilation] = r.FuncVentilation
+	m[AddrHoldingFuncBoostTm] = r.FuncBoostTm
+	m[AddrHoldingFuncCirculationTm] = r.FuncCirculationTm
+	m[AddrHoldingFuncOverpressureTm] = r.FuncOverpressureTm
+	m[AddrHoldingFuncNightTm] = r.FuncNightTm
+	m[AddrHoldingFuncPartyTm] = r.FuncPartyTm
+
+	// uint32 fields (split into two uint16s)
+	hi, lo := SplitU32(r.FuncAwayBegin)
+	m[AddrHoldingFuncAwayBegin] = hi
+	m[AddrHoldingFuncAwayBegin+1] = lo
+	hi, lo = SplitU32(r.FuncAwayEnd)
+	m[AddrHoldingFuncAwayEnd] = hi
+	m[AddrHoldingFuncAwayEnd+1] = lo
+
+	m[AddrHoldingCfgTempSet] = encodeSigned(r.CfgTempSet, 0.1)
+	m[AddrHoldingCfgHumiSet] = encodeUnsigned(r.CfgHumiSet, 0.1)
+	m[AddrHoldingFuncTimeProg] = r.FuncTimeProg
+	m[AddrHoldingFuncAntiradon] = r.FuncAntiradon
+	m[AddrHoldingCfgBypassEnable] = r.CfgBypassEnable
+	m[AddrHoldingCfgHeatingEnable] = r.CfgHeatingEnable
+	m[AddrHoldingCfgCoolingEnable] = r.CfgCoolingEnable
+	m[AddrHoldingCfgComfortEnable] = r.CfgComfortEnable
+	m[AddrHoldingVzvCBPriorityControl] = r.VzvCBPriorityControl
+	m[AddrHoldingVzvKitchenhoodNormallyOpen] = r.VzvKitchenhoodNormallyOpen
+	m[AddrHoldingVzvBoostVolumePerRun] = r.VzvBoostVolumePerRun
+	m[AddrHoldingVzvKitchenhoodNormallyOpenVolume] = r.VzvKitchenhoodNormallyOpenVolume
+
+	// UI temp corrections
+	for i := 0; i < HoldingUIInstances; i++ {
+		addr := AddrHoldingUITempCorrBase + uint16(i*5)
+		m[addr] = encodeSigned(r.UITempCorr[i], 0.1)
+	}
+
+	// External sensor temp corrections
+	for i := 0; i < HoldingExtSensInstances; i++ {
+		addr := AddrHoldingExtSensTempCorrBase + uint16(i*5)
+		m[addr] = encodeSigned(r.ExtSensTempCorr[i], 0.1)
+	}
+
+	// ALFA temp corrections
+	for i := 0; i < AlfaInstances; i++ {
+		m[AddrHoldingAlfaTempCorrBase+uint16(i*5)] = encodeSigned(r.AlfaTempCorr[i], 0.1)
+		m[AddrHoldingAlfaNTCTempCorrBase+uint16(i*5)] = encodeSigned(r.AlfaNTCTempCorr[i], 0.1)
+	}
+
+	// External buttons
+	for i := 0; i < HoldingExtBtnInstances; i++ {
+		base := AddrHoldingExtBtnBase + uint16(i*10)
+		m[base] = r.ExtBtnPresent[i]
+		m[base+1] = r.ExtBtnMode[i]
+		m[base+2] = r.ExtBtnTm[i]
+		m[base+3] = r.ExtBtnActive[i]
+	}
+
+	m[AddrHoldingAccessCode] = r.AccessCode
+	m[AddrHoldingUserPassword] = r.UserPassword
+	m[AddrHoldingPasswordTimeout] = r.PasswordTimeout
+
+	return m
+}
+
+// recoveryEfficiency estimates the supply-side temperature efficiency
+// (fresh - ambient) / (indoor - ambient). It is left out when indoor and
+// outdoor temperatures are too close for a stable ratio or the heater warms
+// the supply air.
+func recoveryEfficiency(r InputRegs) *float64 {
+	diff := r.TempIndoor - r.TempAmbient
+	if math.Abs(diff) < 3 || r.HeatingPower > 0 {
+		return nil
+	}
+	eff := (r.TempFresh - r.TempAmbient) / diff * 100
+	eff = math.Round(math.Max(0, math.Min(100, eff))*10) / 10
+	return &eff
+}
+
+// moistureRecoveryEfficiency estimates the supply-side moisture (latent)
+// efficiency from the humidity ratios of the air streams,
+// (fresh - ambient) / (indoor - ambient). It is left out when a humidity
+// sensor reads 0 or indoor and outdoor moisture are too close for a stable
+// ratio. Only an enthalpy exchanger recovers moisture; on other units this
+// stays around 0.
+func moistureRecoveryEfficiency(r InputRegs) *float64 {
+	if r.HumiAmbient <= 0 || r.HumiFresh <= 0 || r.HumiIndoor <= 0 {
+		return nil
+	}
+	ambient := humidityRatio(r.TempAmbient, r.HumiAmbient)
+	diff := humidityRatio(r.TempIndoor, r.HumiIndoor) - ambient
+	if math.Abs(diff) < 1 {
+		return nil
+	}
+	eff := (humidityRatio(r.TempFresh, r.HumiFresh) - ambient) / diff * 100
+	eff = math.Round(math.Max(0, math.Min(100, eff))*10) / 10
+	return &eff
+}
+
+// humidityRatio returns the water content of air (g/kg of dry air) at t °C
+// and rh % relative humidity at standard pressure, with the Magnus formula
+// for the saturation pressure
+func humidityRatio(t, rh float64) float64 {
+	psat := 6.112 * math.Exp(17.62*t/(243.12+t)) // hPa
+	pv := rh / 100 * psat
+	return 622 * pv / (1013.25 - pv)
+}
+
+// formatMAC prints the MAC registers (two bytes each, high byte first)
+func formatMAC(w [3]uint16) string {
+	return fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x",
+		w[0]>>8, w[0]&0xFF, w[1]>>8, w[1]&0xFF, w[2]>>8, w[2]&0xFF)
+}
+
+// formatRevision prints a revision whose high register is the major and the
+// low register the minor number
+func formatRevision(v uint32) string {
+	return fmt.Sprintf("%d.%d", v>>16, v&0xFFFF)
+}
+
+// SplitU32 splits a uint32 into high and low uint16
+func SplitU32(v uint32) (uint16, uint16) {
+	return uint16(v >> 16), uint16(v & 0xFFFF)
+}
+
+// WriteFieldSpec describes a writable field (addr, scale, register count)
+// and the values the unit accepts for it
+type WriteFieldSpec struct {
+	Addr     uint16
+	Scale    float64 // multiplier to convert float -> register value (value/Scale -> encoded integer)
+	RegCount int     // number of registers used (1 or 2)
+	Signed   bool    // encoded as int16 (temperatures)
+
+	Checked  bool       // Min and Max apply; otherwise any value the register holds
+	Min, Max float64    // accepted range in field units
+	Integer  bool       // only whole numbers, e.g. levels and seconds
+	Enum     []float64  // the only accepted values, checked instead of Min/Max
+	Names    []EnumName // names of the values, accepted in writes instead of the number
+}
+
+// EnumName names a value of an enum field
+type EnumName struct {
+	Value float64
+	Name  string // used in JSON and accepted in writes
+	Label string // shown in the UI
+}
+
+// onOff is the Enum of switches
+var onOff = []float64{0, 1}
+
+// Names of enum fields
+var (
+	ventilationNames = []EnumName{
+		{0, "off", "Off"}, {1, "level1", "1"}, {2, "level2", "2"}, {3, "level3", "3"},
+		{4, "level4", "4"}, {5, "level5", "5"}, {6, "auto", "Auto"},
+	}
+	extBtnModeNames = []EnumName{{0, "boost", "Boost"}, {1, "hood", "Hood"}}
+	cbPriorityNames = []EnumName{{0, "temperature", "Temperature"}, {1, "co2", "CO2"}}
+)
+
+// enumName returns the name of v, or v as a number when it has none
+func enumName(names []EnumName, v uint16) string {
+	for _, n := range names {
+		if n.Value == float64(v) {
+			return n.Name
+		}
+	}
+	return strconv.Itoa(int(v))
+}
+
+// ValueOf returns the value named name (case-insensitive)
+func (s WriteFieldSpec) ValueOf(name string) (float64, bool) {
+	for _, n := range s.Names {
+		if strings.EqualFold(n.Name, name) {
+			return n.Value, true
+		}
+	}
+	return 0, false
+}
+
+// NameOf returns the name of value, or "" when it has none
+func (s WriteFieldSpec) NameOf(value float64) string {
+	for _, n := range s.Names {
+		if n.Value == value {
+			return n.Name
+		}
+	}
+	return ""
+}
+
+// NameList returns the names of the values, comma separated
+func (s WriteFieldSpec) NameList() string {
+	names := make([]string, len(s.Names))
+	for i, n := range s.Names {
+		names[i] = n.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// Validate returns an error when value is outside the range or not one of
+// the Enum values of the field
+func (s WriteFieldSpec) Validate(value float64) error {
+	if len(s.Enum) > 0 {
+		for _, v := range s.Enum {
+			if value == v {
+				return nil
+			}
+		}
+		vals := make([]string, len(s.Enum))
+		for i, v := range s.Enum {
+			vals[i] = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		return fmt.Errorf("must be one of %s", strings.Join(vals, ", "))
+	}
+	if s.Checked && (value < s.Min || value > s.Max) {
+		return fmt.Errorf("must be between %g and %g", s.Min, s.Max)
+	}
+	if s.Integer && value != math.Trunc(value) {
+		return fmt.Errorf("must be a whole number")
+	}
+	return nil
+}
+
+// WriteableFields lists fields that may be written via single-register writes
+var WriteableFields = map[string]WriteFieldSpec{
+	"FuncVentilation":                  {Addr: AddrHoldingFuncVentilation, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 6, Names: ventilationNames},
+	"FuncBoostTm":                      {Addr: AddrHoldingFuncBoostTm, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 7200},
+	"FuncCirculationTm":                {Addr: AddrHoldingFuncCirculationTm, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 7200},
+	"FuncOverpressureTm":               {Addr: AddrHoldingFuncOverpressureTm, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 7200},
+	"FuncNightTm":                      {Addr: AddrHoldingFuncNightTm, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 7200},
+	"FuncPartyTm":                      {Addr: AddrHoldingFuncPartyTm, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 28800},
+	"CfgTempSet":                       {Addr: AddrHoldingCfgTempSet, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: 10, Max: 30},
+	"CfgHumiSet":                       {Addr: AddrHoldingCfgHumiSet, Scale: 0.1, RegCount: 1, Checked: true, Max: 100},
+	"FuncTimeProg":                     {Addr: AddrHoldingFuncTimeProg, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"FuncAntiradon":                    {Addr: AddrHoldingFuncAntiradon, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"CfgBypassEnable":                  {Addr: AddrHoldingCfgBypassEnable, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"CfgHeatingEnable":                 {Addr: AddrHoldingCfgHeatingEnable, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"CfgCoolingEnable":                 {Addr: AddrHoldingCfgCoolingEnable, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"CfgComfortEnable":                 {Addr: AddrHoldingCfgComfortEnable, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"VzvCBPriorityControl":             {Addr: AddrHoldingVzvCBPriorityControl, Scale: 1.0, RegCount: 1, Enum: onOff, Names: cbPriorityNames},
+	"VzvKitchenhoodNormallyOpen":       {Addr: AddrHoldingVzvKitchenhoodNormallyOpen, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"VzvBoostVolumePerRun":             {Addr: AddrHoldingVzvBoostVolumePerRun, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Min: 50, Max: 150},
+	"VzvKitchenhoodNormallyOpenVolume": {Addr: AddrHoldingVzvKitchenhoodNormallyOpenVolume, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Min: 50, Max: 150},
+
+	// External sensor temperature corrections (1..8). The register map gives
+	// no range for these and the external sensor readings below; theirs are
+	// the limits the edit page has always used.
+	"ExtSensTempCorr1": {Addr: AddrHoldingExtSensTempCorrBase + 0, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 50},
+	"ExtSensTempCorr2": {Addr: AddrHoldingExtSensTempCorrBase + 5, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 50},
+	"ExtSensTempCorr3": {Addr: AddrHoldingExtSensTempCorrBase + 10, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 50},
+	"ExtSensTempCorr4": {Addr: AddrHoldingExtSensTempCorrBase + 15, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 50},
+	"ExtSensTempCorr5": {Addr: AddrHoldingExtSensTempCorrBase + 20, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 50},
+	"ExtSensTempCorr6": {Addr: AddrHoldingExtSensTempCorrBase + 25, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 50},
+	"ExtSensTempCorr7": {Addr: AddrHoldingExtSensTempCorrBase + 30, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 50},
+	"ExtSensTempCorr8": {Addr: AddrHoldingExtSensTempCorrBase + 35, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 50},
+	// External buttons (present, mode, tm, active) - 8 instances
+	"ExtBtnPresent1": {Addr: AddrHoldingExtBtnBase + 0, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtBtnMode1":    {Addr: AddrHoldingExtBtnBase + 1, Scale: 1.0, RegCount: 1, Enum: onOff, Names: extBtnModeNames},
+	"ExtBtnTm1":      {Addr: AddrHoldingExtBtnBase + 2, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 3600},
+	"ExtBtnActive1":  {Addr: AddrHoldingExtBtnBase + 3, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtBtnPresent2": {Addr: AddrHoldingExtBtnBase + 10, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtBtnMode2":    {Addr: AddrHoldingExtBtnBase + 11, Scale: 1.0, RegCount: 1, Enum: onOff, Names: extBtnModeNames},
+	"ExtBtnTm2":      {Addr: AddrHoldingExtBtnBase + 12, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 3600},
+	"ExtBtnActive2":  {Addr: AddrHoldingExtBtnBase + 13, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtBtnPresent3": {Addr: AddrHoldingExtBtnBase + 20, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtBtnMode3":    {Addr: AddrHoldingExtBtnBase + 21, Scale: 1.0, RegCount: 1, Enum: onOff, Names: extBtnModeNames},
+	"ExtBtnTm3":      {Addr: AddrHoldingExtBtnBase + 22, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 3600},
+	"ExtBtnActive3":  {Addr: AddrHoldingExtBtnBase + 23, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtBtnPresent4": {Addr: AddrHoldingExtBtnBase + 30, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtBtnMode4":    {Addr: AddrHoldingExtBtnBase + 31, Scale: 1.0, RegCount: 1, Enum: onOff, Names: extBtnModeNames},
+	"ExtBtnTm4":      {Addr: AddrHoldingExtBtnBase + 32, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 3600},
+	"ExtBtnActive4":  {Addr: AddrHoldingExtBtnBase + 33, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtBtnPresent5": {Addr: AddrHoldingExtBtnBase + 40, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtBtnMode5":    {Addr: AddrHoldingExtBtnBase + 41, Scale: 1.0, RegCount: 1, Enum: onOff, Names: extBtnModeNames},
+	"ExtBtnTm5":      {Addr: AddrHoldingExtBtnBase + 42, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 3600},
+	"ExtBtnActive5":  {Addr: AddrHoldingExtBtnBase + 43, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtBtnPresent6": {Addr: AddrHoldingExtBtnBase + 50, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtBtnMode6":    {Addr: AddrHoldingExtBtnBase + 51, Scale: 1.0, RegCount: 1, Enum: onOff, Names: extBtnModeNames},
+	"ExtBtnTm6":      {Addr: AddrHoldingExtBtnBase + 52, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 3600},
+	"ExtBtnActive6":  {Addr: AddrHoldingExtBtnBase + 53, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtBtnPresent7": {Addr: AddrHoldingExtBtnBase + 60, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtBtnMode7":    {Addr: AddrHoldingExtBtnBase + 61, Scale: 1.0, RegCount: 1, Enum: onOff, Names: extBtnModeNames},
+	"ExtBtnTm7":      {Addr: AddrHoldingExtBtnBase + 62, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 3600},
+	"ExtBtnActive7":  {Addr: AddrHoldingExtBtnBase + 63, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtBtnPresent8": {Addr: AddrHoldingExtBtnBase + 70, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtBtnMode8":    {Addr: AddrHoldingExtBtnBase + 71, Scale: 1.0, RegCount: 1, Enum: onOff, Names: extBtnModeNames},
+	"ExtBtnTm8":      {Addr: AddrHoldingExtBtnBase + 72, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 3600},
+	"ExtBtnActive8":  {Addr: AddrHoldingExtBtnBase + 73, Scale: 1.0, RegCount: 1, Enum: onOff},
+
+	// External sensor present/invalidate (addresses mirror input ext sensors at 300+, step 10)
+	"ExtSensPresent1":    {Addr: AddrExtSensBase + 0, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtSensInvalidate1": {Addr: AddrExtSensBase + 1, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 15},
+	"ExtSensPresent2":    {Addr: AddrExtSensBase + 10, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtSensInvalidate2": {Addr: AddrExtSensBase + 11, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 15},
+	"ExtSensPresent3":    {Addr: AddrExtSensBase + 20, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtSensInvalidate3": {Addr: AddrExtSensBase + 21, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 15},
+	"ExtSensPresent4":    {Addr: AddrExtSensBase + 30, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtSensInvalidate4": {Addr: AddrExtSensBase + 31, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 15},
+	"ExtSensPresent5":    {Addr: AddrExtSensBase + 40, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtSensInvalidate5": {Addr: AddrExtSensBase + 41, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 15},
+	"ExtSensPresent6":    {Addr: AddrExtSensBase + 50, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtSensInvalidate6": {Addr: AddrExtSensBase + 51, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 15},
+	"ExtSensPresent7":    {Addr: AddrExtSensBase + 60, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtSensInvalidate7": {Addr: AddrExtSensBase + 61, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 15},
+	"ExtSensPresent8":    {Addr: AddrExtSensBase + 70, Scale: 1.0, RegCount: 1, Enum: onOff},
+	"ExtSensInvalidate8": {Addr: AddrExtSensBase + 71, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 15},
+
+	// Allow writing live external sensor readings (for testing)
+	// For each sensor N (1..8) addresses are AddrExtSensBase + (N-1)*10 + offset
+	"ExtSensTemp1":   {Addr: AddrExtSensBase + 2, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 100},
+	"ExtSensRH1":     {Addr: AddrExtSensBase + 3, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 100},
+	"ExtSensCo21":    {Addr: AddrExtSensBase + 4, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 10000},
+	"ExtSensTFloor1": {Addr: AddrExtSensBase + 5, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 100},
+
+	"ExtSensTemp2":   {Addr: AddrExtSensBase + 12, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 100},
+	"ExtSensRH2":     {Addr: AddrExtSensBase + 13, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 100},
+	"ExtSensCo22":    {Addr: AddrExtSensBase + 14, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 10000},
+	"ExtSensTFloor2": {Addr: AddrExtSensBase + 15, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 100},
+
+	"ExtSensTemp3":   {Addr: AddrExtSensBase + 22, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 100},
+	"ExtSensRH3":     {Addr: AddrExtSensBase + 23, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 100},
+	"ExtSensCo23":    {Addr: AddrExtSensBase + 24, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 10000},
+	"ExtSensTFloor3": {Addr: AddrExtSensBase + 25, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 100},
+
+	"ExtSensTemp4":   {Addr: AddrExtSensBase + 32, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 100},
+	"ExtSensRH4":     {Addr: AddrExtSensBase + 33, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 100},
+	"ExtSensCo24":    {Addr: AddrExtSensBase + 34, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 10000},
+	"ExtSensTFloor4": {Addr: AddrExtSensBase + 35, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 100},
+
+	"ExtSensTemp5":   {Addr: AddrExtSensBase + 42, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 100},
+	"ExtSensRH5":     {Addr: AddrExtSensBase + 43, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 100},
+	"ExtSensCo25":    {Addr: AddrExtSensBase + 44, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 10000},
+	"ExtSensTFloor5": {Addr: AddrExtSensBase + 45, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 100},
+
+	"ExtSensTemp6":   {Addr: AddrExtSensBase + 52, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 100},
+	"ExtSensRH6":     {Addr: AddrExtSensBase + 53, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 100},
+	"ExtSensCo26":    {Addr: AddrExtSensBase + 54, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 10000},
+	"ExtSensTFloor6": {Addr: AddrExtSensBase + 55, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 100},
+
+	"ExtSensTemp7":   {Addr: AddrExtSensBase + 62, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 100},
+	"ExtSensRH7":     {Addr: AddrExtSensBase + 63, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 100},
+	"ExtSensCo27":    {Addr: AddrExtSensBase + 64, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 10000},
+	"ExtSensTFloor7": {Addr: AddrExtSensBase + 65, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 100},
+
+	"ExtSensTemp8":   {Addr: AddrExtSensBase + 72, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 100},
+	"ExtSensRH8":     {Addr: AddrExtSensBase + 73, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 100},
+	"ExtSensCo28":    {Addr: AddrExtSensBase + 74, Scale: 1.0, RegCount: 1, Checked: true, Integer: true, Max: 10000},
+	"ExtSensTFloor8": {Addr: AddrExtSensBase + 75, Scale: 0.1, RegCount: 1, Signed: true, Checked: true, Min: -50, Max: 100},
+}
@@ -0,0 +1,56 @@
+package futura
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteFieldSpecValidate(t *testing.T) {
+	tests := []struct {
+		field string
+		value float64
+		error string // substring; empty = valid
+	}{
+		{"FuncVentilation", 0, ""},
+		{"FuncVentilation", 6, ""},
+		{"FuncVentilation", 7, "between 0 and 6"},
+		{"FuncVentilation", 2.5, "whole number"},
+		{"FuncBoostTm", 7200, ""},
+		{"FuncBoostTm", 90.5, "whole number"},
+		{"CfgTempSet", 21.5, ""},
+		{"CfgTempSet", 9.9, "between 10 and 30"},
+		{"CfgHumiSet", 0, ""}, // Min 0 is checked, not a sentinel
+		{"CfgHumiSet", -1, "between 0 and 100"},
+		{"FuncAntiradon", 1, ""},
+		{"FuncAntiradon", 0.5, "one of 0, 1"},
+		{"ExtSensTempCorr1", -50, ""},
+		{"ExtSensTempCorr1", 50.1, "between -50 and 50"},
+		{"ExtSensTemp1", 99.5, ""},
+		{"ExtSensTemp1", -50.5, "between -50 and 100"},
+	}
+	for _, tt := range tests {
+		err := WriteableFields[tt.field].Validate(tt.value)
+		switch {
+		case tt.error == "" && err != nil:
+			t.Errorf("%s = %v: %v", tt.field, tt.value, err)
+		case tt.error != "" && (err == nil || !strings.Contains(err.Error(), tt.error)):
+			t.Errorf("%s = %v: error %v, want one containing %q", tt.field, tt.value, err, tt.error)
+		}
+	}
+
+	// a field without Checked takes any value
+	if err := (WriteFieldSpec{Scale: 1}).Validate(-12345.5); err != nil {
+		t.Errorf("unchecked field: %v", err)
+	}
+}
+
+func TestWriteableFieldsRanges(t *testing.T) {
+	for name, spec := range WriteableFields {
+		if spec.Checked && spec.Min > spec.Max {
+			t.Errorf("%s: Min %g > Max %g", name, spec.Min, spec.Max)
+		}
+		if spec.Scale == 1 && len(spec.Enum) == 0 && !spec.Integer {
+			t.Errorf("%s: a field in whole units must be Integer", name)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/danielkucera/gofutura/futura"
+)
+
+// Grafana Live push: every poll is sent to Grafana's push endpoint in Influx
+// line protocol. Grafana turns each measurement into a channel
+// stream/<stream>/<measurement> that panels can subscribe to, so they update
+// on every poll instead of every Prometheus scrape.
+
+// GrafanaLiveConfig enables the push
+type GrafanaLiveConfig struct {
+	URL    string `yaml:"url"`    // Grafana base URL, e.g. http://grafana.local:3000
+	Token  string `yaml:"token"`  // service account token with the Editor role
+	Stream string `yaml:"stream"` // stream id (default gofutura)
+}
+
+func (c GrafanaLiveConfig) validate() error {
+	if c.URL != "" && c.Token == "" {
+		return fmt.Errorf("grafana_live.token is required")
+	}
+	if strings.ContainsAny(c.Stream, "/ ") {
+		return fmt.Errorf("grafana_live.stream must not contain / or spaces")
+	}
+	return nil
+}
+
+var (
+	grafanaBusy   atomic.Bool // a push is in flight
+	grafanaMu     sync.Mutex
+	grafanaFailed bool // last push failed, logged once until it works again
+)
+
+// grafanaLines encodes a snapshot as line protocol, one measurement per
+// group of related values
+func grafanaLines(r futura.InputRegs) []byte {
+	var b bytes.Buffer
+	ts := r.Time.UnixNano()
+	line := func(measurement string, fields ...interface{}) {
+		b.WriteString(measurement)
+		for i := 0; i < len(fields); i += 2 {
+			sep := ","
+			if i == 0 {
+				sep = " "
+			}
+			switch v := fields[i+1].(type) {
+			case float64:
+				fmt.Fprintf(&b, "%s%s=%g", sep, fields[i], v)
+			default:
+				fmt.Fprintf(&b, "%s%s=%vi", sep, fields[i], v)
+			}
+		}
+		fmt.Fprintf(&b, " %d\n", ts)
+	}
+	line("temperature", "ambient", r.TempAmbient, "fresh", r.TempFresh, "indoor", r.TempIndoor, "waste", r.TempWaste)
+	line("humidity", "ambient", r.HumiAmbient, "fresh", r.HumiFresh, "indoor", r.HumiIndoor, "waste", r.HumiWaste)
+	line("fans", "air_flow", r.AirFlow, "pwm_supply", r.FanPWMSupply, "pwm_exhaust", r.FanPWMExhaust,
+		"rpm_supply", r.FanRPMSupply, "rpm_exhaust", r.FanRPMExhaust)
+	line("power", "consumption", r.PowerConsumption, "heat_recovering", r.HeatRecovering, "heating", r.HeatingPower)
+	if co2 := maxCo2(r); co2 > 0 {
+		line("co2", "max", co2)
+	}
+	return b.Bytes()
+}
+
+// pushGrafanaLive sends a polled snapshot to Grafana. Pushes don't queue: if
+// the previous one is still running the snapshot is skipped.
+func pushGrafanaLive(r futura.InputRegs) {
+	cfg := appConfig().GrafanaLive
+	if cfg.URL == "" || !grafanaBusy.CompareAndSwap(false, true) {
+		return
+	}
+	body := grafanaLines(r)
+	go func() {
+		defer grafanaBusy.Store(false)
+		err := sendGrafanaLive(cfg, body)
+
+		grafanaMu.Lock()
+		defer grafanaMu.Unlock()
+		switch {
+		case err != nil && !grafanaFailed:
+			log.Printf("grafana live push: %v", err)
+		case err == nil && grafanaFailed:
+			log.Printf("grafana live push works again")
+		}
+		grafanaFailed = err != nil
+	}()
+}
+
+func sendGrafanaLive(cfg GrafanaLiveConfig, body []byte) error {
+	stream := cfg.Stream
+	if stream == "" {
+		stream = "gofutura"
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimRight(cfg.URL, "/")+"/api/live/push/"+stream, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.Token)
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
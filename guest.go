@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// GuestConfig configures guest override tokens
+type GuestConfig struct {
+	Secret   string `yaml:"secret"`    // HMAC key; a random key is used when empty (tokens expire on restart)
+	MaxHours int    `yaml:"max_hours"` // longest validity a token may be issued for (default 72)
+}
+
+// guestFields are the only fields a guest token may write, with their
+// allowed range
+var guestFields = map[string][2]float64{
+	"FuncVentilation": {1, 6},    // levels 1-5 and auto
+	"FuncBoostTm":     {0, 7200}, // seconds
+}
+
+var errGuestExpired = errors.New("token expired")
+
+// guestClaims is the signed payload of a guest token
+type guestClaims struct {
+	Expires int64 `json:"exp"` // unix seconds
+}
+
+var guestKey []byte
+
+func initGuestKey() {
+	if appConfig().Guest.Secret != "" {
+		guestKey = []byte(appConfig().Guest.Secret)
+		return
+	}
+	guestKey = make([]byte, 32)
+	if _, err := rand.Read(guestKey); err != nil {
+		log.Fatalf("Failed to generate guest token key: %v", err)
+	}
+}
+
+// signGuestToken issues a token valid until exp
+func signGuestToken(exp time.Time) string {
+	payload, _ := json.Marshal(guestClaims{Expires: exp.Unix()})
+	mac := hmac.New(sha256.New, guestKey)
+	mac.Write(payload)
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(payload) + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+// verifyGuestToken checks the signature and expiry of a token
+func verifyGuestToken(token string) (guestClaims, error) {
+	var claims guestClaims
+	enc := base64.RawURLEncoding
+	payloadPart, sigPart, ok := strings.Cut(token, ".")
+	if !ok {
+		return claims, errors.New("malformed token")
+	}
+	payload, err := enc.DecodeString(payloadPart)
+	if err != nil {
+		return claims, errors.New("malformed token")
+	}
+	sig, err := enc.DecodeString(sigPart)
+	if err != nil {
+		return claims, errors.New("malformed token")
+	}
+	mac := hmac.New(sha256.New, guestKey)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return claims, errors.New("invalid token signature")
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, errors.New("malformed token")
+	}
+	if time.Now().Unix() > claims.Expires {
+		return claims, errGuestExpired
+	}
+	return claims, nil
+}
+
+// guestToken extracts the token from the Authorization header or ?token=
+func guestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+// handleGuestToken issues a guest token for {"hours":N}
+func handleGuestToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		fmt.Fprintf(w, `{"success":false,"error":"POST required"}`)
+		return
+	}
+	var req struct {
+		Hours int `json:"hours"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		fmt.Fprintf(w, `{"success":false,"error":"Invalid JSON"}`)
+		return
+	}
+	maxHours := appConfig().Guest.MaxHours
+	if maxHours == 0 {
+		maxHours = 72
+	}
+	if req.Hours < 1 || req.Hours > maxHours {
+		fmt.Fprintf(w, `{"success":false,"error":"hours must be between 1 and %d"}`, maxHours)
+		return
+	}
+
+	exp := time.Now().Add(time.Duration(req.Hours) * time.Hour)
+	token := signGuestToken(exp)
+	resp := struct {
+		Success bool      `json:"success"`
+		Token   string    `json:"token"`
+		Expires time.Time `json:"expires"`
+		Link    string    `json:"link"`
+	}{true, token, exp, "/static/guest.html#token=" + token}
+	log.Printf("Issued guest token valid until %s", exp.Format(time.RFC3339))
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("encode guest token json: %v", err)
+	}
+}
+
+// handleGuestQR renders a QR code for ?link= (an absolute guest link)
+func handleGuestQR(w http.ResponseWriter, r *http.Request) {
+	link := r.URL.Query().Get("link")
+	u, err := url.Parse(link)
+	if err != nil || !u.IsAbs() || !strings.HasPrefix(u.Fragment, "token=") {
+		http.Error(w, "invalid link", http.StatusBadRequest)
+		return
+	}
+	if _, err := verifyGuestToken(strings.TrimPrefix(u.Fragment, "token=")); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	png, err := qrcode.Encode(link, qrcode.Medium, 256)
+	if err != nil {
+		http.Error(w, "qr encode error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
+// handleGuestAction writes ventilation level or boost for a valid guest token
+func handleGuestAction(client *ModbusConn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if authLocked(w, r) {
+			return
+		}
+		if _, err := verifyGuestToken(guestToken(r)); err != nil {
+			// expired links are an honest mistake, not an attack
+			if err != errGuestExpired {
+				authFailed(r, "guest token")
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+			return
+		}
+		if r.Method != http.MethodPost {
+			fmt.Fprintf(w, `{"success":false,"error":"POST required"}`)
+			return
+		}
+		var data map[string]float64
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil || len(data) != 1 {
+			fmt.Fprintf(w, `{"success":false,"error":"expected a single field"}`)
+			return
+		}
+		for k, v := range data {
+			limits, ok := guestFields[k]
+			if !ok {
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprintf(w, `{"success":false,"error":%q}`, k+" is not allowed for guests")
+				return
+			}
+			if v < limits[0] || v > limits[1] {
+				fmt.Fprintf(w, `{"success":false,"error":%q}`, fmt.Sprintf("%s must be between %g and %g", k, limits[0], limits[1]))
+				return
+			}
+			if err := checkWritePolicy(r, k, v); err != nil {
+				fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+				return
+			}
+			log.Printf("Guest write: %s = %v", k, v)
+			if err := WriteSingleRegister(client, k, v, requestOrigin(r, "guest")); err != nil {
+				fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+				return
+			}
+			fmt.Fprintf(w, `{"success":true,"message":"%s updated"}`, k)
+		}
+	}
+}
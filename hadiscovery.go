@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/danielkucera/gofutura/futura"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Home Assistant MQTT discovery: with mqtt.ha_discovery every poll is
+// published (retained) to <topic_prefix>/state (input registers) and
+// <topic_prefix>/settings (holding registers), and the entities reading
+// them are announced under <discovery_prefix>/<component>/futura_<serial>/.
+// Commands arrive on <topic_prefix>/set/<field>.
+
+const haDefaultPrefix = "homeassistant"
+
+var (
+	haMu        sync.Mutex
+	haAnnounced string // serial the entities were announced for
+	haConn      *ModbusConn
+)
+
+func haDiscoveryPrefix() string {
+	if p := appConfig().MQTT.DiscoveryPrefix; p != "" {
+		return p
+	}
+	return haDefaultPrefix
+}
+
+// haSubscribe listens for commands and Home Assistant restarts; it runs on
+// every (re)connect, after which the next poll announces the entities again
+func haSubscribe(c mqtt.Client) {
+	haMu.Lock()
+	haAnnounced = ""
+	haMu.Unlock()
+	c.Subscribe(mqttTopic("set/+"), 0, func(_ mqtt.Client, m mqtt.Message) {
+		name := strings.TrimPrefix(m.Topic(), mqttTopic("set/"))
+		go haCommand(name, string(m.Payload()))
+	})
+	// Home Assistant forgets non-retained state when it restarts; announce
+	// again when it comes back
+	c.Subscribe(haDiscoveryPrefix()+"/status", 0, func(_ mqtt.Client, m mqtt.Message) {
+		if string(m.Payload()) == "online" {
+			haMu.Lock()
+			haAnnounced = ""
+			haMu.Unlock()
+		}
+	})
+	c.Publish(mqttTopic("availability"), 0, true, "online")
+}
+
+// haCommandValue converts a command payload to the value of a field.
+// "ventilation" takes ON, OFF, auto or a level 1-5.
+func haCommandValue(name, payload string) (string, float64, error) {
+	payload = strings.TrimSpace(payload)
+	if name == "ventilation" {
+		switch strings.ToLower(payload) {
+		case "on", "auto":
+			return "FuncVentilation", float64(futura.LevelAuto), nil
+		case "off":
+			return "FuncVentilation", 0, nil
+		}
+		level, err := strconv.Atoi(payload)
+		if err != nil || level < 1 || level > 5 {
+			return "", 0, fmt.Errorf("invalid ventilation %q", payload)
+		}
+		return "FuncVentilation", float64(level), nil
+	}
+	spec, ok := futura.WriteableFields[name]
+	if !ok {
+		return "", 0, fmt.Errorf("unknown or not-writable field: %s", name)
+	}
+	if v, ok := spec.ValueOf(payload); ok {
+		return name, v, nil
+	}
+	switch payload {
+	case "ON":
+		return name, 1, nil
+	case "OFF":
+		return name, 0, nil
+	}
+	v, err := strconv.ParseFloat(payload, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid value %q for %s", payload, name)
+	}
+	return name, v, nil
+}
+
+// haCommand writes a field set from Home Assistant. The write policy
+// applies as for API clients.
+func haCommand(name, payload string) {
+	field, value, err := haCommandValue(name, payload)
+	if err == nil {
+		err = checkWritePolicy(nil, field, value)
+	}
+	if err == nil {
+		err = WriteSingleRegister(haConn, field, value, origin{Source: "mqtt", Client: "home-assistant"})
+	}
+	if err != nil {
+		log.Printf("Home Assistant command %s=%s: %v", name, payload, err)
+	}
+}
+
+// publishHAState publishes a poll for Home Assistant, announcing the
+// entities first when the unit is new or Home Assistant restarted
+func publishHAState(r futura.InputRegs, hold futura.HoldingRegs) {
+	if mqttClient == nil || !appConfig().MQTT.HADiscovery {
+		return
+	}
+	haMu.Lock()
+	announce := haAnnounced != r.Serial
+	haAnnounced = r.Serial
+	haMu.Unlock()
+	if announce {
+		publishHADiscovery(r)
+	}
+
+	for topic, v := range map[string]interface{}{"state": r, "settings": hold} {
+		body, err := json.Marshal(v)
+		if err != nil {
+			log.Printf("encode %s: %v", topic, err)
+			continue
+		}
+		mqttClient.Publish(mqttTopic(topic), 0, true, body)
+	}
+}
+
+// haEntity is the discovery config of one entity
+type haEntity map[string]interface{}
+
+// haEntities lists the entities of a unit; sensors of accessories are
+// included for the connected ones
+func haEntities(r futura.InputRegs) map[string]haEntity {
+	state, settings := mqttTopic("state"), mqttTopic("settings")
+	out := map[string]haEntity{}
+	sensor := func(id, name, field, class, unit string) {
+		out["sensor/"+id] = haEntity{
+			"name": name, "state_topic": state, "value_template": "{{ value_json." + field + " }}",
+			"device_class": class, "unit_of_measurement": unit, "state_class": "measurement",
+		}
+	}
+	climateSensors := func(prefix, label string, temp, humi, co2 string) {
+		if temp != "" {
+			sensor(prefix+"_temp", label+" temperature", temp, "temperature", "°C")
+		}
+		if humi != "" {
+			sensor(prefix+"_humi", label+" humidity", humi, "humidity", "%")
+		}
+		if co2 != "" {
+			sensor(prefix+"_co2", label+" CO2", co2, "carbon_dioxide", "ppm")
+		}
+	}
+
+	for _, s := range []struct{ id, label string }{{"ambient", "Outdoor"}, {"fresh", "Supply"}, {"indoor", "Indoor"}, {"waste", "Exhaust"}} {
+		t := strings.ToUpper(s.id[:1]) + s.id[1:]
+		climateSensors(s.id, s.label, "Temp"+t, "Humi"+t, "")
+	}
+	sensor("power", "Power consumption", "PowerConsumption", "power", "W")
+	sensor("heat_recovering", "Heat recovery", "HeatRecovering", "power", "W")
+	out["sensor/air_flow"] = haEntity{"name": "Air flow", "state_topic": state, "value_template": "{{ value_json.AirFlow }}", "unit_of_measurement": "m³/h", "state_class": "measurement", "icon": "mdi:fan"}
+	out["sensor/filter_wear"] = haEntity{"name": "Filter wear", "state_topic": state, "value_template": "{{ value_json.FilterWear }}", "unit_of_measurement": "%", "state_class": "measurement", "icon": "mdi:air-filter"}
+	out["sensor/ventilation_level"] = haEntity{"name": "Ventilation level", "state_topic": settings, "value_template": "{{ value_json.FuncVentilation }}", "icon": "mdi:fan"}
+
+	for i := 0; i < futura.UIInstances; i++ {
+		if r.MBDevConnectedMkUI&(1<<i) != 0 {
+			f := fmt.Sprintf("[%d]", i)
+			climateSensors(fmt.Sprintf("ui%d", i+1), fmt.Sprintf("Controller %d", i+1), "UITemp"+f, "UIHumi"+f, "UICo2"+f)
+		}
+	}
+	for i := 0; i < futura.SensInstances; i++ {
+		if r.MBDevConnectedMkSens&(1<<i) != 0 {
+			f := fmt.Sprintf("[%d]", i)
+			climateSensors(fmt.Sprintf("sens%d", i+1), fmt.Sprintf("Sensor %d", i+1), "SensTemp"+f, "SensHumi"+f, "SensCo2"+f)
+		}
+	}
+	for i := 0; i < futura.AlfaInstances; i++ {
+		if r.MBDevConnectedAlfa&(1<<i) != 0 {
+			f := fmt.Sprintf("[%d]", i)
+			climateSensors(fmt.Sprintf("alfa%d", i+1), fmt.Sprintf("ALFA %d", i+1), "AlfaTemp"+f, "AlfaHumi"+f, "AlfaCo2"+f)
+		}
+	}
+	for i := 0; i < futura.ExtSensInstances; i++ {
+		if r.ExtSensPresent[i] != 0 {
+			f := fmt.Sprintf("[%d]", i)
+			climateSensors(fmt.Sprintf("ext%d", i+1), fmt.Sprintf("External sensor %d", i+1), "ExtSensTemp"+f, "ExtSensRH"+f, "ExtSensCo2"+f)
+		}
+	}
+
+	out["binary_sensor/error"] = haEntity{"name": "Error", "state_topic": state, "device_class": "problem",
+		"value_template": "{{ 'ON' if value_json.FutError else 'OFF' }}"}
+	out["binary_sensor/warning"] = haEntity{"name": "Warning", "state_topic": state, "device_class": "problem",
+		"value_template": "{{ 'ON' if value_json.FutWarning else 'OFF' }}"}
+
+	f := DecodeFeatures(r)
+	switches := map[string]string{
+		"FuncAntiradon": "Antiradon", "FuncTimeProg": "Time program",
+		"CfgBypassEnable": "Bypass", "CfgComfortEnable": "Comfort",
+	}
+	if f.Heater {
+		switches["CfgHeatingEnable"] = "Heating"
+	}
+	if f.CoolBreeze {
+		switches["CfgCoolingEnable"] = "Cooling"
+	}
+	for field, name := range switches {
+		out["switch/"+strings.ToLower(field)] = haEntity{
+			"name": name, "state_topic": settings, "command_topic": mqttTopic("set/" + field),
+			"value_template": "{{ 'ON' if value_json." + field + " else 'OFF' }}",
+		}
+	}
+
+	out["number/humidity_setpoint"] = haEntity{
+		"name": "Humidity setpoint", "state_topic": settings, "command_topic": mqttTopic("set/CfgHumiSet"),
+		"value_template": "{{ value_json.CfgHumiSet }}", "device_class": "humidity", "unit_of_measurement": "%",
+		"min": 0, "max": 100, "step": 1,
+	}
+	out["number/boost_time"] = haEntity{
+		"name": "Boost", "state_topic": settings, "command_topic": mqttTopic("set/FuncBoostTm"),
+		"value_template": "{{ value_json.FuncBoostTm }}", "device_class": "duration", "unit_of_measurement": "s",
+		"min": 0, "max": 7200, "step": 60, "icon": "mdi:fan-plus",
+	}
+
+	out["climate/climate"] = haEntity{
+		"name": nil, "modes": []string{"auto"}, "mode_state_topic": settings, "mode_state_template": "auto",
+		"current_temperature_topic": state, "current_temperature_template": "{{ value_json.TempIndoor }}",
+		"current_humidity_topic": state, "current_humidity_template": "{{ value_json.HumiIndoor }}",
+		"temperature_state_topic": settings, "temperature_state_template": "{{ value_json.CfgTempSet }}",
+		"temperature_command_topic": mqttTopic("set/CfgTempSet"),
+		"min_temp":                  10, "max_temp": 30, "temp_step": 0.5, "temperature_unit": "C",
+	}
+	out["fan/ventilation"] = haEntity{
+		"name":        "Ventilation",
+		"state_topic": settings, "state_value_template": "{{ 'OFF' if value_json.FuncVentilation == 0 else 'ON' }}",
+		"command_topic":              mqttTopic("set/ventilation"),
+		"percentage_state_topic":     settings,
+		"percentage_value_template":  "{{ value_json.FuncVentilation if 0 < value_json.FuncVentilation <= 5 else 'None' }}",
+		"percentage_command_topic":   mqttTopic("set/ventilation"),
+		"speed_range_min":            1,
+		"speed_range_max":            5,
+		"preset_modes":               []string{"auto"},
+		"preset_mode_state_topic":    settings,
+		"preset_mode_value_template": "{{ 'auto' if value_json.FuncVentilation == 6 else 'None' }}",
+		"preset_mode_command_topic":  mqttTopic("set/ventilation"),
+	}
+	return out
+}
+
+// publishHADiscovery announces the entities of the unit (retained)
+func publishHADiscovery(r futura.InputRegs) {
+	node := "futura_" + r.Serial
+	device := map[string]interface{}{
+		"identifiers":   []string{node},
+		"name":          "Futura",
+		"manufacturer":  "Jablotron",
+		"model":         "Futura " + DecodeFeatures(r).Model,
+		"serial_number": r.Serial,
+		"sw_version":    r.FWRevision,
+		"hw_version":    r.HWRevision,
+		"connections":   [][]string{{"mac", r.MAC}},
+	}
+	for key, e := range haEntities(r) {
+		component, id, _ := strings.Cut(key, "/")
+		e["unique_id"] = node + "_" + id
+		e["object_id"] = node + "_" + id
+		e["device"] = device
+		e["availability_topic"] = mqttTopic("availability")
+		body, err := json.Marshal(e)
+		if err != nil {
+			log.Printf("encode discovery %s: %v", key, err)
+			continue
+		}
+		mqttClient.Publish(fmt.Sprintf("%s/%s/%s/%s/config", haDiscoveryPrefix(), component, node, id), 0, true, body)
+	}
+	log.Printf("Announced unit %s to Home Assistant", r.Serial)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Modbus and poller health: with a dead connection the register gauges
+// just keep their last values, so these metrics tell whether polls still
+// reach the unit. The state of the connection is in connect.go.
+
+var (
+	readErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "futura_modbus_read_errors_total",
+		Help: "Failed register reads, by kind: exception (the unit refused the request) or transport",
+	}, []string{"kind"})
+	pollDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "futura_poll_duration_seconds",
+		Help:    "Time a poll of all register ranges took (s)",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10), // 50ms to 25.6s
+	})
+	lastPollSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "futura_last_successful_poll_timestamp_seconds",
+		Help: "Unix time of the last poll that read registers from the unit",
+	})
+)
+
+func RegisterHealthMetrics() {
+	prometheus.MustRegister(readErrorsTotal, pollDuration, lastPollSuccess)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+)
+
+// historyStep is the resolution of the in-memory history; polls within the
+// same step are averaged into one point.
+const historyStep = time.Minute
+
+// HistoryPoint is a single averaged sample
+type HistoryPoint struct {
+	Time  int64   `json:"t"` // unix seconds
+	Value float64 `json:"v"`
+}
+
+// History keeps per-minute averages of selected decoded values
+type History struct {
+	mu        sync.Mutex
+	retention time.Duration
+	series    map[string][]HistoryPoint
+	bucket    int64              // start of the step currently being averaged
+	sums      map[string]float64 // running sums for the current step
+	count     int
+}
+
+// historySeries maps series names to the values they are taken from
+var historySeries = map[string]func(r futura.InputRegs) float64{
+	"temp_indoor":  func(r futura.InputRegs) float64 { return r.TempIndoor },
+	"temp_ambient": func(r futura.InputRegs) float64 { return r.TempAmbient },
+	"temp_fresh":   func(r futura.InputRegs) float64 { return r.TempFresh },
+	"humi_indoor":  func(r futura.InputRegs) float64 { return r.HumiIndoor },
+	"co2":          func(r futura.InputRegs) float64 { return maxCo2(r) },
+	"air_flow":     func(r futura.InputRegs) float64 { return float64(r.AirFlow) },
+	"power":        func(r futura.InputRegs) float64 { return float64(r.PowerConsumption) },
+}
+
+var history *History
+
+func NewHistory(retention time.Duration) *History {
+	return &History{
+		retention: retention,
+		series:    map[string][]HistoryPoint{},
+		sums:      map[string]float64{},
+	}
+}
+
+// Record adds a decoded snapshot taken at time t
+func (h *History) Record(r futura.InputRegs, t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	bucket := t.Truncate(historyStep).Unix()
+	if bucket != h.bucket {
+		h.flush()
+		h.bucket = bucket
+	}
+	for name, get := range historySeries {
+		h.sums[name] += get(r)
+	}
+	h.count++
+}
+
+// flush stores the averages of the current step and drops expired points
+func (h *History) flush() {
+	if h.count == 0 {
+		return
+	}
+	cutoff := time.Unix(h.bucket, 0).Add(-h.retention).Unix()
+	for name, sum := range h.sums {
+		pts := append(h.series[name], HistoryPoint{Time: h.bucket, Value: sum / float64(h.count)})
+		drop := sort.Search(len(pts), func(i int) bool { return pts[i].Time >= cutoff })
+		h.series[name] = pts[drop:]
+		h.sums[name] = 0
+	}
+	h.count = 0
+}
+
+// Range returns the points of a series within [from, to)
+func (h *History) Range(name string, from, to time.Time) []HistoryPoint {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	pts := h.series[name]
+	lo := sort.Search(len(pts), func(i int) bool { return pts[i].Time >= from.Unix() })
+	hi := sort.Search(len(pts), func(i int) bool { return pts[i].Time >= to.Unix() })
+	out := make([]HistoryPoint, hi-lo)
+	copy(out, pts[lo:hi])
+	return out
+}
+
+// maxCo2 returns the highest CO2 reading of all connected sensors
+func maxCo2(r futura.InputRegs) float64 {
+	var max uint16
+	for i := 0; i < futura.UIInstances; i++ {
+		if r.UIAddress[i] != 0 && r.UICo2[i] > max {
+			max = r.UICo2[i]
+		}
+	}
+	for i := 0; i < futura.SensInstances; i++ {
+		if r.SensMBAddress[i] != 0 && r.SensCo2[i] > max {
+			max = r.SensCo2[i]
+		}
+	}
+	for i := 0; i < futura.AlfaInstances; i++ {
+		if r.AlfaMBAddress[i] != 0 && r.AlfaCo2[i] > max {
+			max = r.AlfaCo2[i]
+		}
+	}
+	for i := 0; i < futura.ExtSensInstances; i++ {
+		if r.ExtSensPresent[i] != 0 && r.ExtSensCo2[i] > max {
+			max = r.ExtSensCo2[i]
+		}
+	}
+	return float64(max)
+}
+
+// downsample averages points into buckets of step seconds, for charts of
+// long ranges
+func downsample(pts []HistoryPoint, step int64) []HistoryPoint {
+	out := []HistoryPoint{}
+	var sum float64
+	n := 0
+	for i, p := range pts {
+		sum += p.Value
+		n++
+		bucket := p.Time - p.Time%step
+		if i+1 == len(pts) || pts[i+1].Time-pts[i+1].Time%step != bucket {
+			out = append(out, HistoryPoint{Time: bucket, Value: sum / float64(n)})
+			sum, n = 0, 0
+		}
+	}
+	return out
+}
+
+// handleHistory returns a series between ?from= and ?to= (unix seconds,
+// default last 24h), averaged over ?step= seconds, paged with
+// ?since=&limit=&cursor=&fields=
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name := r.URL.Query().Get("series")
+	if _, ok := historySeries[name]; !ok {
+		http.Error(w, `{"success":false,"error":"unknown series"}`, http.StatusBadRequest)
+		return
+	}
+	page, err := parsePage(r, 0)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"success":false,"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	if !page.Since.IsZero() {
+		from = page.Since
+	}
+	if v := r.URL.Query().Get("from"); v != "" {
+		sec, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, `{"success":false,"error":"invalid from"}`, http.StatusBadRequest)
+			return
+		}
+		from = time.Unix(sec, 0)
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		sec, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, `{"success":false,"error":"invalid to"}`, http.StatusBadRequest)
+			return
+		}
+		to = time.Unix(sec, 0)
+	}
+	var step int64
+	if v := r.URL.Query().Get("step"); v != "" {
+		step, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || step < int64(historyStep/time.Second) {
+			http.Error(w, `{"success":false,"error":"invalid step"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if page.Cursor && page.After >= from.Unix() {
+		from = time.Unix(page.After+1, 0)
+	}
+
+	pts, err := historyRange(name, from, to)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"success":false,"error":%q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if step > 0 {
+		pts = downsample(pts, step)
+	}
+	err = writePage(w, page, len(pts),
+		func(i int) interface{} { return pts[i] },
+		func(i int) int64 { return pts[i].Time })
+	if err != nil {
+		log.Printf("encode history json: %v", err)
+	}
+}
+
+// historyCompare holds today's curve and the same weekday a week earlier,
+// both with times expressed as wall-clock seconds after local midnight
+type historyCompare struct {
+	Series   string         `json:"series"`
+	Today    []HistoryPoint `json:"today"`
+	LastWeek []HistoryPoint `json:"last_week"`
+}
+
+// handleHistoryCompare returns today's curve next to the same weekday last week
+func handleHistoryCompare(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	name := r.URL.Query().Get("series")
+	if _, ok := historySeries[name]; !ok {
+		http.Error(w, `{"success":false,"error":"unknown series"}`, http.StatusBadRequest)
+		return
+	}
+
+	today := startOfDay(time.Now())
+	lastWeek := today.AddDate(0, 0, -7)
+
+	todayPts, err := historyRange(name, today, today.AddDate(0, 0, 1))
+	var lastWeekPts []HistoryPoint
+	if err == nil {
+		lastWeekPts, err = historyRange(name, lastWeek, lastWeek.AddDate(0, 0, 1))
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"success":false,"error":%q}`, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	resp := historyCompare{
+		Series:   name,
+		Today:    offsetPoints(todayPts),
+		LastWeek: offsetPoints(lastWeekPts),
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("encode history compare json: %v", err)
+	}
+}
+
+// offsetPoints converts point times to wall-clock seconds after midnight so
+// both days line up even when one of them is a DST change. The repeated hour
+// of a fall-back day is dropped to keep the curve monotonic.
+func offsetPoints(pts []HistoryPoint) []HistoryPoint {
+	out := pts[:0]
+	last := int64(-1)
+	for _, p := range pts {
+		p.Time = secondsOfDay(time.Unix(p.Time, 0))
+		if p.Time <= last {
+			continue
+		}
+		last = p.Time
+		out = append(out, p)
+	}
+	return out
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Persistent history: with -history-db the history series of every poll are
+// stored in a BoltDB file, one bucket per series keyed by big-endian unix
+// milliseconds. It survives restarts and can reach back much further than
+// the in-memory history, which /api/history then reads from it.
+
+const (
+	// historyDBCommitEvery batches the writes of several polls into one
+	// transaction, so an SD card isn't synced on every poll
+	historyDBCommitEvery = time.Minute
+	historyDBPruneEvery  = time.Hour
+)
+
+// HistoryDB stores the history series of every poll on disk
+type HistoryDB struct {
+	mu        sync.Mutex
+	db        *bolt.DB
+	retention time.Duration
+	pending   map[string][]dbPoint // not committed yet
+	committed time.Time
+	pruned    time.Time
+}
+
+// dbPoint is a single poll's value of a series
+type dbPoint struct {
+	ms    int64 // unix milliseconds
+	value float64
+}
+
+var historyDB *HistoryDB // nil without -history-db
+
+// OpenHistoryDB opens or creates the database in path
+func OpenHistoryDB(path string, retention time.Duration) (*HistoryDB, error) {
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for name := range historySeries {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	now := time.Now()
+	return &HistoryDB{
+		db:        db,
+		retention: retention,
+		pending:   map[string][]dbPoint{},
+		committed: now,
+	}, nil
+}
+
+func dbKey(ms int64) []byte {
+	var k [8]byte
+	binary.BigEndian.PutUint64(k[:], uint64(ms))
+	return k[:]
+}
+
+// Record adds the series values of a decoded snapshot taken at time t
+func (h *HistoryDB) Record(r futura.InputRegs, t time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for name, get := range historySeries {
+		h.pending[name] = append(h.pending[name], dbPoint{t.UnixMilli(), get(r)})
+	}
+	if t.Sub(h.committed) < historyDBCommitEvery {
+		return
+	}
+	if err := h.commit(t); err != nil {
+		log.Printf("history db: %v", err)
+	}
+}
+
+// commit writes the pending points and, once per historyDBPruneEvery, drops
+// points older than the retention. h.mu must be held.
+func (h *HistoryDB) commit(now time.Time) error {
+	prune := now.Sub(h.pruned) >= historyDBPruneEvery
+	cutoff := dbKey(now.Add(-h.retention).UnixMilli())
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		for name, pts := range h.pending {
+			b := tx.Bucket([]byte(name))
+			for _, p := range pts {
+				if err := b.Put(dbKey(p.ms), dbKey(int64(math.Float64bits(p.value)))); err != nil {
+					return err
+				}
+			}
+		}
+		if !prune {
+			return nil
+		}
+		for name := range historySeries {
+			b := tx.Bucket([]byte(name))
+			var old [][]byte
+			c := b.Cursor()
+			for k, _ := c.First(); k != nil && string(k) < string(cutoff); k, _ = c.Next() {
+				old = append(old, k)
+			}
+			for _, k := range old {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	// on failure the points are dropped rather than retried forever
+	h.pending = map[string][]dbPoint{}
+	h.committed = now
+	if prune && err == nil {
+		h.pruned = now
+	}
+	return err
+}
+
+// Range returns the per-minute averages of a series within [from, to), the
+// same resolution as the in-memory history
+func (h *HistoryDB) Range(name string, from, to time.Time) ([]HistoryPoint, error) {
+	out := []HistoryPoint{}
+	var bucket int64
+	var sum float64
+	n := 0
+	add := func(ms int64, v float64) {
+		if ms < from.UnixMilli() || ms >= to.UnixMilli() {
+			return
+		}
+		t := time.UnixMilli(ms).Truncate(historyStep).Unix()
+		if t != bucket && n > 0 {
+			out = append(out, HistoryPoint{Time: bucket, Value: sum / float64(n)})
+			sum, n = 0, 0
+		}
+		bucket = t
+		sum += v
+		n++
+	}
+
+	h.mu.Lock()
+	pending := append([]dbPoint(nil), h.pending[name]...)
+	h.mu.Unlock()
+
+	err := h.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(name))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		end := string(dbKey(to.UnixMilli()))
+		for k, v := c.Seek(dbKey(from.UnixMilli())); k != nil && string(k) < end; k, v = c.Next() {
+			add(int64(binary.BigEndian.Uint64(k)), math.Float64frombits(binary.BigEndian.Uint64(v)))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pending {
+		add(p.ms, p.value)
+	}
+	if n > 0 {
+		out = append(out, HistoryPoint{Time: bucket, Value: sum / float64(n)})
+	}
+	return out, nil
+}
+
+// Close commits the pending points and closes the database
+func (h *HistoryDB) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.commit(time.Now()); err != nil {
+		log.Printf("history db: %v", err)
+	}
+	h.db.Close()
+}
+
+// historyRange reads a series from the database when there is one and from
+// the in-memory history otherwise
+func historyRange(name string, from, to time.Time) ([]HistoryPoint, error) {
+	if historyDB != nil {
+		return historyDB.Range(name, from, to)
+	}
+	return history.Range(name, from, to), nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+)
+
+// readHeaderTimeout bounds how long a client may take to send the request
+// headers; slowloris clients keep a connection open by sending them slowly
+const readHeaderTimeout = 10 * time.Second
+
+// newHTTPServer returns a server for h with the -http-* limits, so slow or
+// idle clients can't hold connections open forever. Long-lived responses
+// (the SSE stream, WebSockets) lift the write timeout themselves.
+func newHTTPServer(h http.Handler) *http.Server {
+	srv := &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: min(readHeaderTimeout, *flagReadTimeout),
+		ReadTimeout:       *flagReadTimeout,
+		WriteTimeout:      *flagWriteTimeout,
+		IdleTimeout:       *flagIdleTimeout,
+		MaxHeaderBytes:    *flagMaxHeaderBytes,
+	}
+	if !*flagHTTP2 {
+		// a non-nil empty map turns off HTTP/2 on TLS listeners
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+	}
+	return srv
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Indoor air quality score thresholds
+const (
+	iaqCo2Good = 800.0  // ppm at or below which CO2 scores 100
+	iaqCo2Bad  = 1500.0 // ppm at or above which CO2 scores 0
+	iaqRHLow   = 40.0   // comfortable RH band (%)
+	iaqRHHigh  = 60.0
+	iaqRHSpan  = 20.0 // RH distance outside the band at which the score reaches 0
+
+	iaqGreen = 70.0 // minimal score for green
+	iaqAmber = 40.0 // minimal score for amber
+)
+
+// IAQZone is the air quality of a single room sensor
+type IAQZone struct {
+	Zone  string  `json:"zone"`
+	Co2   float64 `json:"co2"`
+	RH    float64 `json:"rh"`
+	Score float64 `json:"score"` // 0-100
+	Level string  `json:"level"` // green, amber, red
+}
+
+var (
+	iaqMu    sync.Mutex
+	iaqZones []IAQZone
+
+	iaqScoreGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "iaq_score",
+		Help: "Indoor air quality score per zone (0-100)",
+	}, []string{"zone"})
+	iaqLevelGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "iaq_level",
+		Help: "Indoor air quality level per zone (0=green, 1=amber, 2=red)",
+	}, []string{"zone"})
+)
+
+func RegisterIAQMetrics() {
+	prometheus.MustRegister(iaqScoreGauge, iaqLevelGauge)
+}
+
+// iaqScore combines CO2 and RH sub-scores; the worse of the two wins
+func iaqScore(co2, rh float64) float64 {
+	co2Score := 100.0
+	if co2 > iaqCo2Good {
+		co2Score = 100 * (iaqCo2Bad - co2) / (iaqCo2Bad - iaqCo2Good)
+	}
+
+	rhScore := 100.0
+	if rh < iaqRHLow {
+		rhScore = 100 * (1 - (iaqRHLow-rh)/iaqRHSpan)
+	} else if rh > iaqRHHigh {
+		rhScore = 100 * (1 - (rh-iaqRHHigh)/iaqRHSpan)
+	}
+
+	score := co2Score
+	if rhScore < score {
+		score = rhScore
+	}
+	if score < 0 {
+		score = 0
+	}
+	return score
+}
+
+func iaqLevel(score float64) (string, float64) {
+	switch {
+	case score >= iaqGreen:
+		return "green", 0
+	case score >= iaqAmber:
+		return "amber", 1
+	default:
+		return "red", 2
+	}
+}
+
+// ZoneReading holds the climate readings of one room sensor
+type ZoneReading struct {
+	Zone string
+	Temp float64
+	RH   float64
+	Co2  float64 // 0 when the sensor has no CO2 probe
+}
+
+// zoneReadings lists every connected room sensor (wall controllers, sensors,
+// ALFA controllers and external sensors)
+func zoneReadings(r futura.InputRegs) []ZoneReading {
+	var zones []ZoneReading
+	for i := 0; i < futura.UIInstances; i++ {
+		if r.UIAddress[i] != 0 {
+			zones = append(zones, ZoneReading{fmt.Sprintf("ui%d", i+1), r.UITemp[i], r.UIHumi[i], float64(r.UICo2[i])})
+		}
+	}
+	for i := 0; i < futura.SensInstances; i++ {
+		if r.SensMBAddress[i] != 0 {
+			zones = append(zones, ZoneReading{fmt.Sprintf("sens%d", i+1), r.SensTemp[i], r.SensHumi[i], float64(r.SensCo2[i])})
+		}
+	}
+	for i := 0; i < futura.AlfaInstances; i++ {
+		if r.AlfaMBAddress[i] != 0 {
+			zones = append(zones, ZoneReading{fmt.Sprintf("alfa%d", i+1), r.AlfaTemp[i], r.AlfaHumi[i], float64(r.AlfaCo2[i])})
+		}
+	}
+	for i := 0; i < futura.ExtSensInstances; i++ {
+		if r.ExtSensPresent[i] != 0 {
+			zones = append(zones, ZoneReading{fmt.Sprintf("ext%d", i+1), r.ExtSensTemp[i], r.ExtSensRH[i], float64(r.ExtSensCo2[i])})
+		}
+	}
+	return zones
+}
+
+// ComputeIAQ returns the air quality of every connected sensor that reports CO2
+func ComputeIAQ(r futura.InputRegs) []IAQZone {
+	var zones []IAQZone
+	for _, z := range zoneReadings(r) {
+		if z.Co2 == 0 {
+			continue
+		}
+		score := iaqScore(z.Co2, z.RH)
+		level, _ := iaqLevel(score)
+		zones = append(zones, IAQZone{Zone: z.Zone, Co2: z.Co2, RH: z.RH, Score: score, Level: level})
+	}
+	return zones
+}
+
+// UpdateIAQ recomputes zone scores from a decoded poll and updates metrics
+func UpdateIAQ(r futura.InputRegs) {
+	zones := ComputeIAQ(r)
+
+	iaqScoreGauge.Reset()
+	iaqLevelGauge.Reset()
+	for _, z := range zones {
+		_, level := iaqLevel(z.Score)
+		iaqScoreGauge.WithLabelValues(z.Zone).Set(z.Score)
+		iaqLevelGauge.WithLabelValues(z.Zone).Set(level)
+	}
+
+	iaqMu.Lock()
+	iaqZones = zones
+	iaqMu.Unlock()
+}
+
+// handleIAQ returns the zone scores of the last poll as JSON
+func handleIAQ(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	iaqMu.Lock()
+	zones := iaqZones
+	iaqMu.Unlock()
+	if zones == nil {
+		zones = []IAQZone{}
+	}
+
+	if err := json.NewEncoder(w).Encode(zones); err != nil {
+		log.Printf("encode iaq json: %v", err)
+	}
+}
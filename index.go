@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"time"
+)
+
+// indexPageData is passed to the index.html template, the landing page with
+// an overview of the instance
+type indexPageData struct {
+	Version  string
+	Profile  string
+	Host     string
+	ReadOnly bool
+
+	State     string // connecting, connected or disconnected
+	Since     time.Time
+	LastError string
+
+	Device      *DeviceInfo // nil until the first poll
+	Polled      bool
+	LastPoll    time.Time
+	LastPollAgo time.Duration
+	Stale       bool // restored from -snapshot-file
+	Ventilation string
+	Modes       []string // FutMode states
+	Season      string
+}
+
+// indexData collects the state shown on the landing page
+func indexData() indexPageData {
+	d := indexPageData{
+		Version:  version,
+		Profile:  activeProfile.Name,
+		Host:     fmt.Sprintf("%s:%d", *flagUnitHost, *flagUnitPort),
+		ReadOnly: *flagReadOnly,
+	}
+	if *flagReplay != "" {
+		d.Host = "replay of " + *flagReplay
+	}
+
+	connMu.Lock()
+	st := conn
+	connMu.Unlock()
+	d.State, d.Since, d.LastError = st.State, st.Since.In(appLocation), st.LastError
+
+	deviceInfoMu.Lock()
+	d.Device = deviceInfo
+	deviceInfoMu.Unlock()
+
+	in, hold, ok := latestSnapshot()
+	if ok {
+		d.Polled = true
+		d.LastPoll, d.Stale = in.Time.In(appLocation), in.Stale
+		d.LastPollAgo = time.Since(in.Time).Round(time.Second)
+		d.Ventilation = hold.FuncVentilationName
+		d.Modes = in.FutModeStates
+	}
+
+	seasonMu.Lock()
+	d.Season = season.Season
+	seasonMu.Unlock()
+	return d
+}
+
+// handleIndex renders the landing page; other unknown paths are not found
+func handleIndex(fsys fs.FS) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		tmpl, err := indexPage.template(fsys)
+		if err != nil {
+			log.Printf("parse index template: %v", err)
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tmpl.Execute(w, indexData()); err != nil {
+			log.Printf("render index page: %v", err)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// IntentConfig enables the smart-home intent bridge (/api/intent)
+type IntentConfig struct {
+	Tokens  []string `yaml:"tokens"`   // bearer tokens accepted by the endpoint; the bridge is disabled without any
+	Listen  string   `yaml:"listen"`   // optional separate HTTPS listener, e.g. ":9443"
+	TLSCert string   `yaml:"tls_cert"` // certificate and key for the HTTPS listener
+	TLSKey  string   `yaml:"tls_key"`
+}
+
+// Intent is a smart-home command, either structured or as a spoken phrase
+type Intent struct {
+	Intent string          `json:"intent"` // SetVentilation, SetTemperature, Boost
+	Value  json.RawMessage `json:"value"`
+	Text   string          `json:"text"` // e.g. "set ventilation to high"
+}
+
+// ventilationLevels maps spoken ventilation levels to FuncVentilation values
+var ventilationLevels = map[string]float64{
+	"low":    1,
+	"medium": 3,
+	"high":   5,
+	"auto":   6,
+}
+
+var (
+	reIntentVentilation = regexp.MustCompile(`^set (?:the )?ventilation (?:level )?to (\w+)$`)
+	reIntentTemperature = regexp.MustCompile(`^set (?:the )?temperature to (-?[\d.]+)(?: degrees)?$`)
+	reIntentBoost       = regexp.MustCompile(`^boost(?: for)? (\d+) minutes?$`)
+)
+
+// checkBearer reports whether the request carries one of the given bearer tokens
+func checkBearer(r *http.Request, tokens []string) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	got := []byte(strings.TrimPrefix(auth, "Bearer "))
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare(got, []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIntentText turns a spoken phrase into a structured intent
+func parseIntentText(text string) (Intent, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if m := reIntentVentilation.FindStringSubmatch(text); m != nil {
+		return Intent{Intent: "SetVentilation", Value: json.RawMessage(strconv.Quote(m[1]))}, nil
+	}
+	if m := reIntentTemperature.FindStringSubmatch(text); m != nil {
+		return Intent{Intent: "SetTemperature", Value: json.RawMessage(m[1])}, nil
+	}
+	if m := reIntentBoost.FindStringSubmatch(text); m != nil {
+		return Intent{Intent: "Boost", Value: json.RawMessage(m[1])}, nil
+	}
+	return Intent{}, fmt.Errorf("unrecognized phrase %q", text)
+}
+
+// resolveIntent maps an intent to a writable field and value
+func resolveIntent(in Intent) (string, float64, error) {
+	var num float64
+	var name string
+	if err := json.Unmarshal(in.Value, &num); err != nil {
+		if err := json.Unmarshal(in.Value, &name); err != nil {
+			return "", 0, fmt.Errorf("invalid value")
+		}
+	}
+
+	switch in.Intent {
+	case "SetVentilation":
+		if name != "" {
+			level, ok := ventilationLevels[strings.ToLower(name)]
+			if !ok {
+				if n, err := strconv.Atoi(name); err == nil {
+					level = float64(n)
+				} else {
+					return "", 0, fmt.Errorf("unknown ventilation level %q", name)
+				}
+			}
+			num = level
+		}
+		if num < 1 || num > 6 {
+			return "", 0, fmt.Errorf("ventilation level must be 1-6")
+		}
+		return "FuncVentilation", num, nil
+	case "SetTemperature":
+		if name != "" {
+			return "", 0, fmt.Errorf("temperature must be a number")
+		}
+		return "CfgTempSet", num, nil
+	case "Boost":
+		if name != "" || num <= 0 {
+			return "", 0, fmt.Errorf("boost needs a positive number of minutes")
+		}
+		return "FuncBoostTm", num * 60, nil
+	}
+	return "", 0, fmt.Errorf("unknown intent %q", in.Intent)
+}
+
+// handleIntent executes a smart-home intent as a single register write
+func handleIntent(client *ModbusConn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if authLocked(w, r) {
+			return
+		}
+		if !checkBearer(r, appConfig().Intents.Tokens) {
+			authFailed(r, "intent token")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, `{"success":false,"error":"unauthorized"}`)
+			return
+		}
+		authSucceeded(r)
+		if r.Method != http.MethodPost {
+			fmt.Fprintf(w, `{"success":false,"error":"POST required"}`)
+			return
+		}
+
+		var in Intent
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":"Invalid JSON"}`)
+			return
+		}
+		if in.Intent == "" && in.Text != "" {
+			parsed, err := parseIntentText(in.Text)
+			if err != nil {
+				fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+				return
+			}
+			in = parsed
+		}
+
+		field, value, err := resolveIntent(in)
+		if err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+			return
+		}
+		if err := checkWritePolicy(r, field, value); err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+			return
+		}
+		log.Printf("Intent %s: %s = %v", in.Intent, field, value)
+		if err := WriteSingleRegister(client, field, value, requestOrigin(r, "intent")); err != nil {
+			log.Printf("Intent write error: %v", err)
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+			return
+		}
+		fmt.Fprintf(w, `{"success":true,"message":"%s set to %v"}`, field, value)
+	}
+}
+
+// startIntentListener serves /api/intent on its own HTTPS listener when configured
+func startIntentListener(client *ModbusConn) {
+	cfg := appConfig().Intents
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/intent", rateLimited(handleIntent(client)))
+	// open the certificate and port now so startup fails with the right
+	// exit status
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		configFailed("Intent HTTPS server: %v", err)
+	}
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		startupFailed("listen", exitListen, "Intent HTTPS server failed: %v", err)
+	}
+	// same logging and headers as the main listener
+	srv := newHTTPServer(logRequests(securityHeaders(mux, *flagFrameAncestors)))
+	srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	go func() {
+		log.Printf("Starting intent HTTPS server on %s", cfg.Listen)
+		if err := srv.ServeTLS(ln, "", ""); err != nil {
+			log.Fatalf("Intent HTTPS server failed: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/simonvetter/modbus"
+)
+
+// The Futura LAN module sometimes hangs: it still accepts TCP connections
+// but never answers a Modbus request, and only a power cycle brings it back.
+// A poll in which every read timed out although the connection (re)opened
+// is counted as hung; after lan_recovery.hung_polls of them in a row the
+// module is reported as hung and the recovery action, if any, is sent.
+
+// Event types
+const (
+	EventLANHung      = "lan_module_hung"
+	EventLANRecovered = "lan_module_recovered"
+	EventLANRecovery  = "lan_recovery_action"
+)
+
+// LANRecoveryConfig configures hang detection and the recovery action
+type LANRecoveryConfig struct {
+	HungPolls int            `yaml:"hung_polls"` // consecutive hung polls before acting (default 3)
+	Cooldown  time.Duration  `yaml:"cooldown"`   // minimum time between recovery actions (default 30m)
+	Action    *WebhookConfig `yaml:"action"`     // e.g. a smart plug that power-cycles the module
+}
+
+func (c LANRecoveryConfig) validate() error {
+	if c.HungPolls < 0 {
+		return fmt.Errorf("lan_recovery.hung_polls must not be negative")
+	}
+	if c.Cooldown < 0 {
+		return fmt.Errorf("lan_recovery.cooldown must not be negative")
+	}
+	if c.Action != nil {
+		if c.Action.URL == "" {
+			return fmt.Errorf("lan_recovery.action has no url")
+		}
+		if err := validateTemplate("lan_recovery.action", c.Action.Template, c.Action.TemplateFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	lanMu         sync.Mutex
+	lanReads      int // block reads since the last poll
+	lanTimeouts   int // of which timed out on an open connection
+	lanHungPolls  int // consecutive hung polls
+	lanHung       bool
+	lanLastAction time.Time
+	lanActing     bool // a recovery action is in flight
+
+	lanHungPollsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "futura_lan_hung_polls_total",
+		Help: "Polls in which every read timed out although the connection opened",
+	})
+	lanHungGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "futura_lan_module_hung",
+		Help: "1 while the LAN module is considered hung",
+	})
+	lanActionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "futura_lan_recovery_actions_total",
+		Help: "Recovery actions sent for a hung LAN module, by result",
+	}, []string{"result"})
+)
+
+func RegisterLANMetrics() {
+	prometheus.MustRegister(lanHungPollsTotal, lanHungGauge, lanActionsTotal)
+}
+
+// noteRead records the outcome of a block read. connected is false when the
+// read failed because the connection couldn't be reopened, which is a
+// network problem rather than a hung module.
+func noteRead(err error, connected bool) {
+	lanMu.Lock()
+	defer lanMu.Unlock()
+	lanReads++
+	if err != nil && connected && errors.Is(err, modbus.ErrRequestTimedOut) {
+		lanTimeouts++
+	}
+}
+
+// checkLANHang evaluates the reads of the poll that just finished
+func checkLANHang() {
+	cfg := appConfig().LANRecovery
+	threshold := cfg.HungPolls
+	if threshold == 0 {
+		threshold = 3
+	}
+	cooldown := cfg.Cooldown
+	if cooldown == 0 {
+		cooldown = 30 * time.Minute
+	}
+
+	var events []Event
+	act := false
+
+	lanMu.Lock()
+	reads, timeouts := lanReads, lanTimeouts
+	lanReads, lanTimeouts = 0, 0
+	switch {
+	case reads > 0 && timeouts == reads:
+		lanHungPollsTotal.Inc()
+		lanHungPolls++
+		if lanHungPolls >= threshold && !lanHung {
+			lanHung = true
+			lanHungGauge.Set(1)
+			log.Printf("LAN module seems hung: reads timed out in %d polls in a row", lanHungPolls)
+			events = append(events, Event{Type: EventLANHung, Source: "modbus", Data: map[string]interface{}{"polls": lanHungPolls}})
+		}
+		if lanHung && cfg.Action != nil && !lanActing && time.Since(lanLastAction) >= cooldown {
+			lanLastAction = time.Now()
+			lanActing = true
+			act = true
+		}
+	case timeouts < reads:
+		// the module answered, or the connection itself failed
+		lanHungPolls = 0
+		if lanHung {
+			lanHung = false
+			lanHungGauge.Set(0)
+			log.Printf("LAN module is no longer hung")
+			events = append(events, Event{Type: EventLANRecovered, Source: "modbus"})
+		}
+	}
+	lanMu.Unlock()
+
+	for _, ev := range events {
+		emitEvent(ev)
+	}
+	if act {
+		go runLANRecovery(*cfg.Action)
+	}
+}
+
+// runLANRecovery sends the recovery action and reports its result
+func runLANRecovery(action WebhookConfig) {
+	defer func() {
+		lanMu.Lock()
+		lanActing = false
+		lanMu.Unlock()
+	}()
+
+	ev := Event{Time: time.Now(), Type: EventLANRecovery, Source: "modbus"}
+	payload, err := json.Marshal(ev)
+	if err == nil {
+		payload, err = channelPayload("lan_recovery.action", action.Template, action.TemplateFile, ev, payload)
+	}
+	if err == nil {
+		err = sendWebhook(action, payload)
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+		log.Printf("LAN recovery action %s: %v", action.URL, err)
+	} else {
+		log.Printf("LAN recovery action sent to %s", action.URL)
+	}
+	lanActionsTotal.WithLabelValues(result).Inc()
+	ev.Data = map[string]interface{}{"result": result}
+	emitEvent(ev)
+}
@@ -3,55 +3,25 @@ package main
 import (
 	"embed"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"html/template"
-	"io/fs"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"sort"
+	"sync/atomic"
 	"time"
 
+	"github.com/danielkucera/gofutura/futura"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/simonvetter/modbus"
 )
 
-// Define your target ranges here [StartRegister, EndRegister]
-var inputRanges = [][]uint16{
-	{0, 21},   // System info and Error bitmasks
-	{30, 38},  // Temperatures, Humidity, and Fans
-	{40, 52},  // Temperatures, Humidity, and Fans
-	{60, 75},
-	{100, 154}, // Wall sensor 2
-	{160, 165}, // Alpha Panel 1
-	{170, 175}, // Alpha Panel 2
-	{180, 185}, // Alpha Panel 3
-	{190, 195}, // Alpha Panel 4
-	{200, 205}, // Alpha Panel 5
-	{210, 215}, // Alpha Panel 6
-	{220, 225}, // Alpha Panel 7
-	{230, 235}, // Alpha Panel 8
-}
-
-var holdingRanges = [][]uint16{
-	{0, 17},   // Modes, Timers, and User Settings
-	{20, 23},
-	{300, 305}, // external sensor 1
-	{310, 315}, // external sensor 2
-	{320, 325}, // external sensor 3
-	{330, 335}, // external sensor 4
-	{340, 345}, // external sensor 5
-	{350, 355}, // external sensor 6
-	{360, 365}, // external sensor 7
-	{370, 375}, // external sensor 8
-	{400, 403}, // external button 1
-	{410, 413}, // external button 2
-	{420, 423}, // external button 3
-	{430, 433}, // external button 4
-	{440, 443}, // external button 5
-	{450, 453}, // external button 6
-	{460, 463}, // external button 7
-	{470, 473}, // external button 8
-}
+// Register ranges to poll [StartRegister, EndRegister], taken from the device profile
+var inputRanges [][]uint16
+var holdingRanges [][]uint16
 
 // Command-line options (defaults match previous constants)
 var (
@@ -59,48 +29,149 @@ var (
 	flagUnitPort       = flag.Uint("port", 502, "Modbus port")
 	flagSlaveID        = flag.Uint("slave-id", 1, "Modbus slave ID (0-255)")
 	flagMaxBlockSize   = flag.Uint("max-block-size", 125, "Max registers per Modbus read (standard limit is 125)")
-	flagInputMaxAddr   = flag.Uint("input-max-addr", 255, "Max input register address for validation")
-	flagHoldingMaxAddr = flag.Uint("holding-max-addr", 1024, "Max holding register address for validation")
+	flagMaxInflight    = flag.Uint("max-inflight", 1, "Max concurrent Modbus reads; above 1 extra connections to the unit are opened")
+	flagInputMaxAddr   = flag.Uint("input-max-addr", 0, "Max input register address for validation (0 = profile default)")
+	flagHoldingMaxAddr = flag.Uint("holding-max-addr", 0, "Max holding register address for validation (0 = profile default)")
+	flagInputRanges    = flag.String("input-ranges", "", "Input registers to poll, e.g. 0-40,60-90 (empty = config file or profile)")
+	flagHoldingRanges  = flag.String("holding-ranges", "", "Holding registers to poll, e.g. 0-20,200-300 (empty = config file or profile)")
 	flagHTTPPort       = flag.Uint("http-port", 9090, "HTTP server port for metrics and UI")
+	flagReadTimeout    = flag.Duration("http-read-timeout", 30*time.Second, "Longest time to read a request, headers included (0 = no limit)")
+	flagWriteTimeout   = flag.Duration("http-write-timeout", 60*time.Second, "Longest time to write a response, except the SSE stream and WebSockets (0 = no limit)")
+	flagIdleTimeout    = flag.Duration("http-idle-timeout", 120*time.Second, "How long an idle keep-alive connection is kept open")
+	flagMaxHeaderBytes = flag.Int("http-max-header", 64<<10, "Largest accepted size of request headers, in bytes")
+	flagHTTP2          = flag.Bool("http2", true, "Offer HTTP/2 on the TLS intent listener (false = HTTP/1.1 only)")
 	flagPollInterval   = flag.Duration("poll-interval", 5*time.Second, "Polling interval for Modbus reads")
+	flagProbe          = flag.Bool("probe", false, "Serve /probe?target=host[:port] for polling other units per scrape (futura profile only)")
+	flagPollOnScrape   = flag.Bool("poll-on-scrape", false, "Poll when /metrics is scraped instead of every poll-interval")
+	flagScrapeTimeout  = flag.Duration("poll-on-scrape-timeout", 10*time.Second, "Longest time a scrape waits for its poll with -poll-on-scrape before the previous values are served")
+	flagIdlePoll       = flag.Duration("idle-poll-interval", 0, "Slower polling interval while no client is active, e.g. 60s (0 = always use poll-interval)")
+	flagProfile        = flag.String("profile", "futura", "Device profile: embedded profile name or path to a YAML profile")
+	flagConfig         = flag.String("config", "", "Path to YAML config file (optional)")
+	flagSecretKeyFile  = flag.String("secret-key-file", "", "File with the key for enc: values in the config (default $GOFUTURA_SECRET_KEY)")
+	flagHistoryKeep    = flag.Duration("history-retention", 8*24*time.Hour, "How long to keep per-minute history in memory")
+	flagHistoryDB      = flag.String("history-db", "", "BoltDB file to store the history of every poll in, kept across restarts (empty = memory only)")
+	flagHistoryDBKeep  = flag.Duration("history-db-keep", 30*24*time.Hour, "How long to keep history in -history-db; every poll is stored, so long retentions grow the file quickly")
+	flagReplay         = flag.String("replay", "", "Poll a recording made with -record-raw instead of a unit (replaces -host)")
+	flagFaultTimeout   = flag.Float64("replay-timeout-rate", 0, "Share of requests (0-1) the -replay server leaves unanswered until the client times out")
+	flagFaultDrop      = flag.Float64("replay-drop-rate", 0, "Share of requests (0-1) on which the -replay server drops the connection")
+	flagFaultException = flag.Float64("replay-exception-rate", 0, "Share of requests (0-1) the -replay server answers with a Modbus exception (server device busy)")
+	flagFaultGarble    = flag.Float64("replay-garble-rate", 0, "Share of requests (0-1) the -replay server answers with a garbled frame")
+	flagFaultSeed      = flag.Int64("replay-seed", 0, "Seed of the -replay fault injection, for reproducible runs (0 = random)")
+	flagAuditLog       = flag.String("audit-log", "", "File to append every write of a field to, as JSON lines, for /api/audit (empty = memory only)")
+	flagRecordRaw      = flag.String("record-raw", "", "Append every raw register block read to this file, for gofutura analyze (empty = off)")
+	flagSnapshotFile   = flag.String("snapshot-file", "", "File to keep the last poll in, served as stale data at startup (empty = off)")
+	flagScheduleFile   = flag.String("schedule-file", "", "File to persist the ventilation schedule in (empty = memory only)")
+	flagTimezone       = flag.String("timezone", "", "Time zone for schedules, vacation and reports, e.g. Europe/Prague (empty = system zone)")
+	flagAllowCIDR      = flag.String("allow-cidr", "", "Comma-separated subnets allowed to write, e.g. 192.168.1.0/24 (empty = any)")
+	flagAllowCIDRAll   = flag.Bool("allow-cidr-all", false, "Apply -allow-cidr to all endpoints, not only writes")
+	flagRounding       = flag.String("rounding", "half-up", "Rounding of written values to register units: half-up or half-even")
+	flagRequireDevice  = flag.Bool("require-device", false, "Exit with status 3 when the unit can't be reached at startup instead of retrying in the background")
+	flagConnectRetry   = flag.Duration("connect-retry-max", time.Minute, "Longest pause between attempts to connect or reconnect to the unit while it can't be reached")
+	flagForceWrites    = flag.Bool("force-writes", false, "Allow writes even when the startup self-test fails")
+	flagReadOnly       = flag.Bool("read-only", false, "Never write registers; /api/write-holding reports what it would write")
+	flagSingleWrites   = flag.Bool("single-writes", false, "Write registers one by one (FC6) for devices that reject multi-register writes (FC16)")
+	flagLogRequests    = flag.Bool("log-requests", false, "Log every API request with method, path, status, duration and client IP")
+	flagFrameAncestors = flag.String("frame-ancestors", "'self'", "CSP frame-ancestors of the UI: origins allowed to embed it, e.g. 'self' https://ha.example.com")
+	flagUIDir          = flag.String("ui-dir", "", "Serve the web UI from this directory instead of the embedded files (live reload)")
 )
 
 //go:embed static/*
 var staticFiles embed.FS
 
-var editTmpl *template.Template
 var runtimeMaxBlockSize uint16
+var activeProfile *Profile
+
+// subcommands run instead of the exporter when given as the first argument
+var subcommands = map[string]func(args []string) int{
+	"encrypt-secret": runEncryptSecret,
+	"update":         runUpdate,
+	"check-config":   runCheckConfig,
+	"analyze":        runAnalyze,
+	"watch-raw":      runWatchRaw,
+}
 
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(run(os.Args[2:]))
+		}
+	}
+	captureLogs()
 	flag.Parse()
 
 	if *flagMaxBlockSize == 0 {
-		log.Fatal("max-block-size must be greater than 0")
-	}
-	if *flagUnitHost == "" {
-		log.Fatal("host is required")
+		configFailed("max-block-size must be greater than 0")
 	}
 	if *flagMaxBlockSize > uint(^uint16(0)) {
-		log.Fatalf("max-block-size %d exceeds uint16 max", *flagMaxBlockSize)
+		configFailed("max-block-size %d exceeds uint16 max", *flagMaxBlockSize)
 	}
 	if *flagInputMaxAddr > uint(^uint16(0)) {
-		log.Fatalf("input-max-addr %d exceeds uint16 max", *flagInputMaxAddr)
+		configFailed("input-max-addr %d exceeds uint16 max", *flagInputMaxAddr)
 	}
 	if *flagHoldingMaxAddr > uint(^uint16(0)) {
-		log.Fatalf("holding-max-addr %d exceeds uint16 max", *flagHoldingMaxAddr)
+		configFailed("holding-max-addr %d exceeds uint16 max", *flagHoldingMaxAddr)
+	}
+	if *flagMaxInflight == 0 {
+		configFailed("max-inflight must be greater than 0")
+	}
+
+	if err := parseAllowCIDR(*flagAllowCIDR); err != nil {
+		configFailed("Invalid allow-cidr: %v", err)
+	}
+	if err := loadTimezone(*flagTimezone); err != nil {
+		configFailed("Invalid timezone: %v", err)
 	}
+	rounding, err := futura.ParseRounding(*flagRounding)
+	if err != nil {
+		configFailed("Invalid rounding: %v", err)
+	}
+	writeRounding = rounding
+
+	cfg, err := loadConfig(*flagConfig, *flagSecretKeyFile)
+	if err != nil {
+		configFailed("Failed to load config: %v", err)
+	}
+	currentConfig.Store(cfg)
+	applyDeviceConfig(flag.CommandLine, cfg.Device)
 	if *flagSlaveID > 255 {
-		log.Fatalf("slave-id %d exceeds uint8 max", *flagSlaveID)
+		configFailed("slave-id %d exceeds uint8 max", *flagSlaveID)
 	}
 
-	validateRanges("input", inputRanges, uint16(*flagInputMaxAddr))
-	validateRanges("holding", holdingRanges, uint16(*flagHoldingMaxAddr))
+	profile, err := loadProfile(*flagProfile)
+	if err != nil {
+		configFailed("Failed to load profile: %v", err)
+	}
+	activeProfile = profile
+	if *flagReplay != "" {
+		faults := replayFaults{
+			Timeout:   *flagFaultTimeout,
+			Drop:      *flagFaultDrop,
+			Exception: *flagFaultException,
+			Garble:    *flagFaultGarble,
+			Seed:      *flagFaultSeed,
+		}
+		if err := faults.validate(); err != nil {
+			configFailed("%v", err)
+		}
+		host, port, err := startReplay(*flagReplay, profile.WriteLimits, faults)
+		if err != nil {
+			configFailed("Failed to start replay: %v", err)
+		}
+		*flagUnitHost, *flagUnitPort = host, port
+	}
+	if *flagUnitHost == "" {
+		runSetup(fmt.Sprintf(":%d", *flagHTTPPort))
+	}
+	log.Printf("Using device profile %s", profile.Name)
+	if err := applyRanges(profile, cfg.Device); err != nil {
+		configFailed("%v", err)
+	}
 
 	if *flagUnitPort > uint(^uint16(0)) {
-		log.Fatalf("port %d exceeds uint16 max", *flagUnitPort)
+		configFailed("port %d exceeds uint16 max", *flagUnitPort)
 	}
 	if *flagHTTPPort > 65535 {
-		log.Fatalf("http-port %d exceeds 65535", *flagHTTPPort)
+		configFailed("http-port %d exceeds 65535", *flagHTTPPort)
 	}
 
 	clientConfig := &modbus.ClientConfiguration{
@@ -108,191 +179,501 @@ func main() {
 		Timeout: 5 * time.Second,
 	}
 
-	client, err := modbus.NewClient(clientConfig)
+	mc, err := modbus.NewClient(clientConfig)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		configFailed("Failed to create client: %v", err)
 	}
-	if err := client.SetUnitId(uint8(*flagSlaveID)); err != nil {
-		log.Fatalf("Failed to set slave id: %v", err)
+	if err := mc.SetUnitId(uint8(*flagSlaveID)); err != nil {
+		configFailed("Failed to set slave id: %v", err)
 	}
+	client := NewModbusConn(mc)
 
+	connected := func() {
+		openReadPool(clientConfig, uint8(*flagSlaveID), int(*flagMaxInflight))
+		selfTest(client, profile, uint16(*flagMaxBlockSize))
+	}
 	err = client.Open()
-	if err != nil {
-		log.Fatalf("Failed to connect: %v. Is another tool open?", err)
+	switch {
+	case err == nil:
+		connected()
+	case *flagRequireDevice:
+		startupFailed("device", exitDevice, "Failed to connect: %v. Is another tool open?", err)
+	default:
+		log.Printf("Failed to connect: %v; starting anyway and retrying in the background", err)
+		go connectDevice(client, connected)
 	}
+	noteConnectAttempt(err, time.Now().Add(connectRetryMin))
 	defer client.Close()
+	defer closeReadPool()
+
+	if *flagReadOnly {
+		log.Printf("Read-only mode: no registers will be written")
+	}
+
+	history = NewHistory(*flagHistoryKeep)
+	if *flagHistoryDB != "" {
+		if historyDB, err = OpenHistoryDB(*flagHistoryDB, *flagHistoryDBKeep); err != nil {
+			configFailed("Failed to open history database: %v", err)
+		}
+	}
+	go shutdownOnSignal(client)
+	if *flagAuditLog != "" {
+		if err := openAuditLog(*flagAuditLog); err != nil {
+			configFailed("Failed to open audit log: %v", err)
+		}
+	}
+	if err := initWebPush(); err != nil {
+		configFailed("Failed to set up web push: %v", err)
+	}
+	startNotifications(client)
+	watchButtons()
+	watchAlarms()
+	initGuestKey()
+	ruleEngine.Store(NewRuleEngine(client, cfg.Rules))
+	drift = newDriftWatcher(client)
+	if err := loadSchedule(*flagScheduleFile); err != nil {
+		configFailed("Failed to load schedule: %v", err)
+	}
+	startScheduler(client)
 
 	// Register Prometheus metrics
-	RegisterRegMetrics()
+	RegisterRuleMetrics()
+	RegisterMaintenanceMetrics()
+	RegisterLANMetrics()
+	RegisterSnapshotMetrics()
+	RegisterActivityMetrics()
+	RegisterVerifyMetrics()
+	RegisterConnectMetrics()
+	RegisterHealthMetrics()
+	RegisterAccessLogMetrics()
+	RegisterScrapePollMetrics()
+	RegisterReplayMetrics()
+	RegisterWritePolicyMetrics()
+	if profile.Decoder == DecoderFutura {
+		RegisterRegMetrics()
+		RegisterDeviceInfoMetrics()
+		RegisterIAQMetrics()
+		RegisterDigitalInputMetrics()
+		RegisterOperatingStateMetrics()
+		RegisterAnalogInputMetrics()
+		RegisterBypassMetrics()
+		RegisterDefrostMetrics()
+		RegisterComfortMetrics()
+		RegisterDriftMetrics()
+		RegisterSeasonMetrics()
+		RegisterPowerAlarmMetrics()
+	} else {
+		if err := RegisterProfileMetrics(profile); err != nil {
+			configFailed("Profile %s: %v", profile.Name, err)
+		}
+	}
+	if *flagRecordRaw != "" {
+		if err := openRawRecording(*flagRecordRaw); err != nil {
+			configFailed("Failed to open raw recording: %v", err)
+		}
+	}
+	if *flagSnapshotFile != "" && profile.Decoder == DecoderFutura {
+		restored, err := loadSnapshot(*flagSnapshotFile)
+		if err != nil {
+			log.Printf("Failed to restore snapshot: %v", err)
+		} else if restored {
+			in, _, _ := latestSnapshot()
+			UpdatePrometheus(in)
+			updateDeviceInfo(in)
+			log.Printf("Restored snapshot %d from %s", in.Seq, in.Time.Format(time.RFC3339))
+		}
+	}
 
 	// Start HTTP server for metrics, edit page, and write API
-	http.Handle("/metrics", promhttp.Handler())
-	http.HandleFunc("/", handleIndex)
+	if *flagPollOnScrape {
+		http.Handle("/metrics", pollBeforeScrape(promhttp.Handler()))
+	} else {
+		http.Handle("/metrics", promhttp.Handler())
+	}
+	http.HandleFunc("/readyz", handleReadyz)
 	http.HandleFunc("/api/read-holding", handleReadHolding(client))
 	http.HandleFunc("/api/read-input", handleReadInput(client))
-	http.HandleFunc("/api/write-holding", handleWriteHolding(client))
-	// Serve static assets (images, css, etc.) from embedded files
-	staticSub, err := fs.Sub(staticFiles, "static")
+	http.HandleFunc("/api/write-holding", rateLimited(handleWriteHolding(client)))
+	http.HandleFunc("/api/write-bits", rateLimited(handleWriteBits(client)))
+	http.HandleFunc("/api/fields", handleFields)
+	http.HandleFunc("/api/unlock", handleUnlock)
+	http.HandleFunc("/api/maintenance", handleMaintenance(client))
+	http.HandleFunc("/api/selftest", handleSelfTest(client))
+	http.HandleFunc("/api/info", handleInfo)
+	http.HandleFunc("/api/ext-buttons", rateLimited(handleExtButtons(client)))
+	http.HandleFunc("/api/ext-sensor/", rateLimited(handleExtSensor(client)))
+	http.HandleFunc("/api/history", handleHistory)
+	http.HandleFunc("/api/history/compare", handleHistoryCompare)
+	http.HandleFunc("/api/events", handleEvents)
+	http.HandleFunc("/api/audit", handleAudit)
+	http.HandleFunc("/api/push/key", handlePushKey)
+	http.HandleFunc("/api/push/subscribe", handlePushSubscribe)
+	http.HandleFunc("/api/iaq", handleIAQ)
+	http.HandleFunc("/api/report/monthly", handleMonthlyReport)
+	http.HandleFunc("/api/comfort", handleComfort)
+	http.HandleFunc("/api/schedule", handleSchedule)
+	http.HandleFunc("/api/rules/simulate", handleRulesSimulate)
+	http.HandleFunc("/api/rules/history", handleRulesHistory)
+	http.HandleFunc("/api/drift", handleDrift)
+	http.HandleFunc("/api/action/vacation", rateLimited(handleVacation(client)))
+	http.HandleFunc("/api/season", rateLimited(handleSeason(client)))
+	http.HandleFunc("/api/guest/token", handleGuestToken)
+	http.HandleFunc("/api/guest/qr", handleGuestQR)
+	http.HandleFunc("/api/guest/action", rateLimited(handleGuestAction(client)))
+	http.HandleFunc("/api/ws", handleWS(client))
+	http.HandleFunc("/api/stream", handleStream)
+	http.HandleFunc("/events", handleStream)
+	http.HandleFunc("/api/snapshot.bin", handleSnapshotBin)
+	http.HandleFunc("/api/display", handleDisplay)
+	http.HandleFunc("/api/snapshot.layout", handleSnapshotLayout)
+	if *flagProbe {
+		if profile.Decoder != DecoderFutura {
+			configFailed("-probe needs the futura profile")
+		}
+		http.HandleFunc("/probe", handleProbe)
+	}
+	if len(cfg.Federation.Remotes) > 0 {
+		http.HandleFunc("/api/federation/", handleFederationRead)
+		http.HandleFunc("/metrics/federate", handleFederateMetrics)
+	}
+	if cfg.Updates.Check {
+		http.HandleFunc("/api/version/check", handleVersionCheck)
+	}
+	if len(cfg.Management.Tokens) > 0 {
+		http.HandleFunc("/api/manage/", handleManage(client))
+	}
+	if len(cfg.Intents.Tokens) > 0 {
+		if cfg.Intents.Listen != "" {
+			startIntentListener(client)
+		} else {
+			http.HandleFunc("/api/intent", rateLimited(handleIntent(client)))
+		}
+	}
+	// Serve static assets (images, css, etc.) from embedded files or -ui-dir
+	staticSub, err := uiFS()
 	if err != nil {
-		log.Fatalf("Failed to access embedded static files: %v", err)
+		configFailed("Failed to access UI files: %v", err)
+	}
+	http.Handle("/static/", http.StripPrefix("/static/", uiHandler(staticSub)))
+	http.HandleFunc("/api/ui-version", handleUIVersion(staticSub))
+	http.HandleFunc("/edit", handleEdit(staticSub))
+	http.HandleFunc("/", handleIndex(staticSub))
+
+	// Polling loop: read input and holding ranges periodically and update metrics
+	if *flagPollInterval <= 0 {
+		configFailed("poll-interval must be greater than 0")
 	}
-	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticSub))))
 
 	httpAddr := fmt.Sprintf(":%d", *flagHTTPPort)
+	ln, err := net.Listen("tcp", httpAddr)
+	if err != nil {
+		startupFailed("listen", exitListen, "HTTP server failed: %v", err)
+	}
 	go func() {
 		log.Printf("Starting HTTP server on %s", httpAddr)
-		if err := http.ListenAndServe(httpAddr, nil); err != nil {
+		srv := newHTTPServer(logRequests(securityHeaders(signedRequests(csrfProtect(requireAuth(allowCIDR(trackActivity(http.DefaultServeMux), *flagAllowCIDRAll)))), *flagFrameAncestors)))
+		if err := srv.Serve(ln); err != nil {
 			log.Fatalf("HTTP server failed: %v", err)
 		}
 	}()
-
-	// Polling loop: read input and holding ranges periodically and update metrics
-	if *flagPollInterval <= 0 {
-		log.Fatal("poll-interval must be greater than 0")
-	}
+	startupReady(httpAddr)
 	runtimeMaxBlockSize = uint16(*flagMaxBlockSize)
 
 	pollOnce := func() {
+		if maintenanceActive() || !connectionOpened() {
+			return
+		}
+		if client.brk.open() {
+			// waiting for the next reconnect attempt
+			return
+		}
+		start := time.Now()
 		inputMap := collectRanges(client, modbus.INPUT_REGISTER, inputRanges, runtimeMaxBlockSize)
 		holdingMap := collectRanges(client, modbus.HOLDING_REGISTER, holdingRanges, runtimeMaxBlockSize)
+		pollDuration.Observe(time.Since(start).Seconds())
+		checkLANHang()
+		if len(inputMap) == 0 && len(holdingMap) == 0 {
+			// the unit didn't answer; keep the last values, flagged stale
+			markSnapshotStale()
+			next, err := client.brk.status()
+			if err == nil {
+				err = errors.New("poll read nothing")
+			}
+			noteConnection(err, next)
+			log.Printf("Poll read nothing, keeping the last snapshot")
+			return
+		}
+		noteConnection(nil, time.Time{})
+		lastPollSuccess.SetToCurrentTime()
+		meta := nextPollMeta()
+		cacheRegisters(modbus.INPUT_REGISTER, inputMap, meta)
+		cacheRegisters(modbus.HOLDING_REGISTER, holdingMap, meta)
+
+		if profile.Decoder != DecoderFutura {
+			UpdateProfileMetrics(profile, profile.Decode(inputMap, holdingMap))
+			log.Printf("Poll complete: inputs=%d, holdings=%d", len(inputMap), len(holdingMap))
+			return
+		}
 
 			// Decode input registers
-			decoded := DecodeInputMap(inputMap)
-
-			// Merge external sensor values from holding registers (per spec)
-			for i := 0; i < ExtSensInstances; i++ {
-				base := AddrExtSensBase + uint16(i*10)
-				decoded.ExtSensPresent[i] = u16(holdingMap, base)
-				decoded.ExtSensInvalidate[i] = u16(holdingMap, base+1)
-				decoded.ExtSensTemp[i] = i16f(holdingMap, base+2, 0.1)
-				decoded.ExtSensRH[i] = u16f(holdingMap, base+3, 1.0)
-				decoded.ExtSensCo2[i] = u16(holdingMap, base+4)
-				decoded.ExtSensTFloor[i] = i16f(holdingMap, base+5, 0.1)
-				//log.Printf("Merged ExtSens[%d] from holding: present=%d temp=%.1f RH=%.1f CO2=%d floor=%.1f", i+1, decoded.ExtSensPresent[i], decoded.ExtSensTemp[i], decoded.ExtSensRH[i], decoded.ExtSensCo2[i], decoded.ExtSensTFloor[i])
-			}
+			decoded := futura.DecodeInputMap(inputMap)
+			decoded.SnapshotMeta = meta
+			applyAnalogScaling(&decoded)
 
-			// Also merge external button state so Prometheus and other consumers can see it
-			for i := 0; i < HoldingExtBtnInstances; i++ {
-				base := AddrHoldingExtBtnBase + uint16(i*10)
-				decoded.ExtBtnPresent[i] = u16(holdingMap, base)
-				decoded.ExtBtnMode[i] = u16(holdingMap, base+1)
-				decoded.ExtBtnTm[i] = u16(holdingMap, base+2)
-				decoded.ExtBtnActive[i] = u16(holdingMap, base+3)
-				//log.Printf("Merged ExtBtn[%d] from holding: present=%d mode=%d tm=%d active=%d", i+1, decoded.ExtBtnPresent[i], decoded.ExtBtnMode[i], decoded.ExtBtnTm[i], decoded.ExtBtnActive[i])
-			}
+			// Merge external sensor and button values from holding registers (per spec)
+			futura.MergeHoldingExt(&decoded, holdingMap)
 
 			// Update Prometheus metrics
 			UpdatePrometheus(decoded)
+			updateAnalogMetrics(decoded)
+			updateDeviceInfo(decoded)
+			history.Record(decoded, time.Now())
+			if historyDB != nil {
+				historyDB.Record(decoded, time.Now())
+			}
+			UpdateIAQ(decoded)
+			bypass.update(decoded, time.Now())
+			defrost.update(decoded, time.Now())
+			comfort.update(decoded, time.Now())
+			holding := futura.DecodeHoldingMap(holdingMap)
+			holding.SnapshotMeta = meta
+			storeSnapshot(decoded, holding)
+			rules().Evaluate(decoded, holding)
+			drift.check(holdingMap, time.Now())
+			updateSeason(client, time.Now())
+			checkPowerAlarm(decoded, holding, time.Now())
+			detectEdges(decoded)
+			publishUpdate(decoded)
+			publishStream(decoded)
+			pushGrafanaLive(decoded)
+			publishHAState(decoded, holding)
 
 		log.Printf("Poll complete: inputs=%d, holdings=%d", len(inputMap), len(holdingMap))
 	}
 
 	pollOnce()
-	ticker := time.NewTicker(*flagPollInterval)
-	defer ticker.Stop()
-	for range ticker.C {
+	if *flagPollOnScrape {
+		pollOnScrape(pollOnce)
+	}
+	for {
+		waitNextPoll()
 		pollOnce()
 	}
 }
 
 // collectRanges reads a set of ranges and returns a map[address]value
-func collectRanges(client *modbus.ModbusClient, regType modbus.RegType, ranges [][]uint16, maxBlockSize uint16) map[uint16]uint16 {
+func collectRanges(client *ModbusConn, regType modbus.RegType, ranges [][]uint16, maxBlockSize uint16) map[uint16]uint16 {
 	out := map[uint16]uint16{}
+	spans := splitRanges(ranges, maxBlockSize)
 
-	for _, r := range ranges {
-		start, end := r[0], r[1]
-		totalToRead := (end - start) + 1
-
-		for i := uint16(0); i < totalToRead; i += maxBlockSize {
-			batchStart := start + i
-			batchQuantity := maxBlockSize
-
-			if i+batchQuantity > totalToRead {
-				batchQuantity = totalToRead - i
-			}
+	if len(readPool) > 0 && len(spans) > 1 {
+		readSpansParallel(client, regType, spans, out)
+		return out
+	}
+	for _, s := range spans {
+		regs, ok := readBlock(client, regType, s.start, s.qty)
+		if !ok {
+			continue
+		}
+		for idx, val := range regs {
+			out[s.start+uint16(idx)] = val
+		}
+	}
 
-			regs, err := client.ReadRegisters(batchStart, batchQuantity, regType)
-			if err != nil {
-				log.Printf("ReadRegisters error for %d-%d: %v", batchStart, batchStart+batchQuantity-1, err)
-
-					// Attempt to recover from network errors by reopening the connection once and retrying
-					_ = client.Close()
-					time.Sleep(500 * time.Millisecond)
-					if err2 := client.Open(); err2 != nil {
-						log.Printf("Re-open failed: %v", err2)
-						continue
-					}
+	return out
+}
 
-					// Retry the read once
-					regs, err = client.ReadRegisters(batchStart, batchQuantity, regType)
-					if err != nil {
-						log.Printf("ReadRegisters retry failed for %d-%d: %v", batchStart, batchStart+batchQuantity-1, err)
-						continue
-					}
-				}
+// readBlock reads one block of registers; on a transport error it reopens
+// the connection and retries once, and if that fails too it opens the
+// client's breaker, so further reads return at once until the backoff
+// expires
+func readBlock(client *ModbusConn, regType modbus.RegType, batchStart, batchQuantity uint16) ([]uint16, bool) {
+	if !connectionOpened() || client.brk.open() {
+		return nil, false
+	}
+	regs, err := client.ReadRegisters(batchStart, batchQuantity, regType)
+	if err == nil {
+		readOK(client)
+		recordRaw(regType, batchStart, regs)
+		return regs, true
+	}
+	if modbusException(err) {
+		// the unit answered; the connection is fine
+		readErrorsTotal.WithLabelValues("exception").Inc()
+		log.Printf("ReadRegisters error for %d-%d: %v", batchStart, batchStart+batchQuantity-1, err)
+		noteRead(err, true)
+		return nil, false
+	}
+	readErrorsTotal.WithLabelValues("transport").Inc()
+	retrying := client.brk.failing()
+	if !retrying {
+		log.Printf("ReadRegisters error for %d-%d: %v", batchStart, batchStart+batchQuantity-1, err)
+	}
 
-				for idx, val := range regs {
-				addr := batchStart + uint16(idx)
-				out[addr] = val
-			}
+	// Attempt to recover from network errors by reopening the connection once and retrying
+	err = client.Reopen(reopenPause)
+	reconnectsTotal.Inc()
+	if err != nil {
+		noteRead(err, false)
+	} else {
+		regs, err = client.ReadRegisters(batchStart, batchQuantity, regType)
+		noteRead(err, true)
+		if err != nil {
+			readErrorsTotal.WithLabelValues("transport").Inc()
 		}
 	}
+	if err != nil {
+		d := client.brk.trip(err)
+		if retrying {
+			log.Printf("Reconnecting to the unit failed: %v; next attempt in %s", err, d)
+		} else {
+			log.Printf("Connection to the unit lost: %v; skipping reads, next attempt in %s", err, d)
+		}
+		return nil, false
+	}
+	readOK(client)
+	recordRaw(regType, batchStart, regs)
+	return regs, true
+}
 
-	return out
+// readOK records a successful read and closes the breaker
+func readOK(client *ModbusConn) {
+	noteRead(nil, true)
+	if n := client.brk.reset(); n > 0 {
+		log.Printf("Connection to the unit is back after %d failed attempts", n)
+	}
 }
 
-func validateRanges(name string, ranges [][]uint16, maxAddr uint16) {
+func validateRanges(name string, ranges [][]uint16, maxAddr uint16) error {
 	for idx, r := range ranges {
 		if len(r) != 2 {
-			log.Fatalf("%s range %d must have exactly 2 values", name, idx)
+			return fmt.Errorf("%s range %d must have exactly 2 values", name, idx)
 		}
 		start, end := r[0], r[1]
 		if start > end {
-			log.Fatalf("%s range %d has start > end (%d > %d)", name, idx, start, end)
+			return fmt.Errorf("%s range %d has start > end (%d > %d)", name, idx, start, end)
 		}
 		if end > maxAddr {
-			log.Fatalf("%s range %d exceeds max address %d (end=%d)", name, idx, maxAddr, end)
+			return fmt.Errorf("%s range %d exceeds max address %d (end=%d)", name, idx, maxAddr, end)
 		}
 	}
+	return nil
 }
 
 
-// writeRegisters writes holding registers to the device
-// NOTE: This implementation only performs single-register writes. It will
-// never write registers in batches — each address is written individually.
-func writeRegisters(client *modbus.ModbusClient, registerMap map[uint16]uint16) error {
-	if len(registerMap) == 0 {
-		return nil
-	}
+// maxWriteBlock is the most registers one FC16 request may carry
+const maxWriteBlock = 123
+
+// fc16Rejected is set once the unit answered an FC16 write with an illegal
+// function exception; later writes go register by register
+var fc16Rejected atomic.Bool
+
+// writeBlock is a run of contiguous registers written with one request
+type writeBlock struct {
+	start  uint16
+	values []uint16
+}
 
-	// Write every register individually (no batch writes)
-	for addr, val := range registerMap {
-		log.Printf("Writing register %d = 0x%04X", addr, val)
-		if err := client.WriteRegister(addr, val); err != nil {
-			return fmt.Errorf("write register %d: %w", addr, err)
+// writeBlocks coalesces the registers into contiguous blocks in address
+// order
+func writeBlocks(registerMap map[uint16]uint16) []writeBlock {
+	addrs := make([]int, 0, len(registerMap))
+	for addr := range registerMap {
+		addrs = append(addrs, int(addr))
+	}
+	sort.Ints(addrs)
+	var blocks []writeBlock
+	for _, a := range addrs {
+		addr := uint16(a)
+		if n := len(blocks); n > 0 {
+			b := &blocks[n-1]
+			if int(b.start)+len(b.values) == a && len(b.values) < maxWriteBlock {
+				b.values = append(b.values, registerMap[addr])
+				continue
+			}
 		}
+		blocks = append(blocks, writeBlock{addr, []uint16{registerMap[addr]}})
 	}
-
-	return nil
+	return blocks
 }
 
-// handleIndex redirects to /edit
-func handleIndex(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
-		return
+// writeRegisters writes holding registers to the device, contiguous ones
+// with one FC16 request each. With -single-writes, or after the unit
+// rejected FC16, every register is written on its own (FC6).
+func writeRegisters(client *ModbusConn, registerMap map[uint16]uint16) error {
+	if len(registerMap) == 0 {
+		return nil
+	}
+	if err := writeBlocked(); err != nil {
+		return err
 	}
-	http.Redirect(w, r, "/static/edit.html", http.StatusFound)
+
+	// no poll reads in between
+	defer invalidateRegisters(modbus.HOLDING_REGISTER)
+	return client.Do(func(mc *modbus.ModbusClient) error {
+		for _, b := range writeBlocks(registerMap) {
+			if len(b.values) > 1 && !*flagSingleWrites && !fc16Rejected.Load() {
+				log.Printf("Writing registers %d-%d", b.start, int(b.start)+len(b.values)-1)
+				err := mc.WriteRegisters(b.start, b.values)
+				if err == nil {
+					continue
+				}
+				if !errors.Is(err, modbus.ErrIllegalFunction) {
+					return fmt.Errorf("write registers %d-%d: %w", b.start, int(b.start)+len(b.values)-1, err)
+				}
+				log.Printf("Unit rejected a multi-register write (FC16), writing registers one by one from now on; pass -single-writes to skip the attempt")
+				fc16Rejected.Store(true)
+			}
+			for i, val := range b.values {
+				addr := b.start + uint16(i)
+				log.Printf("Writing register %d = 0x%04X", addr, val)
+				if err := mc.WriteRegister(addr, val); err != nil {
+					return fmt.Errorf("write register %d: %w", addr, err)
+				}
+			}
+		}
+		return nil
+	})
 }
 
 
 // handleReadHolding returns current holding register values as JSON
-func handleReadHolding(client *modbus.ModbusClient) http.HandlerFunc {
+func handleReadHolding(client *ModbusConn) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
-		
-		holdingMap := collectRanges(client, modbus.HOLDING_REGISTER, holdingRanges, runtimeMaxBlockSize)
-		holding := DecodeHoldingMap(holdingMap)
+		if maintenanceActive() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, errMaintenance.Error())
+			return
+		}
+		maxAge, err := readMaxAge(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+			return
+		}
+
+		holdingMap, meta, hit := readRegisters(client, modbus.HOLDING_REGISTER, holdingRanges, maxAge)
+		if len(holdingMap) == 0 {
+			if _, hold, ok := latestSnapshot(); ok {
+				hold.Stale = true
+				serveStale(w, hold, hold.SnapshotMeta)
+				return
+			}
+			if !connectionOpened() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprintf(w, `{"success":false,"error":"not connected to the unit yet"}`)
+				return
+			}
+		}
+		setCacheHeader(w, hit)
+		setSnapshotHeaders(w, meta)
+		if activeProfile.Decoder != DecoderFutura {
+			writeProfileValues(w, activeProfile, nil, holdingMap)
+			return
+		}
+		holding := futura.DecodeHoldingMap(holdingMap)
+		holding.SnapshotMeta = meta
 		
 		// Return as JSON
 		if err := json.NewEncoder(w).Encode(holding); err != nil {
@@ -303,7 +684,7 @@ func handleReadHolding(client *modbus.ModbusClient) http.HandlerFunc {
 }
 
 // handleWriteHolding processes POST requests to write holding registers
-func handleWriteHolding(client *modbus.ModbusClient) http.HandlerFunc {
+func handleWriteHolding(client *ModbusConn) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 		
@@ -312,29 +693,56 @@ func handleWriteHolding(client *modbus.ModbusClient) http.HandlerFunc {
 			return
 		}
 
+		if activeProfile.Decoder != DecoderFutura {
+			fmt.Fprintf(w, `{"success":false,"error":"profile %s does not support writes"}`, activeProfile.Name)
+			return
+		}
+
 		var data map[string]interface{}
 		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
 			fmt.Fprintf(w, `{"success":false,"error":"Invalid JSON"}`)
 			return
 		}
 
+		// names of enum values become their numbers
+		for k, v := range data {
+			val, err := fieldValue(k, v)
+			if err != nil {
+				fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+				return
+			}
+			data[k] = val
+		}
+
 		// If a single field is provided write only that register
 		if len(data) == 1 {
 			for k, v := range data {
-				// v is typically float64 from json decoding
-				val, ok := v.(float64)
-				if !ok {
-					fmt.Fprintf(w, `{"success":false,"error":"invalid value type"}`)
+				val := v.(float64)
+				if err := checkWritePolicy(r, k, val); err != nil {
+					fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+					return
+				}
+				if dryRun(r) {
+					pw, err := planWrite(client, k, val)
+					if err != nil {
+						fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+						return
+					}
+					writeDryRunResponse(w, []plannedWrite{pw})
 					return
 				}
 				log.Printf("Single write requested: %s = %v", k, val)
-				if err := WriteSingleRegister(client, k, val); err != nil {
+				verified, err := writeFieldVerified(client, k, val, requestOrigin(r, "api"))
+				if err != nil {
 					log.Printf("Single write error: %v", err)
+					if writeMismatchResponse(w, err) {
+						return
+					}
 					fmt.Fprintf(w, `{"success":false,"error":"%s"}` , err.Error())
 					return
 				}
 				log.Printf("Single write success: %s = %v", k, val)
-				fmt.Fprintf(w, `{"success":true,"message":"%s updated"}` , k)
+				fmt.Fprintf(w, `{"success":true,"verified":%t,"message":"%s updated"}`, verified, k)
 				return
 			}
 		}
@@ -342,111 +750,98 @@ func handleWriteHolding(client *modbus.ModbusClient) http.HandlerFunc {
 		// Otherwise do a full holding update (writes potentially multiple registers)
 		// Read current holding registers
 		holdingMap := collectRanges(client, modbus.HOLDING_REGISTER, holdingRanges, runtimeMaxBlockSize)
-		holding := DecodeHoldingMap(holdingMap)
-
-		// Update with provided values
-		if v, ok := data["FuncVentilation"]; ok {
-			holding.FuncVentilation = uint16(v.(float64))
-		}
-		if v, ok := data["FuncBoostTm"]; ok {
-			holding.FuncBoostTm = uint16(v.(float64))
-		}
-		if v, ok := data["FuncCirculationTm"]; ok {
-			holding.FuncCirculationTm = uint16(v.(float64))
-		}
-		if v, ok := data["FuncPartyTm"]; ok {
-			holding.FuncPartyTm = uint16(v.(float64))
-		}
-		if v, ok := data["FuncNightTm"]; ok {
-			holding.FuncNightTm = uint16(v.(float64))
-		}
-		if v, ok := data["FuncOverpressureTm"]; ok {
-			holding.FuncOverpressureTm = uint16(v.(float64))
-		}
-		if v, ok := data["CfgTempSet"]; ok {
-			holding.CfgTempSet = v.(float64)
-		}
-		if v, ok := data["CfgHumiSet"]; ok {
-			holding.CfgHumiSet = v.(float64)
-		}
-		if v, ok := data["CfgBypassEnable"]; ok {
-			holding.CfgBypassEnable = uint16(v.(float64))
-		}
-		if v, ok := data["CfgHeatingEnable"]; ok {
-			holding.CfgHeatingEnable = uint16(v.(float64))
-		}
-		if v, ok := data["CfgCoolingEnable"]; ok {
-			holding.CfgCoolingEnable = uint16(v.(float64))
-		}
-		if v, ok := data["CfgComfortEnable"]; ok {
-			holding.CfgComfortEnable = uint16(v.(float64))
-		}
-		if v, ok := data["FuncTimeProg"]; ok {
-			holding.FuncTimeProg = uint16(v.(float64))
-		}
-		if v, ok := data["FuncAntiradon"]; ok {
-			holding.FuncAntiradon = uint16(v.(float64))
+		holding := futura.DecodeHoldingMap(holdingMap)
+		before := holding
+		changed, err := checkBulkWritePolicy(r, data, holding)
+		if err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+			return
 		}
-		if v, ok := data["VzvCBPriorityControl"]; ok {
-			holding.VzvCBPriorityControl = uint16(v.(float64))
+		// the dry run reports the same plan as is written
+		plan, err := planBulkWrite(data, changed)
+		if err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+			return
 		}
-		if v, ok := data["VzvKitchenhoodNormallyOpen"]; ok {
-			holding.VzvKitchenhoodNormallyOpen = uint16(v.(float64))
+		if dryRun(r) {
+			writeDryRunResponse(w, plan)
+			return
 		}
-		if v, ok := data["VzvBoostVolumePerRun"]; ok {
-			holding.VzvBoostVolumePerRun = uint16(v.(float64))
+
+		o := requestOrigin(r, "api")
+		encoded := map[uint16]uint16{}
+		for _, pw := range plan {
+			encoded[pw.Addr] = pw.Register
 		}
-		if v, ok := data["VzvKitchenhoodNormallyOpenVolume"]; ok {
-			holding.VzvKitchenhoodNormallyOpenVolume = uint16(v.(float64))
+		release, err := reserveWrites(changed, o)
+		if err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+			return
 		}
-
-		// Encode and write
-		encoded := EncodeHoldingRegs(holding)
 		if err := writeRegisters(client, encoded); err != nil {
+			release()
 			log.Printf("Write error: %v", err)
+			auditBulkWrite(changed, data, before, o, err)
 			fmt.Fprintf(w, `{"success":false,"error":"%s"}`, err.Error())
 			return
 		}
+		for _, f := range changed {
+			val, _ := data[f].(float64)
+			noteWrite(f, val, o)
+		}
 		log.Printf("Bulk write completed: %d registers written", len(encoded))
+		verified, err := verifyFields(client, changed, encoded)
+		auditBulkWrite(changed, data, before, o, err)
+		if writeMismatchResponse(w, err) {
+			return
+		}
 
-		fmt.Fprintf(w, `{"success":true,"message":"Registers updated successfully"}`)
+		fmt.Fprintf(w, `{"success":true,"verified":%t,"message":"Registers updated successfully"}`, verified)
 	}
 }
 
 // handleReadInput returns current input register values as JSON
-func handleReadInput(client *modbus.ModbusClient) http.HandlerFunc {
+func handleReadInput(client *ModbusConn) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
+		if maintenanceActive() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, errMaintenance.Error())
+			return
+		}
+		maxAge, err := readMaxAge(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+			return
+		}
 
-		inputMap := collectRanges(client, modbus.INPUT_REGISTER, inputRanges, runtimeMaxBlockSize)
-	input := DecodeInputMap(inputMap)
-
-	// Also read holding registers and prefer external sensor values from holdings
-	holdingMap := collectRanges(client, modbus.HOLDING_REGISTER, holdingRanges, runtimeMaxBlockSize)
-	// Override ext sensor fields with holding values (if present)
-	for i := 0; i < ExtSensInstances; i++ {
-		base := AddrExtSensBase + uint16(i*10)
-		input.ExtSensPresent[i] = u16(holdingMap, base)
-		input.ExtSensInvalidate[i] = u16(holdingMap, base+1)
-		input.ExtSensTemp[i] = i16f(holdingMap, base+2, 0.1)
-		input.ExtSensRH[i] = u16f(holdingMap, base+3, 1.0)
-		input.ExtSensCo2[i] = u16(holdingMap, base+4)
-		input.ExtSensTFloor[i] = i16f(holdingMap, base+5, 0.1)
-		// Debug log showing raw holding-derived ext sensor values
-		//log.Printf("ExtSens[%d] holding base=%d present=%d invalidate=%d temp=%.1f RH=%.1f CO2=%d floor=%.1f",
-		//	i+1, base, input.ExtSensPresent[i], input.ExtSensInvalidate[i], input.ExtSensTemp[i], input.ExtSensRH[i], input.ExtSensCo2[i], input.ExtSensTFloor[i])
-	}
-
-		// Merge external button values from holdings so read-input includes them too
-		for i := 0; i < HoldingExtBtnInstances; i++ {
-			base := AddrHoldingExtBtnBase + uint16(i*10)
-			input.ExtBtnPresent[i] = u16(holdingMap, base)
-			input.ExtBtnMode[i] = u16(holdingMap, base+1)
-			input.ExtBtnTm[i] = u16(holdingMap, base+2)
-			input.ExtBtnActive[i] = u16(holdingMap, base+3)
-			//log.Printf("ExtBtn[%d] holding base=%d present=%d mode=%d tm=%d active=%d",
-			//	i+1, base, input.ExtBtnPresent[i], input.ExtBtnMode[i], input.ExtBtnTm[i], input.ExtBtnActive[i])
+		inputMap, meta, hit := readRegisters(client, modbus.INPUT_REGISTER, inputRanges, maxAge)
+		if len(inputMap) == 0 {
+			if in, _, ok := latestSnapshot(); ok {
+				in.Stale = true
+				serveStale(w, in, in.SnapshotMeta)
+				return
+			}
+			if !connectionOpened() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprintf(w, `{"success":false,"error":"not connected to the unit yet"}`)
+				return
+			}
 		}
+		setCacheHeader(w, hit)
+		setSnapshotHeaders(w, meta)
+		if activeProfile.Decoder != DecoderFutura {
+			writeProfileValues(w, activeProfile, inputMap, nil)
+			return
+		}
+	input := futura.DecodeInputMap(inputMap)
+	input.SnapshotMeta = meta
+	applyAnalogScaling(&input)
+
+	// Also read holding registers and prefer external sensor/button values from holdings
+	holdingMap, _, _ := readRegisters(client, modbus.HOLDING_REGISTER, holdingRanges, maxAge)
+	futura.MergeHoldingExt(&input, holdingMap)
 
 		if err := json.NewEncoder(w).Encode(input); err != nil {
 			log.Printf("encode input json: %v", err)
@@ -454,3 +849,18 @@ func handleReadInput(client *modbus.ModbusClient) http.HandlerFunc {
 		}
 	}
 }
+
+// writeProfileValues encodes generically decoded profile values as JSON.
+// Registers of the type not provided (nil map) are omitted.
+func writeProfileValues(w http.ResponseWriter, p *Profile, inputMap, holdingMap map[uint16]uint16) {
+	values := p.Decode(inputMap, holdingMap)
+	for _, reg := range p.Registers {
+		if (reg.Type == "input" && inputMap == nil) || (reg.Type == "holding" && holdingMap == nil) {
+			delete(values, reg.Name)
+		}
+	}
+	if err := json.NewEncoder(w).Encode(values); err != nil {
+		log.Printf("encode profile json: %v", err)
+		http.Error(w, "internal encode error", http.StatusInternalServerError)
+	}
+}
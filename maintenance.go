@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Maintenance mode pauses polling, automation and writes while the unit is
+// being serviced. The Modbus connection is closed so service tools can use
+// the unit's TCP slot; the next read after maintenance reopens it.
+type maintenanceState struct {
+	Active bool      `json:"active"`
+	Since  time.Time `json:"since,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+var (
+	maintenanceMu sync.Mutex
+	maintenance   maintenanceState
+
+	errMaintenance = errors.New("maintenance mode is active")
+
+	maintenanceGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "futura_maintenance_mode",
+		Help: "1 while maintenance mode pauses polling, automation and writes",
+	})
+)
+
+func RegisterMaintenanceMetrics() {
+	prometheus.MustRegister(maintenanceGauge)
+}
+
+func maintenanceActive() bool {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+	return maintenance.Active
+}
+
+// handleMaintenance reports (GET), starts (POST {"reason":"..."}) or ends
+// (DELETE) maintenance mode
+func handleMaintenance(client *ModbusConn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.Method {
+		case http.MethodGet:
+			maintenanceMu.Lock()
+			st := maintenance
+			maintenanceMu.Unlock()
+			if err := json.NewEncoder(w).Encode(st); err != nil {
+				log.Printf("encode maintenance json: %v", err)
+			}
+		case http.MethodPost:
+			var req struct {
+				Reason string `json:"reason"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+				fmt.Fprintf(w, `{"success":false,"error":"Invalid JSON"}`)
+				return
+			}
+			maintenanceMu.Lock()
+			if !maintenance.Active {
+				maintenance = maintenanceState{Active: true, Since: time.Now(), Reason: req.Reason}
+				maintenanceGauge.Set(1)
+				client.Close()
+				log.Printf("Maintenance mode started by %s: %s", clientIP(r), req.Reason)
+			}
+			maintenanceMu.Unlock()
+			fmt.Fprintf(w, `{"success":true,"message":"Maintenance mode active"}`)
+		case http.MethodDelete:
+			maintenanceMu.Lock()
+			if maintenance.Active {
+				log.Printf("Maintenance mode ended by %s after %s", clientIP(r), time.Since(maintenance.Since).Round(time.Second))
+			}
+			maintenance = maintenanceState{}
+			maintenanceGauge.Set(0)
+			maintenanceMu.Unlock()
+			fmt.Fprintf(w, `{"success":true,"message":"Maintenance mode ended"}`)
+		default:
+			fmt.Fprintf(w, `{"success":false,"error":"GET, POST or DELETE required"}`)
+		}
+	}
+}
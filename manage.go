@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Remote management for installers looking after many units:
+// /api/manage/{status,version,logs,reload,restart}, authenticated with the
+// management tokens.
+
+// ManagementConfig enables the management API
+type ManagementConfig struct {
+	Tokens []string `yaml:"tokens"` // bearer tokens allowed to manage this instance
+}
+
+func (c ManagementConfig) validate() error {
+	for i, t := range c.Tokens {
+		if t == "" {
+			return fmt.Errorf("management token %d is empty", i)
+		}
+	}
+	return nil
+}
+
+// version is set at build time: go build -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// exitRestart is the exit status when a restart can't re-execute the
+// binary, so a supervisor with Restart=on-failure starts it again
+const exitRestart = 75
+
+var startTime = time.Now()
+
+// logRingSize is how many recent log lines /api/manage/logs keeps
+const logRingSize = 1000
+
+type logLine struct {
+	Seq  int64     `json:"seq"`
+	Time time.Time `json:"time"`
+	Text string    `json:"text"`
+}
+
+// logRing keeps the latest log lines; the log package writes each message
+// with a single Write
+type logRing struct {
+	mu    sync.Mutex
+	seq   int64
+	lines []logLine
+}
+
+var recentLogs = &logRing{}
+
+func (l *logRing) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for _, text := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		l.seq++
+		l.lines = append(l.lines, logLine{Seq: l.seq, Time: now, Text: text})
+	}
+	if len(l.lines) > logRingSize {
+		l.lines = append([]logLine(nil), l.lines[len(l.lines)-logRingSize:]...)
+	}
+	return len(p), nil
+}
+
+func (l *logRing) list(after int64, since time.Time) []logLine {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	start := sort.Search(len(l.lines), func(i int) bool { return l.lines[i].Seq > after })
+	var out []logLine
+	for _, line := range l.lines[start:] {
+		if !line.Time.Before(since) {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// captureLogs copies the log output into recentLogs
+func captureLogs() {
+	log.SetOutput(io.MultiWriter(os.Stderr, recentLogs))
+}
+
+// VersionInfo describes the running binary
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"` // VCS commit the binary was built from
+	Modified  bool   `json:"modified,omitempty"` // built from a modified tree
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+func versionInfo() VersionInfo {
+	v := VersionInfo{Version: version, GoVersion: runtime.Version(), Platform: runtime.GOOS + "/" + runtime.GOARCH}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				v.Revision = s.Value
+			case "vcs.modified":
+				v.Modified = s.Value == "true"
+			}
+		}
+	}
+	return v
+}
+
+// ManageStatus is returned by /api/manage/status
+type ManageStatus struct {
+	VersionInfo
+	Started     time.Time  `json:"started"`
+	Uptime      string     `json:"uptime"`
+	Profile     string     `json:"profile"`
+	ConfigFile  string     `json:"config_file,omitempty"`
+	LastPoll    *time.Time `json:"last_poll,omitempty"`
+	PollSeq     uint64     `json:"poll_seq"`
+	Stale       bool       `json:"stale"`
+	SelfTest    string     `json:"self_test"` // passed, failed or not run
+	Maintenance bool       `json:"maintenance"`
+	LANHung     bool       `json:"lan_hung"`
+	Goroutines  int        `json:"goroutines"`
+}
+
+func manageStatus() ManageStatus {
+	st := ManageStatus{
+		VersionInfo: versionInfo(),
+		Started:     startTime,
+		Uptime:      time.Since(startTime).Round(time.Second).String(),
+		Profile:     activeProfile.Name,
+		ConfigFile:  *flagConfig,
+		PollSeq:     pollSeq.Load(),
+		SelfTest:    "not run",
+		Maintenance: maintenanceActive(),
+		Goroutines:  runtime.NumGoroutine(),
+	}
+	if in, _, ok := latestSnapshot(); ok {
+		st.LastPoll, st.Stale = &in.Time, in.Stale
+	}
+	selfTestMu.Lock()
+	if selfTestLast != nil {
+		st.SelfTest = "failed"
+		if selfTestLast.Passed {
+			st.SelfTest = "passed"
+		}
+	}
+	selfTestMu.Unlock()
+	lanMu.Lock()
+	st.LANHung = lanHung
+	lanMu.Unlock()
+	return st
+}
+
+// reloadConfig re-reads the config file and applies it. Sections that are
+// only read at startup are returned so the caller can restart for them.
+func reloadConfig(client *ModbusConn) ([]string, error) {
+	cfg, err := loadConfig(*flagConfig, *flagSecretKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	old := appConfig()
+	var restart []string
+	for name, same := range map[string]bool{
+		"mqtt":            reflect.DeepEqual(old.MQTT, cfg.MQTT),
+		"intents":         reflect.DeepEqual(old.Intents, cfg.Intents),
+		"federation":      reflect.DeepEqual(old.Federation, cfg.Federation),
+		"guest.secret":    old.Guest.Secret == cfg.Guest.Secret,
+		"management":      reflect.DeepEqual(old.Management, cfg.Management),
+		"auth":            reflect.DeepEqual(old.Auth, cfg.Auth),
+		"device":          reflect.DeepEqual(old.Device, cfg.Device),
+		"web_push":        reflect.DeepEqual(old.WebPush, cfg.WebPush),
+		"telegram":        reflect.DeepEqual(old.Telegram, cfg.Telegram),
+		"signed_requests": reflect.DeepEqual(old.SignedRequests, cfg.SignedRequests),
+		"updates":         reflect.DeepEqual(old.Updates, cfg.Updates),
+	} {
+		if !same {
+			restart = append(restart, name)
+		}
+	}
+	sort.Strings(restart)
+	currentConfig.Store(cfg)
+	ruleEngine.Store(NewRuleEngine(client, cfg.Rules))
+	return restart, nil
+}
+
+// restartProcess replaces the process with a fresh copy of the binary
+func restartProcess(client *ModbusConn) {
+	log.Printf("Restarting")
+	// the LAN module allows few connections; free ours first
+	client.Close()
+	if historyDB != nil {
+		historyDB.Close()
+	}
+	reexec(os.Args)
+}
+
+// shutdownOnSignal exits on SIGINT and SIGTERM after committing the pending
+// history points and closing the connection; deferred calls in main don't
+// run on os.Exit or log.Fatal
+func shutdownOnSignal(client *ModbusConn) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	s := <-sig
+	log.Printf("Received %v, shutting down", s)
+	if historyDB != nil {
+		historyDB.Close()
+	}
+	client.Close()
+	closeReadPool()
+	os.Exit(0)
+}
+
+// reexec replaces the process with the binary run with args, or exits for
+// the supervisor to restart it
+func reexec(args []string) {
+	exe, err := os.Executable()
+	if err == nil {
+		err = syscall.Exec(exe, args, os.Environ())
+	}
+	log.Printf("Re-executing failed (%v), exiting for the supervisor to restart", err)
+	os.Exit(exitRestart)
+}
+
+// handleManage serves /api/manage/*
+func handleManage(client *ModbusConn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if authLocked(w, r) {
+			return
+		}
+		if !checkBearer(r, appConfig().Management.Tokens) {
+			authFailed(r, "management token")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, `{"success":false,"error":"unauthorized"}`)
+			return
+		}
+		authSucceeded(r)
+
+		action := strings.TrimPrefix(r.URL.Path, "/api/manage/")
+		post := action == "reload" || action == "restart"
+		if post != (r.Method == http.MethodPost) {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			fmt.Fprintf(w, `{"success":false,"error":"wrong method for %s"}`, action)
+			return
+		}
+
+		var resp interface{}
+		switch action {
+		case "status":
+			resp = manageStatus()
+		case "version":
+			resp = versionInfo()
+		case "logs":
+			page, err := parsePage(r, 200)
+			if err != nil {
+				http.Error(w, fmt.Sprintf(`{"success":false,"error":%q}`, err.Error()), http.StatusBadRequest)
+				return
+			}
+			lines := recentLogs.list(page.After, page.Since)
+			err = writePage(w, page, len(lines),
+				func(i int) interface{} { return lines[i] },
+				func(i int) int64 { return lines[i].Seq })
+			if err != nil {
+				log.Printf("encode logs json: %v", err)
+			}
+			return
+		case "reload":
+			restart, err := reloadConfig(client)
+			if err != nil {
+				fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+				return
+			}
+			log.Printf("Config reloaded by %s", clientIP(r))
+			resp = map[string]interface{}{"success": true, "restart_required": restart}
+		case "restart":
+			log.Printf("Restart requested by %s", clientIP(r))
+			fmt.Fprintf(w, `{"success":true,"message":"restarting"}`)
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			go func() {
+				time.Sleep(500 * time.Millisecond)
+				restartProcess(client)
+			}()
+			return
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"success":false,"error":"unknown action %q"}`, action)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("encode manage json: %v", err)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/simonvetter/modbus"
+)
+
+// ModbusConn is the only way the exporter talks to a Modbus connection. The
+// poll loop, HTTP handlers, rules and the scheduler all share one, so every
+// request, reconnect and multi-register sequence holds its lock: a UI write
+// can never land between the close and reopen of a poll's recovery, and a
+// bulk save is never interleaved with poll reads.
+type ModbusConn struct {
+	mu sync.Mutex
+	mc *modbus.ModbusClient
+
+	brk breaker // reconnect backoff, see readBlock
+}
+
+func NewModbusConn(mc *modbus.ModbusClient) *ModbusConn {
+	return &ModbusConn{mc: mc}
+}
+
+func (c *ModbusConn) Open() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mc.Open()
+}
+
+func (c *ModbusConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mc.Close()
+}
+
+// Reopen closes the connection, waits pause and opens it again
+func (c *ModbusConn) Reopen(pause time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_ = c.mc.Close()
+	time.Sleep(pause)
+	return c.mc.Open()
+}
+
+func (c *ModbusConn) ReadRegisters(addr, quantity uint16, regType modbus.RegType) ([]uint16, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mc.ReadRegisters(addr, quantity, regType)
+}
+
+func (c *ModbusConn) WriteRegister(addr, value uint16) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.mc.WriteRegister(addr, value)
+}
+
+// Do runs a sequence of requests that no other request may interleave with
+func (c *ModbusConn) Do(fn func(mc *modbus.ModbusClient) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return fn(c.mc)
+}
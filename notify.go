@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// WebhookConfig is an HTTP endpoint that receives events as JSON
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Method  string            `yaml:"method"`  // defaults to POST
+	Events  []string          `yaml:"events"`  // event types to send; all when empty
+	Headers map[string]string `yaml:"headers"` // extra request headers (e.g. auth)
+
+	Template     string `yaml:"template"`      // request body template (default: the event as JSON)
+	TemplateFile string `yaml:"template_file"` // or a file with it, re-read when changed
+}
+
+// MQTTConfig is the broker events are published to
+type MQTTConfig struct {
+	Broker      string `yaml:"broker"` // e.g. tcp://192.168.1.10:1883
+	ClientID    string `yaml:"client_id"`
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	TopicPrefix string `yaml:"topic_prefix"` // defaults to gofutura
+	Changes     bool   `yaml:"changes"`      // also publish every changed value
+
+	HADiscovery     bool   `yaml:"ha_discovery"`     // publish state and Home Assistant discovery
+	DiscoveryPrefix string `yaml:"discovery_prefix"` // defaults to homeassistant
+
+	Template     string `yaml:"template"`      // payload template (default: the event as JSON)
+	TemplateFile string `yaml:"template_file"` // or a file with it, re-read when changed
+}
+
+// TelegramConfig sends events as Telegram bot messages
+type TelegramConfig struct {
+	Token  string   `yaml:"token"`   // bot token
+	ChatID string   `yaml:"chat_id"` // user, group or channel id
+	Events []string `yaml:"events"`  // event types to send; all when empty
+
+	Template     string `yaml:"template"`      // message template (default: defaultTelegramTemplate)
+	TemplateFile string `yaml:"template_file"` // or a file with it, re-read when changed
+}
+
+const defaultTelegramTemplate = `{{.Event.Type}} ({{.Event.Source}}) at {{localTime .Event.Time "15:04"}}`
+
+var (
+	webhookClient = &http.Client{Timeout: 10 * time.Second}
+	mqttClient    mqtt.Client
+)
+
+// startNotifications connects to the MQTT broker if one is configured.
+// client is used for commands from Home Assistant.
+func startNotifications(client *ModbusConn) {
+	cfg := appConfig().MQTT
+	if cfg.Broker == "" {
+		return
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true)
+	if cfg.HADiscovery {
+		haConn = client
+		opts.SetWill(mqttTopic("availability"), "offline", 0, true).
+			SetOnConnectHandler(haSubscribe)
+	}
+	mqttClient = mqtt.NewClient(opts)
+	// with ConnectRetry the token completes once the first attempt is made;
+	// the client keeps retrying in the background
+	mqttClient.Connect()
+	log.Printf("MQTT publishing to %s", cfg.Broker)
+	if cfg.Changes {
+		changes.Subscribe(publishMQTTChange)
+	}
+}
+
+// publishMQTTChange publishes a changed value to changes/<field>, with the
+// instance appended for array fields (changes/AlfaCo2/1)
+func publishMQTTChange(c futura.Change) {
+	topic := "changes/" + c.Field
+	if c.Index > 0 {
+		topic += fmt.Sprintf("/%d", c.Index)
+	}
+	body, err := json.Marshal(c)
+	if err != nil {
+		log.Printf("encode change: %v", err)
+		return
+	}
+	mqttClient.Publish(mqttTopic(topic), 0, false, body)
+}
+
+func mqttTopic(suffix string) string {
+	prefix := appConfig().MQTT.TopicPrefix
+	if prefix == "" {
+		prefix = "gofutura"
+	}
+	return prefix + "/" + suffix
+}
+
+// notifyEvent delivers an event to all configured webhooks and MQTT.
+// Delivery is asynchronous so a slow receiver never delays polling.
+func notifyEvent(ev Event) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("encode event: %v", err)
+		return
+	}
+
+	for i, hook := range appConfig().Webhooks {
+		if !eventSelected(hook.Events, ev.Type) {
+			continue
+		}
+		go func(i int, hook WebhookConfig) {
+			body, err := channelPayload(fmt.Sprintf("webhook %d", i), hook.Template, hook.TemplateFile, ev, payload)
+			if err == nil {
+				err = sendWebhook(hook, body)
+			}
+			if err != nil {
+				log.Printf("webhook %s: %v", hook.URL, err)
+			}
+		}(i, hook)
+	}
+
+	if mqttClient != nil {
+		cfg := appConfig().MQTT
+		body, err := channelPayload("mqtt", cfg.Template, cfg.TemplateFile, ev, payload)
+		if err != nil {
+			log.Printf("mqtt: %v", err)
+		} else {
+			mqttClient.Publish(mqttTopic("events/"+ev.Type), 0, false, body)
+		}
+	}
+
+	if appConfig().WebPush.Subject != "" && eventSelected(appConfig().WebPush.pushEvents(), ev.Type) {
+		go notifyPush(ev)
+	}
+
+	if tg := appConfig().Telegram; tg.Token != "" && eventSelected(tg.Events, ev.Type) {
+		go func() {
+			tmpl := tg.Template
+			if tmpl == "" && tg.TemplateFile == "" {
+				tmpl = defaultTelegramTemplate
+			}
+			text, _, err := renderTemplate("telegram", tmpl, tg.TemplateFile, ev)
+			if err == nil {
+				err = sendTelegram(tg, text)
+			}
+			if err != nil {
+				log.Printf("telegram: %v", err)
+			}
+		}()
+	}
+}
+
+// channelPayload renders the channel's template, or returns the event JSON
+// when it has none
+func channelPayload(name, inline, file string, ev Event, eventJSON []byte) ([]byte, error) {
+	text, ok, err := renderTemplate(name, inline, file, ev)
+	if err != nil {
+		return nil, fmt.Errorf("template: %w", err)
+	}
+	if !ok {
+		return eventJSON, nil
+	}
+	return []byte(text), nil
+}
+
+func sendTelegram(cfg TelegramConfig, text string) error {
+	body, err := json.Marshal(map[string]string{"chat_id": cfg.ChatID, "text": text})
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post("https://api.telegram.org/bot"+cfg.Token+"/sendMessage", "application/json", bytes.NewReader(body))
+	if err != nil {
+		// the error contains the URL and with it the bot token
+		return fmt.Errorf("send message failed")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+func eventSelected(types []string, typ string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func sendWebhook(hook WebhookConfig, payload []byte) error {
+	method := hook.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequest(method, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range hook.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
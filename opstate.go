@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Operating state event types
+const (
+	EventOperatingStateOn  = "operating_state_on"
+	EventOperatingStateOff = "operating_state_off"
+)
+
+var operatingStateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "futura_operating_state",
+	Help: "Operating states of the unit from FutMode (1=active): bypass, and bitN for bits without a documented meaning",
+}, []string{"state"})
+
+func RegisterOperatingStateMetrics() {
+	prometheus.MustRegister(operatingStateGauge)
+}
+
+// updateOperatingStates exports the FutMode states and emits events when
+// they change (prev is nil on the first poll). Bits without a name get a
+// series once they have been set.
+func updateOperatingStates(prev *futura.InputRegs, cur futura.InputRegs) {
+	var prevMode uint32
+	if prev != nil {
+		prevMode = prev.FutMode
+	}
+	named := uint32(0)
+	for _, s := range futura.FutModeStates {
+		named |= s.Bit
+	}
+	for b := 0; b < 32; b++ {
+		bit := uint32(1) << b
+		on := cur.FutMode&bit != 0
+		if !on && prevMode&bit == 0 && named&bit == 0 {
+			continue
+		}
+		name := futura.FutModeStateName(b)
+		v := 0.0
+		if on {
+			v = 1
+		}
+		operatingStateGauge.WithLabelValues(name).Set(v)
+
+		if prev == nil || (prevMode&bit != 0) == on {
+			continue
+		}
+		typ := EventOperatingStateOff
+		if on {
+			typ = EventOperatingStateOn
+		}
+		emitEvent(Event{
+			Type:   typ,
+			Source: name,
+			Data:   map[string]interface{}{"mode": cur.FutMode},
+		})
+	}
+}
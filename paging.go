@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPageLimit caps ?limit= on list endpoints
+const maxPageLimit = 5000
+
+// pageParams are the common list parameters of the log, event and history
+// endpoints:
+//
+//	since   unix seconds or RFC3339; only items at or after this time
+//	limit   maximum number of items returned
+//	cursor  opaque value from the X-Next-Cursor header of the previous page
+//	fields  comma-separated JSON keys to keep in each item
+//
+// Responses stay plain JSON arrays; when more items are available the
+// cursor for the next page is sent in the X-Next-Cursor header.
+type pageParams struct {
+	Since  time.Time
+	Limit  int
+	After  int64 // decoded cursor: only items with a key greater than this
+	Cursor bool  // whether a cursor was given
+	Fields []string
+}
+
+// parsePage reads the paging parameters; defLimit applies when ?limit= is
+// absent (0 means unlimited)
+func parsePage(r *http.Request, defLimit int) (pageParams, error) {
+	q := r.URL.Query()
+	p := pageParams{Limit: defLimit}
+	if v := q.Get("since"); v != "" {
+		t, err := parseTimeParam(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid since")
+		}
+		p.Since = t
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return p, fmt.Errorf("invalid limit")
+		}
+		p.Limit = n
+	}
+	if p.Limit > maxPageLimit {
+		p.Limit = maxPageLimit
+	}
+	if v := q.Get("cursor"); v != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid cursor")
+		}
+		p.After, err = strconv.ParseInt(string(raw), 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("invalid cursor")
+		}
+		p.Cursor = true
+	}
+	if v := q.Get("fields"); v != "" {
+		p.Fields = strings.Split(v, ",")
+	}
+	return p, nil
+}
+
+// parseTimeParam accepts unix seconds or RFC3339
+func parseTimeParam(v string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+func encodeCursor(key int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(key, 10)))
+}
+
+// writePage encodes one page of items (already filtered by since/cursor and
+// sorted by key). key returns the cursor key of item i.
+func writePage(w http.ResponseWriter, p pageParams, n int, item func(i int) interface{}, key func(i int) int64) error {
+	if p.Limit > 0 && n > p.Limit {
+		w.Header().Set("X-Next-Cursor", encodeCursor(key(p.Limit-1)))
+		n = p.Limit
+	}
+	items := make([]interface{}, n)
+	for i := range items {
+		items[i] = item(i)
+	}
+	if len(p.Fields) > 0 {
+		filtered, err := filterFields(items, p.Fields)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(filtered)
+	}
+	return json.NewEncoder(w).Encode(items)
+}
+
+// filterFields keeps only the given top-level JSON keys of each item
+func filterFields(items []interface{}, fields []string) ([]map[string]json.RawMessage, error) {
+	out := make([]map[string]json.RawMessage, len(items))
+	for i, it := range items {
+		data, err := json.Marshal(it)
+		if err != nil {
+			return nil, err
+		}
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(data, &all); err != nil {
+			return nil, err
+		}
+		out[i] = map[string]json.RawMessage{}
+		for _, f := range fields {
+			if v, ok := all[f]; ok {
+				out[i][f] = v
+			}
+		}
+	}
+	return out, nil
+}
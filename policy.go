@@ -0,0 +1,392 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WritePolicyConfig restricts writes coming through the API. The exporter's
+// own writers (rules, schedule, vacation) are configured by the admin and
+// are not restricted.
+type WritePolicyConfig struct {
+	Locked      []string      `yaml:"locked"`       // fields that need an admin token or an unlock call
+	AdminTokens []string      `yaml:"admin_tokens"` // bearer tokens of the admin role
+	UnlockFor   time.Duration `yaml:"unlock_for"`   // how long an unlock lasts (default 5m)
+
+	Limits      map[string]SoftLimit     `yaml:"limits"`       // soft limits, tighter than the device allows
+	MinInterval map[string]time.Duration `yaml:"min_interval"` // minimum time between writes of a field; "default" for the others
+	RateLimit   RateLimit                `yaml:"rate_limit"`   // write requests per client
+}
+
+// RateLimit allows each client Requests write requests per Per
+type RateLimit struct {
+	Requests int           `yaml:"requests"`
+	Per      time.Duration `yaml:"per"` // default 1m
+}
+
+// minIntervalDefault is the min_interval key for fields not listed
+const minIntervalDefault = "default"
+
+// SoftLimit bounds the values API clients may write to a field; admins can
+// bypass it with ?override=1
+type SoftLimit struct {
+	Min *float64 `yaml:"min"`
+	Max *float64 `yaml:"max"`
+}
+
+var (
+	unlockMu sync.Mutex
+	unlocked = map[string]time.Time{} // field -> unlock expiry
+
+	lastWriteMu sync.Mutex
+	lastWrite   = map[string]time.Time{} // field -> start of the last write, see reserveWrites
+
+	rateMu      sync.Mutex
+	rateWindows = map[string]*rateWindow{} // client IP -> write requests
+
+	writesThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "futura_writes_throttled_total",
+		Help: "Writes refused by write_policy.rate_limit or min_interval",
+	}, []string{"reason"})
+)
+
+type rateWindow struct {
+	requests int
+	first    time.Time // start of the current window
+}
+
+func RegisterWritePolicyMetrics() {
+	prometheus.MustRegister(writesThrottled)
+}
+
+func (c WritePolicyConfig) validate() error {
+	for _, f := range c.Locked {
+		if _, ok := futura.WriteableFields[f]; !ok {
+			return fmt.Errorf("write_policy: unknown field %q", f)
+		}
+	}
+	for f, d := range c.MinInterval {
+		if _, ok := futura.WriteableFields[f]; !ok && f != minIntervalDefault {
+			return fmt.Errorf("write_policy.min_interval: unknown field %q", f)
+		}
+		if d < 0 {
+			return fmt.Errorf("write_policy.min_interval: %s must not be negative", f)
+		}
+	}
+	if c.RateLimit.Requests < 0 || c.RateLimit.Per < 0 {
+		return fmt.Errorf("write_policy.rate_limit must not be negative")
+	}
+	for f, l := range c.Limits {
+		if _, ok := futura.WriteableFields[f]; !ok {
+			return fmt.Errorf("write_policy.limits: unknown field %q", f)
+		}
+		if l.Min != nil && l.Max != nil && *l.Min > *l.Max {
+			return fmt.Errorf("write_policy.limits: %s min is above max", f)
+		}
+	}
+	return nil
+}
+
+func fieldLocked(field string) bool {
+	for _, f := range appConfig().WritePolicy.Locked {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// isAdmin reports whether the request carries an admin token
+func isAdmin(r *http.Request) bool {
+	return r != nil && len(appConfig().WritePolicy.AdminTokens) > 0 && checkBearer(r, appConfig().WritePolicy.AdminTokens)
+}
+
+// adminOverride reports whether an admin asked to bypass soft limits
+func adminOverride(r *http.Request) bool {
+	return isAdmin(r) && (r.URL.Query().Get("override") == "1" || r.Header.Get("X-Gofutura-Override") == "1")
+}
+
+// checkWritePolicy returns an error when an API client may not write field.
+// r is the originating request (nil for callers without one).
+func checkWritePolicy(r *http.Request, field string, value float64) error {
+	if l, ok := appConfig().WritePolicy.Limits[field]; ok && !adminOverride(r) {
+		if l.Min != nil && value < *l.Min {
+			return fmt.Errorf("%s must be at least %g", field, *l.Min)
+		}
+		if l.Max != nil && value > *l.Max {
+			return fmt.Errorf("%s must be at most %g", field, *l.Max)
+		}
+	}
+	if fieldLocked(field) && !isAdmin(r) {
+		unlockMu.Lock()
+		until := unlocked[field]
+		unlockMu.Unlock()
+		if time.Now().After(until) {
+			return fmt.Errorf("field %s is locked; unlock it first", field)
+		}
+	}
+	return nil
+}
+
+// checkBulkWritePolicy checks the fields of a full form write and returns the
+// fields that change; fields sent with their current value pass
+func checkBulkWritePolicy(r *http.Request, data map[string]interface{}, hold futura.HoldingRegs) ([]string, error) {
+	var changed []string
+	for k, v := range data {
+		val, _ := v.(float64)
+		cur, ok := structField(reflect.ValueOf(hold), k, 0)
+		if spec, known := futura.WriteableFields[k]; ok && known && math.Abs(cur-val) < spec.Scale/2 {
+			continue
+		}
+		if spec, known := futura.WriteableFields[k]; known {
+			if err := spec.Validate(val); err != nil {
+				return nil, fmt.Errorf("%s %w", k, err)
+			}
+		}
+		if err := checkWritePolicy(r, k, val); err != nil {
+			return nil, err
+		}
+		if err := checkWriteRate(k, origin{Source: "api"}); err != nil {
+			return nil, err
+		}
+		if err := checkFeature(k, val); err != nil {
+			return nil, err
+		}
+		changed = append(changed, k)
+	}
+	return changed, nil
+}
+
+// writeBlocked returns why the device may not be written at all right now,
+// or nil
+func writeBlocked() error {
+	if *flagReadOnly {
+		return errReadOnly
+	}
+	if !connectionOpened() {
+		return errNotConnected
+	}
+	if maintenanceActive() {
+		return errMaintenance
+	}
+	if selfTestFailed() && !*flagForceWrites {
+		return fmt.Errorf("writes disabled: startup self-test failed (see /api/selftest)")
+	}
+	return nil
+}
+
+// internalWriters are the sources that write on their own cadence: rule
+// cooldowns, schedule hours, sensor feeds. min_interval.default doesn't
+// apply to them, only the intervals of fields listed by name.
+var internalWriters = map[string]bool{
+	"rule": true, "schedule": true, "season": true, "vacation": true,
+	"desired_state": true, "ext_sensor": true,
+}
+
+// minInterval returns the write_policy.min_interval of a field for a writer
+func minInterval(field string, o origin) time.Duration {
+	if d, ok := appConfig().WritePolicy.MinInterval[field]; ok {
+		return d
+	}
+	if internalWriters[o.Source] {
+		return 0
+	}
+	return appConfig().WritePolicy.MinInterval[minIntervalDefault]
+}
+
+// checkWriteRate enforces write_policy.min_interval. Intervals of fields
+// listed by name apply to every writer, including rules and the schedule,
+// since they protect the unit. It only checks; writes reserve their slot
+// with reserveWrites.
+func checkWriteRate(field string, o origin) error {
+	lastWriteMu.Lock()
+	defer lastWriteMu.Unlock()
+	return writeRateLocked(field, o, time.Now())
+}
+
+// writeRateLocked is checkWriteRate; lastWriteMu must be held
+func writeRateLocked(field string, o origin, now time.Time) error {
+	d := minInterval(field, o)
+	if d <= 0 {
+		return nil
+	}
+	if wait := d - now.Sub(lastWrite[field]); wait > 0 {
+		writesThrottled.WithLabelValues("min_interval").Inc()
+		return fmt.Errorf("%s was changed recently; retry in %s", field, wait.Round(time.Second))
+	}
+	return nil
+}
+
+// reserveWrites checks min_interval for fields about to be written and
+// takes their slots under the same lock, so of two concurrent writes of a
+// field only the first passes. Writes of internal writers take it too, so a
+// user write right after one waits. release gives the slots back when the
+// write failed.
+func reserveWrites(fields []string, o origin) (release func(), err error) {
+	now := time.Now()
+	lastWriteMu.Lock()
+	defer lastWriteMu.Unlock()
+	for _, f := range fields {
+		if err := writeRateLocked(f, o, now); err != nil {
+			return nil, err
+		}
+	}
+	prev := map[string]time.Time{}
+	for _, f := range fields {
+		prev[f] = lastWrite[f]
+		lastWrite[f] = now
+	}
+	return func() {
+		lastWriteMu.Lock()
+		defer lastWriteMu.Unlock()
+		for f, t := range prev {
+			// unless a later write took the slot meanwhile
+			if lastWrite[f].Equal(now) {
+				lastWrite[f] = t
+			}
+		}
+	}, nil
+}
+
+// rateLimitError refuses a write request over write_policy.rate_limit
+type rateLimitError struct {
+	requests   int
+	per, retry time.Duration
+}
+
+func (e rateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded: at most %d writes per %s", e.requests, e.per)
+}
+
+// countWriteRequest counts a write request against write_policy.rate_limit:
+// each client IP gets a number of requests per window. Signed requests are
+// limited by key name instead. what names the request in the log.
+func countWriteRequest(r *http.Request, what string) error {
+	l := appConfig().WritePolicy.RateLimit
+	if l.Requests <= 0 {
+		return nil
+	}
+	per := l.Per
+	if per <= 0 {
+		per = time.Minute
+	}
+	client := clientIP(r)
+	if name := signedBy(r); name != "" {
+		client = "key " + name
+	}
+	now := time.Now()
+
+	rateMu.Lock()
+	win := rateWindows[client]
+	if win == nil || now.Sub(win.first) >= per {
+		win = &rateWindow{first: now}
+		rateWindows[client] = win
+	}
+	win.requests++
+	over := win.requests > l.Requests
+	retry := per - now.Sub(win.first)
+	// forget idle clients
+	for k, v := range rateWindows {
+		if now.Sub(v.first) >= per {
+			delete(rateWindows, k)
+		}
+	}
+	rateMu.Unlock()
+
+	if !over {
+		return nil
+	}
+	writesThrottled.WithLabelValues("rate_limit").Inc()
+	log.Printf("Rate limit: refused %s from %s (more than %d per %s)", what, client, l.Requests, per)
+	return rateLimitError{l.Requests, per, retry}
+}
+
+// rateLimited enforces write_policy.rate_limit on a write endpoint; requests
+// over it get 429
+func rateLimited(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			next(w, r)
+			return
+		}
+		var rl rateLimitError
+		if err := countWriteRequest(r, r.Method+" "+r.URL.Path); errors.As(err, &rl) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(rl.retry.Seconds())+1))
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, rl.Error())
+			return
+		}
+		next(w, r)
+	}
+}
+
+type lockState struct {
+	Field         string     `json:"field"`
+	UnlockedUntil *time.Time `json:"unlocked_until,omitempty"`
+}
+
+// handleUnlock lists locked fields (GET), unlocks a field for a while
+// (POST {"field":"..."}) or locks it again (DELETE ?field=)
+func handleUnlock(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		unlockMu.Lock()
+		out := []lockState{}
+		for _, f := range appConfig().WritePolicy.Locked {
+			st := lockState{Field: f}
+			if until, ok := unlocked[f]; ok && time.Now().Before(until) {
+				st.UnlockedUntil = &until
+			}
+			out = append(out, st)
+		}
+		unlockMu.Unlock()
+		sort.Slice(out, func(i, j int) bool { return out[i].Field < out[j].Field })
+		if err := json.NewEncoder(w).Encode(out); err != nil {
+			log.Printf("encode locks json: %v", err)
+		}
+	case http.MethodPost:
+		var req struct {
+			Field string `json:"field"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":"Invalid JSON"}`)
+			return
+		}
+		if !fieldLocked(req.Field) {
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, "field "+req.Field+" is not locked")
+			return
+		}
+		d := appConfig().WritePolicy.UnlockFor
+		if d <= 0 {
+			d = 5 * time.Minute
+		}
+		until := time.Now().Add(d)
+		unlockMu.Lock()
+		unlocked[req.Field] = until
+		unlockMu.Unlock()
+		log.Printf("Field %s unlocked by %s until %s", req.Field, clientIP(r), until.Format(time.RFC3339))
+		fmt.Fprintf(w, `{"success":true,"message":%q,"until":%q}`, req.Field+" unlocked", until.Format(time.RFC3339))
+	case http.MethodDelete:
+		field := r.URL.Query().Get("field")
+		unlockMu.Lock()
+		delete(unlocked, field)
+		unlockMu.Unlock()
+		fmt.Fprintf(w, `{"success":true,"message":%q}`, field+" locked")
+	default:
+		fmt.Fprintf(w, `{"success":false,"error":"GET, POST or DELETE required"}`)
+	}
+}
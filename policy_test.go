@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func withWritePolicy(t *testing.T, p WritePolicyConfig) {
+	t.Helper()
+	prev := appConfig()
+	cfg := *prev
+	cfg.WritePolicy = p
+	currentConfig.Store(&cfg)
+	lastWriteMu.Lock()
+	lastWrite = map[string]time.Time{}
+	lastWriteMu.Unlock()
+	t.Cleanup(func() { currentConfig.Store(prev) })
+}
+
+func TestReserveWritesConcurrent(t *testing.T) {
+	withWritePolicy(t, WritePolicyConfig{MinInterval: map[string]time.Duration{"CfgTempSet": time.Hour}})
+	api := origin{Source: "api"}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	passed := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := reserveWrites([]string{"CfgTempSet"}, api); err == nil {
+				mu.Lock()
+				passed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if passed != 1 {
+		t.Fatalf("%d concurrent writes passed min_interval, want 1", passed)
+	}
+}
+
+func TestReserveWritesRelease(t *testing.T) {
+	withWritePolicy(t, WritePolicyConfig{MinInterval: map[string]time.Duration{"CfgTempSet": time.Hour, minIntervalDefault: time.Hour}})
+	api := origin{Source: "api"}
+
+	release, err := reserveWrites([]string{"CfgTempSet", "CfgHumiSet"}, api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reserveWrites([]string{"CfgHumiSet"}, api); err == nil {
+		t.Fatal("second write passed min_interval")
+	}
+	// the write failed
+	release()
+	if _, err := reserveWrites([]string{"CfgTempSet", "CfgHumiSet"}, api); err != nil {
+		t.Fatalf("after release: %v", err)
+	}
+
+	// a refused field reserves none of the others
+	withWritePolicy(t, WritePolicyConfig{MinInterval: map[string]time.Duration{"CfgTempSet": time.Hour, minIntervalDefault: time.Hour}})
+	if _, err := reserveWrites([]string{"CfgTempSet"}, api); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reserveWrites([]string{"CfgHumiSet", "CfgTempSet"}, api); err == nil {
+		t.Fatal("bulk write passed min_interval")
+	}
+	if err := checkWriteRate("CfgHumiSet", api); err != nil {
+		t.Fatalf("refused bulk write reserved CfgHumiSet: %v", err)
+	}
+}
+
+func TestMinIntervalDefaultInternalWriters(t *testing.T) {
+	withWritePolicy(t, WritePolicyConfig{MinInterval: map[string]time.Duration{"CfgTempSet": time.Hour, minIntervalDefault: time.Hour}})
+	tests := []struct {
+		field  string
+		source string
+		want   time.Duration
+	}{
+		{"CfgTempSet", "api", time.Hour},
+		{"CfgTempSet", "rule", time.Hour}, // listed by name: applies to everyone
+		{"FuncVentilation", "api", time.Hour},
+		{"FuncVentilation", "ws", time.Hour},
+		{"FuncVentilation", "schedule", 0},
+		{"FuncVentilation", "rule", 0},
+		{"ExtSensTemp1", "ext_sensor", 0},
+	}
+	for _, tt := range tests {
+		if got := minInterval(tt.field, origin{Source: tt.source}); got != tt.want {
+			t.Errorf("minInterval(%s, %s) = %s, want %s", tt.field, tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitSharedWindow(t *testing.T) {
+	withWritePolicy(t, WritePolicyConfig{RateLimit: RateLimit{Requests: 2, Per: time.Hour}})
+	rateMu.Lock()
+	rateWindows = map[string]*rateWindow{}
+	rateMu.Unlock()
+
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	post := func(addr string) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/write-holding", nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		rateLimited(ok)(w, r)
+		return w.Code
+	}
+	if code := post("192.0.2.1:1000"); code != http.StatusOK {
+		t.Fatalf("first write: status %d", code)
+	}
+	// a WebSocket write of the same client takes the second slot
+	ws := httptest.NewRequest(http.MethodGet, "/api/ws", nil)
+	ws.RemoteAddr = "192.0.2.1:2000"
+	if err := countWriteRequest(ws, "WebSocket write"); err != nil {
+		t.Fatalf("WebSocket write: %v", err)
+	}
+	if err := countWriteRequest(ws, "WebSocket write"); err == nil {
+		t.Error("third write in the window was allowed")
+	}
+	if code := post("192.0.2.1:1000"); code != http.StatusTooManyRequests {
+		t.Errorf("write over the limit: status %d, want 429", code)
+	}
+	if code := post("192.0.2.2:1000"); code != http.StatusOK {
+		t.Errorf("other client: status %d", code)
+	}
+}
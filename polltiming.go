@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// PollingConfig shapes when polls happen within the poll interval
+type PollingConfig struct {
+	// Align starts polls on multiples of the interval since midnight, e.g.
+	// at :00, :05, :10 with 5s, so per-minute history samples line up
+	Align bool `yaml:"align"`
+	// Jitter delays each poll by a random amount up to this, so exporters
+	// sharing one RS485 gateway don't all ask at the same moment
+	Jitter time.Duration `yaml:"jitter"`
+}
+
+func (c PollingConfig) validate() error {
+	if c.Jitter < 0 {
+		return fmt.Errorf("polling.jitter must not be negative")
+	}
+	return nil
+}
+
+// nextPollDelay returns how long to wait for the next poll after now
+func nextPollDelay(interval time.Duration, now time.Time) time.Duration {
+	cfg := appConfig().Polling
+	delay := interval
+	if cfg.Align {
+		// measured from midnight in -timezone, so hourly polls follow the
+		// local clock even in zones with a half-hour offset
+		midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		delay = interval - now.Sub(midnight)%interval
+	}
+	if cfg.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(cfg.Jitter) + 1))
+	}
+	return delay
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Power consumption sanity check: at a low ventilation level the unit draws
+// little power, so a high PowerConsumption sustained there usually means a
+// (pre)heater that is stuck on. The alarm is raised as an event, which the
+// notification channels and rules can react to.
+
+// Event types
+const (
+	EventPowerAlarm        = "power_alarm"
+	EventPowerAlarmCleared = "power_alarm_cleared"
+)
+
+// PowerAlarmConfig configures the power consumption ceiling
+type PowerAlarmConfig struct {
+	MaxWatts   uint16        `yaml:"max_watts"`    // ceiling of PowerConsumption; disabled when 0
+	For        time.Duration `yaml:"for"`          // how long it must be exceeded (default 15m)
+	MaxLevel   uint16        `yaml:"max_level"`    // ventilation levels 1 to this count as low (default 2)
+	MaxAirFlow uint16        `yaml:"max_air_flow"` // or an AirFlow up to this (m³/h), e.g. in auto mode
+}
+
+func (c PowerAlarmConfig) validate() error {
+	if c.For < 0 {
+		return fmt.Errorf("power_alarm.for must not be negative")
+	}
+	if c.MaxLevel > 5 {
+		return fmt.Errorf("power_alarm.max_level must be 0-5")
+	}
+	return nil
+}
+
+func (c PowerAlarmConfig) duration() time.Duration {
+	if c.For > 0 {
+		return c.For
+	}
+	return 15 * time.Minute
+}
+
+// lowVentilation reports whether the unit ventilates at a low level
+func (c PowerAlarmConfig) lowVentilation(in futura.InputRegs, hold futura.HoldingRegs) bool {
+	maxLevel := c.MaxLevel
+	if maxLevel == 0 {
+		maxLevel = 2
+	}
+	if hold.FuncVentilation >= 1 && hold.FuncVentilation <= maxLevel {
+		return true
+	}
+	return c.MaxAirFlow > 0 && in.AirFlow <= c.MaxAirFlow
+}
+
+var (
+	powerMu    sync.Mutex
+	powerSince *time.Time // when the ceiling started to be exceeded
+	powerAlarm bool
+	powerGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "futura_power_alarm",
+		Help: "1 while the power consumption exceeds power_alarm.max_watts at a low ventilation level",
+	})
+	powerAlarmsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "futura_power_alarms_total",
+		Help: "Times the power alarm was raised",
+	})
+)
+
+func RegisterPowerAlarmMetrics() {
+	prometheus.MustRegister(powerGauge, powerAlarmsTotal)
+}
+
+// checkPowerAlarm runs after every poll and raises the alarm once the
+// ceiling has been exceeded at a low ventilation level for the configured
+// time
+func checkPowerAlarm(in futura.InputRegs, hold futura.HoldingRegs, now time.Time) {
+	cfg := appConfig().PowerAlarm
+	if cfg.MaxWatts == 0 {
+		return
+	}
+	exceeded := in.PowerConsumption > cfg.MaxWatts && cfg.lowVentilation(in, hold)
+	data := map[string]interface{}{
+		"watts": in.PowerConsumption, "max_watts": cfg.MaxWatts,
+		"ventilation": hold.FuncVentilationName, "air_flow": in.AirFlow,
+	}
+
+	var ev *Event
+	powerMu.Lock()
+	switch {
+	case exceeded && powerSince == nil:
+		powerSince = &now
+	case exceeded && !powerAlarm && now.Sub(*powerSince) >= cfg.duration():
+		powerAlarm = true
+		powerGauge.Set(1)
+		powerAlarmsTotal.Inc()
+		log.Printf("Power alarm: %d W at ventilation %s since %s", in.PowerConsumption, hold.FuncVentilationName, powerSince.In(appLocation).Format("15:04"))
+		data["since"] = *powerSince
+		ev = &Event{Type: EventPowerAlarm, Source: "unit", Data: data}
+	case !exceeded:
+		powerSince = nil
+		if powerAlarm {
+			powerAlarm = false
+			powerGauge.Set(0)
+			log.Printf("Power alarm cleared: %d W at ventilation %s", in.PowerConsumption, hold.FuncVentilationName)
+			ev = &Event{Type: EventPowerAlarmCleared, Source: "unit", Data: data}
+		}
+	}
+	powerMu.Unlock()
+
+	if ev != nil {
+		emitEvent(*ev)
+	}
+}
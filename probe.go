@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/simonvetter/modbus"
+)
+
+// Multi-target probing like the blackbox and SNMP exporters: with -probe,
+// /probe?target=192.168.1.50 connects to that unit for the duration of the
+// scrape, reads the ranges of the futura profile and returns its register
+// metrics, so one instance can monitor several units from the Prometheus
+// config alone. Probes don't poll the unit of -host, write, record history
+// or run rules.
+
+// probeDefaultPort is the Modbus TCP port used when the target has none
+const probeDefaultPort = "502"
+
+// handleProbe reads one target and serves its metrics
+func handleProbe(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, probeDefaultPort)
+	}
+	unit := uint64(*flagSlaveID)
+	if s := r.URL.Query().Get("unit"); s != "" {
+		var err error
+		if unit, err = strconv.ParseUint(s, 10, 8); err != nil {
+			http.Error(w, "unit must be 0-255", http.StatusBadRequest)
+			return
+		}
+	}
+
+	reg := prometheus.NewRegistry()
+	success := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "futura_probe_success",
+		Help: "1 if the target was read",
+	})
+	duration := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "futura_probe_duration_seconds",
+		Help: "Time the probe took (s)",
+	})
+	reg.MustRegister(success, duration)
+
+	start := time.Now()
+	in, err := probeTarget(target, uint8(unit), scrapeTimeout(r))
+	duration.Set(time.Since(start).Seconds())
+	if err != nil {
+		log.Printf("Probe of %s failed: %v", target, err)
+	} else {
+		success.Set(1)
+		m := newRegMetrics()
+		m.register(reg)
+		m.update(in)
+	}
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// probeTarget opens a connection to target, reads the input and holding
+// ranges and decodes them. timeout bounds each request.
+func probeTarget(target string, unit uint8, timeout time.Duration) (futura.InputRegs, error) {
+	mc, err := modbus.NewClient(&modbus.ClientConfiguration{
+		URL:     "tcp://" + target,
+		Timeout: min(timeout, 5*time.Second),
+	})
+	if err != nil {
+		return futura.InputRegs{}, err
+	}
+	if err := mc.SetUnitId(unit); err != nil {
+		return futura.InputRegs{}, err
+	}
+	if err := mc.Open(); err != nil {
+		return futura.InputRegs{}, err
+	}
+	defer mc.Close()
+
+	read := func(regType modbus.RegType, ranges [][]uint16) (map[uint16]uint16, error) {
+		out := map[uint16]uint16{}
+		for _, s := range splitRanges(ranges, runtimeMaxBlockSize) {
+			regs, err := mc.ReadRegisters(s.start, s.qty, regType)
+			if err != nil {
+				if modbusException(err) {
+					// a range this unit doesn't have
+					continue
+				}
+				return nil, fmt.Errorf("read %d-%d: %w", s.start, s.start+s.qty-1, err)
+			}
+			for i, v := range regs {
+				out[s.start+uint16(i)] = v
+			}
+		}
+		if len(out) == 0 && len(ranges) > 0 {
+			return nil, fmt.Errorf("no registers could be read")
+		}
+		return out, nil
+	}
+	inputMap, err := read(modbus.INPUT_REGISTER, inputRanges)
+	if err != nil {
+		return futura.InputRegs{}, err
+	}
+	holdingMap, err := read(modbus.HOLDING_REGISTER, holdingRanges)
+	if err != nil {
+		return futura.InputRegs{}, err
+	}
+	in := futura.DecodeInputMap(inputMap)
+	futura.MergeHoldingExt(&in, holdingMap)
+	return in, nil
+}
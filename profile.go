@@ -0,0 +1,282 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+// Device profiles describe which registers a unit exposes and how to decode
+// them. The Futura profile uses the typed decoders in regs.go; other profiles
+// list their registers explicitly and are decoded generically.
+
+//go:embed profiles/*.yaml
+var profileFiles embed.FS
+
+// DecoderFutura selects the built-in typed Futura decoder
+const DecoderFutura = "futura"
+
+// Profile is a device register map loaded from YAML
+type Profile struct {
+	Name           string            `yaml:"name"`
+	Description    string            `yaml:"description"`
+	Decoder        string            `yaml:"decoder"` // "futura" or empty for generic decoding
+	InputMaxAddr   uint16            `yaml:"input_max_addr"`
+	HoldingMaxAddr uint16            `yaml:"holding_max_addr"`
+	InputRanges    [][]uint16        `yaml:"input_ranges"`
+	HoldingRanges  [][]uint16        `yaml:"holding_ranges"`
+	Registers      []ProfileRegister `yaml:"registers"`
+	Identity       ProfileIdentity   `yaml:"identity"` // checked by the startup self-test
+	WriteLimits    []WriteLimit      `yaml:"write_limits"`
+}
+
+// WriteLimit describes how the device treats writes of a holding register
+// outside its range. The -replay server applies them, so tests against a
+// recording see the writes the unit would refuse or change.
+type WriteLimit struct {
+	Field  string   `yaml:"field"` // writable field (futura decoder) or holding register of the profile
+	Min    *float64 `yaml:"min"`   // in field units
+	Max    *float64 `yaml:"max"`
+	Action string   `yaml:"action"` // reject (Modbus exception 3) or clamp
+
+	addr   uint16
+	scale  float64
+	signed bool
+}
+
+// ProfileRegister describes a single value of a generic profile
+type ProfileRegister struct {
+	Name   string  `yaml:"name"`
+	Type   string  `yaml:"type"` // input or holding
+	Addr   uint16  `yaml:"addr"`
+	Format string  `yaml:"format"` // u16, i16 or u32 (two registers, high word first)
+	Scale  float64 `yaml:"scale"`  // defaults to 1
+	Unit   string  `yaml:"unit"`
+	Metric string  `yaml:"metric"` // optional Prometheus gauge name
+	Help   string  `yaml:"help"`
+}
+
+// loadProfile loads an embedded profile by name or a user-provided YAML file by path
+func loadProfile(name string) (*Profile, error) {
+	var data []byte
+	var err error
+	if strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml") || strings.ContainsRune(name, filepath.Separator) {
+		data, err = os.ReadFile(name)
+	} else {
+		data, err = profileFiles.ReadFile("profiles/" + name + ".yaml")
+		if err != nil {
+			return nil, fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(embeddedProfileNames(), ", "))
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read profile: %w", err)
+	}
+
+	p := &Profile{}
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("parse profile %s: %w", name, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("profile %s: %w", name, err)
+	}
+	return p, nil
+}
+
+// embeddedProfileNames lists the profiles shipped inside the binary
+func embeddedProfileNames() []string {
+	entries, err := profileFiles.ReadDir("profiles")
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), ".yaml"))
+	}
+	return names
+}
+
+// metricName is the syntax of Prometheus metric names
+var metricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+func (p *Profile) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if p.Decoder != "" && p.Decoder != DecoderFutura {
+		return fmt.Errorf("unknown decoder %q", p.Decoder)
+	}
+	if len(p.InputRanges) == 0 && len(p.HoldingRanges) == 0 {
+		return fmt.Errorf("no register ranges defined")
+	}
+	if p.Decoder == "" && len(p.Registers) == 0 {
+		return fmt.Errorf("generic profile must list registers")
+	}
+	names := map[string]bool{}
+	metrics := map[string]string{}
+	for i := range p.Registers {
+		reg := &p.Registers[i]
+		if reg.Name == "" {
+			return fmt.Errorf("register %d has no name", i)
+		}
+		if names[reg.Name] {
+			return fmt.Errorf("register %s is listed twice", reg.Name)
+		}
+		names[reg.Name] = true
+		if reg.Metric != "" {
+			if !metricName.MatchString(reg.Metric) {
+				return fmt.Errorf("register %s has invalid metric name %q", reg.Name, reg.Metric)
+			}
+			if other, ok := metrics[reg.Metric]; ok {
+				return fmt.Errorf("registers %s and %s have the same metric %s", other, reg.Name, reg.Metric)
+			}
+			metrics[reg.Metric] = reg.Name
+		}
+		if reg.Type != "input" && reg.Type != "holding" {
+			return fmt.Errorf("register %s has invalid type %q", reg.Name, reg.Type)
+		}
+		switch reg.Format {
+		case "":
+			reg.Format = "u16"
+		case "u16", "i16", "u32":
+		default:
+			return fmt.Errorf("register %s has invalid format %q", reg.Name, reg.Format)
+		}
+		if reg.Scale == 0 {
+			reg.Scale = 1.0
+		}
+	}
+	for i := range p.WriteLimits {
+		if err := p.resolveWriteLimit(&p.WriteLimits[i]); err != nil {
+			return fmt.Errorf("write limit %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// resolveWriteLimit looks up the register of a write limit
+func (p *Profile) resolveWriteLimit(l *WriteLimit) error {
+	if l.Action != "reject" && l.Action != "clamp" {
+		return fmt.Errorf("%s: action must be reject or clamp", l.Field)
+	}
+	if l.Min == nil && l.Max == nil {
+		return fmt.Errorf("%s: min or max is required", l.Field)
+	}
+	if p.Decoder == DecoderFutura {
+		spec, ok := futura.WriteableFields[l.Field]
+		if !ok || spec.RegCount != 1 {
+			return fmt.Errorf("unknown single-register field %q", l.Field)
+		}
+		l.addr, l.scale, l.signed = spec.Addr, spec.Scale, spec.Signed
+		return nil
+	}
+	for _, reg := range p.Registers {
+		if reg.Name == l.Field && reg.Type == "holding" && reg.Format != "u32" {
+			l.addr, l.scale, l.signed = reg.Addr, reg.Scale, reg.Format == "i16"
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown 16-bit holding register %q", l.Field)
+}
+
+// check applies the limit to a written register word; ok is false when the
+// write is rejected
+func (l WriteLimit) check(word uint16) (out uint16, ok bool) {
+	v := float64(word) * l.scale
+	if l.signed {
+		v = float64(int16(word)) * l.scale
+	}
+	lim := v
+	if l.Min != nil && v < *l.Min {
+		lim = *l.Min
+	}
+	if l.Max != nil && v > *l.Max {
+		lim = *l.Max
+	}
+	switch {
+	case lim == v:
+		return word, true
+	case l.Action == "reject":
+		return word, false
+	}
+	out, _ = writeRounding.Encode(lim, l.scale, l.signed)
+	return out, true
+}
+
+// Decode converts raw register maps into named values for generic profiles
+func (p *Profile) Decode(inputMap, holdingMap map[uint16]uint16) map[string]float64 {
+	out := make(map[string]float64, len(p.Registers))
+	for _, reg := range p.Registers {
+		m := inputMap
+		if reg.Type == "holding" {
+			m = holdingMap
+		}
+		switch reg.Format {
+		case "i16":
+			out[reg.Name] = i16f(m, reg.Addr, reg.Scale)
+		case "u32":
+			out[reg.Name] = float64(u32(m, reg.Addr)) * reg.Scale
+		default:
+			out[reg.Name] = u16f(m, reg.Addr, reg.Scale)
+		}
+	}
+	return out
+}
+
+// RegisterProfileMetrics registers a gauge for every profile register with a
+// metric name. It fails when a name is taken by one of the exporter's own
+// metrics.
+func RegisterProfileMetrics(p *Profile) error {
+	for _, reg := range p.Registers {
+		if reg.Metric == "" {
+			continue
+		}
+		help := reg.Help
+		if help == "" {
+			help = reg.Name
+		}
+		if reg.Unit != "" {
+			help += " (" + reg.Unit + ")"
+		}
+		addGauge(reg.Metric, help)
+		if err := prometheus.Register(regGauges[reg.Metric]); err != nil {
+			// the names are valid, so it's taken
+			return fmt.Errorf("register %s: metric %s is already used by the exporter", reg.Name, reg.Metric)
+		}
+	}
+	return nil
+}
+
+// UpdateProfileMetrics sets gauges from generically decoded values
+func UpdateProfileMetrics(p *Profile, values map[string]float64) {
+	for _, reg := range p.Registers {
+		if reg.Metric == "" {
+			continue
+		}
+		setGauge(reg.Metric, values[reg.Name])
+	}
+}
+
+// simple helper to safely read address from map
+func u16(m map[uint16]uint16, addr uint16) uint16 { return m[addr] }
+
+func u32(m map[uint16]uint16, addr uint16) uint32 {
+	hi := uint32(m[addr])
+	lo := uint32(m[addr+1])
+	return (hi << 16) | lo
+}
+
+func i16f(m map[uint16]uint16, addr uint16, scale float64) float64 {
+	v := int16(m[addr])
+	return float64(v) * scale
+}
+
+func u16f(m map[uint16]uint16, addr uint16, scale float64) float64 {
+	return float64(m[addr]) * scale
+}
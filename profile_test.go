@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProfileValidateRegisters(t *testing.T) {
+	tests := []struct {
+		name  string
+		regs  []ProfileRegister
+		error string // substring; empty = valid
+	}{
+		{"valid", []ProfileRegister{
+			{Name: "temp", Type: "input", Metric: "unit_temp_celsius"},
+			{Name: "fan", Type: "input", Metric: "unit:fan_speed"},
+			{Name: "raw", Type: "holding"},
+		}, ""},
+		{"duplicate register", []ProfileRegister{
+			{Name: "temp", Type: "input"},
+			{Name: "temp", Type: "holding"},
+		}, "listed twice"},
+		{"duplicate metric", []ProfileRegister{
+			{Name: "a", Type: "input", Metric: "unit_temp"},
+			{Name: "b", Type: "input", Metric: "unit_temp"},
+		}, "same metric"},
+		{"metric with a dash", []ProfileRegister{{Name: "a", Type: "input", Metric: "unit-temp"}}, "invalid metric name"},
+		{"metric starting with a digit", []ProfileRegister{{Name: "a", Type: "input", Metric: "1temp"}}, "invalid metric name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Profile{Name: "test", InputRanges: [][]uint16{{0, 10}}, Registers: tt.regs}
+			err := p.validate()
+			if tt.error == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.error)
+			}
+		})
+	}
+}
+
+func TestRegisterProfileMetricsCollision(t *testing.T) {
+	p := &Profile{Name: "test", InputRanges: [][]uint16{{0, 10}}, Registers: []ProfileRegister{
+		{Name: "goroutines", Type: "input", Metric: "go_goroutines"},
+	}}
+	if err := p.validate(); err != nil {
+		t.Fatal(err)
+	}
+	err := RegisterProfileMetrics(p)
+	if err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Fatalf("error = %v, want a collision with the Go collector", err)
+	}
+}
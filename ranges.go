@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The register ranges polled come from the profile, unless the config file
+// (device.input_ranges, device.holding_ranges) or the -input-ranges and
+// -holding-ranges flags trim or extend them, e.g. for units with other
+// firmware or fewer connected peripherals.
+
+// parseRanges parses a comma-separated list of addresses and start-end
+// ranges, e.g. "0-40,60-90,120"
+func parseRanges(s string) ([][]uint16, error) {
+	var out [][]uint16
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.ParseUint(strings.TrimSpace(first), 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q", part)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.ParseUint(strings.TrimSpace(last), 10, 16); err != nil {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+		}
+		out = append(out, []uint16{uint16(start), uint16(end)})
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no ranges in %q", s)
+	}
+	return out, nil
+}
+
+// pollRanges picks the ranges to poll: the flag when given, else the config
+// file, else the profile
+func pollRanges(flagValue string, configured, profile [][]uint16) ([][]uint16, error) {
+	switch {
+	case flagValue != "":
+		return parseRanges(flagValue)
+	case len(configured) > 0:
+		return configured, nil
+	}
+	return profile, nil
+}
+
+// applyRanges sets inputRanges and holdingRanges for p and validates them
+// against the register map
+func applyRanges(p *Profile, dev DeviceConfig) error {
+	var err error
+	if inputRanges, err = pollRanges(*flagInputRanges, dev.InputRanges, p.InputRanges); err != nil {
+		return fmt.Errorf("input-ranges: %w", err)
+	}
+	if holdingRanges, err = pollRanges(*flagHoldingRanges, dev.HoldingRanges, p.HoldingRanges); err != nil {
+		return fmt.Errorf("holding-ranges: %w", err)
+	}
+	if len(inputRanges) == 0 && len(holdingRanges) == 0 {
+		return fmt.Errorf("no register ranges to poll")
+	}
+
+	inputMaxAddr := p.InputMaxAddr
+	if *flagInputMaxAddr != 0 {
+		inputMaxAddr = uint16(*flagInputMaxAddr)
+	}
+	holdingMaxAddr := p.HoldingMaxAddr
+	if *flagHoldingMaxAddr != 0 {
+		holdingMaxAddr = uint16(*flagHoldingMaxAddr)
+	}
+	if err := validateRanges("input", inputRanges, inputMaxAddr); err != nil {
+		return err
+	}
+	return validateRanges("holding", holdingRanges, holdingMaxAddr)
+}
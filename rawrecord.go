@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/simonvetter/modbus"
+)
+
+// Raw register recording for reverse engineering: with -record-raw every
+// block read from the unit is appended to a file as a JSON line, and
+// `gofutura analyze` summarizes a recording per address: how often it
+// changed, its values and what they might mean.
+
+// rawBlock is one recorded read
+type rawBlock struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"` // input or holding
+	Start  uint16    `json:"start"`
+	Values []uint16  `json:"values"`
+}
+
+var (
+	rawMu   sync.Mutex
+	rawFile *os.File
+)
+
+func regTypeName(t modbus.RegType) string {
+	if t == modbus.HOLDING_REGISTER {
+		return "holding"
+	}
+	return "input"
+}
+
+// openRawRecording appends the reads to path from now on
+func openRawRecording(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	rawMu.Lock()
+	rawFile = f
+	rawMu.Unlock()
+	log.Printf("Recording raw reads to %s", path)
+	return nil
+}
+
+// recordRaw appends a read block to the recording, if one is open
+func recordRaw(t modbus.RegType, start uint16, values []uint16) {
+	rawMu.Lock()
+	defer rawMu.Unlock()
+	if rawFile == nil {
+		return
+	}
+	line, err := json.Marshal(rawBlock{Time: time.Now(), Type: regTypeName(t), Start: start, Values: values})
+	if err != nil {
+		return
+	}
+	if _, err := rawFile.Write(append(line, '\n')); err != nil {
+		log.Printf("record raw: %v, recording stopped", err)
+		rawFile.Close()
+		rawFile = nil
+	}
+}
+
+// readRawFile calls fn for every block of a recording
+func readRawFile(path string, fn func(rawBlock) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var b rawBlock
+		if err := json.Unmarshal(sc.Bytes(), &b); err != nil {
+			return fmt.Errorf("%s line %d: %w", path, n, err)
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// addrStats summarizes the values of one register in a recording
+type addrStats struct {
+	typ       string
+	addr      uint16
+	samples   int
+	changes   int
+	last      uint16
+	min, max  uint16
+	counts    map[uint16]int
+	monotonic bool // never decreased
+}
+
+// candidates guesses what the values of a register could be
+func (s *addrStats) candidates() []string {
+	var out []string
+	if len(s.counts) <= 2 && s.max <= 1 {
+		return []string{"flag"}
+	}
+	signed := s.max >= 0x8000
+	lo, hi := float64(s.min), float64(s.max)
+	if signed {
+		lo, hi = 0, 0
+		for v := range s.counts {
+			f := float64(int16(v))
+			lo, hi = min(lo, f), max(hi, f)
+		}
+		if lo > -2000 {
+			out = append(out, "i16")
+		}
+	}
+	switch {
+	case lo*0.1 >= -40 && hi*0.1 <= 90 && hi-lo >= 5:
+		out = append(out, "x0.1 °C?")
+	case !signed && hi*0.1 <= 100 && hi-lo >= 5:
+		out = append(out, "x0.1 %?")
+	}
+	if !signed && lo >= 300 && hi <= 5000 {
+		out = append(out, "CO2 ppm?")
+	}
+	if s.monotonic && s.changes >= 3 {
+		out = append(out, "counter")
+	}
+	if !signed && len(s.counts) <= 8 && s.max < 16 {
+		out = append(out, "enum")
+	}
+	return out
+}
+
+// topValues returns the most frequent values with their share
+func (s *addrStats) topValues(n int) string {
+	vals := make([]uint16, 0, len(s.counts))
+	for v := range s.counts {
+		vals = append(vals, v)
+	}
+	sort.Slice(vals, func(i, j int) bool {
+		if s.counts[vals[i]] != s.counts[vals[j]] {
+			return s.counts[vals[i]] > s.counts[vals[j]]
+		}
+		return vals[i] < vals[j]
+	})
+	var parts []string
+	for i, v := range vals {
+		if i == n {
+			parts = append(parts, fmt.Sprintf("+%d more", len(vals)-n))
+			break
+		}
+		parts = append(parts, fmt.Sprintf("%d:%d%%", v, s.counts[v]*100/s.samples))
+	}
+	return strings.Join(parts, " ")
+}
+
+// heatBar shows the share of samples in which a register changed
+func heatBar(changes, samples int) string {
+	const width = 10
+	if samples < 2 {
+		return strings.Repeat(".", width)
+	}
+	n := (changes*width + samples - 2) / (samples - 1)
+	return strings.Repeat("#", n) + strings.Repeat(".", width-n)
+}
+
+func runAnalyze(args []string) int {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	typ := fs.String("type", "", "Only this register type: input or holding (empty = both)")
+	all := fs.Bool("all", false, "Also list registers that never changed")
+	top := fs.Int("top", 4, "Most frequent values shown per register")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gofutura analyze [-type input|holding] [-all] FILE\n\nFILE is a recording made with -record-raw.\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	stats := map[string]*addrStats{}
+	var first, last time.Time
+	blocks := 0
+	err := readRawFile(fs.Arg(0), func(b rawBlock) error {
+		if *typ != "" && b.Type != *typ {
+			return nil
+		}
+		if first.IsZero() {
+			first = b.Time
+		}
+		last = b.Time
+		blocks++
+		for i, v := range b.Values {
+			addr := b.Start + uint16(i)
+			key := fmt.Sprintf("%s/%05d", b.Type, addr)
+			s := stats[key]
+			if s == nil {
+				s = &addrStats{typ: b.Type, addr: addr, min: v, max: v, counts: map[uint16]int{}, monotonic: true}
+				stats[key] = s
+			} else if v != s.last {
+				s.changes++
+				if v < s.last {
+					s.monotonic = false
+				}
+			}
+			s.samples++
+			s.last = v
+			s.min, s.max = min(s.min, v), max(s.max, v)
+			s.counts[v]++
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	keys := make([]string, 0, len(stats))
+	for k := range stats {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Printf("%d blocks, %d registers, %s to %s\n\n", blocks, len(stats), first.Format(time.RFC3339), last.Format(time.RFC3339))
+	fmt.Printf("%-8s %5s %7s %-10s %6s %6s  %-30s %s\n", "type", "addr", "changes", "heat", "min", "max", "values", "candidates")
+	constant := 0
+	for _, k := range keys {
+		s := stats[k]
+		if s.changes == 0 && !*all {
+			constant++
+			continue
+		}
+		fmt.Printf("%-8s %5d %7d %-10s %6d %6d  %-30s %s\n", s.typ, s.addr, s.changes, heatBar(s.changes, s.samples),
+			s.min, s.max, s.topValues(*top), strings.Join(s.candidates(), ", "))
+	}
+	if constant > 0 {
+		fmt.Printf("\n%d registers never changed (-all lists them)\n", constant)
+	}
+	return 0
+}
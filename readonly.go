@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// Read-only mode: with -read-only no register is ever written. Rules, the
+// schedule and the other writers fail as in maintenance mode, and
+// /api/write-holding answers with the writes it would have made instead.
+// A single request can ask for the same with ?dry_run=true.
+
+var errReadOnly = errors.New("writes disabled: read-only mode (-read-only)")
+
+// plannedWrite is a write a dry run would have made
+type plannedWrite struct {
+	Field    string  `json:"field"`
+	Value    float64 `json:"value"`
+	Addr     uint16  `json:"addr"`
+	Register uint16  `json:"register"` // encoded value
+}
+
+// dryRun reports whether a write request only reports what it would write
+func dryRun(r *http.Request) bool {
+	v := r.URL.Query().Get("dry_run")
+	return *flagReadOnly || v == "true" || v == "1"
+}
+
+// planWrite runs the checks of a single-field write without writing
+func planWrite(client *ModbusConn, name string, value float64) (plannedWrite, error) {
+	spec, encoded, err := encodeField(name, value)
+	if err != nil {
+		return plannedWrite{}, err
+	}
+	if err := checkExtSensPresent(client, name); err != nil {
+		return plannedWrite{}, err
+	}
+	if err := checkFeature(name, value); err != nil {
+		return plannedWrite{}, err
+	}
+	if err := checkWriteRate(name, origin{Source: "api"}); err != nil {
+		return plannedWrite{}, err
+	}
+	return plannedWrite{name, value, spec.Addr, encoded}, nil
+}
+
+// planBulkWrite lists the registers of the changed fields of a full form
+// write, which checkBulkWritePolicy has already checked. Only these are
+// written; the others include registers that aren't polled and decode as 0.
+func planBulkWrite(data map[string]interface{}, changed []string) ([]plannedWrite, error) {
+	out := []plannedWrite{}
+	for _, f := range changed {
+		val, _ := data[f].(float64)
+		spec, encoded, err := encodeField(f, val)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, plannedWrite{f, val, spec.Addr, encoded})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Addr < out[j].Addr })
+	return out, nil
+}
+
+// writeDryRunResponse answers a dry run with the writes it would have made
+func writeDryRunResponse(w http.ResponseWriter, writes []plannedWrite) {
+	resp := struct {
+		Success bool           `json:"success"`
+		DryRun  bool           `json:"dry_run"`
+		Writes  []plannedWrite `json:"writes"`
+	}{true, true, writes}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("encode dry run json: %v", err)
+	}
+}
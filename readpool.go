@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/simonvetter/modbus"
+)
+
+// A Modbus TCP connection carries one request at a time, so reading ranges
+// concurrently needs more connections. With -max-inflight above 1 extra
+// connections to the same unit are opened and the blocks of a poll are
+// spread over them. Not every gateway accepts several connections; the
+// Futura LAN module is best left at the default of 1.
+
+// readPool holds the extra connections, empty when reads are sequential
+var readPool []*ModbusConn
+
+// openReadPool opens up to n-1 connections besides the main one. Connections
+// that can't be opened are skipped, so a gateway with a connection limit
+// degrades to fewer parallel reads instead of failing.
+func openReadPool(cfg *modbus.ClientConfiguration, unitID uint8, n int) {
+	for i := 1; i < n; i++ {
+		mc, err := modbus.NewClient(cfg)
+		if err == nil {
+			err = mc.SetUnitId(unitID)
+		}
+		if err == nil {
+			err = mc.Open()
+		}
+		if err != nil {
+			log.Printf("Extra Modbus connection %d: %v; reading with %d connection(s)", i, err, len(readPool)+1)
+			return
+		}
+		readPool = append(readPool, NewModbusConn(mc))
+	}
+	if len(readPool) > 0 {
+		log.Printf("Reading with up to %d connections", len(readPool)+1)
+	}
+}
+
+func closeReadPool() {
+	for _, c := range readPool {
+		c.Close()
+	}
+}
+
+// readSpan is one block of a range read with a single request
+type readSpan struct {
+	start, qty uint16
+}
+
+// splitRanges cuts ranges into blocks of at most maxBlockSize registers
+func splitRanges(ranges [][]uint16, maxBlockSize uint16) []readSpan {
+	var spans []readSpan
+	for _, r := range ranges {
+		start, end := r[0], r[1]
+		total := (end - start) + 1
+		for i := uint16(0); i < total; i += maxBlockSize {
+			qty := maxBlockSize
+			if i+qty > total {
+				qty = total - i
+			}
+			spans = append(spans, readSpan{start + i, qty})
+		}
+	}
+	return spans
+}
+
+// readSpansParallel reads the spans over client and the pool connections
+func readSpansParallel(client *ModbusConn, regType modbus.RegType, spans []readSpan, out map[uint16]uint16) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	work := make(chan readSpan)
+	for _, c := range append([]*ModbusConn{client}, readPool...) {
+		wg.Add(1)
+		go func(c *ModbusConn) {
+			defer wg.Done()
+			for s := range work {
+				regs, ok := readBlock(c, regType, s.start, s.qty)
+				if !ok {
+					continue
+				}
+				mu.Lock()
+				for idx, val := range regs {
+					out[s.start+uint16(idx)] = val
+				}
+				mu.Unlock()
+			}
+		}(c)
+	}
+	for _, s := range spans {
+		work <- s
+	}
+	close(work)
+	wg.Wait()
+}
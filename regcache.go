@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/simonvetter/modbus"
+)
+
+// Register cache: the poll loop keeps the raw registers it read, so
+// /api/read-input and /api/read-holding answer without reading every range
+// from the unit again. ?max_age=30s (or seconds) sets how old the cached
+// registers may be, ?refresh=1 forces a live read.
+
+type cachedRegs struct {
+	values map[uint16]uint16 // shared, never modified after caching
+	meta   futura.SnapshotMeta
+}
+
+var (
+	regCacheMu sync.Mutex
+	regCache   = map[modbus.RegType]cachedRegs{}
+)
+
+// cacheRegisters stores registers read by the poll loop or a live read
+func cacheRegisters(regType modbus.RegType, values map[uint16]uint16, meta futura.SnapshotMeta) {
+	if len(values) == 0 {
+		return
+	}
+	regCacheMu.Lock()
+	regCache[regType] = cachedRegs{values, meta}
+	regCacheMu.Unlock()
+}
+
+// invalidateRegisters drops cached registers after a write, so the next
+// read shows what the unit accepted
+func invalidateRegisters(regType modbus.RegType) {
+	regCacheMu.Lock()
+	delete(regCache, regType)
+	regCacheMu.Unlock()
+}
+
+// readMaxAge returns how old cached registers the request accepts; zero
+// forces a live read. By default one missed poll is tolerated.
+func readMaxAge(r *http.Request) (time.Duration, error) {
+	q := r.URL.Query()
+	if q.Get("refresh") == "1" {
+		return 0, nil
+	}
+	s := q.Get("max_age")
+	if s == "" {
+		return 2 * *flagPollInterval, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid max_age %q", s)
+	}
+	return d, nil
+}
+
+// readRegisters returns the cached registers when they are at most maxAge
+// old, otherwise reads them from the unit and caches the result. The
+// returned map must not be modified.
+func readRegisters(client *ModbusConn, regType modbus.RegType, ranges [][]uint16, maxAge time.Duration) (map[uint16]uint16, futura.SnapshotMeta, bool) {
+	if maxAge > 0 {
+		regCacheMu.Lock()
+		c, ok := regCache[regType]
+		regCacheMu.Unlock()
+		if ok && time.Since(c.meta.Time) <= maxAge {
+			return c.values, c.meta, true
+		}
+	}
+	values := collectRanges(client, regType, ranges, runtimeMaxBlockSize)
+	meta := readMeta()
+	cacheRegisters(regType, values, meta)
+	return values, meta, false
+}
+
+// setCacheHeader reports in X-Cache whether the response came from the
+// register cache
+func setCacheHeader(w http.ResponseWriter, hit bool) {
+	if hit {
+		w.Header().Set("X-Cache", "hit")
+	} else {
+		w.Header().Set("X-Cache", "miss")
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/simonvetter/modbus"
+)
+
+// Replay: with -replay the exporter reads a recording made with -record-raw
+// instead of a unit. A local Modbus TCP server plays the recording back in
+// real time, looping at the end, so polling, metrics and the UI behave as
+// they did when it was recorded. Writes change the served registers until
+// the recording overwrites them; the write limits of the profile decide
+// which writes are refused or clamped, as the unit would.
+
+type replayServer struct {
+	mu      sync.Mutex
+	blocks  []rawBlock // sorted by time
+	next    int        // first block not applied yet
+	started time.Time  // wall time the current loop started
+	regs    map[string]map[uint16]uint16
+	limits  map[uint16]WriteLimit // holding address -> limit
+}
+
+// loadReplay reads a recording; the served registers start out with the
+// first value recorded for each of them
+func loadReplay(path string) (*replayServer, error) {
+	s := &replayServer{regs: map[string]map[uint16]uint16{"input": {}, "holding": {}}}
+	err := readRawFile(path, func(b rawBlock) error {
+		if _, ok := s.regs[b.Type]; !ok {
+			return fmt.Errorf("%s: unknown register type %q", path, b.Type)
+		}
+		s.blocks = append(s.blocks, b)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(s.blocks) == 0 {
+		return nil, fmt.Errorf("%s has no recorded reads", path)
+	}
+	sort.SliceStable(s.blocks, func(i, j int) bool { return s.blocks[i].Time.Before(s.blocks[j].Time) })
+	for i := len(s.blocks) - 1; i >= 0; i-- {
+		s.apply(s.blocks[i])
+	}
+	s.started = time.Now()
+	return s, nil
+}
+
+func (s *replayServer) apply(b rawBlock) {
+	for i, v := range b.Values {
+		s.regs[b.Type][b.Start+uint16(i)] = v
+	}
+}
+
+// advance applies the blocks recorded up to the current replay time
+func (s *replayServer) advance() {
+	first, last := s.blocks[0].Time, s.blocks[len(s.blocks)-1].Time
+	now := first.Add(time.Since(s.started))
+	if now.After(last) && s.next == len(s.blocks) {
+		// loop
+		s.started, s.next = time.Now(), 0
+		now = first
+	}
+	for s.next < len(s.blocks) && !s.blocks[s.next].Time.After(now) {
+		s.apply(s.blocks[s.next])
+		s.next++
+	}
+}
+
+func (s *replayServer) read(typ string, addr, qty uint16) ([]uint16, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance()
+	// registers that weren't recorded read as 0, so a profile polling more
+	// than the recording still works
+	out := make([]uint16, qty)
+	for i := range out {
+		out[i] = s.regs[typ][addr+uint16(i)]
+	}
+	return out, nil
+}
+
+func (s *replayServer) HandleCoils(*modbus.CoilsRequest) ([]bool, error) {
+	return nil, modbus.ErrIllegalFunction
+}
+
+func (s *replayServer) HandleDiscreteInputs(*modbus.DiscreteInputsRequest) ([]bool, error) {
+	return nil, modbus.ErrIllegalFunction
+}
+
+func (s *replayServer) HandleInputRegisters(req *modbus.InputRegistersRequest) ([]uint16, error) {
+	return s.read("input", req.Addr, req.Quantity)
+}
+
+func (s *replayServer) HandleHoldingRegisters(req *modbus.HoldingRegistersRequest) ([]uint16, error) {
+	if !req.IsWrite {
+		return s.read("holding", req.Addr, req.Quantity)
+	}
+	words := make([]uint16, len(req.Args))
+	for i, v := range req.Args {
+		addr := req.Addr + uint16(i)
+		l, ok := s.limits[addr]
+		if !ok {
+			words[i] = v
+			continue
+		}
+		w, ok := l.check(v)
+		if !ok {
+			log.Printf("Replay: rejecting write of %s (register %d = %d)", l.Field, addr, v)
+			return nil, modbus.ErrIllegalDataValue
+		}
+		if w != v {
+			log.Printf("Replay: clamping write of %s (register %d = %d -> %d)", l.Field, addr, v, w)
+		}
+		words[i] = w
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, w := range words {
+		s.regs["holding"][req.Addr+uint16(i)] = w
+	}
+	return nil, nil
+}
+
+// startReplay serves the recording on a free local port, applying the
+// write limits, and returns its address; with faults it is the address of
+// the fault proxy in front of it
+func startReplay(path string, limits []WriteLimit, faults replayFaults) (string, uint, error) {
+	s, err := loadReplay(path)
+	if err != nil {
+		return "", 0, err
+	}
+	s.limits = map[uint16]WriteLimit{}
+	for _, l := range limits {
+		s.limits[l.addr] = l
+	}
+	// the server doesn't report the port it bound, so pick a free one first
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", 0, err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	srv, err := modbus.NewServer(&modbus.ServerConfiguration{
+		URL:        fmt.Sprintf("tcp://127.0.0.1:%d", port),
+		Timeout:    time.Minute,
+		MaxClients: 16,
+		Logger:     log.New(log.Writer(), "replay: ", log.LstdFlags),
+	}, s)
+	if err == nil {
+		err = srv.Start()
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	first, last := s.blocks[0].Time, s.blocks[len(s.blocks)-1].Time
+	log.Printf("Replaying %s: %d reads from %s, %s long", path, len(s.blocks), first.Format(time.RFC3339), last.Sub(first).Round(time.Second))
+	if faults.enabled() {
+		proxyPort, err := startFaultProxy(fmt.Sprintf("127.0.0.1:%d", port), faults)
+		if err != nil {
+			return "", 0, err
+		}
+		return "127.0.0.1", proxyPort, nil
+	}
+	return "127.0.0.1", uint(port), nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Fault injection for -replay: with any of the -replay-*-rate flags set, the
+// exporter talks to the replay server through a proxy that breaks that
+// share of the requests, so the reconnect manager, the circuit breaker and
+// the retries can be exercised without a flaky unit. Per request at most one
+// fault is injected:
+//
+//   - timeout: the request is answered by the server but the response is
+//     withheld, so the client times out
+//   - drop: the connection is closed without a response
+//   - exception: the request is answered with exception 6 (server device
+//     busy) without reaching the server
+//   - garble: the response PDU is replaced by random bytes of the same length
+
+// replayFaults are the rates (0-1) of each fault
+type replayFaults struct {
+	Timeout, Drop, Exception, Garble float64
+	Seed                             int64 // 0 = random
+}
+
+func (f replayFaults) enabled() bool {
+	return f.Timeout > 0 || f.Drop > 0 || f.Exception > 0 || f.Garble > 0
+}
+
+func (f replayFaults) validate() error {
+	for name, rate := range map[string]float64{"timeout": f.Timeout, "drop": f.Drop, "exception": f.Exception, "garble": f.Garble} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("replay-%s-rate must be between 0 and 1", name)
+		}
+	}
+	if sum := f.Timeout + f.Drop + f.Exception + f.Garble; sum > 1 {
+		return fmt.Errorf("replay fault rates add up to %.2f, more than 1", sum)
+	}
+	return nil
+}
+
+var replayFaultsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "futura_replay_faults_total",
+	Help: "Faults injected into the replay server's responses by kind",
+}, []string{"fault"})
+
+func RegisterReplayMetrics() {
+	prometheus.MustRegister(replayFaultsTotal)
+}
+
+// faultProxy forwards Modbus TCP frames to the replay server, injecting
+// faults
+type faultProxy struct {
+	backend string
+	faults  replayFaults
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// pick returns the fault to inject into the next request, if any
+func (p *faultProxy) pick() string {
+	p.mu.Lock()
+	x := p.rng.Float64()
+	p.mu.Unlock()
+	f := p.faults
+	for _, c := range []struct {
+		name string
+		rate float64
+	}{{"timeout", f.Timeout}, {"drop", f.Drop}, {"exception", f.Exception}, {"garble", f.Garble}} {
+		if x < c.rate {
+			return c.name
+		}
+		x -= c.rate
+	}
+	return ""
+}
+
+func (p *faultProxy) serve(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			log.Printf("Replay fault proxy stopped: %v", err)
+			return
+		}
+		go p.handle(c)
+	}
+}
+
+func (p *faultProxy) handle(client net.Conn) {
+	defer client.Close()
+	server, err := net.Dial("tcp", p.backend)
+	if err != nil {
+		log.Printf("Replay fault proxy: %v", err)
+		return
+	}
+	defer server.Close()
+
+	for {
+		req, err := readMBAPFrame(client)
+		if err != nil {
+			return
+		}
+		fault := p.pick()
+		if fault != "" {
+			replayFaultsTotal.WithLabelValues(fault).Inc()
+		}
+		switch fault {
+		case "drop":
+			log.Printf("Replay: dropping the connection (function %d)", req[7])
+			return
+		case "exception":
+			resp := make([]byte, 9)
+			copy(resp, req[:7])
+			binary.BigEndian.PutUint16(resp[4:], 3)
+			resp[7], resp[8] = req[7]|0x80, 0x06
+			if _, err := client.Write(resp); err != nil {
+				return
+			}
+			continue
+		}
+
+		if _, err := server.Write(req); err != nil {
+			return
+		}
+		resp, err := readMBAPFrame(server)
+		if err != nil {
+			return
+		}
+		switch fault {
+		case "timeout":
+			continue
+		case "garble":
+			p.mu.Lock()
+			p.rng.Read(resp[7:])
+			p.mu.Unlock()
+		}
+		if _, err := client.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+// readMBAPFrame reads one Modbus TCP frame: the 7 byte MBAP header (the
+// length counts the unit ID) and the PDU
+func readMBAPFrame(r io.Reader) ([]byte, error) {
+	hdr := make([]byte, 7)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	n := int(binary.BigEndian.Uint16(hdr[4:]))
+	if n < 2 || n > 254 {
+		return nil, fmt.Errorf("invalid MBAP length %d", n)
+	}
+	frame := make([]byte, 6+n)
+	copy(frame, hdr)
+	if _, err := io.ReadFull(r, frame[7:]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// startFaultProxy listens on a free local port and forwards to backend
+func startFaultProxy(backend string, f replayFaults) (uint, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	seed := f.Seed
+	if seed == 0 {
+		seed = rand.Int63()
+	}
+	p := &faultProxy{backend: backend, faults: f, rng: rand.New(rand.NewSource(seed))}
+	go p.serve(ln)
+	log.Printf("Replay: injecting faults (timeout %.3g, drop %.3g, exception %.3g, garble %.3g, seed %d)",
+		f.Timeout, f.Drop, f.Exception, f.Garble, seed)
+	return uint(ln.Addr().(*net.TCPAddr).Port), nil
+}
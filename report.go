@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MonthlyReport aggregates derived statistics for one calendar month
+type MonthlyReport struct {
+	Month           string  `json:"month"` // YYYY-MM
+	BypassOpenHours float64 `json:"bypass_open_hours"`
+	FreeCoolingKWh  float64 `json:"free_cooling_kwh"`
+
+	DefrostCycles    int     `json:"defrost_cycles"`
+	DefrostHours     float64 `json:"defrost_hours"`
+	DefrostMaxPerDay int     `json:"defrost_max_per_day"` // most cycles started on one day
+}
+
+var (
+	reportMu sync.Mutex
+	reports  = map[string]*MonthlyReport{}
+)
+
+// monthReport returns the report for the month of t in the configured time
+// zone; reportMu must be held
+func monthReport(t time.Time) *MonthlyReport {
+	key := t.In(appLocation).Format("2006-01")
+	rep, ok := reports[key]
+	if !ok {
+		rep = &MonthlyReport{Month: key}
+		reports[key] = rep
+	}
+	return rep
+}
+
+// updateReport applies fn to the report of the month of t
+func updateReport(t time.Time, fn func(rep *MonthlyReport)) {
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	fn(monthReport(t))
+}
+
+// handleMonthlyReport returns all monthly reports, or one with ?month=YYYY-MM
+func handleMonthlyReport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	reportMu.Lock()
+	var out []MonthlyReport
+	month := r.URL.Query().Get("month")
+	for key, rep := range reports {
+		if month == "" || month == key {
+			out = append(out, *rep)
+		}
+	}
+	reportMu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Month < out[j].Month })
+	if out == nil {
+		out = []MonthlyReport{}
+	}
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		log.Printf("encode report json: %v", err)
+	}
+}
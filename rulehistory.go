@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Rule history outcomes
+const (
+	RuleFired   = "fired"   // conditions became true (or event matched) and writes were issued
+	RuleCleared = "cleared" // conditions of a poll rule stopped holding
+	RuleSkipped = "skipped" // an event matched but the conditions did not hold
+)
+
+// ruleHistorySize is how many entries /api/rules/history keeps
+const ruleHistorySize = 500
+
+// RuleHistoryEntry records one rule outcome with the values it was based on
+type RuleHistoryEntry struct {
+	Seq        int64              `json:"seq"`
+	Time       time.Time          `json:"time"`
+	Rule       string             `json:"rule"`
+	Trigger    string             `json:"trigger"` // "poll" or the event type
+	Outcome    string             `json:"outcome"`
+	Conditions []ConditionResult  `json:"conditions"`
+	Writes     map[string]float64 `json:"writes,omitempty"`
+	Errors     map[string]string  `json:"errors,omitempty"` // field -> write error
+}
+
+type ruleLog struct {
+	mu      sync.Mutex
+	seq     int64
+	entries []RuleHistoryEntry
+}
+
+var ruleHistory = &ruleLog{}
+
+var (
+	ruleFired = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "futura_rule_fired_total",
+		Help: "Number of times a rule fired",
+	}, []string{"rule"})
+	ruleWriteErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "futura_rule_write_errors_total",
+		Help: "Failed writes issued by a rule",
+	}, []string{"rule"})
+)
+
+func RegisterRuleMetrics() {
+	prometheus.MustRegister(ruleFired, ruleWriteErrors)
+}
+
+func (l *ruleLog) add(e RuleHistoryEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	e.Seq = l.seq
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	l.entries = append(l.entries, e)
+	if len(l.entries) > ruleHistorySize {
+		l.entries = append([]RuleHistoryEntry(nil), l.entries[len(l.entries)-ruleHistorySize:]...)
+	}
+}
+
+func (l *ruleLog) list(after int64, since time.Time, rule string) []RuleHistoryEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	start := sort.Search(len(l.entries), func(i int) bool { return l.entries[i].Seq > after })
+	var out []RuleHistoryEntry
+	for _, e := range l.entries[start:] {
+		if e.Time.Before(since) || (rule != "" && e.Rule != rule) {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// handleRulesHistory returns recent rule outcomes, oldest first, paged with
+// ?since=&limit=&cursor=&fields= and filtered by ?rule=
+func handleRulesHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	page, err := parsePage(r, 100)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"success":false,"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	entries := ruleHistory.list(page.After, page.Since, r.URL.Query().Get("rule"))
+	err = writePage(w, page, len(entries),
+		func(i int) interface{} { return entries[i] },
+		func(i int) int64 { return entries[i].Seq })
+	if err != nil {
+		log.Printf("encode rule history json: %v", err)
+	}
+}
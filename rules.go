@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/danielkucera/gofutura/futura"
+)
+
+// RuleConfig is an automation: when its trigger fires and all conditions
+// hold, the listed fields are written.
+//
+// Rules with an event trigger run whenever a matching event is emitted.
+// Rules without one are evaluated after every poll and fire once each time
+// their conditions become true.
+type RuleConfig struct {
+	Name   string             `yaml:"name"`
+	Event  string             `yaml:"event"`  // event type to react to (optional)
+	Source string             `yaml:"source"` // event source filter, e.g. a digital input name
+	When   []RuleCondition    `yaml:"when"`
+	Write  map[string]float64 `yaml:"write"` // writable field -> value
+}
+
+// RuleCondition compares a snapshot field against a constant
+type RuleCondition struct {
+	Field string  `yaml:"field" json:"field"` // field of /api/read-input or /api/read-holding
+	Index int     `yaml:"index" json:"index"` // 1-based instance for array fields
+	Op    string  `yaml:"op" json:"op"`       // <, <=, >, >=, ==, !=
+	Value float64 `yaml:"value" json:"value"`
+}
+
+// RuleEngine evaluates the configured rules against polled data and events
+type RuleEngine struct {
+	client *ModbusConn
+	rules  []RuleConfig
+
+	mu      sync.Mutex
+	input   futura.InputRegs
+	holding futura.HoldingRegs
+	active  map[string]bool // last condition state of poll-triggered rules
+}
+
+// ruleEngine runs the rules of the current config; a reload replaces it
+var ruleEngine atomic.Pointer[RuleEngine]
+
+// rules returns the current rule engine, nil before startup
+func rules() *RuleEngine {
+	return ruleEngine.Load()
+}
+
+func NewRuleEngine(client *ModbusConn, cfg []RuleConfig) *RuleEngine {
+	return &RuleEngine{client: client, rules: cfg, active: map[string]bool{}}
+}
+
+// Evaluate stores the latest snapshot and runs poll-triggered rules.
+// Changes of a rule's condition state are recorded in the rule history.
+func (e *RuleEngine) Evaluate(in futura.InputRegs, hold futura.HoldingRegs) {
+	e.mu.Lock()
+	e.input, e.holding = in, hold
+	var fire []ruleFiring
+	for _, rule := range e.rules {
+		if rule.Event != "" {
+			continue
+		}
+		conds, ok := evalConditions(in, hold, rule.When)
+		switch {
+		case ok && !e.active[rule.Name]:
+			fire = append(fire, ruleFiring{rule, "poll", conds})
+		case !ok && e.active[rule.Name]:
+			ruleHistory.add(RuleHistoryEntry{Rule: rule.Name, Trigger: "poll", Outcome: RuleCleared, Conditions: conds})
+		}
+		e.active[rule.Name] = ok
+	}
+	e.mu.Unlock()
+
+	for _, f := range fire {
+		e.fire(f)
+	}
+}
+
+// HandleEvent runs event-triggered rules matching the event
+func (e *RuleEngine) HandleEvent(ev Event) {
+	e.mu.Lock()
+	var fire []ruleFiring
+	for _, rule := range e.rules {
+		if rule.Event != ev.Type || (rule.Source != "" && rule.Source != ev.Source) {
+			continue
+		}
+		conds, ok := evalConditions(e.input, e.holding, rule.When)
+		if !ok {
+			ruleHistory.add(RuleHistoryEntry{Rule: rule.Name, Trigger: ev.Type, Outcome: RuleSkipped, Conditions: conds})
+			continue
+		}
+		fire = append(fire, ruleFiring{rule, ev.Type, conds})
+	}
+	e.mu.Unlock()
+
+	for _, f := range fire {
+		e.fire(f)
+	}
+}
+
+// evalConditions checks all conditions against a snapshot
+func evalConditions(in futura.InputRegs, hold futura.HoldingRegs, when []RuleCondition) ([]ConditionResult, bool) {
+	out := []ConditionResult{}
+	holds := true
+	for _, c := range when {
+		cr := ConditionResult{RuleCondition: c}
+		if v, ok := snapshotField(in, hold, c.Field, c.Index); ok {
+			cr.Actual = &v
+			cr.OK = compare(v, c.Op, c.Value)
+		}
+		holds = holds && cr.OK
+		out = append(out, cr)
+	}
+	return out, holds
+}
+
+// ConditionResult is one evaluated condition of a simulated rule
+type ConditionResult struct {
+	RuleCondition
+	Actual *float64 `json:"actual"` // nil when the field is unknown
+	OK     bool     `json:"ok"`
+}
+
+// RuleResult tells whether a rule would fire for a snapshot
+type RuleResult struct {
+	Name       string             `json:"name"`
+	Trigger    string             `json:"trigger"` // "poll" or the event type
+	Conditions []ConditionResult  `json:"conditions"`
+	Fires      bool               `json:"fires"`
+	Writes     map[string]float64 `json:"writes,omitempty"`
+	Note       string             `json:"note,omitempty"`
+}
+
+// Simulate evaluates all rules against a snapshot and an optional event
+// without writing anything
+func (e *RuleEngine) Simulate(in futura.InputRegs, hold futura.HoldingRegs, ev *Event) []RuleResult {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	out := []RuleResult{}
+	for _, rule := range e.rules {
+		conds, holds := evalConditions(in, hold, rule.When)
+		res := RuleResult{Name: rule.Name, Trigger: "poll", Conditions: conds}
+
+		switch {
+		case rule.Event != "":
+			res.Trigger = rule.Event
+			matches := ev != nil && ev.Type == rule.Event && (rule.Source == "" || rule.Source == ev.Source)
+			res.Fires = matches && holds
+			if !matches {
+				res.Note = "no matching event"
+			}
+		case holds && e.active[rule.Name]:
+			res.Note = "conditions already held at the last poll; fires again only after they clear"
+		default:
+			res.Fires = holds
+		}
+		if res.Fires {
+			res.Writes = rule.Write
+		}
+		out = append(out, res)
+	}
+	return out
+}
+
+// Snapshot returns the last polled data the rules were evaluated against
+func (e *RuleEngine) Snapshot() (futura.InputRegs, futura.HoldingRegs) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.input, e.holding
+}
+
+// handleRulesSimulate evaluates the rules without touching the device.
+// The optional body overlays fields on the live snapshot and can supply an
+// event:
+//
+//	{"input":{"TempIndoor":27},"holding":{"CfgBypassEnable":1},
+//	 "event":{"type":"digital_input_on","source":"window"}}
+func handleRulesSimulate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		fmt.Fprintf(w, `{"success":false,"error":"GET or POST required"}`)
+		return
+	}
+	var req struct {
+		Input   json.RawMessage `json:"input"`
+		Holding json.RawMessage `json:"holding"`
+		Event   *Event          `json:"event"`
+	}
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			fmt.Fprintf(w, `{"success":false,"error":"Invalid JSON"}`)
+			return
+		}
+	}
+
+	in, hold := rules().Snapshot()
+	if len(req.Input) > 0 {
+		if err := json.Unmarshal(req.Input, &in); err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, "input: "+err.Error())
+			return
+		}
+	}
+	if len(req.Holding) > 0 {
+		if err := json.Unmarshal(req.Holding, &hold); err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, "holding: "+err.Error())
+			return
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(rules().Simulate(in, hold, req.Event)); err != nil {
+		log.Printf("encode rule simulation json: %v", err)
+	}
+}
+
+// ruleFiring is a rule about to fire with the conditions that let it
+type ruleFiring struct {
+	rule       RuleConfig
+	trigger    string
+	conditions []ConditionResult
+}
+
+func (e *RuleEngine) fire(f ruleFiring) {
+	rule := f.rule
+	log.Printf("Rule %q fired", rule.Name)
+	ruleFired.WithLabelValues(rule.Name).Inc()
+	entry := RuleHistoryEntry{Rule: rule.Name, Trigger: f.trigger, Outcome: RuleFired, Conditions: f.conditions, Writes: rule.Write}
+	for field, value := range rule.Write {
+		if err := WriteSingleRegister(e.client, field, value, origin{Source: "rule", Client: rule.Name}); err != nil {
+			log.Printf("Rule %q write %s: %v", rule.Name, field, err)
+			ruleWriteErrors.WithLabelValues(rule.Name).Inc()
+			if entry.Errors == nil {
+				entry.Errors = map[string]string{}
+			}
+			entry.Errors[field] = err.Error()
+		}
+	}
+	ruleHistory.add(entry)
+}
+
+var validOps = map[string]bool{"<": true, "<=": true, ">": true, ">=": true, "==": true, "!=": true}
+
+func compare(a float64, op string, b float64) bool {
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	}
+	return false
+}
+
+// snapshotField looks up a numeric field by name in the input registers,
+// falling back to the holding registers. index selects the 1-based instance
+// of array fields.
+func snapshotField(in futura.InputRegs, hold futura.HoldingRegs, field string, index int) (float64, bool) {
+	if v, ok := structField(reflect.ValueOf(in), field, index); ok {
+		return v, true
+	}
+	return structField(reflect.ValueOf(hold), field, index)
+}
+
+func structField(s reflect.Value, field string, index int) (float64, bool) {
+	f := s.FieldByName(field)
+	if !f.IsValid() {
+		return 0, false
+	}
+	if f.Kind() == reflect.Array {
+		if index < 1 || index > f.Len() {
+			return 0, false
+		}
+		f = f.Index(index - 1)
+	}
+	switch f.Kind() {
+	case reflect.Uint16, reflect.Uint32:
+		return float64(f.Uint()), true
+	case reflect.Float64:
+		return f.Float(), true
+	}
+	return 0, false
+}
+
+func validateRules(cfg []RuleConfig) error {
+	seen := map[string]bool{}
+	for i, rule := range cfg {
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d has no name", i)
+		}
+		if seen[rule.Name] {
+			return fmt.Errorf("duplicate rule name %q", rule.Name)
+		}
+		seen[rule.Name] = true
+		if rule.Event == "" && len(rule.When) == 0 {
+			return fmt.Errorf("rule %q needs an event or conditions", rule.Name)
+		}
+		for _, c := range rule.When {
+			if !validOps[c.Op] {
+				return fmt.Errorf("rule %q has invalid operator %q", rule.Name, c.Op)
+			}
+			if _, ok := snapshotField(futura.InputRegs{}, futura.HoldingRegs{}, c.Field, c.Index); !ok {
+				return fmt.Errorf("rule %q has unknown field %s (index %d)", rule.Name, c.Field, c.Index)
+			}
+		}
+		for field, value := range rule.Write {
+			spec, ok := futura.WriteableFields[field]
+			if !ok {
+				return fmt.Errorf("rule %q writes unknown field %s", rule.Name, field)
+			}
+			if err := spec.Validate(value); err != nil {
+				return fmt.Errorf("rule %q: %s %w", rule.Name, field, err)
+			}
+		}
+	}
+	return nil
+}
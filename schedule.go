@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// VentilationSchedule sets the ventilation level per hour of day, with
+// separate curves for weekdays and weekends. Levels between two hours are
+// interpolated so the level changes gradually.
+type VentilationSchedule struct {
+	Enabled bool        `json:"enabled"`
+	Smooth  bool        `json:"smooth"`  // interpolate between hourly points
+	Weekday [24]float64 `json:"weekday"` // level 1-5 at the start of each hour
+	Weekend [24]float64 `json:"weekend"`
+}
+
+var (
+	scheduleMu sync.Mutex
+	schedule   = defaultSchedule()
+)
+
+func defaultSchedule() VentilationSchedule {
+	s := VentilationSchedule{Smooth: true}
+	for h := 0; h < 24; h++ {
+		s.Weekday[h] = 2
+		s.Weekend[h] = 2
+	}
+	return s
+}
+
+func (s VentilationSchedule) validate() error {
+	for h := 0; h < 24; h++ {
+		if s.Weekday[h] < 1 || s.Weekday[h] > 5 || s.Weekend[h] < 1 || s.Weekend[h] > 5 {
+			return fmt.Errorf("levels must be between 1 and 5 (hour %d)", h)
+		}
+	}
+	return nil
+}
+
+// LevelAt returns the scheduled ventilation level for t. Hours are wall-clock
+// hours in the configured time zone: on spring-forward days the skipped hour
+// is never scheduled and on fall-back days the repeated hour uses its level twice.
+func (s VentilationSchedule) LevelAt(t time.Time) uint16 {
+	t = t.In(appLocation)
+	curve := s.Weekday
+	next := s.Weekday
+	if wd := t.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		curve = s.Weekend
+	}
+	// the point after 23:00 belongs to the next day's curve
+	if wd := (t.Weekday() + 1) % 7; wd == time.Saturday || wd == time.Sunday {
+		next = s.Weekend
+	}
+
+	h := t.Hour()
+	level := curve[h]
+	if s.Smooth {
+		to := curve[(h+1)%24]
+		if h == 23 {
+			to = next[0]
+		}
+		frac := float64(t.Minute()) / 60
+		level += (to - level) * frac
+	}
+	return uint16(math.Round(level))
+}
+
+// loadSchedule reads the schedule file if it exists
+func loadSchedule(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var s VentilationSchedule
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := s.validate(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	schedule = s
+	return nil
+}
+
+func saveSchedule(path string, s VentilationSchedule) error {
+	if path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// startScheduler writes the scheduled ventilation level whenever it changes.
+// Manual changes on the unit are kept until the schedule moves to a new level.
+// The schedule pauses during vacation and maintenance mode.
+func startScheduler(client *ModbusConn) {
+	go func() {
+		var applied uint16
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for ; ; <-ticker.C {
+			scheduleMu.Lock()
+			s := schedule
+			scheduleMu.Unlock()
+			if !s.Enabled || vacationActive() || maintenanceActive() {
+				applied = 0
+				continue
+			}
+			level := s.LevelAt(localNow())
+			if level == applied {
+				continue
+			}
+			log.Printf("Schedule: ventilation level %d", level)
+			if err := WriteSingleRegister(client, "FuncVentilation", float64(level), origin{Source: "schedule"}); err != nil {
+				log.Printf("Schedule write error: %v", err)
+				continue
+			}
+			applied = level
+		}
+	}()
+}
+
+// handleSchedule returns (GET) or replaces (PUT/POST) the ventilation schedule
+func handleSchedule(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+		scheduleMu.Lock()
+		s := schedule
+		scheduleMu.Unlock()
+		if err := json.NewEncoder(w).Encode(s); err != nil {
+			log.Printf("encode schedule json: %v", err)
+		}
+	case http.MethodPut, http.MethodPost:
+		var s VentilationSchedule
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":"Invalid JSON"}`)
+			return
+		}
+		if err := s.validate(); err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+			return
+		}
+		if err := saveSchedule(*flagScheduleFile, s); err != nil {
+			log.Printf("save schedule: %v", err)
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+			return
+		}
+		scheduleMu.Lock()
+		schedule = s
+		scheduleMu.Unlock()
+		fmt.Fprintf(w, `{"success":true,"message":"Schedule saved"}`)
+	default:
+		fmt.Fprintf(w, `{"success":false,"error":"GET or PUT required"}`)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// With -poll-on-scrape the poll loop has no ticker: every /metrics scrape
+// asks for a poll and waits until it's done, up to -poll-on-scrape-timeout,
+// so Prometheus sets the sampling cadence. Scrapes arriving while a poll
+// runs share the next one.
+
+var (
+	scrapeMu      sync.Mutex
+	scrapeWaiting []chan struct{} // scrapes waiting for the next poll
+	scrapeWake    = make(chan struct{}, 1)
+
+	scrapePollTimeouts = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "futura_scrape_poll_timeouts_total",
+		Help: "Scrapes answered with the previous poll because the poll they triggered took too long",
+	})
+)
+
+func RegisterScrapePollMetrics() {
+	prometheus.MustRegister(scrapePollTimeouts)
+}
+
+// requestPoll asks the poll loop for a poll that starts after this call;
+// the returned channel is closed once it has finished
+func requestPoll() <-chan struct{} {
+	done := make(chan struct{})
+	scrapeMu.Lock()
+	scrapeWaiting = append(scrapeWaiting, done)
+	scrapeMu.Unlock()
+	select {
+	case scrapeWake <- struct{}{}:
+	default:
+	}
+	return done
+}
+
+// pollOnScrape runs poll for every batch of requested polls; it never
+// returns
+func pollOnScrape(poll func()) {
+	for range scrapeWake {
+		scrapeMu.Lock()
+		batch := scrapeWaiting
+		scrapeWaiting = nil
+		scrapeMu.Unlock()
+		if len(batch) == 0 {
+			continue
+		}
+		poll()
+		for _, done := range batch {
+			close(done)
+		}
+	}
+}
+
+// scrapeTimeout returns -poll-on-scrape-timeout, shortened to leave time
+// for the response when Prometheus announces a shorter scrape timeout
+func scrapeTimeout(r *http.Request) time.Duration {
+	timeout := *flagScrapeTimeout
+	if s, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64); err == nil && s > 0 {
+		if t := time.Duration(s*float64(time.Second)) * 8 / 10; t < timeout {
+			timeout = t
+		}
+	}
+	return timeout
+}
+
+// pollBeforeScrape serves next after a fresh poll, or after the timeout with
+// the values of the previous one
+func pollBeforeScrape(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := scrapeTimeout(r)
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		select {
+		case <-requestPoll():
+		case <-t.C:
+			scrapePollTimeouts.Inc()
+			log.Printf("Poll for a scrape took longer than %s, serving the previous poll", timeout)
+		case <-r.Context().Done():
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
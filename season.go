@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Seasonal profiles: the summer and winter settings (bypass, cooling,
+// heating, setpoints) are written when the season changes, either on fixed
+// dates or when the rolling average outdoor temperature crosses a
+// threshold. The season can be overridden in the UI until the next
+// automatic switchover.
+
+// Seasons
+const (
+	SeasonSummer = "summer"
+	SeasonWinter = "winter"
+)
+
+// EventSeasonChanged is emitted when the profile of another season is
+// applied
+const EventSeasonChanged = "season_changed"
+
+// SeasonsConfig defines the profiles and when to switch between them
+type SeasonsConfig struct {
+	Switch string             `yaml:"switch"` // "dates" (default) or "temperature"
+	Summer map[string]float64 `yaml:"summer"` // writable field -> value
+	Winter map[string]float64 `yaml:"winter"`
+
+	// dates: summer from SummerFrom to SummerTo (MM-DD), winter otherwise
+	SummerFrom string `yaml:"summer_from"`
+	SummerTo   string `yaml:"summer_to"`
+
+	// temperature: summer once the average outdoor temperature rises above
+	// SummerAbove, winter once it falls below WinterBelow
+	Average     time.Duration `yaml:"average"`      // window of the average (default 72h)
+	SummerAbove *float64      `yaml:"summer_above"` // °C (default 15)
+	WinterBelow *float64      `yaml:"winter_below"` // °C (default 10)
+}
+
+func (c SeasonsConfig) enabled() bool {
+	return len(c.Summer) > 0 || len(c.Winter) > 0
+}
+
+func (c SeasonsConfig) validate() error {
+	if !c.enabled() {
+		return nil
+	}
+	for season, profile := range map[string]map[string]float64{SeasonSummer: c.Summer, SeasonWinter: c.Winter} {
+		for field, value := range profile {
+			spec, ok := futura.WriteableFields[field]
+			if !ok {
+				return fmt.Errorf("seasons.%s: unknown field %q", season, field)
+			}
+			if err := spec.Validate(value); err != nil {
+				return fmt.Errorf("seasons.%s: %s %w", season, field, err)
+			}
+		}
+	}
+	switch c.Switch {
+	case "", "dates":
+		if _, err := time.Parse("01-02", c.SummerFrom); err != nil {
+			return fmt.Errorf("seasons.summer_from must be a MM-DD date")
+		}
+		if _, err := time.Parse("01-02", c.SummerTo); err != nil {
+			return fmt.Errorf("seasons.summer_to must be a MM-DD date")
+		}
+	case "temperature":
+		if c.Average < 0 {
+			return fmt.Errorf("seasons.average must not be negative")
+		}
+		if above, below := c.thresholds(); above < below {
+			return fmt.Errorf("seasons.summer_above must not be below winter_below")
+		}
+	default:
+		return fmt.Errorf("seasons.switch must be dates or temperature")
+	}
+	return nil
+}
+
+// thresholds returns summer_above and winter_below with their defaults
+func (c SeasonsConfig) thresholds() (above, below float64) {
+	above, below = 15, 10
+	if c.SummerAbove != nil {
+		above = *c.SummerAbove
+	}
+	if c.WinterBelow != nil {
+		below = *c.WinterBelow
+	}
+	return above, below
+}
+
+func (c SeasonsConfig) averageWindow() time.Duration {
+	if c.Average > 0 {
+		return c.Average
+	}
+	return 72 * time.Hour
+}
+
+// profile returns the settings of season
+func (c SeasonsConfig) profile(season string) map[string]float64 {
+	if season == SeasonSummer {
+		return c.Summer
+	}
+	return c.Winter
+}
+
+// SeasonState is the current season and why
+type SeasonState struct {
+	Configured bool       `json:"configured"`
+	Season     string     `json:"season,omitempty"` // summer or winter, empty before the first poll
+	Auto       string     `json:"auto,omitempty"`   // what the automatic switchover picks
+	Override   bool       `json:"override"`         // set manually, until Auto changes
+	Since      *time.Time `json:"since,omitempty"`
+	Pending    string     `json:"pending,omitempty"`         // season whose profile failed to write, retried
+	Average    *float64   `json:"outdoor_average,omitempty"` // °C, with switch: temperature
+	LastError  string     `json:"last_error,omitempty"`      // of the last switchover
+}
+
+// seasonRetryAfter is how long a failed switchover waits before the fields
+// that failed are written again
+const seasonRetryAfter = time.Minute
+
+var (
+	seasonMu sync.Mutex
+	season   SeasonState
+
+	// fields of the pending season still to write, and when to retry
+	seasonRetryFields []string
+	seasonRetryAt     time.Time
+
+	seasonGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "futura_season",
+		Help: "1 for the season whose profile is applied",
+	}, []string{"season"})
+)
+
+func RegisterSeasonMetrics() {
+	prometheus.MustRegister(seasonGauge)
+}
+
+// autoSeason returns the season the configured switchover picks at now;
+// prev is kept while the average is between the thresholds
+func autoSeason(cfg SeasonsConfig, prev string, now time.Time) (string, *float64) {
+	if cfg.Switch != "temperature" {
+		day := now.In(appLocation).Format("01-02")
+		from, to := cfg.SummerFrom, cfg.SummerTo
+		// MM-DD strings sort like dates; a window may wrap around new year
+		inSummer := from <= day && day <= to
+		if from > to {
+			inSummer = day >= from || day <= to
+		}
+		if inSummer {
+			return SeasonSummer, nil
+		}
+		return SeasonWinter, nil
+	}
+
+	pts := history.Range("temp_ambient", now.Add(-cfg.averageWindow()), now)
+	if len(pts) == 0 {
+		return prev, nil
+	}
+	var sum float64
+	for _, p := range pts {
+		sum += p.Value
+	}
+	avg := sum / float64(len(pts))
+	above, below := cfg.thresholds()
+	switch {
+	case avg > above:
+		return SeasonSummer, &avg
+	case avg < below:
+		return SeasonWinter, &avg
+	case prev != "":
+		return prev, &avg
+	case avg >= (above+below)/2:
+		return SeasonSummer, &avg
+	}
+	return SeasonWinter, &avg
+}
+
+// updateSeason runs after every poll and applies the profile of the season
+// when the automatic switchover changes it. At startup the season is taken
+// over without writing, so a restart doesn't undo manual changes.
+func updateSeason(client *ModbusConn, now time.Time) {
+	cfg := appConfig().Seasons
+	if !cfg.enabled() {
+		return
+	}
+	seasonMu.Lock()
+	auto, avg := autoSeason(cfg, season.Auto, now)
+	season.Average = avg
+	if auto == "" {
+		seasonMu.Unlock()
+		return
+	}
+	changed := season.Auto != "" && auto != season.Auto
+	season.Auto = auto
+	if changed && season.Override {
+		log.Printf("Season: automatic switchover to %s ends the manual override", auto)
+		season.Override = false
+	}
+	// a manual choice whose profile failed to write is retried as well
+	target, source := auto, "auto"
+	if season.Override {
+		target, source = season.Pending, "manual"
+	}
+	if target == "" || target == season.Season {
+		season.Pending = ""
+		seasonMu.Unlock()
+		return
+	}
+	if season.Pending == target && now.Before(seasonRetryAt) {
+		seasonMu.Unlock()
+		return
+	}
+	if season.Season == "" {
+		season.Season, season.Since = target, &now
+		setSeasonGauge(target)
+		seasonMu.Unlock()
+		log.Printf("Season: %s", target)
+		return
+	}
+	seasonMu.Unlock()
+
+	applySeason(client, target, source)
+}
+
+// applySeason writes the profile of s; source is auto or manual. The season
+// only changes once every field is written; until then it is pending and
+// the automatic switchover retries the fields that failed.
+func applySeason(client *ModbusConn, s, source string) error {
+	profile := appConfig().Seasons.profile(s)
+	fields := make([]string, 0, len(profile))
+	for f := range profile {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	seasonMu.Lock()
+	if season.Pending == s && len(seasonRetryFields) > 0 {
+		fields = seasonRetryFields
+	}
+	seasonMu.Unlock()
+
+	log.Printf("Season: switching to %s (%s)", s, source)
+	var errs, failed []string
+	for _, f := range fields {
+		if err := WriteSingleRegister(client, f, profile[f], origin{Source: "season", Client: s}); err != nil {
+			log.Printf("Season %s: write %s: %v", s, f, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", f, err))
+			failed = append(failed, f)
+		}
+	}
+	now := time.Now()
+	seasonMu.Lock()
+	season.LastError = strings.Join(errs, "; ")
+	if len(failed) > 0 {
+		season.Pending = s
+		seasonRetryFields, seasonRetryAt = failed, now.Add(seasonRetryAfter)
+		seasonMu.Unlock()
+		log.Printf("Season: %s stays pending, retrying in %s", s, seasonRetryAfter)
+		return errors.New(strings.Join(errs, "; "))
+	}
+	season.Season, season.Since, season.Pending = s, &now, ""
+	seasonRetryFields = nil
+	setSeasonGauge(s)
+	seasonMu.Unlock()
+
+	emitEvent(Event{Type: EventSeasonChanged, Source: source, Data: map[string]interface{}{"season": s}})
+	return nil
+}
+
+func setSeasonGauge(s string) {
+	for _, name := range []string{SeasonSummer, SeasonWinter} {
+		if name == s {
+			seasonGauge.WithLabelValues(name).Set(1)
+		} else {
+			seasonGauge.WithLabelValues(name).Set(0)
+		}
+	}
+}
+
+// handleSeason returns the season (GET) or overrides it (POST
+// {"season":"summer"|"winter"|"auto"})
+func handleSeason(client *ModbusConn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.Method {
+		case http.MethodGet:
+			seasonMu.Lock()
+			st := season
+			seasonMu.Unlock()
+			st.Configured = appConfig().Seasons.enabled()
+			if err := json.NewEncoder(w).Encode(st); err != nil {
+				log.Printf("encode season json: %v", err)
+			}
+		case http.MethodPost:
+			if !appConfig().Seasons.enabled() {
+				fmt.Fprintf(w, `{"success":false,"error":"no seasons in the config file"}`)
+				return
+			}
+			var req struct {
+				Season string `json:"season"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				fmt.Fprintf(w, `{"success":false,"error":"Invalid JSON"}`)
+				return
+			}
+			seasonMu.Lock()
+			target := req.Season
+			switch target {
+			case SeasonSummer, SeasonWinter:
+				season.Override = true
+			case "auto":
+				season.Override = false
+				target = season.Auto
+			default:
+				seasonMu.Unlock()
+				fmt.Fprintf(w, `{"success":false,"error":"season must be summer, winter or auto"}`)
+				return
+			}
+			current := season.Season
+			// a manual choice writes the whole profile, not only what failed
+			seasonRetryFields = nil
+			seasonMu.Unlock()
+
+			// choosing a season applies its profile again, e.g. after manual changes
+			if target != "" && (req.Season != "auto" || target != current) {
+				if err := applySeason(client, target, "manual"); err != nil {
+					fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+					return
+				}
+			}
+			fmt.Fprintf(w, `{"success":true,"message":"Season set to %s"}`, req.Season)
+		default:
+			fmt.Fprintf(w, `{"success":false,"error":"GET or POST required"}`)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Secrets in the config file can be given as references instead of
+// plaintext, in any string value:
+//
+//	password: ${env:MQTT_PASSWORD}   taken from the environment
+//	password: enc:AbCd...            AES-GCM encrypted with the secret key
+//
+// The secret key is read from $GOFUTURA_SECRET_KEY or -secret-key-file and
+// is only needed when the config contains enc: values.
+
+const (
+	secretKeyEnv = "GOFUTURA_SECRET_KEY"
+	encPrefix    = "enc:"
+)
+
+var envRef = regexp.MustCompile(`^\$\{env:([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// loadSecretKey returns the AES key derived from the key file or environment
+func loadSecretKey(keyFile string) ([]byte, error) {
+	raw := os.Getenv(secretKeyEnv)
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read secret key: %w", err)
+		}
+		raw = string(data)
+	}
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, fmt.Errorf("no secret key: set %s or use -secret-key-file", secretKeyEnv)
+	}
+	key := sha256.Sum256([]byte(raw))
+	return key[:], nil
+}
+
+func encryptSecret(key []byte, plaintext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return encPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptSecret(key []byte, value string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encPrefix))
+	if err != nil {
+		return "", errors.New("invalid encrypted value")
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("invalid encrypted value")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("cannot decrypt value (wrong secret key?)")
+	}
+	return string(plain), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// resolveSecrets replaces secret references in all string values of a parsed
+// YAML document
+func resolveSecrets(node *yaml.Node, keyFile string) error {
+	var key []byte
+	var walk func(n *yaml.Node) error
+	walk = func(n *yaml.Node) error {
+		if n.Kind == yaml.ScalarNode && n.Tag == "!!str" {
+			if m := envRef.FindStringSubmatch(n.Value); m != nil {
+				v, ok := os.LookupEnv(m[1])
+				if !ok {
+					return fmt.Errorf("line %d: environment variable %s is not set", n.Line, m[1])
+				}
+				n.Value = v
+			} else if strings.HasPrefix(n.Value, encPrefix) {
+				if key == nil {
+					var err error
+					if key, err = loadSecretKey(keyFile); err != nil {
+						return fmt.Errorf("line %d: %w", n.Line, err)
+					}
+				}
+				v, err := decryptSecret(key, n.Value)
+				if err != nil {
+					return fmt.Errorf("line %d: %w", n.Line, err)
+				}
+				n.Value = v
+			}
+			return nil
+		}
+		for _, c := range n.Content {
+			if err := walk(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(node)
+}
+
+// runEncryptSecret implements `gofutura encrypt-secret`: it reads a secret
+// from stdin and prints the enc: value to paste into the config file
+func runEncryptSecret(args []string) int {
+	fs := flag.NewFlagSet("encrypt-secret", flag.ExitOnError)
+	keyFile := fs.String("secret-key-file", "", "File with the secret key (default $"+secretKeyEnv+")")
+	genKey := fs.Bool("gen-key", false, "Print a new random secret key and exit")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gofutura encrypt-secret [-secret-key-file FILE] < secret\n       gofutura encrypt-secret -gen-key > FILE\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *genKey {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			fmt.Fprintf(os.Stderr, "generate key: %v\n", err)
+			return 1
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(buf))
+		return 0
+	}
+
+	key, err := loadSecretKey(*keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "Secret: ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		fmt.Fprintf(os.Stderr, "read secret: %v\n", err)
+		return 1
+	}
+	secret := strings.TrimRight(line, "\r\n")
+	if secret == "" {
+		fmt.Fprintln(os.Stderr, "empty secret")
+		return 1
+	}
+	out, err := encryptSecret(key, secret)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "encrypt: %v\n", err)
+		return 1
+	}
+	fmt.Println(out)
+	return 0
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/simonvetter/modbus"
+)
+
+// ProfileIdentity lists the device IDs and register map versions a profile
+// is known to match; empty lists accept any non-blank value
+type ProfileIdentity struct {
+	DeviceIDs      []uint16 `yaml:"device_ids"`
+	RegmapVersions []uint32 `yaml:"regmap_versions"`
+}
+
+// SelfTestCheck is one step of the startup self-test
+type SelfTestCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// SelfTestReport is the outcome of the startup self-test. Writes stay
+// disabled while it has failed, unless -force-writes is given.
+type SelfTestReport struct {
+	Time          time.Time       `json:"time"`
+	Profile       string          `json:"profile"`
+	Passed        bool            `json:"passed"`
+	DeviceID      *uint16         `json:"device_id,omitempty"`
+	RegmapVersion *uint32         `json:"regmap_version,omitempty"`
+	Checks        []SelfTestCheck `json:"checks"`
+}
+
+var (
+	selfTestMu   sync.Mutex
+	selfTestLast *SelfTestReport
+)
+
+// selfTestFailed reports whether the last self-test failed
+func selfTestFailed() bool {
+	selfTestMu.Lock()
+	defer selfTestMu.Unlock()
+	return selfTestLast != nil && !selfTestLast.Passed
+}
+
+// runSelfTest verifies that all configured ranges respond and, for the
+// Futura decoder, that the unit identifies as a device the profile matches
+func runSelfTest(client *ModbusConn, p *Profile, maxBlockSize uint16) SelfTestReport {
+	rep := SelfTestReport{Time: time.Now(), Profile: p.Name, Passed: true}
+	add := func(name string, ok bool, detail string) {
+		rep.Checks = append(rep.Checks, SelfTestCheck{Name: name, OK: ok, Detail: detail})
+		if !ok {
+			rep.Passed = false
+		}
+	}
+
+	inputMap := map[uint16]uint16{}
+	for _, set := range []struct {
+		typ    modbus.RegType
+		name   string
+		ranges [][]uint16
+		out    map[uint16]uint16
+	}{
+		{modbus.INPUT_REGISTER, "input", inputRanges, inputMap},
+		{modbus.HOLDING_REGISTER, "holding", holdingRanges, nil},
+	} {
+		for _, r := range set.ranges {
+			err := readRange(client, set.typ, r[0], r[1], maxBlockSize, set.out)
+			name := fmt.Sprintf("%s range %d-%d", set.name, r[0], r[1])
+			if err != nil {
+				add(name, false, err.Error())
+			} else {
+				add(name, true, "")
+			}
+		}
+	}
+
+	if p.Decoder != DecoderFutura {
+		return rep
+	}
+
+	in := futura.DecodeInputMap(inputMap)
+	rep.DeviceID, rep.RegmapVersion = &in.FactDeviceID, &in.SysRegmapVersion
+
+	switch {
+	case in.FactDeviceID == 0 || in.FactDeviceID == 0xFFFF:
+		add("device id", false, fmt.Sprintf("FactDeviceID is 0x%04X, not a Futura register map?", in.FactDeviceID))
+	case len(p.Identity.DeviceIDs) > 0 && !containsU16(p.Identity.DeviceIDs, in.FactDeviceID):
+		add("device id", false, fmt.Sprintf("FactDeviceID %d is not one of %v for profile %s", in.FactDeviceID, p.Identity.DeviceIDs, p.Name))
+	default:
+		add("device id", true, fmt.Sprintf("%d", in.FactDeviceID))
+	}
+
+	switch {
+	case in.SysRegmapVersion == 0 || in.SysRegmapVersion == 0xFFFFFFFF:
+		add("register map version", false, fmt.Sprintf("SysRegmapVersion is 0x%08X", in.SysRegmapVersion))
+	case len(p.Identity.RegmapVersions) > 0 && !containsU32(p.Identity.RegmapVersions, in.SysRegmapVersion):
+		add("register map version", false, fmt.Sprintf("SysRegmapVersion %d is not one of %v for profile %s", in.SysRegmapVersion, p.Identity.RegmapVersions, p.Name))
+	default:
+		add("register map version", true, fmt.Sprintf("%d", in.SysRegmapVersion))
+	}
+
+	// a shifted map decodes other registers as temperatures
+	plausible := func(t float64) bool { return t > -50 && t < 90 }
+	if plausible(in.TempIndoor) && plausible(in.TempAmbient) {
+		add("temperature plausibility", true, "")
+	} else {
+		add("temperature plausibility", false, fmt.Sprintf("indoor %.1f °C, ambient %.1f °C", in.TempIndoor, in.TempAmbient))
+	}
+	return rep
+}
+
+// readRange reads a register range in blocks, storing values in out if set
+func readRange(client *ModbusConn, typ modbus.RegType, start, end, maxBlockSize uint16, out map[uint16]uint16) error {
+	for addr := int(start); addr <= int(end); addr += int(maxBlockSize) {
+		qty := maxBlockSize
+		if rest := int(end) - addr + 1; rest < int(qty) {
+			qty = uint16(rest)
+		}
+		regs, err := client.ReadRegisters(uint16(addr), qty, typ)
+		if err != nil {
+			return fmt.Errorf("read %d-%d: %w", addr, addr+int(qty)-1, err)
+		}
+		for i, v := range regs {
+			if out != nil {
+				out[uint16(addr+i)] = v
+			}
+		}
+	}
+	return nil
+}
+
+func containsU16(list []uint16, v uint16) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsU32(list []uint32, v uint32) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// selfTest runs the self-test, logs the report and stores it
+func selfTest(client *ModbusConn, p *Profile, maxBlockSize uint16) SelfTestReport {
+	rep := runSelfTest(client, p, maxBlockSize)
+	for _, c := range rep.Checks {
+		status := "ok"
+		if !c.OK {
+			status = "FAILED"
+		}
+		if c.Detail != "" {
+			log.Printf("Self-test %s: %s (%s)", c.Name, status, c.Detail)
+		} else {
+			log.Printf("Self-test %s: %s", c.Name, status)
+		}
+	}
+	switch {
+	case rep.Passed:
+		log.Printf("Self-test passed")
+	case *flagForceWrites:
+		log.Printf("Self-test FAILED; writes stay enabled because of -force-writes")
+	default:
+		log.Printf("Self-test FAILED; writes are disabled. Check -profile and the unit firmware, re-run with POST /api/selftest or start with -force-writes")
+	}
+
+	selfTestMu.Lock()
+	selfTestLast = &rep
+	selfTestMu.Unlock()
+	return rep
+}
+
+// handleSelfTest returns the last self-test report (GET) or runs it again (POST)
+func handleSelfTest(client *ModbusConn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var rep SelfTestReport
+		switch r.Method {
+		case http.MethodGet:
+			selfTestMu.Lock()
+			if selfTestLast != nil {
+				rep = *selfTestLast
+			}
+			selfTestMu.Unlock()
+		case http.MethodPost:
+			if maintenanceActive() {
+				fmt.Fprintf(w, `{"success":false,"error":%q}`, errMaintenance.Error())
+				return
+			}
+			rep = selfTest(client, activeProfile, runtimeMaxBlockSize)
+		default:
+			fmt.Fprintf(w, `{"success":false,"error":"GET or POST required"}`)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(rep); err != nil {
+			log.Printf("encode self-test json: %v", err)
+		}
+	}
+}
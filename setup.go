@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/simonvetter/modbus"
+	"gopkg.in/yaml.v3"
+)
+
+// First-run setup: started without a host, the exporter serves a wizard
+// instead that finds the unit, tests the connection and writes the device
+// and MQTT sections of the config file, then restarts with it.
+
+// DeviceConfig is the connection to the unit. Command-line flags override it.
+type DeviceConfig struct {
+	Host         string        `yaml:"host"`
+	Port         uint          `yaml:"port"`
+	SlaveID      *uint         `yaml:"slave_id"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+
+	InputRanges   [][]uint16 `yaml:"input_ranges"` // [start, end] pairs polled instead of the profile's
+	HoldingRanges [][]uint16 `yaml:"holding_ranges"`
+}
+
+func (c DeviceConfig) validate() error {
+	if c.Port > 65535 {
+		return fmt.Errorf("device.port %d exceeds 65535", c.Port)
+	}
+	if c.SlaveID != nil && *c.SlaveID > 255 {
+		return fmt.Errorf("device.slave_id %d exceeds 255", *c.SlaveID)
+	}
+	if c.PollInterval < 0 {
+		return fmt.Errorf("device.poll_interval must not be negative")
+	}
+	return nil
+}
+
+// defaultConfigFile is written by the wizard when -config isn't given
+const defaultConfigFile = "gofutura.yaml"
+
+// applyDeviceConfig fills the connection flags not given on the command
+// line (parsed into fs) from the config file
+func applyDeviceConfig(fs *flag.FlagSet, d DeviceConfig) {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["host"] && d.Host != "" {
+		*flagUnitHost = d.Host
+	}
+	if !set["port"] && d.Port != 0 {
+		*flagUnitPort = d.Port
+	}
+	if !set["slave-id"] && d.SlaveID != nil {
+		*flagSlaveID = *d.SlaveID
+	}
+	if !set["poll-interval"] && d.PollInterval != 0 {
+		*flagPollInterval = d.PollInterval
+	}
+}
+
+// runSetup serves the setup wizard until the config is saved; it doesn't
+// return
+func runSetup(httpAddr string) {
+	path := *flagConfig
+	if path == "" {
+		path = defaultConfigFile
+	}
+	staticSub, err := uiFS()
+	if err != nil {
+		configFailed("Failed to access UI files: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		http.ServeFileFS(w, r, staticSub, "setup.html")
+	})
+	mux.HandleFunc("/api/setup/discover", handleSetupDiscover)
+	mux.HandleFunc("/api/setup/test", handleSetupTest)
+	mux.HandleFunc("/api/setup/save", handleSetupSave(path))
+
+	ln, err := net.Listen("tcp", httpAddr)
+	if err != nil {
+		startupFailed("listen", exitListen, "HTTP server failed: %v", err)
+	}
+	log.Printf("No host configured: open http://<this machine>%s/ to set up, the config is written to %s", httpAddr, path)
+	writeStartupReport(StartupReport{Status: "setup", HTTPAddr: httpAddr})
+	if err := newHTTPServer(securityHeaders(csrfProtect(allowCIDR(mux, *flagAllowCIDRAll)), *flagFrameAncestors)).Serve(ln); err != nil {
+		log.Fatalf("HTTP server failed: %v", err)
+	}
+}
+
+// discoverHosts scans the local /24 networks for Modbus TCP servers
+func discoverHosts(ctx context.Context) []string {
+	addrs, _ := net.InterfaceAddrs()
+	seen := map[string]bool{}
+	var candidates []string
+	for _, a := range addrs {
+		ipn, ok := a.(*net.IPNet)
+		if !ok || ipn.IP.IsLoopback() || ipn.IP.To4() == nil {
+			continue
+		}
+		ip := ipn.IP.To4()
+		for i := 1; i < 255; i++ {
+			h := net.IPv4(ip[0], ip[1], ip[2], byte(i)).String()
+			if !ip.Equal(net.ParseIP(h)) && !seen[h] {
+				seen[h] = true
+				candidates = append(candidates, h)
+			}
+		}
+	}
+
+	var (
+		mu    sync.Mutex
+		found []string
+		wg    sync.WaitGroup
+		sem   = make(chan struct{}, 64)
+	)
+	d := net.Dialer{Timeout: 500 * time.Millisecond}
+	for _, h := range candidates {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(h string) {
+			defer func() { <-sem; wg.Done() }()
+			conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(h, "502"))
+			if err != nil {
+				return
+			}
+			conn.Close()
+			mu.Lock()
+			found = append(found, h)
+			mu.Unlock()
+		}(h)
+	}
+	wg.Wait()
+	sort.Slice(found, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(found[i]).To4(), net.ParseIP(found[j]).To4()) < 0
+	})
+	return found
+}
+
+// handleSetupDiscover lists hosts with an open Modbus TCP port
+func handleSetupDiscover(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	ctx, cancel := context.WithTimeout(r.Context(), 20*time.Second)
+	defer cancel()
+	hosts := discoverHosts(ctx)
+	if hosts == nil {
+		hosts = []string{}
+	}
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "hosts": hosts}); err != nil {
+		log.Printf("encode discover json: %v", err)
+	}
+}
+
+// setupDevice is the connection entered in the wizard
+type setupDevice struct {
+	Host    string `json:"host"`
+	Port    uint   `json:"port"`
+	SlaveID uint   `json:"slave_id"`
+}
+
+func (d setupDevice) check() error {
+	if d.Host == "" {
+		return fmt.Errorf("host is required")
+	}
+	if d.Port == 0 || d.Port > 65535 {
+		return fmt.Errorf("invalid port %d", d.Port)
+	}
+	if d.SlaveID > 255 {
+		return fmt.Errorf("slave id %d exceeds 255", d.SlaveID)
+	}
+	return nil
+}
+
+// testDevice connects to the unit and reads its identity, or the first
+// register of a generic profile
+func testDevice(ctx context.Context, d setupDevice) (map[string]interface{}, error) {
+	mc, err := modbus.NewClient(&modbus.ClientConfiguration{
+		URL:     "tcp://" + net.JoinHostPort(d.Host, strconv.Itoa(int(d.Port))),
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := mc.SetUnitId(uint8(d.SlaveID)); err != nil {
+		return nil, err
+	}
+	if err := mc.Open(); err != nil {
+		return nil, fmt.Errorf("connect: %w", err)
+	}
+	defer mc.Close()
+
+	if activeProfile.Decoder == DecoderFutura {
+		in, err := futura.NewClient(mc).Readings(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"serial": in.Serial, "firmware": in.FWRevision, "mac": in.MAC}, nil
+	}
+	regType, ranges := modbus.INPUT_REGISTER, activeProfile.InputRanges
+	if len(ranges) == 0 {
+		regType, ranges = modbus.HOLDING_REGISTER, activeProfile.HoldingRanges
+	}
+	if _, err := mc.ReadRegisters(ranges[0][0], 1, regType); err != nil {
+		return nil, fmt.Errorf("read register %d: %w", ranges[0][0], err)
+	}
+	return map[string]interface{}{"profile": activeProfile.Name}, nil
+}
+
+// handleSetupTest tries the connection entered in the wizard
+func handleSetupTest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		fmt.Fprintf(w, `{"success":false,"error":"POST required"}`)
+		return
+	}
+	var d setupDevice
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		fmt.Fprintf(w, `{"success":false,"error":"Invalid JSON"}`)
+		return
+	}
+	if err := d.check(); err != nil {
+		fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 20*time.Second)
+	defer cancel()
+	info, err := testDevice(ctx, d)
+	if err != nil {
+		fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+		return
+	}
+	info["success"] = true
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		log.Printf("encode setup test json: %v", err)
+	}
+}
+
+// setupRequest is what the wizard saves
+type setupRequest struct {
+	setupDevice
+	PollInterval string `json:"poll_interval"`
+	MQTT         struct {
+		Broker      string `json:"broker"`
+		Username    string `json:"username"`
+		Password    string `json:"password"`
+		TopicPrefix string `json:"topic_prefix"`
+	} `json:"mqtt"`
+}
+
+// writeSetupConfig sets the device and MQTT sections of the config file,
+// keeping the other sections of an existing file (but not its comments)
+func writeSetupConfig(path string, req setupRequest, interval time.Duration) error {
+	doc := map[string]interface{}{}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+		if doc == nil {
+			doc = map[string]interface{}{}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	doc["device"] = map[string]interface{}{
+		"host":          req.Host,
+		"port":          req.Port,
+		"slave_id":      req.SlaveID,
+		"poll_interval": interval.String(),
+	}
+	if req.MQTT.Broker != "" {
+		mqtt, _ := doc["mqtt"].(map[string]interface{})
+		if mqtt == nil {
+			mqtt = map[string]interface{}{}
+		}
+		mqtt["broker"] = req.MQTT.Broker
+		for k, v := range map[string]string{"username": req.MQTT.Username, "password": req.MQTT.Password, "topic_prefix": req.MQTT.TopicPrefix} {
+			if v != "" {
+				mqtt[k] = v
+			}
+		}
+		doc["mqtt"] = mqtt
+	}
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	// validate before replacing the file; it may hold an MQTT password
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if _, err := loadConfig(tmp, *flagSecretKeyFile); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// handleSetupSave writes the config and restarts with it
+func handleSetupSave(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodPost {
+			fmt.Fprintf(w, `{"success":false,"error":"POST required"}`)
+			return
+		}
+		var req setupRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":"Invalid JSON"}`)
+			return
+		}
+		err := req.check()
+		interval := 5 * time.Second
+		if err == nil && req.PollInterval != "" {
+			interval, err = time.ParseDuration(req.PollInterval)
+			if err == nil && interval <= 0 {
+				err = fmt.Errorf("poll interval must be positive")
+			}
+		}
+		if err == nil {
+			err = writeSetupConfig(path, req, interval)
+		}
+		if err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+			return
+		}
+		log.Printf("Setup saved to %s, restarting", path)
+		fmt.Fprintf(w, `{"success":true,"message":"saved to %s, restarting"}`, path)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+
+		args := os.Args
+		if *flagConfig == "" {
+			args = append([]string{args[0], "-config", path}, args[1:]...)
+		}
+		go func() {
+			time.Sleep(500 * time.Millisecond)
+			reexec(args)
+		}()
+	}
+}
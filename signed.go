@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SignedRequestsConfig configures HMAC-signed requests for machine clients
+// (Node-RED, scripts) that can't keep a session. A signed request carries
+//
+//	X-Gofutura-Key:       key name from the config
+//	X-Gofutura-Timestamp: unix seconds
+//	X-Gofutura-Signature: hex HMAC-SHA256 over
+//	                      METHOD "\n" PATH?QUERY "\n" TIMESTAMP "\n" hex(SHA256(body))
+type SignedRequestsConfig struct {
+	Keys    map[string]string `yaml:"keys"`     // key name -> shared secret
+	MaxSkew time.Duration     `yaml:"max_skew"` // accepted clock difference (default 5m)
+}
+
+const (
+	headerSignKey       = "X-Gofutura-Key"
+	headerSignTimestamp = "X-Gofutura-Timestamp"
+	headerSignature     = "X-Gofutura-Signature"
+
+	maxSignedBody = 1 << 20
+)
+
+type signedKeyCtx struct{}
+
+// signedBy returns the key name a request was signed with, if any
+func signedBy(r *http.Request) string {
+	name, _ := r.Context().Value(signedKeyCtx{}).(string)
+	return name
+}
+
+var (
+	seenSigMu sync.Mutex
+	seenSigs  = map[string]time.Time{} // signature -> expiry, against replays
+)
+
+func signatureMaxSkew() time.Duration {
+	if s := appConfig().SignedRequests.MaxSkew; s > 0 {
+		return s
+	}
+	return 5 * time.Minute
+}
+
+// requestSignature computes the expected signature of a request
+func requestSignature(secret, method, uri, timestamp string, body []byte) string {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, uri, timestamp, hex.EncodeToString(sum[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignature checks the signature headers; the body is restored for
+// the next handler
+func verifySignature(r *http.Request) (string, error) {
+	name := r.Header.Get(headerSignKey)
+	secret, ok := appConfig().SignedRequests.Keys[name]
+	if !ok {
+		return "", fmt.Errorf("unknown key")
+	}
+	ts := r.Header.Get(headerSignTimestamp)
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid timestamp")
+	}
+	skew := time.Since(time.Unix(sec, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > signatureMaxSkew() {
+		return "", fmt.Errorf("timestamp outside allowed window")
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBody+1))
+	if err != nil {
+		return "", fmt.Errorf("read body: %w", err)
+	}
+	if len(body) > maxSignedBody {
+		return "", fmt.Errorf("body too large")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	want := requestSignature(secret, r.Method, r.URL.RequestURI(), ts, body)
+	got := r.Header.Get(headerSignature)
+	if !hmac.Equal([]byte(got), []byte(want)) {
+		return "", fmt.Errorf("invalid signature")
+	}
+
+	seenSigMu.Lock()
+	defer seenSigMu.Unlock()
+	now := time.Now()
+	for s, exp := range seenSigs {
+		if now.After(exp) {
+			delete(seenSigs, s)
+		}
+	}
+	if _, dup := seenSigs[got]; dup {
+		return "", fmt.Errorf("replayed request")
+	}
+	seenSigs[got] = now.Add(2 * signatureMaxSkew())
+	return name, nil
+}
+
+// signedRequests verifies requests carrying signature headers. Invalid
+// signatures are rejected; valid ones are marked so that network
+// restrictions (-allow-cidr) don't apply to them.
+func signedRequests(next http.Handler) http.Handler {
+	if len(appConfig().SignedRequests.Keys) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(headerSignature) == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if authLocked(w, r) {
+			return
+		}
+		name, err := verifySignature(r)
+		if err != nil {
+			authFailed(r, "request signature")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+			return
+		}
+		authSucceeded(r)
+		if isWriteRequest(r) {
+			log.Printf("Signed %s %s by %s", r.Method, r.URL.Path, name)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), signedKeyCtx{}, name)))
+	})
+}
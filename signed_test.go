@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func withSigningKeys(t *testing.T, keys map[string]string) {
+	t.Helper()
+	prev := appConfig()
+	cfg := *prev
+	cfg.SignedRequests = SignedRequestsConfig{Keys: keys}
+	currentConfig.Store(&cfg)
+	seenSigMu.Lock()
+	seenSigs = map[string]time.Time{}
+	seenSigMu.Unlock()
+	authMu.Lock()
+	authFailures = map[string]*authAttempts{}
+	authMu.Unlock()
+	t.Cleanup(func() { currentConfig.Store(prev) })
+}
+
+// signedRequest builds a request signed with secret; body is what is sent,
+// signedBody what the signature covers
+func signedRequest(key, secret string, ts time.Time, body, signedBody string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/write-holding?x=1", strings.NewReader(body))
+	stamp := strconv.FormatInt(ts.Unix(), 10)
+	r.Header.Set(headerSignKey, key)
+	r.Header.Set(headerSignTimestamp, stamp)
+	r.Header.Set(headerSignature, requestSignature(secret, http.MethodPost, "/api/write-holding?x=1", stamp, []byte(signedBody)))
+	return r
+}
+
+func TestVerifySignature(t *testing.T) {
+	withSigningKeys(t, map[string]string{"nodered": "s3cret"})
+	now := time.Now()
+	body := `{"CfgTempSet":22}`
+	tests := []struct {
+		name    string
+		req     *http.Request
+		wantErr string
+	}{
+		{"valid", signedRequest("nodered", "s3cret", now, body, body), ""},
+		{"wrong secret", signedRequest("nodered", "guess", now, body, body), "invalid signature"},
+		{"unknown key", signedRequest("other", "s3cret", now, body, body), "unknown key"},
+		{"tampered body", signedRequest("nodered", "s3cret", now, `{"CfgTempSet":30}`, body), "invalid signature"},
+		{"stale timestamp", signedRequest("nodered", "s3cret", now.Add(-10*time.Minute), body, body), "outside allowed window"},
+		{"future timestamp", signedRequest("nodered", "s3cret", now.Add(10*time.Minute), body, body), "outside allowed window"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, err := verifySignature(tt.req)
+			if tt.wantErr == "" {
+				if err != nil || name != "nodered" {
+					t.Fatalf("verifySignature = %q, %v; want nodered", name, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("verifySignature error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("invalid timestamp", func(t *testing.T) {
+		r := signedRequest("nodered", "s3cret", now, body, body)
+		r.Header.Set(headerSignTimestamp, "yesterday")
+		if _, err := verifySignature(r); err == nil || !strings.Contains(err.Error(), "invalid timestamp") {
+			t.Fatalf("verifySignature error = %v, want invalid timestamp", err)
+		}
+	})
+}
+
+func TestSignedRequestsReplay(t *testing.T) {
+	withSigningKeys(t, map[string]string{"nodered": "s3cret"})
+	var got string
+	h := signedRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = signedBy(r)
+	}))
+	body := `{"CfgTempSet":22}`
+	ts := time.Now()
+	for i, want := range []int{http.StatusOK, http.StatusUnauthorized} {
+		got = ""
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, signedRequest("nodered", "s3cret", ts, body, body))
+		if w.Code != want {
+			t.Fatalf("request %d: status %d, want %d (%s)", i+1, w.Code, want, w.Body)
+		}
+		if want == http.StatusOK && got != "nodered" {
+			t.Errorf("request %d: signedBy = %q, want nodered", i+1, got)
+		}
+		if want != http.StatusOK && !strings.Contains(w.Body.String(), "replayed request") {
+			t.Errorf("request %d: body %s, want replayed request", i+1, w.Body)
+		}
+	}
+}
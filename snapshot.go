@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var pollSeq atomic.Uint64
+
+// nextPollMeta starts a new poll snapshot
+func nextPollMeta() futura.SnapshotMeta {
+	return futura.SnapshotMeta{Seq: pollSeq.Add(1), Time: time.Now()}
+}
+
+// readMeta describes a live read outside the poll loop
+func readMeta() futura.SnapshotMeta {
+	return futura.SnapshotMeta{Seq: pollSeq.Load(), Time: time.Now()}
+}
+
+// setSnapshotHeaders reports the snapshot in X-Snapshot-Seq and
+// X-Snapshot-Time, for responses whose body can't carry it
+func setSnapshotHeaders(w http.ResponseWriter, m futura.SnapshotMeta) {
+	w.Header().Set("X-Snapshot-Seq", strconv.FormatUint(m.Seq, 10))
+	w.Header().Set("X-Snapshot-Time", m.Time.UTC().Format(time.RFC3339Nano))
+	if m.Stale {
+		w.Header().Set("X-Snapshot-Stale", "1")
+	}
+}
+
+// serveStale answers a read the unit didn't respond to with the kept
+// registers v, whose meta m must already be flagged stale
+func serveStale(w http.ResponseWriter, v interface{}, m futura.SnapshotMeta) {
+	setSnapshotHeaders(w, m)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("encode stale snapshot json: %v", err)
+	}
+}
+
+var (
+	lastPollMu      sync.Mutex
+	lastPollInput   futura.InputRegs
+	lastPollHolding futura.HoldingRegs
+)
+
+// storeSnapshot keeps the registers of the latest poll
+func storeSnapshot(in futura.InputRegs, hold futura.HoldingRegs) {
+	lastPollMu.Lock()
+	lastPollInput, lastPollHolding = in, hold
+	lastPollMu.Unlock()
+	snapshotStale.Set(0)
+	saveSnapshot(in, hold)
+}
+
+// markSnapshotStale flags the kept snapshot after a poll that read nothing
+func markSnapshotStale() {
+	lastPollMu.Lock()
+	lastPollInput.Stale, lastPollHolding.Stale = true, true
+	lastPollMu.Unlock()
+	snapshotStale.Set(1)
+}
+
+// latestSnapshot returns the registers of the latest poll, false before
+// the first one
+func latestSnapshot() (futura.InputRegs, futura.HoldingRegs, bool) {
+	lastPollMu.Lock()
+	defer lastPollMu.Unlock()
+	return lastPollInput, lastPollHolding, lastPollInput.Seq != 0
+}
+
+// Warm start: with -snapshot-file the latest poll is saved to disk and
+// restored, flagged stale, at startup, so dashboards have values before the
+// first poll completes.
+
+// snapshotSaveEvery limits how often the file is rewritten (SD cards)
+const snapshotSaveEvery = time.Minute
+
+var (
+	snapshotFile  string
+	snapshotSaved time.Time
+
+	snapshotStale = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "futura_snapshot_stale",
+		Help: "1 while the exported values come from a restored or old snapshot instead of the last poll",
+	})
+)
+
+func RegisterSnapshotMetrics() {
+	prometheus.MustRegister(snapshotStale)
+}
+
+// persistedSnapshot is the format of -snapshot-file
+type persistedSnapshot struct {
+	Input   futura.InputRegs   `json:"input"`
+	Holding futura.HoldingRegs `json:"holding"`
+}
+
+// loadSnapshot restores the snapshot saved in path, flagged stale. A
+// missing file is not an error.
+func loadSnapshot(path string) (bool, error) {
+	snapshotFile = path
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var p persistedSnapshot
+	if err := json.Unmarshal(data, &p); err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	if p.Input.Seq == 0 {
+		return false, nil
+	}
+	p.Input.Stale, p.Holding.Stale = true, true
+	// keep Seq increasing across restarts
+	pollSeq.Store(p.Input.Seq)
+
+	lastPollMu.Lock()
+	lastPollInput, lastPollHolding = p.Input, p.Holding
+	lastPollMu.Unlock()
+	snapshotStale.Set(1)
+	snapshotSaved = time.Now()
+	return true, nil
+}
+
+// saveSnapshot writes the snapshot to -snapshot-file, at most once per
+// snapshotSaveEvery. Only the poll loop calls it.
+func saveSnapshot(in futura.InputRegs, hold futura.HoldingRegs) {
+	if snapshotFile == "" || time.Since(snapshotSaved) < snapshotSaveEvery {
+		return
+	}
+	snapshotSaved = time.Now()
+	data, err := json.Marshal(persistedSnapshot{in, hold})
+	if err != nil {
+		log.Printf("encode snapshot: %v", err)
+		return
+	}
+	tmp := snapshotFile + ".tmp"
+	if err = os.WriteFile(tmp, data, 0o644); err == nil {
+		err = os.Rename(tmp, snapshotFile)
+	}
+	if err != nil {
+		log.Printf("save snapshot: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Startup failures exit with a status that tells provisioning tools what
+// went wrong, and the outcome of the startup is written to stderr as a
+// single JSON line ({"event":"startup",...}) next to the log.
+const (
+	exitConfig = 2 // invalid flags, config file, profile, schedule or UI directory
+	exitDevice = 3 // the unit can't be reached
+	exitListen = 4 // a port can't be opened, usually because it is in use
+)
+
+// StartupReport is the machine-readable outcome of the startup
+type StartupReport struct {
+	Event    string    `json:"event"`           // always "startup"
+	Status   string    `json:"status"`          // ready, setup (wizard served) or failed
+	Stage    string    `json:"stage,omitempty"` // failed stage: config, device or listen
+	Error    string    `json:"error,omitempty"`
+	ExitCode int       `json:"exit_code,omitempty"`
+	Version  string    `json:"version"`
+	Profile  string    `json:"profile,omitempty"`
+	Host     string    `json:"host,omitempty"`
+	HTTPAddr string    `json:"http_addr,omitempty"`
+	SelfTest string    `json:"self_test,omitempty"` // passed or failed
+	Device   string    `json:"device,omitempty"`    // connected, or connecting in the background
+	Time     time.Time `json:"time"`
+}
+
+func writeStartupReport(rep StartupReport) {
+	rep.Event, rep.Version, rep.Time = "startup", version, time.Now()
+	rep.Host = *flagUnitHost
+	if activeProfile != nil {
+		rep.Profile = activeProfile.Name
+	}
+	if err := json.NewEncoder(os.Stderr).Encode(rep); err != nil {
+		log.Printf("encode startup report: %v", err)
+	}
+}
+
+// startupReady reports a successful startup
+func startupReady(httpAddr string) {
+	rep := StartupReport{Status: "ready", HTTPAddr: httpAddr}
+	connMu.Lock()
+	rep.Device = conn.State
+	connMu.Unlock()
+	selfTestMu.Lock()
+	if selfTestLast != nil {
+		rep.SelfTest = "failed"
+		if selfTestLast.Passed {
+			rep.SelfTest = "passed"
+		}
+	}
+	selfTestMu.Unlock()
+	writeStartupReport(rep)
+}
+
+// startupFailed logs the error, reports it and exits with code
+func startupFailed(stage string, code int, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Print(msg)
+	writeStartupReport(StartupReport{Status: "failed", Stage: stage, Error: msg, ExitCode: code})
+	os.Exit(code)
+}
+
+// configFailed reports an invalid configuration and exits
+func configFailed(format string, args ...interface{}) {
+	startupFailed("config", exitConfig, format, args...)
+}
@@ -0,0 +1,15 @@
+// Service worker of the gofutura web UI: shows Web Push notifications, also
+// while no page is open
+self.addEventListener('push', event => {
+	const msg = event.data ? event.data.json() : { title: 'gofutura', body: '' };
+	event.waitUntil(self.registration.showNotification(msg.title, {
+		body: msg.body,
+		tag: msg.type,
+		timestamp: msg.time ? Date.parse(msg.time) : Date.now()
+	}));
+});
+
+self.addEventListener('notificationclick', event => {
+	event.notification.close();
+	event.waitUntil(clients.openWindow('/edit'));
+});
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+)
+
+// Server-Sent Events stream of the polled input registers at /events (and
+// /api/stream). The first event and every sseFullEvery-th event is a full
+// "snapshot"; the events in between are "delta" events with only the fields
+// that changed since the previous event sent to that client. "write" events
+// report completed writes and "connection" events changes of the connection
+// to the unit.
+
+// sseFullEvery is how often a full snapshot is sent (in polls), so clients
+// that joined late or missed an event resynchronize
+const sseFullEvery = 12
+
+// snapshotFields is a polled snapshot split into top-level JSON fields
+type snapshotFields map[string]json.RawMessage
+
+// sseEvent is a polled snapshot or, when name is set, a named event
+type sseEvent struct {
+	name   string
+	data   []byte
+	fields snapshotFields
+}
+
+// sseWrite is the payload of "write" events
+type sseWrite struct {
+	Field  string    `json:"field"`
+	Value  float64   `json:"value"`
+	Source string    `json:"source"`
+	Client string    `json:"client,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// sseConnection is the payload of "connection" events
+type sseConnection struct {
+	State string    `json:"state"` // connected or disconnected
+	Since time.Time `json:"since"`
+}
+
+var (
+	sseMu      sync.Mutex
+	sseClients = map[chan sseEvent]bool{}
+)
+
+// publishStream hands a polled snapshot to all stream clients. Slow clients
+// miss snapshots; their next delta is still computed against what they got.
+func publishStream(r futura.InputRegs) {
+	sseMu.Lock()
+	defer sseMu.Unlock()
+	if len(sseClients) == 0 {
+		return
+	}
+	fields, err := splitFields(r)
+	if err != nil {
+		log.Printf("stream snapshot: %v", err)
+		return
+	}
+	for ch := range sseClients {
+		select {
+		case ch <- sseEvent{fields: fields}:
+		default:
+		}
+	}
+}
+
+// publishSSE sends a named event to all stream clients. sseMu must be held.
+func publishSSE(name string, v interface{}) {
+	if len(sseClients) == 0 {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("encode %s event: %v", name, err)
+		return
+	}
+	for ch := range sseClients {
+		select {
+		case ch <- sseEvent{name: name, data: data}:
+		default:
+		}
+	}
+}
+
+// publishWrite reports a completed write to stream clients
+func publishWrite(field string, value float64, o origin) {
+	sseMu.Lock()
+	defer sseMu.Unlock()
+	publishSSE("write", sseWrite{field, value, o.Source, o.Client, time.Now()})
+}
+
+// publishConnection tells stream clients that the connection state changed
+func publishConnection(c sseConnection) {
+	sseMu.Lock()
+	defer sseMu.Unlock()
+	publishSSE("connection", c)
+}
+
+func splitFields(v interface{}) (snapshotFields, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields snapshotFields
+	err = json.Unmarshal(data, &fields)
+	return fields, err
+}
+
+// diffFields returns the fields of cur that differ from prev
+func diffFields(prev, cur snapshotFields) snapshotFields {
+	out := snapshotFields{}
+	for k, v := range cur {
+		if !bytes.Equal(prev[k], v) {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// handleStream serves the SSE stream; ?full=1 sends full snapshots only.
+// A "connection" event with the current state is sent first.
+func handleStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	// the stream outlives -http-write-timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("stream: %v", err)
+	}
+	fullOnly := r.URL.Query().Get("full") == "1"
+
+	ch := make(chan sseEvent, 8)
+	sseMu.Lock()
+	sseClients[ch] = true
+	sseMu.Unlock()
+	conn, connected := currentConnection()
+	defer func() {
+		sseMu.Lock()
+		delete(sseClients, ch)
+		sseMu.Unlock()
+	}()
+
+	fmt.Fprintf(w, "retry: 5000\n\n")
+	if connected {
+		data, _ := json.Marshal(conn)
+		fmt.Fprintf(w, "event: connection\ndata: %s\n\n", data)
+	}
+	flusher.Flush()
+
+	var last snapshotFields
+	n := 0
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-ch:
+			if ev.name != "" {
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.name, ev.data); err != nil {
+					return
+				}
+				flusher.Flush()
+				continue
+			}
+			cur := ev.fields
+			event, payload := "snapshot", cur
+			if last != nil && !fullOnly && n%sseFullEvery != 0 {
+				event, payload = "delta", diffFields(last, cur)
+			}
+			last = cur
+			n++
+			if event == "delta" && len(payload) == 0 {
+				continue
+			}
+			data, err := json.Marshal(payload)
+			if err != nil {
+				log.Printf("encode stream event: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
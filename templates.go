@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+)
+
+// notifyData is what notification templates can refer to:
+//
+//	{{.Event.Type}} {{.Event.Source}} {{.Event.Data.button}}
+//	{{.Snapshot.TempIndoor}} {{index .Snapshot.AlfaCo2 0}}
+//	{{localTime .Event.Time "15:04"}}
+type notifyData struct {
+	Event    Event
+	Snapshot futura.InputRegs // last polled values
+}
+
+var templateFuncs = template.FuncMap{
+	"localTime": func(t time.Time, layout string) string { return t.In(appLocation).Format(layout) },
+}
+
+// cachedTemplate is a template given inline or in a file; file templates are
+// re-read when the file changes, so wording can be adjusted without a restart
+type cachedTemplate struct {
+	mu    sync.Mutex
+	tmpl  *template.Template
+	mtime time.Time
+}
+
+var (
+	templateCacheMu sync.Mutex
+	templateCache   = map[string]*cachedTemplate{}
+)
+
+// compileTemplate parses an inline template or the template file
+func compileTemplate(name, inline, file string) (*template.Template, error) {
+	text := inline
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		text = string(data)
+	}
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// renderTemplate renders the template of a channel (name identifies the
+// channel in the cache). It returns false when no template is configured.
+func renderTemplate(name, inline, file string, ev Event) (string, bool, error) {
+	if inline == "" && file == "" {
+		return "", false, nil
+	}
+	templateCacheMu.Lock()
+	c := templateCache[name]
+	if c == nil {
+		c = &cachedTemplate{}
+		templateCache[name] = c
+	}
+	templateCacheMu.Unlock()
+
+	c.mu.Lock()
+	if file != "" {
+		fi, err := os.Stat(file)
+		if err != nil {
+			c.mu.Unlock()
+			return "", true, err
+		}
+		if !fi.ModTime().Equal(c.mtime) {
+			c.tmpl = nil
+			c.mtime = fi.ModTime()
+		}
+	}
+	if c.tmpl == nil {
+		t, err := compileTemplate(name, inline, file)
+		if err != nil {
+			c.mu.Unlock()
+			return "", true, err
+		}
+		c.tmpl = t
+	}
+	tmpl := c.tmpl
+	c.mu.Unlock()
+
+	data := notifyData{Event: ev}
+	if e := rules(); e != nil {
+		data.Snapshot, _ = e.Snapshot()
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", true, err
+	}
+	return buf.String(), true, nil
+}
+
+// validateTemplate checks a channel template at config load
+func validateTemplate(what, inline, file string) error {
+	if inline != "" && file != "" {
+		return fmt.Errorf("%s: template and template_file are exclusive", what)
+	}
+	if inline == "" && file == "" {
+		return nil
+	}
+	if _, err := compileTemplate(what, inline, file); err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	EventAuthLockout = "auth_lockout"
+)
+
+// Failed token attempts are counted per client IP. After authMaxFailures
+// failures within authFailureWindow the IP is locked out for authLockout,
+// and an auth_lockout event is sent to the notification channels.
+const (
+	authMaxFailures   = 5
+	authFailureWindow = 10 * time.Minute
+	authLockout       = 15 * time.Minute
+)
+
+type authAttempts struct {
+	failures    int
+	first       time.Time // start of the current failure window
+	lockedUntil time.Time
+}
+
+var (
+	authMu       sync.Mutex
+	authFailures = map[string]*authAttempts{}
+)
+
+// clientIP returns the remote address of a request without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// authLocked reports whether the client is currently locked out and, if so,
+// writes a 429 response
+func authLocked(w http.ResponseWriter, r *http.Request) bool {
+	ip := clientIP(r)
+	authMu.Lock()
+	a := authFailures[ip]
+	locked := a != nil && time.Now().Before(a.lockedUntil)
+	var retry time.Duration
+	if locked {
+		retry = time.Until(a.lockedUntil)
+	}
+	authMu.Unlock()
+
+	if !locked {
+		return false
+	}
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(retry.Seconds())+1))
+	w.WriteHeader(http.StatusTooManyRequests)
+	fmt.Fprintf(w, `{"success":false,"error":"too many failed attempts"}`)
+	return true
+}
+
+// authFailed records a failed attempt of the given kind (e.g. "intent token")
+func authFailed(r *http.Request, kind string) {
+	ip := clientIP(r)
+	now := time.Now()
+
+	authMu.Lock()
+	a := authFailures[ip]
+	if a == nil || now.Sub(a.first) > authFailureWindow {
+		a = &authAttempts{first: now}
+		authFailures[ip] = a
+	}
+	a.failures++
+	lockout := a.failures >= authMaxFailures && !now.Before(a.lockedUntil)
+	if lockout {
+		a.lockedUntil = now.Add(authLockout)
+		a.failures = 0
+		a.first = now
+	}
+	// forget idle entries so the map doesn't grow with scanners
+	for k, v := range authFailures {
+		if now.Sub(v.first) > authFailureWindow && now.After(v.lockedUntil) {
+			delete(authFailures, k)
+		}
+	}
+	authMu.Unlock()
+
+	log.Printf("Failed %s from %s", kind, ip)
+	if lockout {
+		log.Printf("Locking out %s for %s after %d failed attempts", ip, authLockout, authMaxFailures)
+		emitEvent(Event{
+			Type:   EventAuthLockout,
+			Source: ip,
+			Data: map[string]interface{}{
+				"kind":     kind,
+				"failures": authMaxFailures,
+				"until":    now.Add(authLockout),
+			},
+		})
+	}
+}
+
+// authSucceeded clears the failure count of the client
+func authSucceeded(r *http.Request) {
+	authMu.Lock()
+	defer authMu.Unlock()
+	if a := authFailures[clientIP(r)]; a != nil && time.Now().After(a.lockedUntil) {
+		delete(authFailures, clientIP(r))
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuthLockout(t *testing.T) {
+	reset := func() {
+		authMu.Lock()
+		authFailures = map[string]*authAttempts{}
+		authMu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+
+	req := func(addr string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/intent", nil)
+		r.RemoteAddr = addr
+		return r
+	}
+	locked := func(addr string) bool {
+		return authLocked(httptest.NewRecorder(), req(addr))
+	}
+
+	tests := []struct {
+		name     string
+		failures int
+		success  bool // an authenticated request after the failures
+		locked   bool
+	}{
+		{"below the limit", authMaxFailures - 1, false, false},
+		{"at the limit", authMaxFailures, false, true},
+		{"success resets the count", authMaxFailures - 1, true, false},
+		{"success doesn't lift a lockout", authMaxFailures, true, true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := fmt.Sprintf("192.0.2.%d:4000", i+1)
+			for range tt.failures {
+				authFailed(req(addr), "test token")
+			}
+			if tt.success {
+				authSucceeded(req(addr))
+				if !tt.locked {
+					authFailed(req(addr), "test token")
+				}
+			}
+			if got := locked(addr); got != tt.locked {
+				t.Fatalf("locked = %v, want %v", got, tt.locked)
+			}
+		})
+	}
+
+	t.Run("other clients unaffected", func(t *testing.T) {
+		if locked("198.51.100.1:4000") {
+			t.Fatal("client without failures is locked")
+		}
+	})
+
+	// 192.0.2.2 was locked out "at the limit"
+	t.Run("response", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		if !authLocked(w, req("192.0.2.2:5000")) {
+			t.Fatal("locked client not refused")
+		}
+		if w.Code != http.StatusTooManyRequests {
+			t.Errorf("status %d, want 429", w.Code)
+		}
+		if ra := w.Header().Get("Retry-After"); ra == "" || ra == "0" {
+			t.Errorf("Retry-After = %q", ra)
+		}
+	})
+
+	t.Run("expired lockout", func(t *testing.T) {
+		authMu.Lock()
+		authFailures["192.0.2.2"].lockedUntil = time.Now().Add(-time.Second)
+		authMu.Unlock()
+		if locked("192.0.2.2:4000") {
+			t.Fatal("still locked after the lockout ended")
+		}
+	})
+}
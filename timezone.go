@@ -0,0 +1,51 @@
+package main
+
+import (
+	"time"
+	_ "time/tzdata" // -timezone must work on hosts without a zoneinfo database
+)
+
+// appLocation is the time zone of schedules, the away period and reports
+// (-timezone, default the system zone)
+var appLocation = time.Local
+
+func loadTimezone(name string) error {
+	if name == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return err
+	}
+	appLocation = loc
+	return nil
+}
+
+// parseLocalTime accepts RFC3339 or a wall-clock time without offset
+// (2006-01-02T15:04), which is taken in appLocation. Wall-clock times that
+// are skipped or repeated by a DST change resolve to one of the two offsets.
+func parseLocalTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02T15:04", v, appLocation)
+}
+
+// localNow returns the current time in appLocation
+func localNow() time.Time {
+	return time.Now().In(appLocation)
+}
+
+// startOfDay returns local midnight of the day of t. Days are 23 or 25 hours
+// long around DST changes, so callers must not add 24h to get the next day.
+func startOfDay(t time.Time) time.Time {
+	t = t.In(appLocation)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, appLocation)
+}
+
+// secondsOfDay returns the wall-clock time of t in seconds after midnight;
+// unlike t.Sub(startOfDay(t)) it maps 07:00 to the same value on DST days
+func secondsOfDay(t time.Time) int64 {
+	t = t.In(appLocation)
+	return int64(t.Hour()*3600 + t.Minute()*60 + t.Second())
+}
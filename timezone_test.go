@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// DST changes in Europe/Prague: 2025-03-30 02:00 CET -> 03:00 CEST and
+// 2025-10-26 03:00 CEST -> 02:00 CET, both on a Sunday
+
+func pragueLocation(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("Europe/Prague")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := appLocation
+	appLocation = loc
+	t.Cleanup(func() { appLocation = prev })
+	return loc
+}
+
+func TestScheduleLevelAtDST(t *testing.T) {
+	pragueLocation(t)
+	s := VentilationSchedule{}
+	for h := 0; h < 24; h++ {
+		s.Weekday[h] = 1
+		s.Weekend[h] = float64(h%5 + 1)
+	}
+	smooth := s
+	smooth.Smooth = true
+
+	tests := []struct {
+		name  string
+		s     VentilationSchedule
+		utc   string
+		level uint16
+	}{
+		{"spring: 01:30 CET", s, "2025-03-30T00:30:00Z", 2},
+		{"spring: 03:00 CEST right after the skipped hour", s, "2025-03-30T01:00:00Z", 4},
+		{"spring: 03:30 CEST", s, "2025-03-30T01:30:00Z", 4},
+		{"spring: 23:30 CEST, next day a weekday", s, "2025-03-30T21:30:00Z", 4},
+		{"spring smooth: 23:30 CEST towards Monday 00:00", smooth, "2025-03-30T21:30:00Z", 3},
+		{"fall: first 02:30 CEST", s, "2025-10-26T00:30:00Z", 3},
+		{"fall: repeated 02:30 CET", s, "2025-10-26T01:30:00Z", 3},
+		{"fall: 03:00 CET", s, "2025-10-26T02:00:00Z", 4},
+		{"fall: Saturday 23:00 CEST", s, "2025-10-25T21:00:00Z", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, err := time.Parse(time.RFC3339, tt.utc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.s.LevelAt(at); got != tt.level {
+				t.Errorf("LevelAt(%s) = %d, want %d", at.In(appLocation), got, tt.level)
+			}
+		})
+	}
+}
+
+func TestMonthReportKeyDST(t *testing.T) {
+	pragueLocation(t)
+	tests := []struct {
+		utc string
+		key string
+	}{
+		{"2025-03-30T00:30:00Z", "2025-03"}, // 01:30 CET, before spring-forward
+		{"2025-03-30T01:30:00Z", "2025-03"}, // 03:30 CEST
+		{"2025-03-31T21:59:00Z", "2025-03"}, // 23:59 CEST
+		{"2025-03-31T22:00:00Z", "2025-04"}, // midnight CEST
+		{"2025-10-26T00:30:00Z", "2025-10"}, // first 02:30
+		{"2025-10-26T01:30:00Z", "2025-10"}, // repeated 02:30
+		{"2025-10-31T22:59:00Z", "2025-10"}, // 23:59 CET
+		{"2025-10-31T23:00:00Z", "2025-11"}, // midnight CET
+	}
+	for _, tt := range tests {
+		at, err := time.Parse(time.RFC3339, tt.utc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reportMu.Lock()
+		got := monthReport(at).Month
+		reportMu.Unlock()
+		if got != tt.key {
+			t.Errorf("monthReport(%s) = %s, want %s", tt.utc, got, tt.key)
+		}
+	}
+}
+
+func TestHistoryCompareOffsetsDST(t *testing.T) {
+	loc := pragueLocation(t)
+	tests := []struct {
+		name string
+		day  time.Time
+		want []int64 // wall-clock seconds after midnight
+	}{
+		{
+			// 23 hours: 02:00-02:59 doesn't exist
+			"spring-forward",
+			time.Date(2025, 3, 30, 0, 0, 0, 0, loc),
+			[]int64{0, 3600, 3 * 3600, 4 * 3600},
+		},
+		{
+			// 25 hours: the repeated 02:00 is dropped
+			"fall-back",
+			time.Date(2025, 10, 26, 0, 0, 0, 0, loc),
+			[]int64{0, 3600, 2 * 3600, 3 * 3600, 4 * 3600},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := startOfDay(tt.day.Add(12 * time.Hour)); !got.Equal(tt.day) {
+				t.Fatalf("startOfDay = %s, want %s", got, tt.day)
+			}
+			// a point every real hour from midnight until 04:00 local
+			var pts []HistoryPoint
+			end := time.Date(tt.day.Year(), tt.day.Month(), tt.day.Day(), 4, 0, 0, 0, loc)
+			for at := tt.day; !at.After(end); at = at.Add(time.Hour) {
+				pts = append(pts, HistoryPoint{Time: at.Unix(), Value: 1})
+			}
+			got := offsetPoints(pts)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d points %v, want %v", len(got), got, tt.want)
+			}
+			for i, p := range got {
+				if p.Time != tt.want[i] {
+					t.Errorf("point %d at %d s, want %d s", i, p.Time, tt.want[i])
+				}
+			}
+		})
+	}
+}
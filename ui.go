@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Embedded UI files are also served under fingerprinted names with a hash of
+// their content, e.g. /static/img_futura_ventilation.3f9a0c12e4.png, which
+// browsers may cache for a year: an upgrade changes the name. The plain names
+// carry the hash as ETag and are revalidated on every load.
+
+const immutableCache = "public, max-age=31536000, immutable"
+
+var (
+	assetHashes   = map[string]string{} // file name -> content hash
+	fingerprinted = map[string]string{} // fingerprinted name -> file name
+)
+
+// fingerprintName inserts hash before the extension of name
+func fingerprintName(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// indexAssets hashes the embedded UI files
+func indexAssets(fsys fs.FS) error {
+	return fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:5])
+		assetHashes[p] = hash
+		fingerprinted[fingerprintName(p, hash)] = p
+		return nil
+	})
+}
+
+// assetURL returns the URL of a UI file, fingerprinted when it is embedded.
+// Templates call it as {{asset "name"}}.
+func assetURL(name string) string {
+	if hash, ok := assetHashes[name]; ok {
+		return "/static/" + fingerprintName(name, hash)
+	}
+	return "/static/" + name
+}
+
+// uiFS returns the filesystem the web UI is served from: the -ui-dir
+// directory when set, otherwise the files embedded in the binary.
+func uiFS() (fs.FS, error) {
+	if *flagUIDir != "" {
+		st, err := os.Stat(*flagUIDir)
+		if err != nil {
+			return nil, err
+		}
+		if !st.IsDir() {
+			return nil, &fs.PathError{Op: "open", Path: *flagUIDir, Err: fs.ErrInvalid}
+		}
+		return os.DirFS(*flagUIDir), nil
+	}
+	return fs.Sub(staticFiles, "static")
+}
+
+// templatePages maps the UI files that are templates to the pages rendering
+// them. Their raw text isn't served; "" is the directory listing, which the
+// file server would answer with index.html.
+var templatePages = map[string]string{"": "/", "index.html": "/", "edit.html": "/edit"}
+
+// uiHandler serves UI assets. Files served from disk are never cached so
+// edits show up on the next load.
+func uiHandler(fsys fs.FS) http.Handler {
+	files := http.FileServer(http.FS(fsys))
+	if *flagUIDir != "" {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if page, ok := templatePages[strings.TrimPrefix(r.URL.Path, "/")]; ok {
+				http.Redirect(w, r, page, http.StatusMovedPermanently)
+				return
+			}
+			w.Header().Set("Cache-Control", "no-cache")
+			files.ServeHTTP(w, r)
+		})
+	}
+	if err := indexAssets(fsys); err != nil {
+		log.Printf("hash ui files: %v", err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		if n, ok := fingerprinted[name]; ok {
+			name = n
+		}
+		if page, ok := templatePages[name]; ok {
+			http.Redirect(w, r, page, http.StatusMovedPermanently)
+			return
+		}
+		if name, ok := fingerprinted[r.URL.Path]; ok {
+			w.Header().Set("Cache-Control", immutableCache)
+			w.Header().Set("ETag", `"`+assetHashes[name]+`"`)
+			http.ServeFileFS(w, r, fsys, name)
+			return
+		}
+		if hash, ok := assetHashes[r.URL.Path]; ok {
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"`+hash+`"`)
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// handleUIVersion reports a version of the UI directory that changes whenever
+// a file in it is modified, so the page can reload itself (live reload).
+func handleUIVersion(fsys fs.FS) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		resp := struct {
+			Live    bool   `json:"live"`
+			Version string `json:"version"`
+		}{Live: *flagUIDir != ""}
+
+		if resp.Live {
+			var latest int64
+			err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				if t := info.ModTime().UnixNano(); t > latest {
+					latest = t
+				}
+				return nil
+			})
+			if err != nil {
+				log.Printf("scan ui dir: %v", err)
+			}
+			resp.Version = strconv.FormatInt(latest, 36)
+		}
+
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("encode ui version json: %v", err)
+		}
+	}
+}
+
+// editPageData is passed to the edit.html template
+type editPageData struct {
+	Dashboard []DashboardGroup
+}
+
+// uiPage is the template of a UI page, parsed once from the embedded files
+// or, with -ui-dir, on every request. Handlers share it concurrently.
+type uiPage struct {
+	name string
+	once sync.Once
+	tmpl *template.Template
+	err  error
+}
+
+var (
+	editPage  = &uiPage{name: "edit.html"}
+	indexPage = &uiPage{name: "index.html"}
+)
+
+func (p *uiPage) template(fsys fs.FS) (*template.Template, error) {
+	if *flagUIDir != "" {
+		return p.parse(fsys)
+	}
+	p.once.Do(func() { p.tmpl, p.err = p.parse(fsys) })
+	return p.tmpl, p.err
+}
+
+func (p *uiPage) parse(fsys fs.FS) (*template.Template, error) {
+	return template.New(p.name).Funcs(template.FuncMap{"asset": assetURL}).ParseFS(fsys, p.name)
+}
+
+// handleEdit renders the main UI page from the edit.html template. When the
+// UI is served from -ui-dir the template is re-parsed on every request.
+func handleEdit(fsys fs.FS) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tmpl, err := editPage.template(fsys)
+		if err != nil {
+			log.Printf("parse edit template: %v", err)
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		data := editPageData{Dashboard: appConfig().Dashboard}
+		if err := tmpl.Execute(w, data); err != nil {
+			log.Printf("render edit page: %v", err)
+		}
+	}
+}
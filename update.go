@@ -0,0 +1,298 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Self-update from GitHub releases. The release workflow publishes
+// gofutura_<os>_<arch>[.exe] with a .sha256 file next to it; the download
+// is only installed when it matches the checksum.
+
+const releasesURL = "https://api.github.com/repos/danielkucera/gofutura/releases/latest"
+
+// UpdatesConfig controls release checks of the running exporter
+type UpdatesConfig struct {
+	Check bool `yaml:"check"` // enable /api/version/check, which asks GitHub
+}
+
+// updateClient downloads release binaries, which take longer than a webhook
+var updateClient = &http.Client{Timeout: 5 * time.Minute}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+type release struct {
+	Tag    string         `json:"tag_name"`
+	URL    string         `json:"html_url"`
+	Assets []releaseAsset `json:"assets"`
+}
+
+func (r release) asset(name string) (releaseAsset, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return releaseAsset{}, false
+}
+
+// releaseAssetName is the binary of this platform in a release
+func releaseAssetName() string {
+	name := "gofutura_" + runtime.GOOS + "_" + runtime.GOARCH
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+func latestRelease() (release, error) {
+	var rel release
+	req, err := http.NewRequest(http.MethodGet, releasesURL, nil)
+	if err != nil {
+		return rel, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := updateClient.Do(req)
+	if err != nil {
+		return rel, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return rel, fmt.Errorf("GitHub returned %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rel); err != nil {
+		return rel, fmt.Errorf("decode release: %w", err)
+	}
+	return rel, nil
+}
+
+// compareVersions compares release tags like v1.2 and v1.10.3 numerically.
+// ok is false when either isn't a release version (e.g. dev builds).
+func compareVersions(a, b string) (cmp int, ok bool) {
+	pa, oka := versionParts(a)
+	pb, okb := versionParts(b)
+	if !oka || !okb {
+		return 0, false
+	}
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+func versionParts(v string) ([]int, bool) {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	var parts []int
+	for _, s := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, false
+		}
+		parts = append(parts, n)
+	}
+	return parts, true
+}
+
+// VersionCheck is returned by /api/version/check
+type VersionCheck struct {
+	Current         string    `json:"current"`
+	Latest          string    `json:"latest"`
+	UpdateAvailable bool      `json:"update_available"`
+	URL             string    `json:"url"`
+	Checked         time.Time `json:"checked"`
+}
+
+func checkVersion() (VersionCheck, error) {
+	rel, err := latestRelease()
+	if err != nil {
+		return VersionCheck{}, err
+	}
+	c := VersionCheck{Current: version, Latest: rel.Tag, URL: rel.URL, Checked: time.Now()}
+	cmp, ok := compareVersions(version, rel.Tag)
+	c.UpdateAvailable = ok && cmp < 0
+	return c, nil
+}
+
+// versionCheckEvery limits GitHub API calls (60 an hour without a token)
+const versionCheckEvery = time.Hour
+
+var (
+	versionCheckMu   sync.Mutex
+	versionCheckLast *VersionCheck
+)
+
+// handleVersionCheck reports whether a newer release exists
+func handleVersionCheck(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	versionCheckMu.Lock()
+	defer versionCheckMu.Unlock()
+	if versionCheckLast == nil || time.Since(versionCheckLast.Checked) > versionCheckEvery {
+		c, err := checkVersion()
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+			return
+		}
+		versionCheckLast = &c
+	}
+	if err := json.NewEncoder(w).Encode(versionCheckLast); err != nil {
+		log.Printf("encode version check json: %v", err)
+	}
+}
+
+// downloadVerified downloads a release binary to path and checks it against
+// the published SHA-256 checksum
+func downloadVerified(rel release, path string) error {
+	name := releaseAssetName()
+	bin, ok := rel.asset(name)
+	if !ok {
+		return fmt.Errorf("release %s has no %s", rel.Tag, name)
+	}
+	sum, ok := rel.asset(name + ".sha256")
+	if !ok {
+		return fmt.Errorf("release %s has no checksum for %s", rel.Tag, name)
+	}
+
+	resp, err := updateClient.Get(sum.URL)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("download checksum: %w", err)
+	}
+	fields := strings.Fields(string(data))
+	if resp.StatusCode != http.StatusOK || len(fields) == 0 {
+		return fmt.Errorf("download checksum: %s", resp.Status)
+	}
+	want := strings.ToLower(fields[0])
+
+	resp, err = updateClient.Get(bin.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download %s: %s", name, resp.Status)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o755)
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && hex.EncodeToString(h.Sum(nil)) != want {
+		err = errors.New("checksum mismatch")
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("download %s: %w", name, err)
+	}
+	return nil
+}
+
+// replaceExecutable installs the new binary in place of the running one.
+// Windows can't overwrite a running executable but can rename it.
+func replaceExecutable(exe, newPath string) error {
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(newPath, exe); err != nil {
+		os.Rename(old, exe)
+		return err
+	}
+	if runtime.GOOS != "windows" {
+		os.Remove(old)
+	}
+	return nil
+}
+
+func runUpdate(args []string) int {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	check := fs.Bool("check", false, "Only report whether a newer release exists")
+	force := fs.Bool("force", false, "Install the latest release even if it isn't newer (e.g. over a dev build)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gofutura update [-check] [-force]\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	rel, err := latestRelease()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "check release: %v\n", err)
+		return 1
+	}
+	cmp, comparable := compareVersions(version, rel.Tag)
+	switch {
+	case comparable && cmp >= 0 && !*force:
+		fmt.Printf("gofutura %s is up to date (latest %s)\n", version, rel.Tag)
+		return 0
+	case !comparable && !*force:
+		fmt.Printf("Latest release is %s; this is a %s build, use -force to replace it\n", rel.Tag, version)
+		return 0
+	}
+	if *check {
+		fmt.Printf("Update available: %s -> %s (%s)\n", version, rel.Tag, rel.URL)
+		return 0
+	}
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "locate executable: %v\n", err)
+		return 1
+	}
+	newPath := exe + ".new"
+	fmt.Printf("Downloading %s %s\n", rel.Tag, releaseAssetName())
+	if err := downloadVerified(rel, newPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := replaceExecutable(exe, newPath); err != nil {
+		os.Remove(newPath)
+		fmt.Fprintf(os.Stderr, "install %s: %v\n", exe, err)
+		return 1
+	}
+	fmt.Printf("Updated %s to %s; restart the service to run it\n", exe, rel.Tag)
+	return 0
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/simonvetter/modbus"
+)
+
+// VacationConfig tunes the vacation action
+type VacationConfig struct {
+	Ventilation uint16        `yaml:"ventilation"` // level kept while away (default 1)
+	Prearrival  time.Duration `yaml:"prearrival"`  // how long before return to restore settings (default 2h)
+	Boost       time.Duration `yaml:"boost"`       // boost run at pre-arrival (default 30m)
+}
+
+// vacationState is the active vacation, if any
+type vacationState struct {
+	Active     bool      `json:"active"`
+	Until      time.Time `json:"until,omitempty"`
+	Prearrival time.Time `json:"prearrival,omitempty"`
+
+	// settings restored at pre-arrival
+	restore map[string]float64
+	timer   *time.Timer
+}
+
+var (
+	vacationMu sync.Mutex
+	vacation   vacationState
+)
+
+func vacationSettings() VacationConfig {
+	c := appConfig().Vacation
+	if c.Ventilation == 0 {
+		c.Ventilation = 1
+	}
+	if c.Prearrival == 0 {
+		c.Prearrival = 2 * time.Hour
+	}
+	if c.Boost == 0 {
+		c.Boost = 30 * time.Minute
+	}
+	return c
+}
+
+// vacationActive reports whether the unit is in vacation mode; the
+// ventilation schedule is paused meanwhile
+func vacationActive() bool {
+	vacationMu.Lock()
+	defer vacationMu.Unlock()
+	return vacation.Active
+}
+
+// startVacation writes the away period, minimal ventilation and disables
+// heating/comfort, then arms the pre-arrival timer
+func startVacation(client *ModbusConn, until time.Time) error {
+	cfg := vacationSettings()
+	now := time.Now()
+	if !until.After(now.Add(cfg.Prearrival)) {
+		return fmt.Errorf("until must be more than %s in the future", cfg.Prearrival)
+	}
+
+	vacationMu.Lock()
+	defer vacationMu.Unlock()
+
+	restore := vacation.restore
+	if !vacation.Active {
+		holding := futura.DecodeHoldingMap(collectRanges(client, modbus.HOLDING_REGISTER, holdingRanges, runtimeMaxBlockSize))
+		restore = map[string]float64{
+			"FuncVentilation":  float64(holding.FuncVentilation),
+			"CfgHeatingEnable": float64(holding.CfgHeatingEnable),
+			"CfgComfortEnable": float64(holding.CfgComfortEnable),
+		}
+	}
+
+	away := map[uint16]uint16{}
+	away[futura.AddrHoldingFuncAwayBegin], away[futura.AddrHoldingFuncAwayBegin+1] = futura.SplitU32(uint32(now.Unix()))
+	away[futura.AddrHoldingFuncAwayEnd], away[futura.AddrHoldingFuncAwayEnd+1] = futura.SplitU32(uint32(until.Unix()))
+	if err := writeRegisters(client, away); err != nil {
+		return err
+	}
+	for field, value := range map[string]float64{
+		"FuncVentilation":  float64(cfg.Ventilation),
+		"CfgHeatingEnable": 0,
+		"CfgComfortEnable": 0,
+	} {
+		if err := WriteSingleRegister(client, field, value, origin{Source: "vacation"}); err != nil {
+			return err
+		}
+	}
+
+	if vacation.timer != nil {
+		vacation.timer.Stop()
+	}
+	prearrival := until.Add(-cfg.Prearrival)
+	vacation = vacationState{Active: true, Until: until.In(appLocation), Prearrival: prearrival.In(appLocation), restore: restore}
+	vacation.timer = time.AfterFunc(time.Until(prearrival), func() { vacationPrearrival(client) })
+	log.Printf("Vacation until %s, pre-arrival at %s", until.In(appLocation).Format(time.RFC3339), prearrival.In(appLocation).Format(time.RFC3339))
+	return nil
+}
+
+// vacationPrearrival ends the vacation with a boost, or retries later while
+// maintenance mode blocks writes
+func vacationPrearrival(client *ModbusConn) {
+	if maintenanceActive() {
+		vacationMu.Lock()
+		if vacation.Active {
+			vacation.timer = time.AfterFunc(time.Minute, func() { vacationPrearrival(client) })
+		}
+		vacationMu.Unlock()
+		return
+	}
+	endVacation(client, true)
+}
+
+// endVacation restores the saved settings; with boost it also starts a
+// pre-arrival boost run
+func endVacation(client *ModbusConn, boost bool) error {
+	vacationMu.Lock()
+	defer vacationMu.Unlock()
+
+	if !vacation.Active {
+		return nil
+	}
+	if vacation.timer != nil {
+		vacation.timer.Stop()
+	}
+	var firstErr error
+	for field, value := range vacation.restore {
+		if err := WriteSingleRegister(client, field, value, origin{Source: "vacation"}); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if cfg := vacationSettings(); boost && cfg.Boost > 0 {
+		if err := WriteSingleRegister(client, "FuncBoostTm", cfg.Boost.Seconds(), origin{Source: "vacation"}); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	vacation = vacationState{}
+	log.Printf("Vacation ended (pre-arrival boost: %v)", boost)
+	return firstErr
+}
+
+// handleVacation starts (POST {"until":"RFC3339 or local time"}), reports (GET) or
+// cancels (DELETE) vacation mode
+func handleVacation(client *ModbusConn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.Method {
+		case http.MethodGet:
+			vacationMu.Lock()
+			st := vacation
+			vacationMu.Unlock()
+			if err := json.NewEncoder(w).Encode(st); err != nil {
+				log.Printf("encode vacation json: %v", err)
+			}
+		case http.MethodPost:
+			var req struct {
+				Until string `json:"until"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				fmt.Fprintf(w, `{"success":false,"error":"Invalid JSON"}`)
+				return
+			}
+			until, err := parseLocalTime(req.Until)
+			if err != nil {
+				fmt.Fprintf(w, `{"success":false,"error":"until must be an RFC3339 or local YYYY-MM-DDTHH:MM time"}`)
+				return
+			}
+			if err := startVacation(client, until); err != nil {
+				log.Printf("Vacation error: %v", err)
+				fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+				return
+			}
+			fmt.Fprintf(w, `{"success":true,"message":"Vacation mode active"}`)
+		case http.MethodDelete:
+			if err := endVacation(client, false); err != nil {
+				fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+				return
+			}
+			fmt.Fprintf(w, `{"success":true,"message":"Vacation mode cancelled"}`)
+		default:
+			fmt.Fprintf(w, `{"success":false,"error":"GET, POST or DELETE required"}`)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/simonvetter/modbus"
+)
+
+// Read-back verification: the unit acknowledges some writes but stores a
+// different value, e.g. a setpoint clamped to its own limits. Written fields
+// are read back right away and a difference is reported as a
+// *WriteMismatchError.
+
+// countdownSlack is how far a countdown timer may run down between the write
+// and the read-back, in seconds
+const countdownSlack = 5
+
+// countdownFields count down on the unit once written
+var countdownFields = map[string]bool{
+	"FuncBoostTm":        true,
+	"FuncCirculationTm":  true,
+	"FuncOverpressureTm": true,
+	"FuncNightTm":        true,
+	"FuncPartyTm":        true,
+}
+
+var writeMismatchesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "futura_write_verify_mismatches_total",
+	Help: "Writes the unit stored with a different value than written, by field",
+}, []string{"field"})
+
+func RegisterVerifyMetrics() {
+	prometheus.MustRegister(writeMismatchesTotal)
+}
+
+// WriteMismatchError is a write the unit stored differently
+type WriteMismatchError struct {
+	Field  string  `json:"field"`
+	Wanted float64 `json:"wanted"`
+	Actual float64 `json:"actual"`
+}
+
+func (e *WriteMismatchError) Error() string {
+	return fmt.Sprintf("%s: unit stored %v instead of %v", e.Field, e.Actual, e.Wanted)
+}
+
+// WriteMismatches are the mismatches of a multi-field write
+type WriteMismatches []*WriteMismatchError
+
+func (m WriteMismatches) Error() string {
+	msgs := make([]string, len(m))
+	for i, e := range m {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// decodeField turns a register of a field back into its value
+func decodeField(spec futura.WriteFieldSpec, word uint16) float64 {
+	v := float64(word)
+	if spec.Signed {
+		v = float64(int16(word))
+	}
+	// round away float noise such as 21.500000000000004
+	return math.Round(v*spec.Scale*1000) / 1000
+}
+
+// verifyField reads a written field back and compares it with encoded.
+// verified is false when the read failed; the write itself went through, so
+// that is no error.
+func verifyField(client *ModbusConn, name string, spec futura.WriteFieldSpec, encoded uint16) (mismatch *WriteMismatchError, verified bool) {
+	regs, err := client.ReadRegisters(spec.Addr, 1, modbus.HOLDING_REGISTER)
+	if err != nil {
+		log.Printf("Read-back of %s failed: %v", name, err)
+		return nil, false
+	}
+	actual := regs[0]
+	if actual == encoded || (countdownFields[name] && actual < encoded && encoded-actual <= countdownSlack) {
+		return nil, true
+	}
+	writeMismatchesTotal.WithLabelValues(name).Inc()
+	e := &WriteMismatchError{name, decodeField(spec, encoded), decodeField(spec, actual)}
+	log.Printf("Write verification failed: %v", e)
+	return e, true
+}
+
+// verifyFields reads written fields back, for writes of several registers
+// at once; verified is false when any of them couldn't be read
+func verifyFields(client *ModbusConn, fields []string, encoded map[uint16]uint16) (verified bool, err error) {
+	var out WriteMismatches
+	verified = true
+	for _, f := range fields {
+		spec, ok := futura.WriteableFields[f]
+		if !ok || spec.RegCount != 1 {
+			continue
+		}
+		e, ok := verifyField(client, f, spec, encoded[spec.Addr])
+		if e != nil {
+			out = append(out, e)
+		}
+		verified = verified && ok
+	}
+	if len(out) == 0 {
+		return verified, nil
+	}
+	return verified, out
+}
+
+// writeMismatchResponse reports a write the unit stored differently; it
+// returns false when err is no mismatch
+func writeMismatchResponse(w http.ResponseWriter, err error) bool {
+	var m WriteMismatches
+	switch e := err.(type) {
+	case *WriteMismatchError:
+		m = WriteMismatches{e}
+	case WriteMismatches:
+		m = e
+	default:
+		return false
+	}
+	resp := struct {
+		Success    bool            `json:"success"`
+		Verified   bool            `json:"verified"`
+		Error      string          `json:"error"`
+		Mismatches WriteMismatches `json:"mismatches"`
+	}{false, false, m.Error(), m}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("encode write mismatch json: %v", err)
+	}
+	return true
+}
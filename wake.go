@@ -0,0 +1,118 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Wake-on-demand polling: with -idle-poll-interval the exporter polls slowly
+// while nobody looks at the data, which spares the unit's LAN module, and
+// goes back to -poll-interval as soon as a client shows up.
+
+// activityWindow is how long a request keeps polling fast
+const activityWindow = 2 * time.Minute
+
+var (
+	lastActivity atomic.Int64 // unix nanoseconds of the last UI/API request
+	pollWake     = make(chan struct{}, 1)
+	wsConnected  atomic.Int64 // open WebSocket connections, subscribed or not
+
+	sseClientsGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "futura_sse_clients",
+		Help: "Clients connected to /api/stream",
+	}, func() float64 {
+		sseMu.Lock()
+		defer sseMu.Unlock()
+		return float64(len(sseClients))
+	})
+	wsClientsGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "futura_websocket_clients",
+		Help: "Clients connected to /api/ws",
+	}, func() float64 { return float64(wsConnected.Load()) })
+	wsSubscribersGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "futura_websocket_subscribers",
+		Help: "WebSocket clients subscribed to poll updates",
+	}, func() float64 {
+		wsMu.Lock()
+		defer wsMu.Unlock()
+		return float64(len(wsSubscribers))
+	})
+	pollingIdleGauge = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "futura_polling_idle",
+		Help: "1 while the slower -idle-poll-interval applies",
+	}, func() float64 {
+		if pollingIdle() {
+			return 1
+		}
+		return 0
+	})
+)
+
+func RegisterActivityMetrics() {
+	prometheus.MustRegister(sseClientsGauge, wsClientsGauge, wsSubscribersGauge, pollingIdleGauge)
+}
+
+// noteActivity records a client request and wakes an idle poll loop
+func noteActivity() {
+	wasIdle := pollingIdle()
+	lastActivity.Store(time.Now().UnixNano())
+	if wasIdle {
+		select {
+		case pollWake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// pollingIdle reports whether the slow interval applies: it is configured,
+// no rules or digital inputs need every poll, no stream or WebSocket client
+// is subscribed and there was no request recently
+func pollingIdle() bool {
+	if *flagIdlePoll <= 0 {
+		return false
+	}
+	if cfg := appConfig(); len(cfg.Rules) > 0 || len(cfg.DigitalInputs) > 0 {
+		return false
+	}
+	wsMu.Lock()
+	subscribers := len(wsSubscribers)
+	wsMu.Unlock()
+	sseMu.Lock()
+	subscribers += len(sseClients)
+	sseMu.Unlock()
+	if subscribers > 0 {
+		return false
+	}
+	return time.Since(time.Unix(0, lastActivity.Load())) > activityWindow
+}
+
+// waitNextPoll sleeps for the current poll interval or until woken
+func waitNextPoll() {
+	interval := *flagPollInterval
+	if pollingIdle() {
+		interval = *flagIdlePoll
+	}
+	t := time.NewTimer(nextPollDelay(interval, time.Now().In(appLocation)))
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-pollWake:
+		log.Printf("Client activity, polling every %s again", *flagPollInterval)
+	}
+}
+
+// trackActivity counts UI and API requests as activity. Metrics scrapes
+// don't count; they export whatever was polled last.
+func trackActivity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") || strings.HasPrefix(r.URL.Path, "/edit") || strings.HasPrefix(r.URL.Path, "/static/") {
+			noteActivity()
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/simonvetter/modbus"
+)
+
+// watch-raw polls a few registers quickly and prints only the ones that
+// changed, e.g. to see which registers the wall panel writes.
+
+func runWatchRaw(args []string) int {
+	fs := flag.NewFlagSet("watch-raw", flag.ExitOnError)
+	fs.StringVar(flagConfig, "config", "", "Config file to take device.host from")
+	fs.StringVar(flagUnitHost, "host", "", "Modbus host or IP (default: device.host from the config)")
+	fs.UintVar(flagUnitPort, "port", 502, "Modbus port")
+	fs.UintVar(flagSlaveID, "slave-id", 1, "Modbus slave ID (0-255)")
+	typ := fs.String("type", "holding", "Register type: input or holding")
+	rangeList := fs.String("range", "", "Registers to watch, e.g. 0:30 or 0:30,100:120 (required)")
+	interval := fs.Duration("interval", 500*time.Millisecond, "Time between reads")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gofutura watch-raw [-host HOST] [-type input|holding] -range START:END[,...]\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var regType modbus.RegType
+	switch *typ {
+	case "input":
+		regType = modbus.INPUT_REGISTER
+	case "holding":
+		regType = modbus.HOLDING_REGISTER
+	default:
+		fmt.Fprintf(os.Stderr, "invalid -type %q (input or holding)\n", *typ)
+		return 2
+	}
+	if *rangeList == "" {
+		fs.Usage()
+		return 2
+	}
+	ranges, err := parseRanges(strings.ReplaceAll(*rangeList, ":", "-"))
+	if err == nil {
+		err = validateRanges(*typ, ranges, ^uint16(0))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	cfg, err := loadConfig(*flagConfig, *flagSecretKeyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	applyDeviceConfig(fs, cfg.Device)
+	if *flagUnitHost == "" || *flagSlaveID > 255 || *interval <= 0 {
+		fmt.Fprintln(os.Stderr, "-host (or device.host), a slave id of 0-255 and a positive -interval are required")
+		return 2
+	}
+
+	mc, err := modbus.NewClient(&modbus.ClientConfiguration{
+		URL:     fmt.Sprintf("tcp://%s:%d", *flagUnitHost, *flagUnitPort),
+		Timeout: 5 * time.Second,
+	})
+	if err == nil {
+		err = mc.SetUnitId(uint8(*flagSlaveID))
+	}
+	if err == nil {
+		err = mc.Open()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connect %s: %v\n", *flagUnitHost, err)
+		return 3
+	}
+	client := NewModbusConn(mc)
+	defer client.Close()
+
+	var prev map[uint16]uint16
+	for ; ; time.Sleep(*interval) {
+		cur := map[uint16]uint16{}
+		var readErr error
+		for _, r := range ranges {
+			if readErr = readRange(client, regType, r[0], r[1], 125, cur); readErr != nil {
+				break
+			}
+		}
+		if readErr != nil {
+			fmt.Fprintf(os.Stderr, "%s %v, reconnecting\n", time.Now().Format("15:04:05.000"), readErr)
+			client.Reopen(time.Second)
+			continue
+		}
+		if prev == nil {
+			fmt.Printf("Watching %d %s registers, printing changes\n", len(cur), *typ)
+		} else {
+			printRawChanges(*typ, prev, cur, ranges)
+		}
+		prev = cur
+	}
+}
+
+// printRawChanges prints the registers that differ between two reads, in
+// address order
+func printRawChanges(typ string, prev, cur map[uint16]uint16, ranges [][]uint16) {
+	now := time.Now().Format("15:04:05.000")
+	for _, r := range ranges {
+		for addr := int(r[0]); addr <= int(r[1]); addr++ {
+			a := uint16(addr)
+			old, v := prev[a], cur[a]
+			if old == v {
+				continue
+			}
+			line := fmt.Sprintf("%s %s %5d: %5d -> %5d  (0x%04X -> 0x%04X)", now, typ, a, old, v, old, v)
+			if old >= 0x8000 || v >= 0x8000 {
+				line += fmt.Sprintf("  i16 %d -> %d", int16(old), int16(v))
+			}
+			fmt.Println(line)
+		}
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Web Push: browsers that subscribed on the web UI get events as system
+// notifications, even with the page closed. Messages are encrypted per
+// RFC 8291 (aes128gcm) and the push services are told who sends them with a
+// VAPID key (RFC 8292), generated on first use and kept in the store file
+// together with the subscriptions.
+
+// WebPushConfig enables Web Push notifications
+type WebPushConfig struct {
+	Subject string   `yaml:"subject"` // contact for the push services (mailto: or https: URL); push is off without it
+	Store   string   `yaml:"store"`   // file with the subscriptions and the VAPID key (empty = memory only, lost on restart)
+	Events  []string `yaml:"events"`  // event types to send (default: defaultPushEvents)
+
+	Services []string `yaml:"services"` // push service hosts accepted besides defaultPushServices, e.g. a self-hosted one
+
+	Template     string `yaml:"template"`      // notification text template (default: defaultTelegramTemplate)
+	TemplateFile string `yaml:"template_file"` // or a file with it, re-read when changed
+}
+
+// pushTTL is how long a push service keeps a message for an offline browser
+const pushTTL = 24 * time.Hour
+
+// maxPushSubscriptions limits the browsers that can subscribe; every event
+// is sent to each of them
+const maxPushSubscriptions = 20
+
+// defaultPushEvents are sent without web_push.events: errors and alerts
+// only, not every setting or button
+var defaultPushEvents = []string{EventUnitError, EventUnitWarning, EventFilterDue, EventPowerAlarm, EventLANHung}
+
+// defaultPushServices are the hosts, or with a leading dot the domains, of
+// the push services of the major browsers. Subscriptions to other endpoints
+// are refused so the exporter can't be made to post to arbitrary URLs.
+var defaultPushServices = []string{
+	"fcm.googleapis.com",                // Chrome, Edge on Android, Opera
+	"updates.push.services.mozilla.com", // Firefox
+	".push.apple.com",                   // Safari
+	".notify.windows.com",               // Edge on Windows
+}
+
+// PushSubscription is what the browser's PushManager.subscribe returns
+type PushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"` // browser public key, base64url
+		Auth   string `json:"auth"`   // authentication secret, base64url
+	} `json:"keys"`
+}
+
+// pushStore is the content of the store file
+type pushStore struct {
+	VAPIDKey      string             `json:"vapid_key"` // base64 SEC 1 DER
+	Subscriptions []PushSubscription `json:"subscriptions"`
+}
+
+var (
+	pushMu     sync.Mutex
+	pushKey    *ecdsa.PrivateKey // nil while push is off
+	pushSubs   []PushSubscription
+	pushB64    = base64.RawURLEncoding
+	errPushOff = errors.New("web push is not configured (web_push.subject)")
+)
+
+func (c WebPushConfig) validate() error {
+	if c.Subject == "" {
+		return nil
+	}
+	u, err := url.Parse(c.Subject)
+	if err != nil || (u.Scheme != "mailto" && u.Scheme != "https") {
+		return fmt.Errorf("web_push.subject must be a mailto: or https: URL")
+	}
+	return validateTemplate("web_push", c.Template, c.TemplateFile)
+}
+
+// initWebPush loads the store or creates a VAPID key
+func initWebPush() error {
+	cfg := appConfig().WebPush
+	if cfg.Subject == "" {
+		return nil
+	}
+	var st pushStore
+	if cfg.Store != "" {
+		data, err := os.ReadFile(cfg.Store)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &st); err != nil {
+				return fmt.Errorf("%s: %w", cfg.Store, err)
+			}
+		}
+	}
+
+	pushMu.Lock()
+	defer pushMu.Unlock()
+	pushSubs = st.Subscriptions
+	if st.VAPIDKey != "" {
+		der, err := base64.StdEncoding.DecodeString(st.VAPIDKey)
+		if err != nil {
+			return fmt.Errorf("%s: vapid_key: %w", cfg.Store, err)
+		}
+		if pushKey, err = x509.ParseECPrivateKey(der); err != nil {
+			return fmt.Errorf("%s: vapid_key: %w", cfg.Store, err)
+		}
+		return nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	pushKey = key
+	log.Printf("Generated a new Web Push key; browsers have to subscribe again")
+	return savePushStore()
+}
+
+// savePushStore writes the key and subscriptions. pushMu must be held.
+func savePushStore() error {
+	path := appConfig().WebPush.Store
+	if path == "" {
+		return nil
+	}
+	der, err := x509.MarshalECPrivateKey(pushKey)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(pushStore{base64.StdEncoding.EncodeToString(der), pushSubs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// pushEvents returns the event types sent to the browsers
+func (c WebPushConfig) pushEvents() []string {
+	if len(c.Events) == 0 {
+		return defaultPushEvents
+	}
+	return c.Events
+}
+
+// knownPushService reports whether host belongs to one of the accepted push
+// services
+func knownPushService(host string, extra []string) bool {
+	host = strings.ToLower(host)
+	for _, s := range append(append([]string(nil), defaultPushServices...), extra...) {
+		s = strings.ToLower(s)
+		if host == s || (strings.HasPrefix(s, ".") && strings.HasSuffix(host, s)) {
+			return true
+		}
+	}
+	return false
+}
+
+// pushPublicKey returns the VAPID public key as the browser wants it
+func pushPublicKey() (string, error) {
+	if pushKey == nil {
+		return "", errPushOff
+	}
+	pub, err := pushKey.PublicKey.ECDH()
+	if err != nil {
+		return "", err
+	}
+	return pushB64.EncodeToString(pub.Bytes()), nil
+}
+
+// hkdf is HKDF-SHA256 (RFC 5869) for outputs of at most 32 bytes
+func hkdf(salt, ikm, info []byte, n int) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	prk := mac.Sum(nil)
+	mac = hmac.New(sha256.New, prk)
+	mac.Write(info)
+	mac.Write([]byte{1})
+	return mac.Sum(nil)[:n]
+}
+
+// encryptPush encrypts a message for a subscription (RFC 8291)
+func encryptPush(sub PushSubscription, msg []byte) ([]byte, error) {
+	uaRaw, err := pushB64.DecodeString(sub.Keys.P256dh)
+	if err != nil {
+		return nil, fmt.Errorf("p256dh: %w", err)
+	}
+	auth, err := pushB64.DecodeString(sub.Keys.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("auth: %w", err)
+	}
+	uaPub, err := ecdh.P256().NewPublicKey(uaRaw)
+	if err != nil {
+		return nil, fmt.Errorf("p256dh: %w", err)
+	}
+	asKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := asKey.ECDH(uaPub)
+	if err != nil {
+		return nil, err
+	}
+	asPub := asKey.PublicKey().Bytes()
+
+	keyInfo := append(append([]byte("WebPush: info\x00"), uaRaw...), asPub...)
+	ikm := hkdf(auth, secret, keyInfo, 32)
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// a single record, ended by the 0x02 delimiter
+	sealed := gcm.Seal(nil, nonce, append(append([]byte(nil), msg...), 2), nil)
+
+	var body bytes.Buffer
+	body.Write(salt)
+	binary.Write(&body, binary.BigEndian, uint32(4096))
+	body.WriteByte(byte(len(asPub)))
+	body.Write(asPub)
+	body.Write(sealed)
+	return body.Bytes(), nil
+}
+
+// vapidAuth returns the Authorization header for a push service
+func vapidAuth(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	header := pushB64.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": appConfig().WebPush.Subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	signing := header + "." + pushB64.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(signing))
+	r, s, err := ecdsa.Sign(rand.Reader, pushKey, hash[:])
+	if err != nil {
+		return "", err
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	pub, err := pushPublicKey()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("vapid t=%s.%s, k=%s", signing, pushB64.EncodeToString(sig), pub), nil
+}
+
+// errPushGone means the browser unsubscribed; the subscription is dropped
+var errPushGone = errors.New("subscription expired")
+
+func sendPush(sub PushSubscription, msg []byte) error {
+	body, err := encryptPush(sub, msg)
+	if err != nil {
+		return err
+	}
+	auth, err := vapidAuth(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(pushTTL.Seconds())))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return errPushGone
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// pushMessage is the payload the service worker shows
+type pushMessage struct {
+	Title string    `json:"title"`
+	Body  string    `json:"body"`
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+}
+
+// notifyPush sends an event to all subscribed browsers
+func notifyPush(ev Event) {
+	cfg := appConfig().WebPush
+	tmpl := cfg.Template
+	if tmpl == "" && cfg.TemplateFile == "" {
+		tmpl = defaultTelegramTemplate
+	}
+	text, _, err := renderTemplate("web_push", tmpl, cfg.TemplateFile, ev)
+	if err != nil {
+		log.Printf("web push: %v", err)
+		return
+	}
+	msg, err := json.Marshal(pushMessage{"gofutura", text, ev.Type, ev.Time})
+	if err != nil {
+		log.Printf("web push: %v", err)
+		return
+	}
+
+	pushMu.Lock()
+	subs := append([]PushSubscription(nil), pushSubs...)
+	pushMu.Unlock()
+	// in parallel, so a slow push service doesn't delay the others; there
+	// are at most maxPushSubscriptions
+	var wg sync.WaitGroup
+	for _, sub := range subs {
+		wg.Add(1)
+		go func(sub PushSubscription) {
+			defer wg.Done()
+			err := sendPush(sub, msg)
+			if errors.Is(err, errPushGone) {
+				log.Printf("web push: %s unsubscribed, removing it", pushService(sub.Endpoint))
+				removePushSubscription(sub.Endpoint)
+			} else if err != nil {
+				log.Printf("web push to %s: %v", pushService(sub.Endpoint), err)
+			}
+		}(sub)
+	}
+	wg.Wait()
+}
+
+// pushService returns the host of an endpoint; the full URL identifies the
+// browser and isn't logged
+func pushService(endpoint string) string {
+	if u, err := url.Parse(endpoint); err == nil {
+		return u.Host
+	}
+	return "?"
+}
+
+func removePushSubscription(endpoint string) bool {
+	pushMu.Lock()
+	defer pushMu.Unlock()
+	for i, s := range pushSubs {
+		if s.Endpoint == endpoint {
+			pushSubs = append(pushSubs[:i:i], pushSubs[i+1:]...)
+			if err := savePushStore(); err != nil {
+				log.Printf("web push store: %v", err)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// handlePushKey returns the VAPID public key for PushManager.subscribe
+func handlePushKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	pushMu.Lock()
+	key, err := pushPublicKey()
+	pushMu.Unlock()
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"success":false,"error":%q}`, err.Error())
+		return
+	}
+	fmt.Fprintf(w, `{"public_key":%q}`, key)
+}
+
+// handlePushSubscribe adds (POST, the PushSubscription as JSON) or removes
+// (DELETE {"endpoint":"..."}) a browser
+func handlePushSubscribe(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var sub PushSubscription
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil || sub.Endpoint == "" {
+		fmt.Fprintf(w, `{"success":false,"error":"Invalid JSON"}`)
+		return
+	}
+	u, err := url.Parse(sub.Endpoint)
+	if err != nil || u.Scheme != "https" {
+		fmt.Fprintf(w, `{"success":false,"error":"endpoint must be an https URL"}`)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		if _, err := encryptPush(sub, nil); err != nil {
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, "invalid keys: "+err.Error())
+			return
+		}
+		pushMu.Lock()
+		defer pushMu.Unlock()
+		if pushKey == nil {
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, errPushOff.Error())
+			return
+		}
+		if !knownPushService(u.Hostname(), appConfig().WebPush.Services) {
+			log.Printf("Refused web push subscription to %s from %s", u.Hostname(), clientIP(r))
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, "unknown push service "+u.Hostname()+", add it to web_push.services")
+			return
+		}
+		replaced := false
+		for i, s := range pushSubs {
+			if s.Endpoint == sub.Endpoint {
+				pushSubs = append(pushSubs[:i:i], pushSubs[i+1:]...)
+				replaced = true
+				break
+			}
+		}
+		if !replaced && len(pushSubs) >= maxPushSubscriptions {
+			fmt.Fprintf(w, `{"success":false,"error":%q}`, fmt.Sprintf("too many subscriptions (at most %d), unsubscribe another device first", maxPushSubscriptions))
+			return
+		}
+		pushSubs = append(pushSubs, sub)
+		if err := savePushStore(); err != nil {
+			log.Printf("web push store: %v", err)
+		}
+		log.Printf("Web push subscription added (%s, %d in total)", pushService(sub.Endpoint), len(pushSubs))
+		fmt.Fprintf(w, `{"success":true}`)
+	case http.MethodDelete:
+		if !removePushSubscription(sub.Endpoint) {
+			fmt.Fprintf(w, `{"success":false,"error":"not subscribed"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"success":true}`)
+	default:
+		fmt.Fprintf(w, `{"success":false,"error":"POST or DELETE required"}`)
+	}
+}
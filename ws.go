@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/danielkucera/gofutura/futura"
+	"github.com/gorilla/websocket"
+	"github.com/simonvetter/modbus"
+)
+
+// JSON-RPC 2.0 over a single WebSocket, for wall tablets and other custom
+// UIs that would otherwise poll several REST endpoints.
+//
+// Methods:
+//
+//	read        {"type":"input"|"holding"}  current decoded registers
+//	write       {"Field": value, ...}       write single fields
+//	subscribe                               push "update" notifications after each poll
+//	unsubscribe
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"` // notifications only
+	Params  interface{}     `json:"params,omitempty"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// wsConn is one client connection; all writes go through send so only the
+// writer goroutine touches the socket
+type wsConn struct {
+	conn *websocket.Conn
+	send chan rpcMessage
+	req  *http.Request // upgrade request, for write permissions
+}
+
+var (
+	wsMu          sync.Mutex
+	wsSubscribers = map[*wsConn]bool{}
+)
+
+// publishUpdate pushes a polled snapshot to all subscribed clients. Slow
+// clients miss updates rather than stalling the poll loop.
+func publishUpdate(r futura.InputRegs) {
+	wsMu.Lock()
+	defer wsMu.Unlock()
+	for c := range wsSubscribers {
+		select {
+		case c.send <- rpcMessage{JSONRPC: "2.0", Method: "update", Params: r}:
+		default:
+		}
+	}
+}
+
+// handleWS serves the JSON-RPC WebSocket endpoint
+func handleWS(client *ModbusConn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("websocket upgrade: %v", err)
+			return
+		}
+		c := &wsConn{conn: conn, send: make(chan rpcMessage, 16), req: r}
+		done := make(chan struct{})
+		go c.writeLoop(done)
+
+		wsConnected.Add(1)
+		defer func() {
+			wsConnected.Add(-1)
+			wsMu.Lock()
+			delete(wsSubscribers, c)
+			wsMu.Unlock()
+			close(done)
+			conn.Close()
+		}()
+
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var req rpcRequest
+			if err := json.Unmarshal(data, &req); err != nil {
+				c.reply(nil, nil, &rpcError{rpcParseError, "parse error"})
+				continue
+			}
+			if req.JSONRPC != "2.0" || req.Method == "" {
+				c.reply(req.ID, nil, &rpcError{rpcInvalidRequest, "invalid request"})
+				continue
+			}
+			result, rerr := c.call(client, req)
+			if req.ID == nil {
+				continue // notification, no response
+			}
+			c.reply(req.ID, result, rerr)
+		}
+	}
+}
+
+// writeLoop sends queued messages; after a write error it keeps draining the
+// queue so senders never block until the read loop notices the closed socket
+func (c *wsConn) writeLoop(done chan struct{}) {
+	failed := false
+	for {
+		select {
+		case msg := <-c.send:
+			if failed {
+				continue
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := c.conn.WriteJSON(msg); err != nil {
+				failed = true
+				c.conn.Close()
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+func (c *wsConn) reply(id json.RawMessage, result interface{}, rerr *rpcError) {
+	msg := rpcMessage{JSONRPC: "2.0", ID: id, Result: result, Error: rerr}
+	if rerr == nil && result == nil {
+		msg.Result = struct{}{}
+	}
+	c.send <- msg
+}
+
+func (c *wsConn) call(client *ModbusConn, req rpcRequest) (interface{}, *rpcError) {
+	switch req.Method {
+	case "read":
+		var p struct {
+			Type string `json:"type"`
+		}
+		if len(req.Params) > 0 {
+			if err := json.Unmarshal(req.Params, &p); err != nil {
+				return nil, &rpcError{rpcInvalidParams, "invalid params"}
+			}
+		}
+		return rpcRead(client, p.Type)
+	case "write":
+		var params map[string]interface{}
+		if err := json.Unmarshal(req.Params, &params); err != nil || len(params) == 0 {
+			return nil, &rpcError{rpcInvalidParams, "params must be an object of field values"}
+		}
+		fields := make(map[string]float64, len(params))
+		for k, v := range params {
+			val, err := fieldValue(k, v)
+			if err != nil {
+				return nil, &rpcError{rpcInvalidParams, err.Error()}
+			}
+			fields[k] = val
+		}
+		if activeProfile.Decoder != DecoderFutura {
+			return nil, &rpcError{rpcServerError, "profile " + activeProfile.Name + " does not support writes"}
+		}
+		// the upgrade is a GET and isn't limited; every write call counts
+		if err := countWriteRequest(c.req, "WebSocket write"); err != nil {
+			return nil, &rpcError{rpcServerError, err.Error()}
+		}
+		for k, v := range fields {
+			if err := checkWritePolicy(c.req, k, v); err != nil {
+				return nil, &rpcError{rpcServerError, err.Error()}
+			}
+		}
+		var written []string
+		for k, v := range fields {
+			log.Printf("WebSocket write: %s = %v", k, v)
+			if err := WriteSingleRegister(client, k, v, requestOrigin(c.req, "ws")); err != nil {
+				return map[string]interface{}{"written": written}, &rpcError{rpcServerError, err.Error()}
+			}
+			written = append(written, k)
+		}
+		return map[string]interface{}{"written": written}, nil
+	case "subscribe":
+		if activeProfile.Decoder != DecoderFutura {
+			return nil, &rpcError{rpcServerError, "profile " + activeProfile.Name + " does not support subscriptions"}
+		}
+		wsMu.Lock()
+		wsSubscribers[c] = true
+		wsMu.Unlock()
+		return true, nil
+	case "unsubscribe":
+		wsMu.Lock()
+		delete(wsSubscribers, c)
+		wsMu.Unlock()
+		return true, nil
+	}
+	return nil, &rpcError{rpcMethodNotFound, "method not found"}
+}
+
+// rpcRead reads the current registers like /api/read-input and /api/read-holding
+func rpcRead(client *ModbusConn, typ string) (interface{}, *rpcError) {
+	if maintenanceActive() {
+		return nil, &rpcError{rpcServerError, errMaintenance.Error()}
+	}
+	// served from the poll cache like the HTTP reads without max_age
+	maxAge := 2 * *flagPollInterval
+	switch typ {
+	case "", "input":
+		inputMap, meta, _ := readRegisters(client, modbus.INPUT_REGISTER, inputRanges, maxAge)
+		if activeProfile.Decoder != DecoderFutura {
+			return activeProfile.Decode(inputMap, nil), nil
+		}
+		input := futura.DecodeInputMap(inputMap)
+		input.SnapshotMeta = meta
+		applyAnalogScaling(&input)
+		holdingMap, _, _ := readRegisters(client, modbus.HOLDING_REGISTER, holdingRanges, maxAge)
+		futura.MergeHoldingExt(&input, holdingMap)
+		return input, nil
+	case "holding":
+		holdingMap, meta, _ := readRegisters(client, modbus.HOLDING_REGISTER, holdingRanges, maxAge)
+		if activeProfile.Decoder != DecoderFutura {
+			return activeProfile.Decode(nil, holdingMap), nil
+		}
+		holding := futura.DecodeHoldingMap(holdingMap)
+		holding.SnapshotMeta = meta
+		return holding, nil
+	}
+	return nil, &rpcError{rpcInvalidParams, `type must be "input" or "holding"`}
+}